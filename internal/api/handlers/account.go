@@ -2,13 +2,23 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/service/account"
+	"gopkg.in/yaml.v3"
 )
 
+// maxImportSize limits the size of account import files
+const maxImportSize = 1 << 20 // 1MB
+
+// passphraseHeader carries the passphrase that encrypts or decrypts exported secrets
+const passphraseHeader = "X-Export-Passphrase"
+
 // AccountHandler handles account management requests
 type AccountHandler struct {
 	service *account.Service
@@ -168,3 +178,107 @@ func (h *AccountHandler) SyncSpace(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(account)
 }
+
+// Import handles POST /accounts/import
+// Accepts a YAML or JSON account manifest
+func (h *AccountHandler) Import(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxImportSize+1))
+	if err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+	if len(body) > maxImportSize {
+		handleError(w, r, errors.InvalidRequest("Import file is too large"))
+		return
+	}
+
+	// JSON is valid YAML, so one decoder handles both formats
+	var manifest types.AccountManifest
+	if err := yaml.Unmarshal(body, &manifest); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid import file: "+err.Error()))
+		return
+	}
+	if len(manifest.Accounts) == 0 {
+		handleError(w, r, errors.InvalidRequest("Import file has no accounts"))
+		return
+	}
+
+	results, err := h.service.Import(r.Context(), &manifest, account.ImportOptions{
+		Update:     r.URL.Query().Get("mode") == "update",
+		Passphrase: r.Header.Get(passphraseHeader),
+	})
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	summary := map[string]int{}
+	for _, result := range results {
+		summary[result.Result]++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"results": results,
+		"summary": summary,
+	})
+}
+
+// Export handles GET /accounts/export
+func (h *AccountHandler) Export(w http.ResponseWriter, r *http.Request) {
+	format := strings.ToLower(r.URL.Query().Get("format"))
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "yaml" {
+		handleError(w, r, errors.InvalidRequest("format must be json or yaml"))
+		return
+	}
+
+	manifest, err := h.service.Export(r.Context(), r.Header.Get(passphraseHeader))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	if format == "yaml" {
+		data, err := yaml.Marshal(manifest)
+		if err != nil {
+			handleError(w, r, errors.InternalError(err.Error()))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Header().Set("Content-Disposition", `attachment; filename="accounts.yaml"`)
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="accounts.json"`)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(manifest)
+}
+
+// AuthStatus handles GET /accounts/auth-status
+func (h *AccountHandler) AuthStatus(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.service.AuthStatus(r.Context(), r.URL.Query().Get("check") == "true")
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	pending := 0
+	for _, status := range statuses {
+		if status.NeedsConsent {
+			pending++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accounts":      statuses,
+		"total":         len(statuses),
+		"needs_consent": pending,
+	})
+}
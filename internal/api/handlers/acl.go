@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
+)
+
+// ACLHandler handles the roles granted on buckets and VFS directories
+type ACLHandler struct {
+	aclService *acl.Service
+}
+
+// NewACLHandler creates a new ACL handler
+func NewACLHandler(aclService *acl.Service) *ACLHandler {
+	return &ACLHandler{
+		aclService: aclService,
+	}
+}
+
+// Permissions handles GET /vfs/{bucket}/_acl?path=
+// Lists the grants that apply to a path, inherited ones included, and the effective role of each user
+func (h *ACLHandler) Permissions(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	perms, err := h.aclService.Permissions(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket, r.URL.Query().Get("path"))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(perms)
+}
+
+// Grant handles POST /vfs/{bucket}/_acl
+func (h *ACLHandler) Grant(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var req types.GrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
+		return
+	}
+
+	grant, err := h.aclService.Grant(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket, &req)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grant)
+}
+
+// Revoke handles DELETE /vfs/{bucket}/_acl/{grant_id}
+func (h *ACLHandler) Revoke(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	grantID := vars["grant_id"]
+
+	if err := h.aclService.Revoke(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket, grantID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkRole checks that the caller of a request holds at least a role on a bucket path
+func checkRole(r *http.Request, aclService *acl.Service, bucket, path, role string) error {
+	return aclService.Check(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket, path, role)
+}
+
+// destinationScope returns the path the editor role is needed on to write an item to
+// destination under a conflict policy. Renaming may pick any free name next to the
+// destination, so it needs the role on the destination's parent; files merged onto a
+// taken name are renamed too, while directories merge into the destination itself.
+func destinationScope(destination, policy string, dir bool) string {
+	if policy == conflict.Rename || (policy == conflict.Merge && !dir) {
+		return path.Dir(strings.TrimSuffix(destination, "/"))
+	}
+	return destination
+}
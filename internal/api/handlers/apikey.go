@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/apikey"
+)
+
+// APIKeyHandler handles API key management requests
+type APIKeyHandler struct {
+	service *apikey.Service
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(service *apikey.Service) *APIKeyHandler {
+	return &APIKeyHandler{service: service}
+}
+
+// List handles GET /keys
+// ?user_id= limits the list to the keys of one user
+func (h *APIKeyHandler) List(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.service.List(r.Context(), r.URL.Query().Get("user_id"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": keys,
+	})
+}
+
+// Create handles POST /keys
+// The response contains the key itself, which cannot be retrieved again
+func (h *APIKeyHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req types.CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	key, err := h.service.Create(r.Context(), &req)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(key)
+}
+
+// Get handles GET /keys/{id}
+func (h *APIKeyHandler) Get(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	key, err := h.service.Get(r.Context(), id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(key)
+}
+
+// Delete handles DELETE /keys/{id}
+func (h *APIKeyHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/service/user"
+)
+
+// AuthHandler handles sign-in, sign-out and the signed-in user's own account
+type AuthHandler struct {
+	userService *user.Service
+	baseURL     string
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(userService *user.Service, baseURL string) *AuthHandler {
+	return &AuthHandler{
+		userService: userService,
+		baseURL:     baseURL,
+	}
+}
+
+// Login handles POST /auth/login
+// Checks a username and password, sets the session cookie and returns the session token
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	session, u, err := h.userService.Login(r.Context(), req.Username, req.Password)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	h.setSessionCookie(w, r, session.Token, session.ExpiresAt)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      session.Token,
+		"expires_at": session.ExpiresAt,
+		"user":       u,
+	})
+}
+
+// Logout handles POST /auth/logout
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if err := h.userService.Logout(r.Context(), middleware.RequestToken(r)); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	h.setSessionCookie(w, r, "", time.Unix(0, 0))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me handles GET /auth/me
+func (h *AuthHandler) Me(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := h.userService.Get(r.Context(), userID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// ChangePassword handles PUT /auth/password
+// Every session of the user ends, including the current one
+func (h *AuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.requireUser(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	if err := h.userService.ChangePassword(r.Context(), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	h.setSessionCookie(w, r, "", time.Unix(0, 0))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// OIDCLogin handles GET /auth/oidc/login
+// Redirects the browser to the OIDC provider
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	authURL, err := h.userService.OIDCLoginURL(r.Context(), h.oidcRedirectURI(r))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
+}
+
+// OIDCCallback handles GET /auth/oidc/callback
+// Finishes the sign-in, sets the session cookie and sends the browser to the web interface
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if errorCode := query.Get("error"); errorCode != "" {
+		handleError(w, r, errors.InvalidRequest("OIDC error: "+errorCode+" - "+query.Get("error_description")))
+		return
+	}
+	if query.Get("code") == "" || query.Get("state") == "" {
+		handleError(w, r, errors.InvalidRequest("Missing code or state parameter"))
+		return
+	}
+
+	session, _, err := h.userService.OIDCCallback(r.Context(), query.Get("code"), query.Get("state"), h.oidcRedirectURI(r))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	h.setSessionCookie(w, r, session.Token, session.ExpiresAt)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// oidcRedirectURI returns the URL the OIDC provider sends the browser back to
+func (h *AuthHandler) oidcRedirectURI(r *http.Request) string {
+	return externalURL(r, h.baseURL, "/api/v1/auth/oidc/callback")
+}
+
+// requireUser returns the user behind a request, writing an error when the caller is not a user
+func (h *AuthHandler) requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := middleware.UserIDFromContext(r.Context())
+	if userID == "" {
+		handleError(w, r, errors.InvalidRequest("this request needs a signed-in user"))
+		return "", false
+	}
+	return userID, true
+}
+
+// setSessionCookie sets the session cookie, or clears it when token is empty.
+// SameSite=Lax keeps other sites from sending it with their form posts.
+func (h *AuthHandler) setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" || strings.HasPrefix(h.baseURL, "https://"),
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
@@ -5,18 +5,24 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
 	"github.com/xuecangming/onedrive-storage/internal/service/bucket"
+	"github.com/xuecangming/onedrive-storage/internal/service/user"
 )
 
 // BucketHandler handles bucket-related requests
 type BucketHandler struct {
-	service *bucket.Service
+	service     *bucket.Service
+	userService *user.Service
+	aclService  *acl.Service
 }
 
 // NewBucketHandler creates a new bucket handler
-func NewBucketHandler(service *bucket.Service) *BucketHandler {
-	return &BucketHandler{service: service}
+func NewBucketHandler(service *bucket.Service, userService *user.Service, aclService *acl.Service) *BucketHandler {
+	return &BucketHandler{service: service, userService: userService, aclService: aclService}
 }
 
 // List handles GET /buckets
@@ -27,6 +33,23 @@ func (h *BucketHandler) List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Bucket-scoped API keys only see their own buckets, and users the buckets they own or hold a role in
+	if principal := middleware.PrincipalFromContext(r.Context()); principal != nil {
+		granted, err := h.aclService.GrantedBuckets(r.Context(), principal.UserID)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+
+		allowed := make([]*types.Bucket, 0, len(buckets))
+		for _, b := range buckets {
+			if principal.AllowsBucket(b.Name) && (principal.CanAccessOwned(b.OwnerID) || granted[b.Name]) {
+				allowed = append(allowed, b)
+			}
+		}
+		buckets = allowed
+	}
+
 	response := map[string]interface{}{
 		"buckets": buckets,
 	}
@@ -40,7 +63,7 @@ func (h *BucketHandler) Create(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 
-	bucket, err := h.service.Create(r.Context(), bucketName)
+	bucket, err := h.service.Create(r.Context(), bucketName, middleware.UserIDFromContext(r.Context()))
 	if err != nil {
 		handleError(w, r, err)
 		return
@@ -56,6 +79,11 @@ func (h *BucketHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleOwner); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
 	if err := h.service.Delete(r.Context(), bucketName); err != nil {
 		handleError(w, r, err)
 		return
@@ -64,6 +92,77 @@ func (h *BucketHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// GetPolicy handles GET /buckets/{bucket}/policy
+func (h *BucketHandler) GetPolicy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleViewer); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	policy, err := h.service.GetPolicy(r.Context(), bucketName)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdatePolicy handles PUT /buckets/{bucket}/policy
+func (h *BucketHandler) UpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var policy types.PlacementPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	updated, err := h.service.UpdatePolicy(r.Context(), bucketName, &policy)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// SetOwner handles PUT /buckets/{bucket}/owner
+// An empty owner_id leaves the bucket without an owner, reachable only by admins and service keys
+func (h *BucketHandler) SetOwner(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucketName := vars["bucket"]
+
+	var req struct {
+		OwnerID string `json:"owner_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+	if req.OwnerID != "" {
+		if _, err := h.userService.Get(r.Context(), req.OwnerID); err != nil {
+			handleError(w, r, err)
+			return
+		}
+	}
+
+	updated, err := h.service.SetOwner(r.Context(), bucketName, req.OwnerID)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
 // handleError handles application errors
 func handleError(w http.ResponseWriter, r *http.Request, err error) {
 	if appErr, ok := err.(*errors.AppError); ok {
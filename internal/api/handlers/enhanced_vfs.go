@@ -7,19 +7,24 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
 	"github.com/xuecangming/onedrive-storage/internal/service/vfs"
 )
 
 // EnhancedVFSHandler handles enhanced VFS requests (starred, trash, recent, search)
 type EnhancedVFSHandler struct {
 	enhancedService *vfs.EnhancedService
+	aclService      *acl.Service
 }
 
 // NewEnhancedVFSHandler creates a new enhanced VFS handler
-func NewEnhancedVFSHandler(enhancedService *vfs.EnhancedService) *EnhancedVFSHandler {
+func NewEnhancedVFSHandler(enhancedService *vfs.EnhancedService, aclService *acl.Service) *EnhancedVFSHandler {
 	return &EnhancedVFSHandler{
 		enhancedService: enhancedService,
+		aclService:      aclService,
 	}
 }
 
@@ -45,7 +50,18 @@ func (h *EnhancedVFSHandler) StarFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.enhancedService.StarFile(bucket, req.FileID, req.FilePath); err != nil {
+	// The stored path comes from the file itself, so the role is checked where the file really is
+	file, err := h.enhancedService.GetFileByID(bucket, req.FileID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	if err := checkRole(r, h.aclService, bucket, file.FullPath, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	if err := h.enhancedService.StarFile(bucket, file.ID, file.FullPath, middleware.UserIDFromContext(r.Context())); err != nil {
 		errors.WriteError(w, err)
 		return
 	}
@@ -69,7 +85,7 @@ func (h *EnhancedVFSHandler) UnstarFile(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.enhancedService.UnstarFile(bucket, fileID); err != nil {
+	if err := h.enhancedService.UnstarFile(bucket, fileID, middleware.UserIDFromContext(r.Context())); err != nil {
 		errors.WriteError(w, err)
 		return
 	}
@@ -82,7 +98,12 @@ func (h *EnhancedVFSHandler) GetStarredFiles(w http.ResponseWriter, r *http.Requ
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
-	items, err := h.enhancedService.GetStarredFiles(bucket)
+	items, err := h.enhancedService.GetStarredFiles(bucket, middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	items, err = h.visibleItems(r, bucket, items)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
@@ -110,6 +131,20 @@ func (h *EnhancedVFSHandler) GetTrashItems(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Deleted items stay visible to those who could see them where they were
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	visible := make([]*types.TrashItem, 0, len(items))
+	for _, item := range items {
+		if resolver.Allows(item.OriginalPath, types.RoleViewer) {
+			visible = append(visible, item)
+		}
+	}
+	items = visible
+
 	response := map[string]interface{}{
 		"items": items,
 		"total": len(items),
@@ -122,6 +157,7 @@ func (h *EnhancedVFSHandler) GetTrashItems(w http.ResponseWriter, r *http.Reques
 // RestoreFromTrash restores an item from trash
 func (h *EnhancedVFSHandler) RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	bucket := vars["bucket"]
 	trashID := vars["trash_id"]
 
 	if trashID == "" {
@@ -129,7 +165,11 @@ func (h *EnhancedVFSHandler) RestoreFromTrash(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	if err := h.enhancedService.RestoreFromTrash(trashID); err != nil {
+	if !h.checkTrashItem(w, r, bucket, trashID) {
+		return
+	}
+
+	if err := h.enhancedService.RestoreFromTrash(bucket, trashID); err != nil {
 		errors.WriteError(w, err)
 		return
 	}
@@ -144,6 +184,7 @@ func (h *EnhancedVFSHandler) RestoreFromTrash(w http.ResponseWriter, r *http.Req
 // DeleteFromTrash permanently deletes an item from trash
 func (h *EnhancedVFSHandler) DeleteFromTrash(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
+	bucket := vars["bucket"]
 	trashID := vars["trash_id"]
 
 	if trashID == "" {
@@ -151,7 +192,11 @@ func (h *EnhancedVFSHandler) DeleteFromTrash(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	if err := h.enhancedService.DeleteFromTrash(trashID); err != nil {
+	if !h.checkTrashItem(w, r, bucket, trashID) {
+		return
+	}
+
+	if err := h.enhancedService.DeleteFromTrash(bucket, trashID); err != nil {
 		errors.WriteError(w, err)
 		return
 	}
@@ -164,6 +209,11 @@ func (h *EnhancedVFSHandler) EmptyTrash(w http.ResponseWriter, r *http.Request)
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 
+	if err := checkRole(r, h.aclService, bucket, "/", types.RoleOwner); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
 	count, err := h.enhancedService.EmptyTrash(bucket)
 	if err != nil {
 		errors.WriteError(w, err)
@@ -192,7 +242,12 @@ func (h *EnhancedVFSHandler) GetRecentFiles(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	items, err := h.enhancedService.GetRecentFiles(bucket, limit)
+	items, err := h.enhancedService.GetRecentFiles(bucket, middleware.UserIDFromContext(r.Context()), limit)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	items, err = h.visibleItems(r, bucket, items)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
@@ -232,7 +287,7 @@ func (h *EnhancedVFSHandler) Search(w http.ResponseWriter, r *http.Request) {
 	// Check for type filter
 	fileType := r.URL.Query().Get("type")
 	
-	var results interface{}
+	var results []types.SearchResult
 	var err error
 
 	if fileType != "" {
@@ -246,6 +301,19 @@ func (h *EnhancedVFSHandler) Search(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	visible := make([]types.SearchResult, 0, len(results))
+	for _, result := range results {
+		if resolver.Allows(result.Path, types.RoleViewer) {
+			visible = append(visible, result)
+		}
+	}
+	results = visible
+
 	response := map[string]interface{}{
 		"query":   query,
 		"results": results,
@@ -301,6 +369,11 @@ func (h *EnhancedVFSHandler) GetFilesByDateRange(w http.ResponseWriter, r *http.
 		errors.WriteError(w, err)
 		return
 	}
+	items, err = h.visibleItems(r, bucket, items)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	response := map[string]interface{}{
 		"from":  from,
@@ -312,3 +385,34 @@ func (h *EnhancedVFSHandler) GetFilesByDateRange(w http.ResponseWriter, r *http.
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+// visibleItems keeps the items the caller holds at least the viewer role on
+func (h *EnhancedVFSHandler) visibleItems(r *http.Request, bucket string, items []types.VFSItem) ([]types.VFSItem, error) {
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := make([]types.VFSItem, 0, len(items))
+	for _, item := range items {
+		if resolver.Allows(item.Path, types.RoleViewer) {
+			visible = append(visible, item)
+		}
+	}
+	return visible, nil
+}
+
+// checkTrashItem checks that the caller may restore or delete a trash item, which needs the
+// editor role where the item was deleted from
+func (h *EnhancedVFSHandler) checkTrashItem(w http.ResponseWriter, r *http.Request, bucket, trashID string) bool {
+	item, err := h.enhancedService.GetTrashItem(bucket, trashID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return false
+	}
+	if err := checkRole(r, h.aclService, bucket, item.OriginalPath, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/service/migration"
+)
+
+// MigrationHandler handles account draining requests
+type MigrationHandler struct {
+	service *migration.Service
+}
+
+// NewMigrationHandler creates a new migration handler
+func NewMigrationHandler(service *migration.Service) *MigrationHandler {
+	return &MigrationHandler{service: service}
+}
+
+// Drain handles POST /accounts/{id}/drain
+func (h *MigrationHandler) Drain(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	task, err := h.service.DrainAccount(r.Context(), id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/core/oauthstate"
 	"github.com/xuecangming/onedrive-storage/internal/infrastructure/onedrive"
 	"github.com/xuecangming/onedrive-storage/internal/service/account"
 )
@@ -15,20 +16,27 @@ import (
 type OAuthHandler struct {
 	accountService *account.Service
 	baseURL        string
+	states         *oauthstate.Store
 }
 
 // NewOAuthHandler creates a new OAuth handler
-func NewOAuthHandler(accountService *account.Service, baseURL string) *OAuthHandler {
+func NewOAuthHandler(accountService *account.Service, baseURL string, states *oauthstate.Store) *OAuthHandler {
 	return &OAuthHandler{
 		accountService: accountService,
 		baseURL:        baseURL,
+		states:         states,
 	}
 }
 
 // getRedirectURI returns the OAuth redirect URI, using baseURL if set, otherwise from request
 func (h *OAuthHandler) getRedirectURI(r *http.Request) string {
-	if h.baseURL != "" {
-		return h.baseURL + "/api/v1/oauth/callback"
+	return externalURL(r, h.baseURL, "/api/v1/oauth/callback")
+}
+
+// externalURL returns the URL clients reach a path at, using baseURL if set, otherwise from request
+func externalURL(r *http.Request, baseURL, path string) string {
+	if baseURL != "" {
+		return baseURL + path
 	}
 	
 	// Determine scheme
@@ -48,7 +56,7 @@ func (h *OAuthHandler) getRedirectURI(r *http.Request) string {
 		host = fwdHost
 	}
 	
-	return fmt.Sprintf("%s://%s/api/v1/oauth/callback", scheme, host)
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
 }
 
 // Authorize handles GET /oauth/authorize/{id}
@@ -63,6 +71,10 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Account not found", http.StatusNotFound)
 		return
 	}
+	if account.IsAppOnly(acc) {
+		http.Error(w, "client_credentials accounts do not need user consent", http.StatusBadRequest)
+		return
+	}
 
 	// Get dynamic redirect URI from request
 	redirectURI := h.getRedirectURI(r)
@@ -76,8 +88,13 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 	}
 	auth := onedrive.NewAuth(authConfig)
 
-	// Generate authorization URL with account ID as state
-	authURL := auth.GetAuthorizationURL(id)
+	// Bind a signed, single-use state and a PKCE verifier to this authorization
+	state, entry, err := h.states.Issue(id, redirectURI)
+	if err != nil {
+		http.Error(w, "Failed to create OAuth state", http.StatusInternalServerError)
+		return
+	}
+	authURL := auth.GetAuthorizationURL(state, oauthstate.CodeChallenge(entry.CodeVerifier))
 
 	// Redirect to Microsoft login
 	http.Redirect(w, r, authURL, http.StatusTemporaryRedirect)
@@ -87,7 +104,7 @@ func (h *OAuthHandler) Authorize(w http.ResponseWriter, r *http.Request) {
 // Receives authorization code from Microsoft and exchanges for tokens
 func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
-	state := r.URL.Query().Get("state") // This is the account ID
+	state := r.URL.Query().Get("state")
 	errorCode := r.URL.Query().Get("error")
 
 	if errorCode != "" {
@@ -101,15 +118,25 @@ func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get account by ID (state)
-	acc, err := h.accountService.Get(r.Context(), state)
+	// The state is consumed even when the callback fails, so it cannot be replayed
+	entry, err := h.states.Consume(state)
 	if err != nil {
-		http.Error(w, "Account not found", http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Get dynamic redirect URI from request
+	// The token exchange must use the redirect URI the authorization was started with
 	redirectURI := h.getRedirectURI(r)
+	if redirectURI != entry.RedirectURI {
+		http.Error(w, "OAuth state was issued for a different redirect URI", http.StatusBadRequest)
+		return
+	}
+
+	acc, err := h.accountService.Get(r.Context(), entry.AccountID)
+	if err != nil {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
 
 	// Create auth client
 	authConfig := onedrive.AuthConfig{
@@ -121,27 +148,18 @@ func (h *OAuthHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	auth := onedrive.NewAuth(authConfig)
 
 	// Exchange code for tokens
-	tokenResp, err := auth.ExchangeCode(r.Context(), code)
+	tokenResp, err := auth.ExchangeCode(r.Context(), code, entry.CodeVerifier)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to exchange code: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Update account with tokens
-	expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
-	acc.AccessToken = tokenResp.AccessToken
-	acc.RefreshToken = tokenResp.RefreshToken
-	acc.TokenExpires = expiresAt
-	acc.Status = "active"
-
-	if err := h.accountService.Update(r.Context(), acc); err != nil {
+	// Update account with tokens and sync space info
+	if err := h.accountService.StoreTokens(r.Context(), acc.ID, tokenResp); err != nil {
 		http.Error(w, fmt.Sprintf("Failed to update account: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Try to sync space info
-	_ = h.accountService.SyncSpaceInfo(r.Context(), acc.ID)
-
 	// Redirect to root (frontend)
 	http.Redirect(w, r, "/", http.StatusFound)
 }
@@ -173,3 +191,35 @@ func (h *OAuthHandler) TokenStatus(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(status)
 }
+
+// StartDeviceAuth handles POST /oauth/device/{id}
+// Starts the device code flow and returns the code the user enters at the verification URL
+func (h *OAuthHandler) StartDeviceAuth(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	auth, err := h.accountService.StartDeviceAuthorization(r.Context(), id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth)
+}
+
+// DeviceAuthStatus handles GET /oauth/device/{id}
+// Returns the state of the latest device code flow of an account
+func (h *OAuthHandler) DeviceAuthStatus(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	auth, err := h.accountService.DeviceAuthorizationStatus(id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(auth)
+}
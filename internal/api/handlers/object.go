@@ -10,23 +10,31 @@ import (
 	"strings"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
 	"github.com/xuecangming/onedrive-storage/internal/service/object"
 )
 
-// ObjectHandler handles object-related requests
+// ObjectHandler handles object-related requests.
+// Object keys are not VFS paths, so the object API needs a role on the whole bucket.
 type ObjectHandler struct {
-	service *object.Service
+	service    *object.Service
+	aclService *acl.Service
 }
 
 // NewObjectHandler creates a new object handler
-func NewObjectHandler(service *object.Service) *ObjectHandler {
-	return &ObjectHandler{service: service}
+func NewObjectHandler(service *object.Service, aclService *acl.Service) *ObjectHandler {
+	return &ObjectHandler{service: service, aclService: aclService}
 }
 
 // List handles GET /objects/{bucket}
 func (h *ObjectHandler) List(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleViewer); err != nil {
+		handleError(w, r, err)
+		return
+	}
 
 	prefix := r.URL.Query().Get("prefix")
 	marker := r.URL.Query().Get("marker")
@@ -60,6 +68,10 @@ func (h *ObjectHandler) Upload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 	key := vars["key"]
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleEditor); err != nil {
+		handleError(w, r, err)
+		return
+	}
 
 	contentType := r.Header.Get("Content-Type")
 	if contentType == "" {
@@ -98,6 +110,10 @@ func (h *ObjectHandler) Download(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 	key := vars["key"]
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleViewer); err != nil {
+		handleError(w, r, err)
+		return
+	}
 
 	obj, reader, err := h.service.Download(r.Context(), bucketName, key)
 	if err != nil {
@@ -185,6 +201,10 @@ func (h *ObjectHandler) Head(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 	key := vars["key"]
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleViewer); err != nil {
+		handleError(w, r, err)
+		return
+	}
 
 	obj, err := h.service.GetMetadata(r.Context(), bucketName, key)
 	if err != nil {
@@ -206,6 +226,10 @@ func (h *ObjectHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucketName := vars["bucket"]
 	key := vars["key"]
+	if err := checkRole(r, h.aclService, bucketName, "/", types.RoleEditor); err != nil {
+		handleError(w, r, err)
+		return
+	}
 
 	if err := h.service.Delete(r.Context(), bucketName, key); err != nil {
 		handleError(w, r, err)
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/service/rebalance"
+)
+
+// RebalanceHandler handles rebalance requests
+type RebalanceHandler struct {
+	service *rebalance.Service
+}
+
+// NewRebalanceHandler creates a new rebalance handler
+func NewRebalanceHandler(service *rebalance.Service) *RebalanceHandler {
+	return &RebalanceHandler{service: service}
+}
+
+// Plan handles GET /space/rebalance/plan
+func (h *RebalanceHandler) Plan(w http.ResponseWriter, r *http.Request) {
+	var tolerance float64
+	if value := r.URL.Query().Get("tolerance"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			handleError(w, r, errors.InvalidRequest("Invalid tolerance"))
+			return
+		}
+		tolerance = parsed
+	}
+
+	plan, err := h.service.Plan(r.Context(), tolerance)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
+
+// Start handles POST /space/rebalance
+func (h *RebalanceHandler) Start(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Tolerance float64 `json:"tolerance"`
+	}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			handleError(w, r, errors.InvalidRequest("Invalid request body"))
+			return
+		}
+	}
+
+	task, err := h.service.Start(r.Context(), req.Tolerance)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
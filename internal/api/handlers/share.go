@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
+	"github.com/xuecangming/onedrive-storage/internal/service/share"
+	"github.com/xuecangming/onedrive-storage/internal/service/vfs"
+)
+
+// SharePasswordHeader carries the password of a protected share link
+const SharePasswordHeader = "X-Share-Password"
+
+// ShareHandler handles share links: managing them inside a bucket, and the public
+// routes that serve what they share
+type ShareHandler struct {
+	shareService *share.Service
+	vfsService   *vfs.Service
+	aclService   *acl.Service
+	baseURL      string
+}
+
+// NewShareHandler creates a new share link handler
+func NewShareHandler(shareService *share.Service, vfsService *vfs.Service, aclService *acl.Service, baseURL string) *ShareHandler {
+	return &ShareHandler{
+		shareService: shareService,
+		vfsService:   vfsService,
+		aclService:   aclService,
+		baseURL:      baseURL,
+	}
+}
+
+// ==================== Link Management ====================
+
+// Create handles POST /vfs/{bucket}/_shares
+// Sharing needs the editor role on the shared item
+func (h *ShareHandler) Create(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var req types.CreateShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
+		return
+	}
+	if req.Path == "" {
+		errors.WriteError(w, errors.NewInvalidRequestError("path is required"))
+		return
+	}
+
+	if err := checkRole(r, h.aclService, bucket, req.Path, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	link, err := h.shareService.Create(r.Context(), bucket, &req, middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	link.URL = externalURL(r, h.baseURL, "/api/v1/share/"+link.Token)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(link)
+}
+
+// List handles GET /vfs/{bucket}/_shares
+// Lists the links on items the caller holds the editor role on, only those of one item with ?path=
+func (h *ShareHandler) List(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	links, err := h.shareService.List(r.Context(), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	only := r.URL.Query().Get("path")
+	visible := make([]*types.ShareLink, 0, len(links))
+	for _, link := range links {
+		if only != "" && link.Path != path.Clean("/"+only) {
+			continue
+		}
+		if resolver.Allows(link.Path, types.RoleEditor) {
+			visible = append(visible, link)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items": visible,
+		"total": len(visible),
+	})
+}
+
+// Get handles GET /vfs/{bucket}/_shares/{share_id}
+func (h *ShareHandler) Get(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.managedLink(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(link)
+}
+
+// Delete handles DELETE /vfs/{bucket}/_shares/{share_id}
+func (h *ShareHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.managedLink(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.Delete(r.Context(), link.Bucket, link.ID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Accesses handles GET /vfs/{bucket}/_shares/{share_id}/accesses
+// Returns the most recent uses of a link, newest first
+func (h *ShareHandler) Accesses(w http.ResponseWriter, r *http.Request) {
+	link, ok := h.managedLink(w, r)
+	if !ok {
+		return
+	}
+
+	limit := 100
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 && l <= 1000 {
+			limit = l
+		}
+	}
+
+	accesses, err := h.shareService.Accesses(r.Context(), link.Bucket, link.ID, limit)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"share":    link,
+		"accesses": accesses,
+	})
+}
+
+// managedLink returns the link a management request is about, writing an error when it does
+// not exist or the caller lacks the editor role on its item
+func (h *ShareHandler) managedLink(w http.ResponseWriter, r *http.Request) (*types.ShareLink, bool) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	shareID := vars["share_id"]
+
+	link, err := h.shareService.Get(r.Context(), bucket, shareID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return nil, false
+	}
+	if err := checkRole(r, h.aclService, bucket, link.Path, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return nil, false
+	}
+	return link, true
+}
+
+// ==================== Public Access ====================
+
+// Info handles GET /share/{token}
+// Describes the shared item, with the top level of a shared directory
+func (h *ShareHandler) Info(w http.ResponseWriter, r *http.Request) {
+	link, access, ok := h.openLink(w, r)
+	if !ok {
+		return
+	}
+
+	response := map[string]interface{}{
+		"name":       path.Base(link.Path),
+		"type":       link.TargetType,
+		"mode":       link.Mode,
+		"expires_at": link.ExpiresAt,
+	}
+	if link.MaxDownloads > 0 {
+		response["downloads_left"] = max(int64(link.MaxDownloads)-link.Downloads, 0)
+	}
+
+	if link.TargetType == "file" {
+		file, err := h.vfsService.GetFile(link.Bucket, link.Path)
+		if err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+		response["size"] = file.Size
+		response["mime_type"] = file.MimeType
+		response["updated_at"] = file.UpdatedAt
+	} else {
+		items, err := h.sharedItems(link, link.Path)
+		if err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+		response["items"] = items
+	}
+
+	h.shareService.RecordView(r.Context(), link, access)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Browse handles GET /share/{token}/list?path=
+// Lists a directory below a shared directory
+func (h *ShareHandler) Browse(w http.ResponseWriter, r *http.Request) {
+	link, access, ok := h.openLink(w, r)
+	if !ok {
+		return
+	}
+	if link.TargetType != "directory" {
+		errors.WriteError(w, errors.NewInvalidRequestError("share link is not for a directory"))
+		return
+	}
+
+	rel := r.URL.Query().Get("path")
+	items, err := h.sharedItems(link, h.shareService.Resolve(link, rel))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	h.shareService.RecordView(r.Context(), link, access)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":  path.Clean("/" + rel),
+		"items": items,
+		"total": len(items),
+	})
+}
+
+// Download handles GET /share/{token}/download?path=
+// Serves the shared file, or a file below a shared directory, with Range support.
+// Requests continuing a download already counted for the client are not counted again.
+func (h *ShareHandler) Download(w http.ResponseWriter, r *http.Request) {
+	link, access, ok := h.openLink(w, r)
+	if !ok {
+		return
+	}
+
+	reader, file, err := h.vfsService.DownloadFile(link.Bucket, h.shareService.Resolve(link, r.URL.Query().Get("path")))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	defer reader.Close()
+
+	session, err := h.shareService.BeginDownload(r.Context(), link, access, file)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	if file.MimeType != "" {
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+	counter := &countingWriter{ResponseWriter: w}
+	http.ServeContent(counter, r, file.Name, file.UpdatedAt, reader)
+	h.shareService.EndDownload(session, counter.written)
+}
+
+// countingWriter counts the body bytes written to a response
+type countingWriter struct {
+	http.ResponseWriter
+	written int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.ResponseWriter.Write(p)
+	c.written += int64(n)
+	return n, err
+}
+
+// Zip handles GET /share/{token}/zip?path=
+// Streams a shared directory, or a directory below it, as a zip archive
+func (h *ShareHandler) Zip(w http.ResponseWriter, r *http.Request) {
+	link, access, ok := h.openLink(w, r)
+	if !ok {
+		return
+	}
+	if link.TargetType != "directory" {
+		errors.WriteError(w, errors.NewInvalidRequestError("share link is not for a directory"))
+		return
+	}
+
+	dirPath := h.shareService.Resolve(link, r.URL.Query().Get("path"))
+	if _, err := h.vfsService.ListDirectory(link.Bucket, dirPath, false); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	if err := h.shareService.RecordDownload(r.Context(), link, access); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	name := path.Base(dirPath)
+	if name == "/" {
+		name = link.Bucket
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+
+	// The archive is streamed, so errors past this point can only cut it short
+	if err := h.vfsService.WriteZip(w, link.Bucket, dirPath); err != nil {
+		log.Printf("Warning: zip of share link %s stopped: %v", link.ID, err)
+	}
+}
+
+// Upload handles PUT /share/{token}/upload?path=
+// Uploads a file into a directory shared in upload mode. The file belongs to the user who created the link.
+func (h *ShareHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	link, access, ok := h.openLink(w, r)
+	if !ok {
+		return
+	}
+	if link.Mode != types.ShareModeUpload {
+		errors.WriteError(w, errors.Forbidden("share link does not allow uploads"))
+		return
+	}
+
+	rel := r.URL.Query().Get("path")
+	if path.Clean("/"+rel) == "/" {
+		errors.WriteError(w, errors.NewInvalidRequestError("path parameter is required"))
+		return
+	}
+	if r.ContentLength <= 0 {
+		errors.WriteError(w, errors.NewInvalidRequestError("Content-Length header is required"))
+		return
+	}
+	mimeType := r.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	file, err := h.vfsService.UploadFile(link.Bucket, h.shareService.Resolve(link, rel), r.Body, r.ContentLength, mimeType, link.CreatedBy)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	h.shareService.RecordUpload(r.Context(), link, access)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":      file.Name,
+		"path":      h.shareService.Relative(link, file.FullPath),
+		"size":      file.Size,
+		"mime_type": file.MimeType,
+	})
+}
+
+// openLink opens the link named by a public request, writing an error when it cannot be used
+func (h *ShareHandler) openLink(w http.ResponseWriter, r *http.Request) (*types.ShareLink, *types.ShareAccess, bool) {
+	vars := mux.Vars(r)
+	token := vars["token"]
+
+	access := &types.ShareAccess{
+		Path:       path.Clean("/" + r.URL.Query().Get("path")),
+		RemoteAddr: middleware.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+	}
+
+	// Passwords only travel in a header, which unlike the URL never reaches the request log
+	link, err := h.shareService.Open(r.Context(), token, r.Header.Get(SharePasswordHeader), access)
+	if err != nil {
+		errors.WriteError(w, err)
+		return nil, nil, false
+	}
+	return link, access, true
+}
+
+// sharedItems lists a directory below a shared directory, with paths as seen through the link
+func (h *ShareHandler) sharedItems(link *types.ShareLink, dirPath string) ([]types.VFSItem, error) {
+	items, err := h.vfsService.ListDirectory(link.Bucket, dirPath, false)
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		items[i].Path = h.shareService.Relative(link, items[i].Path)
+		items[i].IsStarred = false
+	}
+	if items == nil {
+		items = []types.VFSItem{}
+	}
+	return items, nil
+}
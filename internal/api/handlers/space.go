@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/core/circuitbreaker"
 	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
 	"github.com/xuecangming/onedrive-storage/internal/service/account"
 )
@@ -15,10 +17,10 @@ type SpaceHandler struct {
 }
 
 // NewSpaceHandler creates a new space handler
-func NewSpaceHandler(accountService *account.Service) *SpaceHandler {
+func NewSpaceHandler(accountService *account.Service, balancer *loadbalancer.Balancer) *SpaceHandler {
 	return &SpaceHandler{
 		accountService: accountService,
-		balancer:       loadbalancer.NewBalancer(loadbalancer.StrategyLeastUsed),
+		balancer:       balancer,
 	}
 }
 
@@ -76,6 +78,87 @@ func (h *SpaceHandler) ListAccounts(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// Health handles GET /space/health
+// Returns the circuit breaker state of each account
+func (h *SpaceHandler) Health(w http.ResponseWriter, r *http.Request) {
+	accounts, err := h.accountService.List(r.Context())
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	snapshots := h.balancer.HealthSnapshot()
+
+	var accountsHealth []map[string]interface{}
+	for _, acc := range accounts {
+		snapshot, exists := snapshots[acc.ID]
+		if !exists {
+			snapshot = circuitbreaker.Snapshot{State: circuitbreaker.StateClosed}
+		}
+
+		accountsHealth = append(accountsHealth, map[string]interface{}{
+			"id":      acc.ID,
+			"name":    acc.Name,
+			"status":  acc.Status,
+			"circuit": snapshot,
+		})
+	}
+
+	response := map[string]interface{}{
+		"accounts": accountsHealth,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetStrategy handles GET /space/strategy
+// Returns the current load balancing strategy and average upload times
+func (h *SpaceHandler) GetStrategy(w http.ResponseWriter, r *http.Request) {
+	h.writeStrategy(w)
+}
+
+// SetStrategy handles PUT /space/strategy
+// Switches the load balancing strategy at runtime
+func (h *SpaceHandler) SetStrategy(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Strategy string `json:"strategy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	strategy, err := loadbalancer.ParseStrategy(req.Strategy)
+	if err != nil {
+		errors.WriteError(w, errors.InvalidRequest(err.Error()))
+		return
+	}
+	if err := h.balancer.SetStrategy(strategy); err != nil {
+		errors.WriteError(w, errors.InvalidRequest(err.Error()))
+		return
+	}
+
+	h.writeStrategy(w)
+}
+
+// writeStrategy writes the current strategy state
+func (h *SpaceHandler) writeStrategy(w http.ResponseWriter) {
+	latencies := make(map[string]int64)
+	for id, latency := range h.balancer.LatencySnapshot() {
+		latencies[id] = latency.Milliseconds()
+	}
+
+	response := map[string]interface{}{
+		"strategy":              h.balancer.Strategy(),
+		"available":             loadbalancer.Strategies(),
+		"avg_upload_ms_per_mib": latencies,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // AccountDetail handles GET /space/accounts/{id}
 func (h *SpaceHandler) AccountDetail(w http.ResponseWriter, r *http.Request) {
 	// Reuse account handler
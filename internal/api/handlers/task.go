@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/service/task"
 )
 
@@ -26,8 +28,28 @@ func (h *TaskHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	id := vars["id"]
 
 	task, err := h.service.GetTask(id)
+	if err != nil || !canSeeTask(r, task) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(task)
+}
+
+// Cancel handles POST /tasks/{id}/cancel
+func (h *TaskHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if task, err := h.service.GetTask(id); err != nil || !canSeeTask(r, task) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	task, err := h.service.CancelTask(id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusConflict)
 		return
 	}
 
@@ -36,8 +58,17 @@ func (h *TaskHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 }
 
 // List handles GET /tasks
+// Admins see every task, users the tasks they started and other keys none
 func (h *TaskHandler) List(w http.ResponseWriter, r *http.Request) {
-	tasks, err := h.service.ListTasks()
+	tasks := []*types.Task{}
+	var err error
+	if principal := middleware.PrincipalFromContext(r.Context()); principal != nil && !principal.HasScope(types.ScopeAdmin) {
+		if principal.UserID != "" {
+			tasks, err = h.service.ListUserTasks(principal.UserID)
+		}
+	} else {
+		tasks, err = h.service.ListTasks()
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -48,3 +79,9 @@ func (h *TaskHandler) List(w http.ResponseWriter, r *http.Request) {
 		"tasks": tasks,
 	})
 }
+
+// canSeeTask reports whether the caller of a request may see a task
+func canSeeTask(r *http.Request, task *types.Task) bool {
+	principal := middleware.PrincipalFromContext(r.Context())
+	return principal == nil || principal.HasScope(types.ScopeAdmin) || (principal.UserID != "" && principal.UserID == task.UserID)
+}
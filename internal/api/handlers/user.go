@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/user"
+)
+
+// UserHandler handles user management requests
+type UserHandler struct {
+	service *user.Service
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(service *user.Service) *UserHandler {
+	return &UserHandler{service: service}
+}
+
+// List handles GET /users
+func (h *UserHandler) List(w http.ResponseWriter, r *http.Request) {
+	users, err := h.service.List(r.Context())
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": users,
+	})
+}
+
+// Create handles POST /users
+func (h *UserHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var req types.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	u, err := h.service.Create(r.Context(), &req)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+// Get handles GET /users/{id}
+func (h *UserHandler) Get(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	u, err := h.service.Get(r.Context(), id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// Update handles PUT /users/{id}
+// Setting a password signs the user out everywhere
+func (h *UserHandler) Update(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	var req types.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		handleError(w, r, errors.InvalidRequest("Invalid request body"))
+		return
+	}
+
+	u, err := h.service.Update(r.Context(), id, &req)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// Delete handles DELETE /users/{id}
+func (h *UserHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	if err := h.service.Delete(r.Context(), id); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
+	"github.com/xuecangming/onedrive-storage/internal/service/vfs"
+)
+
+// VersionHandler handles the previous versions of VFS files and the rules that keep them
+type VersionHandler struct {
+	vfsService *vfs.Service
+	aclService *acl.Service
+}
+
+// NewVersionHandler creates a new file version handler
+func NewVersionHandler(vfsService *vfs.Service, aclService *acl.Service) *VersionHandler {
+	return &VersionHandler{
+		vfsService: vfsService,
+		aclService: aclService,
+	}
+}
+
+// List handles GET /vfs/{bucket}/_versions?path=
+// Returns the current file and its previous versions, newest first
+func (h *VersionHandler) List(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		errors.WriteError(w, errors.NewInvalidRequestError("path parameter is required"))
+		return
+	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	file, versions, err := h.vfsService.ListVersions(bucket, path)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"file":     file,
+		"versions": versions,
+		"total":    len(versions),
+	})
+}
+
+// Download handles GET /vfs/{bucket}/_versions/{version_id}/download
+func (h *VersionHandler) Download(w http.ResponseWriter, r *http.Request) {
+	v, file, ok := h.version(w, r, types.RoleViewer)
+	if !ok {
+		return
+	}
+
+	reader, err := h.vfsService.DownloadVersion(v)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+	if v.MimeType != "" {
+		w.Header().Set("Content-Type", v.MimeType)
+	}
+	http.ServeContent(w, r, file.Name, v.CreatedAt, reader)
+}
+
+// Restore handles POST /vfs/{bucket}/_versions/{version_id}/restore
+// The version becomes the current content; the content it replaces is kept as a version
+func (h *VersionHandler) Restore(w http.ResponseWriter, r *http.Request) {
+	v, _, ok := h.version(w, r, types.RoleEditor)
+	if !ok {
+		return
+	}
+
+	file, err := h.vfsService.RestoreVersion(v.Bucket, v.ID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(file)
+}
+
+// Delete handles DELETE /vfs/{bucket}/_versions/{version_id}
+func (h *VersionHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	v, _, ok := h.version(w, r, types.RoleEditor)
+	if !ok {
+		return
+	}
+
+	if err := h.vfsService.DeleteVersion(v.Bucket, v.ID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRules handles GET /buckets/{bucket}/versioning
+func (h *VersionHandler) GetRules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	if err := checkRole(r, h.aclService, bucket, "/", types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	rules, err := h.vfsService.VersionRules(bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// SetRules handles PUT /buckets/{bucket}/versioning
+func (h *VersionHandler) SetRules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var req types.VersionRules
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
+		return
+	}
+	if err := checkRole(r, h.aclService, bucket, "/", types.RoleOwner); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	rules, err := h.vfsService.SetVersionRules(bucket, &req)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// ResetRules handles DELETE /buckets/{bucket}/versioning
+// The bucket falls back to the configured defaults
+func (h *VersionHandler) ResetRules(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	if err := checkRole(r, h.aclService, bucket, "/", types.RoleOwner); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	rules, err := h.vfsService.ResetVersionRules(bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+// version returns the version a request is about, writing an error when it does not exist
+// or the caller lacks a role on its file
+func (h *VersionHandler) version(w http.ResponseWriter, r *http.Request, role string) (*types.FileVersion, *types.VirtualFile, bool) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	versionID := vars["version_id"]
+
+	v, file, err := h.vfsService.GetVersion(bucket, versionID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return nil, nil, false
+	}
+	if err := checkRole(r, h.aclService, bucket, file.FullPath, role); err != nil {
+		errors.WriteError(w, err)
+		return nil, nil, false
+	}
+	return v, file, true
+}
@@ -2,25 +2,38 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
+	"log"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/archive"
+	"github.com/xuecangming/onedrive-storage/internal/core/conditional"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+	"github.com/xuecangming/onedrive-storage/internal/core/listing"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
 	"github.com/xuecangming/onedrive-storage/internal/service/vfs"
 )
 
 // VFSHandler handles virtual file system requests
 type VFSHandler struct {
 	vfsService *vfs.Service
+	aclService *acl.Service
 }
 
 // NewVFSHandler creates a new VFS handler
-func NewVFSHandler(vfsService *vfs.Service) *VFSHandler {
+func NewVFSHandler(vfsService *vfs.Service, aclService *acl.Service) *VFSHandler {
 	return &VFSHandler{
 		vfsService: vfsService,
+		aclService: aclService,
 	}
 }
 
@@ -34,6 +47,10 @@ func (h *VFSHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	// Get content type
 	mimeType := r.Header.Get("Content-Type")
@@ -48,15 +65,36 @@ func (h *VFSHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Upload file
-	file, err := h.vfsService.UploadFile(bucket, path, r.Body, size, mimeType)
+	// A file already at the path is handled by the conflict policy. ?overwrite=true and
+	// If-Match, which names the file to replace, imply overwriting.
+	policy := r.URL.Query().Get("conflict")
+	if !conflict.Valid(policy) {
+		errors.WriteError(w, errors.NewInvalidRequestError(conflictPolicyError))
+		return
+	}
+	if policy == "" && (r.URL.Query().Get("overwrite") == "true" || r.Header.Get("If-Match") != "") {
+		policy = conflict.Overwrite
+	}
+	if scope := destinationScope(path, policy, false); scope != path {
+		if err := checkRole(r, h.aclService, bucket, scope, types.RoleEditor); err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+	}
+	file, resolution, err := h.vfsService.PutFile(bucket, path, r.Body, size, mimeType, middleware.UserIDFromContext(r.Context()), policy, filePrecondition(r))
 	if err != nil {
 		errors.WriteError(w, err)
 		return
 	}
 
+	status := http.StatusCreated
+	if resolution == conflict.Overwrite || resolution == conflict.Skip {
+		status = http.StatusOK
+	}
+	setValidators(w, file)
+	setConflictResolution(w, resolution)
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(file)
 }
 
@@ -66,16 +104,33 @@ func (h *VFSHandler) InitiateMultipartUpload(w http.ResponseWriter, r *http.Requ
 	bucket := vars["bucket"]
 
 	var req struct {
-		Path     string `json:"path"`
-		MimeType string `json:"mime_type"`
-		Size     int64  `json:"size,omitempty"`
+		Path      string `json:"path"`
+		MimeType  string `json:"mime_type"`
+		Size      int64  `json:"size,omitempty"`
+		Conflict  string `json:"conflict,omitempty"`
+		Overwrite bool   `json:"overwrite,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
 		return
 	}
+	if !conflict.Valid(req.Conflict) {
+		errors.WriteError(w, errors.NewInvalidRequestError(conflictPolicyError))
+		return
+	}
+	// overwrite is the same as conflict=overwrite, like for single uploads
+	if req.Conflict == "" && req.Overwrite {
+		req.Conflict = conflict.Overwrite
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		req.Path = "/" + req.Path
+	}
+	if err := checkRole(r, h.aclService, bucket, destinationScope(req.Path, req.Conflict, false), types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
-	uploadID, err := h.vfsService.InitiateUpload(bucket, req.Path, req.MimeType, req.Size)
+	uploadID, err := h.vfsService.InitiateUpload(bucket, req.Path, req.MimeType, req.Size, middleware.UserIDFromContext(r.Context()), req.Conflict)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
@@ -92,6 +147,10 @@ func (h *VFSHandler) UploadPart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	uploadID := vars["uploadId"]
+	if _, err := h.checkUploadRole(r, bucket, uploadID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	partNumberStr := r.URL.Query().Get("partNumber")
 	partNumber, err := strconv.Atoi(partNumberStr)
@@ -165,18 +224,43 @@ func (h *VFSHandler) CompleteMultipartUpload(w http.ResponseWriter, r *http.Requ
 		Path      string `json:"path"`
 		TotalSize int64  `json:"total_size"`
 		MimeType  string `json:"mime_type"`
+		Conflict  string `json:"conflict,omitempty"`
+		Overwrite bool   `json:"overwrite,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
 		return
 	}
+	if !conflict.Valid(req.Conflict) {
+		errors.WriteError(w, errors.NewInvalidRequestError(conflictPolicyError))
+		return
+	}
+	if req.Conflict == "" && req.Overwrite {
+		req.Conflict = conflict.Overwrite
+	}
+	uploadPath, err := h.checkUploadRole(r, bucket, uploadID)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	if scope := destinationScope(uploadPath, req.Conflict, false); scope != uploadPath {
+		if err := checkRole(r, h.aclService, bucket, scope, types.RoleEditor); err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+	}
+	// The path may be left out; one that is given must be the upload's
+	if req.Path == "" {
+		req.Path = uploadPath
+	}
 
-	file, err := h.vfsService.CompleteUpload(bucket, req.Path, uploadID, req.TotalSize, req.MimeType)
+	file, resolution, err := h.vfsService.CompleteUpload(bucket, req.Path, uploadID, req.TotalSize, req.MimeType, middleware.UserIDFromContext(r.Context()), req.Conflict)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
 	}
 
+	setConflictResolution(w, resolution)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(file)
 }
@@ -186,6 +270,10 @@ func (h *VFSHandler) ListParts(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	uploadID := vars["uploadId"]
+	if _, err := h.checkUploadRole(r, bucket, uploadID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	parts, err := h.vfsService.ListParts(bucket, uploadID)
 	if err != nil {
@@ -205,6 +293,10 @@ func (h *VFSHandler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request
 	vars := mux.Vars(r)
 	bucket := vars["bucket"]
 	uploadID := vars["uploadId"]
+	if _, err := h.checkUploadRole(r, bucket, uploadID); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	err := h.vfsService.AbortUpload(bucket, uploadID)
 	if err != nil {
@@ -215,6 +307,19 @@ func (h *VFSHandler) AbortMultipartUpload(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// checkUploadRole requires the editor role on the path a multipart upload was started
+// for, and returns that path
+func (h *VFSHandler) checkUploadRole(r *http.Request, bucket, uploadID string) (string, error) {
+	path, err := h.vfsService.UploadPath(bucket, uploadID)
+	if err != nil {
+		return "", err
+	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleEditor); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 // GetThumbnail handles GET /vfs/{bucket}/_thumbnail/{file_id}
 // Note: file_id here is actually the path, but encoded? 
 // Or we can use a query param ?path=...
@@ -239,6 +344,10 @@ func (h *VFSHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	data, contentType, err := h.vfsService.GetThumbnail(bucket, path, size)
 	if err != nil {
@@ -258,6 +367,69 @@ func (h *VFSHandler) GetThumbnail(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// defaultLargestLimit is the number of directories the largest folders report lists by default
+const defaultLargestLimit = 20
+
+// LargestDirectories reports the directories below a path that use the most space
+func (h *VFSHandler) LargestDirectories(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+	query := r.URL.Query()
+
+	path := query.Get("path")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	limit := defaultLargestLimit
+	if v := query.Get("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxListLimit {
+			errors.WriteError(w, errors.NewInvalidRequestError(fmt.Sprintf("limit must be between 1 and %d", maxListLimit)))
+			return
+		}
+		limit = l
+	}
+
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	items, err := h.vfsService.LargestDirectories(bucket, path, limit)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":        path,
+		"directories": items,
+	})
+}
+
+// RepairAggregates starts a task recomputing the size and count totals of a bucket's directories
+func (h *VFSHandler) RepairAggregates(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	if err := checkRole(r, h.aclService, bucket, "/", types.RoleOwner); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	task, err := h.vfsService.RepairAggregatesAsync(bucket, middleware.UserIDFromContext(r.Context()))
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
+}
+
 // Get retrieves a file or lists a directory
 func (h *VFSHandler) Get(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -268,6 +440,16 @@ func (h *VFSHandler) Get(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	// A directory is downloaded as an archive when one is asked for
+	if format := r.URL.Query().Get("archive"); format != "" {
+		h.downloadArchive(w, r, bucket, path, format)
+		return
+	}
 
 	// Check if it's a directory listing request (path ends with / or has directory query param)
 	isDir := strings.HasSuffix(path, "/") || r.URL.Query().Get("type") == "directory"
@@ -283,6 +465,18 @@ func (h *VFSHandler) Get(w http.ResponseWriter, r *http.Request) {
 
 // downloadFile downloads a file
 func (h *VFSHandler) downloadFile(w http.ResponseWriter, r *http.Request, bucket, path string) {
+	// Answer conditional requests before opening the content
+	if conditional.Present(r.Header) {
+		file, err := h.vfsService.GetFile(bucket, path)
+		if err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+		if !checkConditions(w, r, file) {
+			return
+		}
+	}
+
 	reader, file, err := h.vfsService.DownloadFile(bucket, path)
 	if err != nil {
 		errors.WriteError(w, err)
@@ -291,29 +485,73 @@ func (h *VFSHandler) downloadFile(w http.ResponseWriter, r *http.Request, bucket
 	defer reader.Close()
 
 	// Use http.ServeContent to handle Range requests automatically
+	setValidators(w, file)
 	http.ServeContent(w, r, file.Name, file.UpdatedAt, reader)
 }
 
-// listDirectory lists directory contents
+// maxListLimit is the largest page of a directory listing
+const maxListLimit = 1000
+
+// listDirectory lists directory contents. Without limit, every item is returned.
 func (h *VFSHandler) listDirectory(w http.ResponseWriter, r *http.Request, bucket, path string) {
 	// Get query parameters
-	recursive := r.URL.Query().Get("recursive") == "true"
+	query := r.URL.Query()
+	recursive := query.Get("recursive") == "true"
+
+	opts := types.ListOptions{
+		Sort:       query.Get("sort"),
+		Cursor:     query.Get("cursor"),
+		Type:       query.Get("kind"),
+		Extensions: listing.ParseExtensions(query.Get("ext")),
+	}
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		errors.WriteError(w, errors.NewInvalidRequestError("order must be asc or desc"))
+		return
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			errors.WriteError(w, errors.NewInvalidRequestError(fmt.Sprintf("limit must be between 1 and %d", maxListLimit)))
+			return
+		}
+		opts.Limit = limit
+	}
 
-	items, err := h.vfsService.ListDirectory(bucket, path, recursive)
-	if err != nil {
-		errors.WriteError(w, err)
+	if recursive {
+		if opts.Limit > 0 || opts.Cursor != "" || opts.Sort != "" || opts.Type != "" || len(opts.Extensions) > 0 {
+			errors.WriteError(w, errors.NewInvalidRequestError("recursive listings cannot be paginated, sorted or filtered"))
+			return
+		}
+
+		items, err := h.vfsService.ListDirectory(bucket, path, true)
+		if err != nil {
+			errors.WriteError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"path":  path,
+			"items": items,
+			"total": len(items),
+		})
 		return
 	}
 
-	response := map[string]interface{}{
-		"path":  path,
-		"items": items,
-		"total": len(items),
+	page, err := h.vfsService.ListDirectoryPage(bucket, path, opts)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(page)
 }
 
 // Delete deletes a file or directory
@@ -326,6 +564,10 @@ func (h *VFSHandler) Delete(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	// Check if it's a directory
 	isDir := strings.HasSuffix(path, "/") || r.URL.Query().Get("type") == "directory"
@@ -333,7 +575,7 @@ func (h *VFSHandler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	if isDir {
 		// Delete directory asynchronously
-		task, err := h.vfsService.DeleteDirectoryAsync(bucket, path, recursive)
+		task, err := h.vfsService.DeleteDirectoryAsync(bucket, path, recursive, middleware.UserIDFromContext(r.Context()))
 		if err != nil {
 			errors.WriteError(w, err)
 			return
@@ -345,7 +587,7 @@ func (h *VFSHandler) Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	} else {
 		// Delete file synchronously
-		err := h.vfsService.DeleteFile(bucket, path)
+		err := h.vfsService.DeleteFile(bucket, path, filePrecondition(r))
 		if err != nil {
 			errors.WriteError(w, err)
 			return
@@ -379,8 +621,12 @@ func (h *VFSHandler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
-	dir, err := h.vfsService.CreateDirectory(bucket, path)
+	dir, err := h.vfsService.CreateDirectory(bucket, path, middleware.UserIDFromContext(r.Context()))
 	if err != nil {
 		errors.WriteError(w, err)
 		return
@@ -400,6 +646,7 @@ func (h *VFSHandler) Move(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Source      string `json:"source"`
 		Destination string `json:"destination"`
+		Conflict    string `json:"conflict"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -412,6 +659,10 @@ func (h *VFSHandler) Move(w http.ResponseWriter, r *http.Request) {
 		errors.WriteError(w, errors.NewInvalidRequestError("source and destination are required"))
 		return
 	}
+	if !conflict.Valid(req.Conflict) {
+		errors.WriteError(w, errors.NewInvalidRequestError(conflictPolicyError))
+		return
+	}
 
 	// Ensure paths are properly formatted
 	if !strings.HasPrefix(req.Source, "/") {
@@ -421,11 +672,20 @@ func (h *VFSHandler) Move(w http.ResponseWriter, r *http.Request) {
 		req.Destination = "/" + req.Destination
 	}
 
+	// Moving takes the item out of the source and puts it in the destination
+	if err := checkRole(r, h.aclService, bucket, req.Source, types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 	// Determine if it's a directory or file
 	isDir := strings.HasSuffix(req.Source, "/")
+	if err := checkRole(r, h.aclService, bucket, destinationScope(req.Destination, req.Conflict, isDir), types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	if isDir {
-		task, err := h.vfsService.MoveDirectoryAsync(bucket, req.Source, req.Destination)
+		task, err := h.vfsService.MoveDirectoryAsync(bucket, req.Source, req.Destination, middleware.UserIDFromContext(r.Context()), req.Conflict)
 		if err != nil {
 			errors.WriteError(w, err)
 			return
@@ -435,11 +695,13 @@ func (h *VFSHandler) Move(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(task)
 		return
 	} else {
-		result, err := h.vfsService.MoveFile(bucket, req.Source, req.Destination)
+		// Conditional headers apply to the source file
+		result, resolution, err := h.vfsService.MoveFile(bucket, req.Source, req.Destination, middleware.UserIDFromContext(r.Context()), req.Conflict, filePrecondition(r))
 		if err != nil {
 			errors.WriteError(w, err)
 			return
 		}
+		setConflictResolution(w, resolution)
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(result)
@@ -455,6 +717,7 @@ func (h *VFSHandler) Copy(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Source      string `json:"source"`
 		Destination string `json:"destination"`
+		Conflict    string `json:"conflict"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -467,6 +730,10 @@ func (h *VFSHandler) Copy(w http.ResponseWriter, r *http.Request) {
 		errors.WriteError(w, errors.NewInvalidRequestError("source and destination are required"))
 		return
 	}
+	if !conflict.Valid(req.Conflict) {
+		errors.WriteError(w, errors.NewInvalidRequestError(conflictPolicyError))
+		return
+	}
 
 	// Ensure paths are properly formatted
 	if !strings.HasPrefix(req.Source, "/") {
@@ -475,13 +742,21 @@ func (h *VFSHandler) Copy(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(req.Destination, "/") {
 		req.Destination = "/" + req.Destination
 	}
+	if err := checkRole(r, h.aclService, bucket, req.Source, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	// For now, copy is implemented by downloading and re-uploading
 	// This is a simple implementation
 	isDir := strings.HasSuffix(req.Source, "/")
+	if err := checkRole(r, h.aclService, bucket, destinationScope(req.Destination, req.Conflict, isDir), types.RoleEditor); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	if isDir {
-		task, err := h.vfsService.CopyDirectoryAsync(bucket, req.Source, req.Destination)
+		task, err := h.vfsService.CopyDirectoryAsync(bucket, req.Source, req.Destination, middleware.UserIDFromContext(r.Context()), req.Conflict)
 		if err != nil {
 			errors.WriteError(w, err)
 			return
@@ -492,24 +767,85 @@ func (h *VFSHandler) Copy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Copy file by downloading and re-uploading
-	reader, file, err := h.vfsService.DownloadFile(bucket, req.Source)
+	newFile, resolution, err := h.vfsService.CopyFile(bucket, req.Source, req.Destination, middleware.UserIDFromContext(r.Context()), req.Conflict)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
 	}
-	defer reader.Close()
 
-	// Upload to new location
-	newFile, err := h.vfsService.UploadFile(bucket, req.Destination, reader, file.Size, file.MimeType)
+	status := http.StatusCreated
+	if resolution == conflict.Overwrite || resolution == conflict.Skip {
+		status = http.StatusOK
+	}
+	setConflictResolution(w, resolution)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(newFile)
+}
+
+// Batch runs a list of moves, copies, deletes and stars in one task
+func (h *VFSHandler) Batch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var req types.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
+		return
+	}
+
+	// Ensure paths are properly formatted
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		for _, p := range []*string{&op.Path, &op.Source, &op.Destination} {
+			if *p != "" && !strings.HasPrefix(*p, "/") {
+				*p = "/" + *p
+			}
+		}
+	}
+
+	// Roles are checked per operation as it runs, like the single-item endpoints do
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	require := func(path, role string) error {
+		if !resolver.Allows(path, role) {
+			return errors.Forbidden(fmt.Sprintf("the %s role is required on %s", role, path))
+		}
+		return nil
+	}
+	// A source without a trailing slash may be a file, so its merges are checked like a
+	// file's, which may rename
+	authorize := func(op types.BatchOperation) error {
+		switch op.Op {
+		case "move":
+			if err := require(op.Source, types.RoleEditor); err != nil {
+				return err
+			}
+			return require(destinationScope(op.Destination, op.Conflict, strings.HasSuffix(op.Source, "/")), types.RoleEditor)
+		case "copy":
+			if err := require(op.Source, types.RoleViewer); err != nil {
+				return err
+			}
+			return require(destinationScope(op.Destination, op.Conflict, strings.HasSuffix(op.Source, "/")), types.RoleEditor)
+		case "delete":
+			return require(op.Path, types.RoleEditor)
+		default:
+			return require(op.Path, types.RoleViewer)
+		}
+	}
+
+	task, err := h.vfsService.StartBatch(bucket, req, middleware.UserIDFromContext(r.Context()), authorize)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newFile)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(task)
 }
 
 // Head retrieves file metadata
@@ -522,18 +858,173 @@ func (h *VFSHandler) Head(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(path, "/") {
 		path = "/" + path
 	}
+	if err := checkRole(r, h.aclService, bucket, path, types.RoleViewer); err != nil {
+		errors.WriteError(w, err)
+		return
+	}
 
 	file, err := h.vfsService.GetFile(bucket, path)
 	if err != nil {
 		errors.WriteError(w, err)
 		return
 	}
+	if !checkConditions(w, r, file) {
+		return
+	}
 
 	// Set headers
+	setValidators(w, file)
 	w.Header().Set("Content-Type", file.MimeType)
 	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
-	w.Header().Set("ETag", file.ID)
-	w.Header().Set("Last-Modified", file.UpdatedAt.Format(http.TimeFormat))
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// conflictPolicyError is the message for an unknown conflict policy
+const conflictPolicyError = "conflict must be fail, overwrite, rename, skip or merge"
+
+// setConflictResolution tells the client how a name conflict at the destination was resolved
+func setConflictResolution(w http.ResponseWriter, resolution string) {
+	if resolution != "" {
+		w.Header().Set("X-Conflict-Resolution", resolution)
+	}
+}
+
+// setValidators sets the headers clients make conditional requests with
+func setValidators(w http.ResponseWriter, file *types.VirtualFile) {
+	w.Header().Set("ETag", vfs.ETag(file))
+	w.Header().Set("Last-Modified", file.UpdatedAt.UTC().Format(http.TimeFormat))
+}
+
+// checkConditions evaluates the conditional headers of a GET or HEAD request against a
+// file, answering 304 or 412 itself. It reports whether the request should go on.
+func checkConditions(w http.ResponseWriter, r *http.Request, file *types.VirtualFile) bool {
+	switch conditional.Evaluate(r.Method, r.Header, vfs.ETag(file), file.UpdatedAt, true) {
+	case conditional.NotModified:
+		setValidators(w, file)
+		w.WriteHeader(http.StatusNotModified)
+		return false
+	case conditional.Failed:
+		errors.WriteError(w, errors.PreconditionFailed(fmt.Sprintf("precondition failed for %s", file.FullPath)))
+		return false
+	}
+	return true
+}
+
+// filePrecondition turns the conditional headers of a request that changes a file into a
+// precondition on it, or nil when there are none
+func filePrecondition(r *http.Request) vfs.Precondition {
+	if !conditional.Present(r.Header) {
+		return nil
+	}
+	return func(file *types.VirtualFile) error {
+		if file == nil {
+			if conditional.Evaluate(r.Method, r.Header, "", time.Time{}, false) != conditional.Proceed {
+				return errors.PreconditionFailed("precondition failed: file does not exist")
+			}
+			return nil
+		}
+		if conditional.Evaluate(r.Method, r.Header, vfs.ETag(file), file.UpdatedAt, true) != conditional.Proceed {
+			return errors.PreconditionFailed(fmt.Sprintf("precondition failed for %s", file.FullPath))
+		}
+		return nil
+	}
+}
+
+// downloadArchive streams a directory and everything below it as an archive
+func (h *VFSHandler) downloadArchive(w http.ResponseWriter, r *http.Request, bucket, dirPath, format string) {
+	query := r.URL.Query()
+	filter, err := archiveFilter(format, query["include"], query["exclude"])
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	a, err := h.vfsService.DirectoryArchive(bucket, dirPath)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	dirPath = strings.TrimSuffix(dirPath, "/")
+	name := dirPath[strings.LastIndex(dirPath, "/")+1:]
+	if name == "" {
+		name = bucket
+	}
+	writeArchive(w, a, format, name, filter, bucket+dirPath+"/")
+}
+
+// Archive streams selected files and directories as one archive
+func (h *VFSHandler) Archive(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	bucket := vars["bucket"]
+
+	var req types.ArchiveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		errors.WriteError(w, errors.NewInvalidRequestError("invalid request body"))
+		return
+	}
+	if req.Format == "" {
+		req.Format = archive.FormatZip
+	}
+	filter, err := archiveFilter(req.Format, req.Include, req.Exclude)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	// Ensure paths are properly formatted
+	for i, p := range req.Paths {
+		if !strings.HasPrefix(p, "/") {
+			req.Paths[i] = "/" + p
+		}
+	}
+
+	resolver, err := h.aclService.Resolver(r.Context(), middleware.PrincipalFromContext(r.Context()), bucket)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+	for _, p := range req.Paths {
+		if !resolver.Allows(p, types.RoleViewer) {
+			errors.WriteError(w, errors.Forbidden(fmt.Sprintf("the %s role is required on %s", types.RoleViewer, p)))
+			return
+		}
+	}
+
+	a, err := h.vfsService.SelectionArchive(bucket, req.Paths)
+	if err != nil {
+		errors.WriteError(w, err)
+		return
+	}
+
+	name := req.Name
+	if name == "" {
+		name = "download"
+	}
+	writeArchive(w, a, req.Format, name, filter, bucket)
+}
+
+// archiveFilter checks an archive format and builds the filter of its include and
+// exclude patterns
+func archiveFilter(format string, include, exclude []string) (*archive.Filter, error) {
+	if !archive.ValidFormat(format) {
+		return nil, errors.NewInvalidRequestError("archive must be zip or tar.gz")
+	}
+	filter, err := archive.NewFilter(include, exclude)
+	if err != nil {
+		return nil, errors.NewInvalidRequestError(err.Error())
+	}
+	return filter, nil
+}
+
+// writeArchive sends the headers of an archive download and streams the archive
+func writeArchive(w http.ResponseWriter, a *vfs.Archive, format, name string, filter *archive.Filter, source string) {
+	w.Header().Set("Content-Type", archive.ContentType(format))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + archive.Extension(format)}))
+
+	// The archive is streamed, so errors past this point can only cut it short
+	if err := a.Write(archive.NewWriter(format, w), filter); err != nil {
+		log.Printf("Warning: archive of %s stopped: %v", source, err)
+	}
+}
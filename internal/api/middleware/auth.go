@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// Authenticator resolves the caller behind the session token or API key presented with a request
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*types.Principal, error)
+}
+
+// BucketAuthorizer decides whether a caller may reach a bucket they are not limited from by scope
+type BucketAuthorizer interface {
+	AuthorizeBucket(ctx context.Context, principal *types.Principal, bucket string) error
+}
+
+// SessionCookie is the cookie holding the session token of a signed-in browser
+const SessionCookie = "ods_session"
+
+type principalContextKey struct{}
+
+// publicPaths are reachable without credentials, relative to the API prefix.
+// The OAuth and OIDC callbacks are browser redirects protected by their signed state.
+var publicPaths = map[string]bool{
+	"/health":             true,
+	"/info":               true,
+	"/ready":              true,
+	"/live":               true,
+	"/oauth/callback":     true,
+	"/auth/login":         true,
+	"/auth/oidc/login":    true,
+	"/auth/oidc/callback": true,
+}
+
+// publicPrefixes are route groups reachable without credentials. Share links carry their own token.
+var publicPrefixes = []string{
+	"/share/",
+}
+
+// dataResources are the route groups that hold bucket data. Everything else needs the admin scope,
+// except selfResources.
+var dataResources = map[string]bool{
+	"buckets": true,
+	"objects": true,
+	"vfs":     true,
+}
+
+// selfResources are the route groups about the caller itself, open to every authenticated caller
+var selfResources = map[string]bool{
+	"auth":  true,
+	"tasks": true,
+}
+
+// PrincipalFromContext returns the caller of a request, or nil when authentication is disabled
+func PrincipalFromContext(ctx context.Context) *types.Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*types.Principal)
+	return principal
+}
+
+// WithPrincipal returns a context carrying the caller of a request
+func WithPrincipal(ctx context.Context, principal *types.Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// UserIDFromContext returns the user behind a request, empty when there is none
+func UserIDFromContext(ctx context.Context) string {
+	if principal := PrincipalFromContext(ctx); principal != nil {
+		return principal.UserID
+	}
+	return ""
+}
+
+// RequireAuth requires a session or API key on every route under apiPrefix except the public ones.
+// The token is read from "Authorization: Bearer <token>", "X-API-Key" or the session cookie. The caller
+// must have the scope the route needs, bucket-scoped keys only reach their own buckets, and users
+// only reach buckets the bucket authorizer lets them.
+func RequireAuth(auth Authenticator, buckets BucketAuthorizer, apiPrefix string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := strings.TrimPrefix(r.URL.Path, apiPrefix)
+			if r.Method == "OPTIONS" || isPublic(path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			token := RequestToken(r)
+			if token == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				errors.WriteError(w, errors.Unauthorized("API key or session required"))
+				return
+			}
+			principal, err := auth.Authenticate(r.Context(), token)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+				errors.WriteError(w, err)
+				return
+			}
+
+			bucket := mux.Vars(r)["bucket"]
+			if err := authorize(principal, r.Method, path, bucket); err != nil {
+				errors.WriteError(w, err)
+				return
+			}
+			if bucket != "" && buckets != nil {
+				if err := buckets.AuthorizeBucket(r.Context(), principal, bucket); err != nil {
+					errors.WriteError(w, err)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// isPublic reports whether a path relative to the API prefix is reachable without credentials
+func isPublic(path string) bool {
+	if publicPaths[path] {
+		return true
+	}
+	for _, prefix := range publicPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequestToken returns the session token or API key sent with a request
+func RequestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if token, found := strings.CutPrefix(auth, "Bearer "); found {
+			return strings.TrimSpace(token)
+		}
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// authorize checks that a caller may call a route
+func authorize(principal *types.Principal, method, path, bucket string) error {
+	scope := requiredScope(method, path)
+	if !principal.HasScope(scope) {
+		return errors.Forbidden("missing the " + scope + " scope")
+	}
+
+	resource, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if principal.IsBucketScoped() {
+		if !dataResources[resource] && !selfResources[resource] {
+			return errors.Forbidden("bucket-scoped API keys can only access bucket data")
+		}
+		if bucket != "" && !principal.AllowsBucket(bucket) {
+			return errors.Forbidden("API key has no access to bucket " + bucket)
+		}
+	}
+	return nil
+}
+
+// requiredScope returns the scope a route needs
+func requiredScope(method, path string) string {
+	resource, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if selfResources[resource] {
+		return types.ScopeRead
+	}
+	if !dataResources[resource] {
+		return types.ScopeAdmin
+	}
+	// Placement policies decide which accounts hold a bucket's data, and owners who can reach it
+	if resource == "buckets" && (strings.HasSuffix(path, "/policy") || strings.HasSuffix(path, "/owner")) && method != "GET" {
+		return types.ScopeAdmin
+	}
+	if method == "GET" || method == "HEAD" {
+		return types.ScopeRead
+	}
+	return types.ScopeWrite
+}
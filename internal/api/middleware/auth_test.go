@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+type fakeAuthenticator map[string]*types.Principal
+
+func (f fakeAuthenticator) Authenticate(ctx context.Context, token string) (*types.Principal, error) {
+	if p, ok := f[token]; ok {
+		return p, nil
+	}
+	return nil, errors.Unauthorized("invalid API key")
+}
+
+// fakeBuckets maps bucket names to their owners
+type fakeBuckets map[string]string
+
+func (f fakeBuckets) AuthorizeBucket(ctx context.Context, principal *types.Principal, bucket string) error {
+	if owner, ok := f[bucket]; ok && !principal.CanAccessOwned(owner) {
+		return errors.Forbidden("no access to bucket " + bucket)
+	}
+	return nil
+}
+
+func newAuthRouter() *mux.Router {
+	tokens := fakeAuthenticator{
+		"reader":     {KeyID: "1", Scopes: []string{types.ScopeRead}},
+		"writer":     {KeyID: "2", Scopes: []string{types.ScopeWrite}},
+		"admin":      {KeyID: "3", Scopes: []string{types.ScopeAdmin}},
+		"app":        {KeyID: "4", Scopes: []string{types.ScopeAdmin}, Buckets: []string{"app-data"}},
+		"sess_alice": {UserID: "alice", Scopes: []string{types.ScopeWrite}},
+		"sess_root":  {UserID: "root", Scopes: []string{types.ScopeAdmin}},
+	}
+	buckets := fakeBuckets{"photos": "alice", "bob-files": "bob", "legacy": ""}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		if PrincipalFromContext(r.Context()) == nil && !isPublic(strings.TrimPrefix(r.URL.Path, "/api/v1")) {
+			w.WriteHeader(http.StatusTeapot)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	router := mux.NewRouter()
+	api := router.PathPrefix("/api/v1").Subrouter()
+	api.Use(RequireAuth(tokens, buckets, "/api/v1"))
+	api.HandleFunc("/health", ok)
+	api.HandleFunc("/buckets", ok)
+	api.HandleFunc("/buckets/{bucket}/policy", ok)
+	api.HandleFunc("/objects/{bucket}/{key}", ok)
+	api.HandleFunc("/buckets/{bucket}/owner", ok)
+	api.HandleFunc("/accounts", ok)
+	api.HandleFunc("/tasks", ok)
+	api.HandleFunc("/auth/login", ok)
+	api.HandleFunc("/share/{token}", ok)
+	return router
+}
+
+func TestRequireAuth(t *testing.T) {
+	router := newAuthRouter()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		want   int
+	}{
+		{"public route without key", "GET", "/api/v1/health", "", http.StatusOK},
+		{"missing key", "GET", "/api/v1/buckets", "", http.StatusUnauthorized},
+		{"invalid key", "GET", "/api/v1/buckets", "nope", http.StatusUnauthorized},
+		{"read key reads objects", "GET", "/api/v1/objects/photos/a.jpg", "reader", http.StatusOK},
+		{"read key cannot write", "PUT", "/api/v1/objects/photos/a.jpg", "reader", http.StatusForbidden},
+		{"write key writes objects", "PUT", "/api/v1/objects/photos/a.jpg", "writer", http.StatusOK},
+		{"write key cannot manage accounts", "GET", "/api/v1/accounts", "writer", http.StatusForbidden},
+		{"write key cannot change policy", "PUT", "/api/v1/buckets/photos/policy", "writer", http.StatusForbidden},
+		{"admin key manages accounts", "GET", "/api/v1/accounts", "admin", http.StatusOK},
+		{"bucket key reaches its bucket", "PUT", "/api/v1/objects/app-data/a.jpg", "app", http.StatusOK},
+		{"bucket key lists buckets", "GET", "/api/v1/buckets", "app", http.StatusOK},
+		{"bucket key cannot reach other buckets", "GET", "/api/v1/objects/photos/a.jpg", "app", http.StatusForbidden},
+		{"bucket key cannot manage accounts", "GET", "/api/v1/accounts", "app", http.StatusForbidden},
+		{"bucket key lists its tasks", "GET", "/api/v1/tasks", "app", http.StatusOK},
+		{"login without credentials", "POST", "/api/v1/auth/login", "", http.StatusOK},
+		{"share link without credentials", "GET", "/api/v1/share/shr_abc", "", http.StatusOK},
+		{"user reaches own bucket", "PUT", "/api/v1/objects/photos/a.jpg", "sess_alice", http.StatusOK},
+		{"user cannot reach other user's bucket", "GET", "/api/v1/objects/bob-files/a.jpg", "sess_alice", http.StatusForbidden},
+		{"user cannot reach bucket without owner", "GET", "/api/v1/objects/legacy/a.jpg", "sess_alice", http.StatusForbidden},
+		{"user creates new bucket", "PUT", "/api/v1/objects/new-bucket/a.jpg", "sess_alice", http.StatusOK},
+		{"user cannot manage accounts", "GET", "/api/v1/accounts", "sess_alice", http.StatusForbidden},
+		{"user cannot change owner", "PUT", "/api/v1/buckets/photos/owner", "sess_alice", http.StatusForbidden},
+		{"admin user reaches any bucket", "GET", "/api/v1/objects/bob-files/a.jpg", "sess_root", http.StatusOK},
+		{"service key reaches bucket without owner", "GET", "/api/v1/objects/legacy/a.jpg", "reader", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status code = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireAuth_XAPIKeyHeader(t *testing.T) {
+	router := newAuthRouter()
+
+	req := httptest.NewRequest("GET", "/api/v1/buckets", nil)
+	req.Header.Set("X-API-Key", "reader")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+	}
+}
+
+func TestRequireAuth_UnauthorizedSetsChallenge(t *testing.T) {
+	router := newAuthRouter()
+
+	req := httptest.NewRequest("GET", "/api/v1/buckets", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Header().Get("WWW-Authenticate") == "" {
+		t.Error("WWW-Authenticate header is missing")
+	}
+}
+
+func TestRequireAuth_SessionCookie(t *testing.T) {
+	router := newAuthRouter()
+
+	req := httptest.NewRequest("GET", "/api/v1/objects/photos/a.jpg", nil)
+	req.AddCookie(&http.Cookie{Name: SessionCookie, Value: "sess_alice"})
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("status code = %v, want %v", w.Code, http.StatusOK)
+	}
+}
@@ -34,7 +34,7 @@ func DefaultCORSConfig() *CORSConfig {
 	return &CORSConfig{
 		AllowedOrigins: allowedOrigins,
 		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "HEAD", "OPTIONS"},
-		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Requested-With"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-Requested-With", "X-Export-Passphrase", "X-API-Key"},
 		MaxAge:         "86400",
 	}
 }
@@ -257,3 +257,8 @@ func getClientIP(r *http.Request) string {
 	}
 	return addr
 }
+
+// ClientIP returns the IP address of the client behind a request
+func ClientIP(r *http.Request) string {
+	return getClientIP(r)
+}
@@ -2,18 +2,28 @@ package api
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 
 	"github.com/gorilla/mux"
 	"github.com/xuecangming/onedrive-storage/internal/api/handlers"
 	"github.com/xuecangming/onedrive-storage/internal/api/middleware"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/circuitbreaker"
+	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
+	"github.com/xuecangming/onedrive-storage/internal/core/oauthstate"
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 	"github.com/xuecangming/onedrive-storage/internal/service/account"
+	"github.com/xuecangming/onedrive-storage/internal/service/acl"
+	"github.com/xuecangming/onedrive-storage/internal/service/apikey"
 	"github.com/xuecangming/onedrive-storage/internal/service/audit"
 	"github.com/xuecangming/onedrive-storage/internal/service/bucket"
+	"github.com/xuecangming/onedrive-storage/internal/service/migration"
 	"github.com/xuecangming/onedrive-storage/internal/service/object"
+	"github.com/xuecangming/onedrive-storage/internal/service/rebalance"
+	"github.com/xuecangming/onedrive-storage/internal/service/share"
 	"github.com/xuecangming/onedrive-storage/internal/service/task"
+	"github.com/xuecangming/onedrive-storage/internal/service/user"
 	"github.com/xuecangming/onedrive-storage/internal/service/vfs"
 )
 
@@ -32,6 +42,16 @@ type Server struct {
 	enhancedVFSHandler *handlers.EnhancedVFSHandler
 	auditHandler       *handlers.AuditHandler
 	taskHandler        *handlers.TaskHandler
+	migrationHandler   *handlers.MigrationHandler
+	rebalanceHandler   *handlers.RebalanceHandler
+	apiKeyHandler      *handlers.APIKeyHandler
+	authHandler        *handlers.AuthHandler
+	userHandler        *handlers.UserHandler
+	aclHandler         *handlers.ACLHandler
+	shareHandler       *handlers.ShareHandler
+	versionHandler     *handlers.VersionHandler
+	userService        *user.Service
+	aclService         *acl.Service
 }
 
 // NewServer creates a new HTTP server
@@ -43,30 +63,65 @@ func NewServer(config *types.Config, db *sql.DB) *Server {
 	vfsRepo := repository.NewVFSRepository(db)
 	enhancedVFSRepo := repository.NewEnhancedVFSRepository(db)
 	taskRepo := repository.NewTaskRepository()
+	replicaRepo := repository.NewReplicaRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	aclRepo := repository.NewACLRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	versionRepo := repository.NewVersionRepository(db)
 
 	// Create services
-	bucketService := bucket.NewService(bucketRepo)
+	bucketService := bucket.NewService(bucketRepo, accountRepo)
 	accountService := account.NewService(accountRepo)
+	// Shared load balancer so upload outcomes feed the same circuit breakers
+	balancer, err := loadbalancer.NewBalancerFromConfig(config.Storage.LoadBalance)
+	if err != nil {
+		log.Printf("Warning: %v, using %s", err, loadbalancer.StrategyLeastUsed)
+		balancer = loadbalancer.NewBalancerWithBreakers(
+			loadbalancer.StrategyLeastUsed,
+			circuitbreaker.FromConfig(config.Storage.LoadBalance.CircuitBreaker),
+		)
+	}
 	// Use OneDrive integration for real storage
-	objectService := object.NewServiceWithOneDrive(objectRepo, bucketRepo, accountService)
+	objectService := object.NewServiceWithOneDrive(objectRepo, bucketRepo, replicaRepo, accountService, balancer)
 	taskService := task.NewService(taskRepo)
-	vfsService := vfs.NewService(vfsRepo, objectService, bucketRepo, taskService)
-	enhancedVFSService := vfs.NewEnhancedService(enhancedVFSRepo, vfsRepo, bucketRepo)
-	auditService := audit.NewService(objectRepo, accountService)
+	vfsService := vfs.NewService(vfsRepo, enhancedVFSRepo, versionRepo, objectService, bucketRepo, taskService, config.Storage.Trash, config.Storage.Versions)
+	enhancedVFSService := vfs.NewEnhancedService(enhancedVFSRepo, vfsRepo, bucketRepo, vfsService)
+	// Deleted files keep their objects until their time in trash is over, previous
+	// versions until their bucket's rules drop them
+	vfsService.ResumeJobs()
+	vfsService.StartTrashPurge()
+	vfsService.StartVersionPrune()
+	auditService := audit.NewService(objectRepo, replicaRepo, bucketRepo, accountService, taskService, objectService)
+	migrationService := migration.NewService(accountService, taskService, objectService)
+	rebalanceService := rebalance.NewService(accountService, taskService, objectService, config.Storage.Rebalance)
+	apiKeyService := apikey.NewService(apiKeyRepo, userRepo, config.Auth.AdminKey)
+	userService := user.NewService(userRepo, sessionRepo, apiKeyService, config.Auth, oauthstate.NewStore(config.Server.OAuthStateSecret, oauthstate.DefaultTTL))
+	aclService := acl.NewService(aclRepo, bucketRepo, vfsRepo, userRepo)
+	shareService := share.NewService(shareRepo, vfsRepo)
 
 	// Create handlers
-	bucketHandler := handlers.NewBucketHandler(bucketService)
-	objectHandler := handlers.NewObjectHandler(objectService)
+	bucketHandler := handlers.NewBucketHandler(bucketService, userService, aclService)
+	objectHandler := handlers.NewObjectHandler(objectService, aclService)
 	accountHandler := handlers.NewAccountHandler(accountService)
-	spaceHandler := handlers.NewSpaceHandler(accountService)
+	spaceHandler := handlers.NewSpaceHandler(accountService, balancer)
 	healthHandler := handlers.NewHealthHandler(db)
-	vfsHandler := handlers.NewVFSHandler(vfsService)
-	enhancedVFSHandler := handlers.NewEnhancedVFSHandler(enhancedVFSService)
+	vfsHandler := handlers.NewVFSHandler(vfsService, aclService)
+	enhancedVFSHandler := handlers.NewEnhancedVFSHandler(enhancedVFSService, aclService)
 	auditHandler := handlers.NewAuditHandler(auditService)
 	taskHandler := handlers.NewTaskHandler(taskService)
+	migrationHandler := handlers.NewMigrationHandler(migrationService)
+	rebalanceHandler := handlers.NewRebalanceHandler(rebalanceService)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	authHandler := handlers.NewAuthHandler(userService, config.Server.BaseURL)
+	userHandler := handlers.NewUserHandler(userService)
+	aclHandler := handlers.NewACLHandler(aclService)
+	shareHandler := handlers.NewShareHandler(shareService, vfsService, aclService, config.Server.BaseURL)
+	versionHandler := handlers.NewVersionHandler(vfsService, aclService)
 
 	// Create OAuth handler (redirect URI will be determined dynamically from request)
-	oauthHandler := handlers.NewOAuthHandler(accountService, config.Server.BaseURL)
+	oauthHandler := handlers.NewOAuthHandler(accountService, config.Server.BaseURL, oauthstate.NewStore(config.Server.OAuthStateSecret, oauthstate.DefaultTTL))
 
 	server := &Server{
 		config:             config,
@@ -82,6 +137,16 @@ func NewServer(config *types.Config, db *sql.DB) *Server {
 		enhancedVFSHandler: enhancedVFSHandler,
 		auditHandler:       auditHandler,
 		taskHandler:        taskHandler,
+		migrationHandler:   migrationHandler,
+		rebalanceHandler:   rebalanceHandler,
+		apiKeyHandler:      apiKeyHandler,
+		authHandler:        authHandler,
+		userHandler:        userHandler,
+		aclHandler:         aclHandler,
+		shareHandler:       shareHandler,
+		versionHandler:     versionHandler,
+		userService:        userService,
+		aclService:         aclService,
 	}
 
 	server.setupRoutes()
@@ -103,6 +168,14 @@ func (s *Server) setupRoutes() {
 
 	// API v1 routes
 	api := s.router.PathPrefix(s.config.Server.APIPrefix).Subrouter()
+	if s.config.Auth.Enabled {
+		if s.config.Auth.AdminKey == "" {
+			log.Printf("Warning: API authentication is enabled without an admin key, only stored API keys and user sessions are accepted")
+		}
+		api.Use(middleware.RequireAuth(s.userService, s.aclService, s.config.Server.APIPrefix))
+	} else {
+		log.Printf("Warning: API authentication is disabled, all routes are open")
+	}
 
 	// Health check and readiness endpoints
 	api.HandleFunc("/health", s.healthHandler.Health).Methods("GET", "OPTIONS")
@@ -114,6 +187,12 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/buckets", s.bucketHandler.List).Methods("GET", "OPTIONS")
 	api.HandleFunc("/buckets/{bucket}", s.bucketHandler.Create).Methods("PUT", "OPTIONS")
 	api.HandleFunc("/buckets/{bucket}", s.bucketHandler.Delete).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/policy", s.bucketHandler.GetPolicy).Methods("GET", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/policy", s.bucketHandler.UpdatePolicy).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/owner", s.bucketHandler.SetOwner).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/versioning", s.versionHandler.GetRules).Methods("GET", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/versioning", s.versionHandler.SetRules).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/buckets/{bucket}/versioning", s.versionHandler.ResetRules).Methods("DELETE", "OPTIONS")
 
 	// Object routes
 	api.HandleFunc("/objects/{bucket}", s.objectHandler.List).Methods("GET", "OPTIONS")
@@ -125,14 +204,23 @@ func (s *Server) setupRoutes() {
 	// Account management routes
 	api.HandleFunc("/accounts", s.accountHandler.List).Methods("GET", "OPTIONS")
 	api.HandleFunc("/accounts", s.accountHandler.Create).Methods("POST", "OPTIONS")
+	api.HandleFunc("/accounts/import", s.accountHandler.Import).Methods("POST", "OPTIONS")
+	api.HandleFunc("/accounts/export", s.accountHandler.Export).Methods("GET", "OPTIONS")
+	api.HandleFunc("/accounts/auth-status", s.accountHandler.AuthStatus).Methods("GET", "OPTIONS")
 	api.HandleFunc("/accounts/{id}", s.accountHandler.Get).Methods("GET", "OPTIONS")
 	api.HandleFunc("/accounts/{id}", s.accountHandler.Update).Methods("PUT", "OPTIONS")
 	api.HandleFunc("/accounts/{id}", s.accountHandler.Delete).Methods("DELETE", "OPTIONS")
 	api.HandleFunc("/accounts/{id}/refresh", s.accountHandler.RefreshToken).Methods("POST", "OPTIONS")
 	api.HandleFunc("/accounts/{id}/sync", s.accountHandler.SyncSpace).Methods("POST", "OPTIONS")
+	api.HandleFunc("/accounts/{id}/drain", s.migrationHandler.Drain).Methods("POST", "OPTIONS")
 
 	// Space management routes
 	api.HandleFunc("/space", s.spaceHandler.Overview).Methods("GET", "OPTIONS")
+	api.HandleFunc("/space/health", s.spaceHandler.Health).Methods("GET", "OPTIONS")
+	api.HandleFunc("/space/strategy", s.spaceHandler.GetStrategy).Methods("GET", "OPTIONS")
+	api.HandleFunc("/space/strategy", s.spaceHandler.SetStrategy).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/space/rebalance/plan", s.rebalanceHandler.Plan).Methods("GET", "OPTIONS")
+	api.HandleFunc("/space/rebalance", s.rebalanceHandler.Start).Methods("POST", "OPTIONS")
 	api.HandleFunc("/space/accounts", s.spaceHandler.ListAccounts).Methods("GET", "OPTIONS")
 	api.HandleFunc("/space/accounts/{id}", s.spaceHandler.AccountDetail).Methods("GET", "OPTIONS")
 	api.HandleFunc("/space/accounts/{id}/sync", s.spaceHandler.SyncAccount).Methods("POST", "OPTIONS")
@@ -141,6 +229,8 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/oauth/authorize/{id}", s.oauthHandler.Authorize).Methods("GET", "OPTIONS")
 	api.HandleFunc("/oauth/callback", s.oauthHandler.Callback).Methods("GET", "OPTIONS")
 	api.HandleFunc("/oauth/status/{id}", s.oauthHandler.TokenStatus).Methods("GET", "OPTIONS")
+	api.HandleFunc("/oauth/device/{id}", s.oauthHandler.StartDeviceAuth).Methods("POST", "OPTIONS")
+	api.HandleFunc("/oauth/device/{id}", s.oauthHandler.DeviceAuthStatus).Methods("GET", "OPTIONS")
 
 	// Virtual File System routes
 	// Multipart upload routes (must be before generic path routes to avoid conflict)
@@ -150,13 +240,38 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/vfs/{bucket}/_upload/{uploadId}", s.vfsHandler.AbortMultipartUpload).Methods("DELETE", "OPTIONS")
 	api.HandleFunc("/vfs/{bucket}/_upload/{uploadId}/complete", s.vfsHandler.CompleteMultipartUpload).Methods("POST", "OPTIONS")
 	
+	// Access control routes
+	api.HandleFunc("/vfs/{bucket}/_acl", s.aclHandler.Permissions).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_acl", s.aclHandler.Grant).Methods("POST", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_acl/{grant_id}", s.aclHandler.Revoke).Methods("DELETE", "OPTIONS")
+
+	// Share link management routes
+	api.HandleFunc("/vfs/{bucket}/_shares", s.shareHandler.List).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_shares", s.shareHandler.Create).Methods("POST", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_shares/{share_id}", s.shareHandler.Get).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_shares/{share_id}", s.shareHandler.Delete).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_shares/{share_id}/accesses", s.shareHandler.Accesses).Methods("GET", "OPTIONS")
+
+	// File version routes
+	api.HandleFunc("/vfs/{bucket}/_versions", s.versionHandler.List).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_versions/{version_id}", s.versionHandler.Delete).Methods("DELETE", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_versions/{version_id}/download", s.versionHandler.Download).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_versions/{version_id}/restore", s.versionHandler.Restore).Methods("POST", "OPTIONS")
+
+	// Batch operations route
+	api.HandleFunc("/vfs/{bucket}/_batch", s.vfsHandler.Batch).Methods("POST", "OPTIONS")
+
+	// Archive download route
+	api.HandleFunc("/vfs/{bucket}/_archive", s.vfsHandler.Archive).Methods("POST", "OPTIONS")
+
+	// Directory usage routes
+	api.HandleFunc("/vfs/{bucket}/_largest", s.vfsHandler.LargestDirectories).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/_aggregates/repair", s.vfsHandler.RepairAggregates).Methods("POST", "OPTIONS")
+
 	// Thumbnail route
 	api.HandleFunc("/vfs/{bucket}/_thumbnail", s.vfsHandler.GetThumbnail).Methods("GET", "OPTIONS")
 
-	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.UploadFile).Methods("PUT", "OPTIONS")
-	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Get).Methods("GET", "OPTIONS")
-	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Head).Methods("HEAD", "OPTIONS")
-	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Delete).Methods("DELETE", "OPTIONS")
+	// Directory creation, move and copy routes
 	api.HandleFunc("/vfs/{bucket}/_mkdir", s.vfsHandler.CreateDirectory).Methods("POST", "OPTIONS")
 	api.HandleFunc("/vfs/{bucket}/_move", s.vfsHandler.Move).Methods("POST", "OPTIONS")
 	api.HandleFunc("/vfs/{bucket}/_copy", s.vfsHandler.Copy).Methods("POST", "OPTIONS")
@@ -177,13 +292,49 @@ func (s *Server) setupRoutes() {
 	api.HandleFunc("/vfs/{bucket}/_trash/{trash_id}/restore", s.enhancedVFSHandler.RestoreFromTrash).Methods("POST", "OPTIONS")
 	api.HandleFunc("/vfs/{bucket}/_trash/{trash_id}", s.enhancedVFSHandler.DeleteFromTrash).Methods("DELETE", "OPTIONS")
 
+	// Paths of files and directories. The first matching route wins, so these come after
+	// every "_" route of the same method.
+	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.UploadFile).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Get).Methods("GET", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Head).Methods("HEAD", "OPTIONS")
+	api.HandleFunc("/vfs/{bucket}/{path:.*}", s.vfsHandler.Delete).Methods("DELETE", "OPTIONS")
+
+	// Public share link routes, authenticated by the link token
+	api.HandleFunc("/share/{token}", s.shareHandler.Info).Methods("GET", "OPTIONS")
+	api.HandleFunc("/share/{token}/list", s.shareHandler.Browse).Methods("GET", "OPTIONS")
+	api.HandleFunc("/share/{token}/download", s.shareHandler.Download).Methods("GET", "OPTIONS")
+	api.HandleFunc("/share/{token}/zip", s.shareHandler.Zip).Methods("GET", "OPTIONS")
+	api.HandleFunc("/share/{token}/upload", s.shareHandler.Upload).Methods("PUT", "OPTIONS")
+
 	// Audit routes
 	api.HandleFunc("/audit/start", s.auditHandler.StartAudit).Methods("POST", "OPTIONS")
 	api.HandleFunc("/audit/status", s.auditHandler.GetStatus).Methods("GET", "OPTIONS")
 
+	// API key routes
+	api.HandleFunc("/keys", s.apiKeyHandler.List).Methods("GET", "OPTIONS")
+	api.HandleFunc("/keys", s.apiKeyHandler.Create).Methods("POST", "OPTIONS")
+	api.HandleFunc("/keys/{id}", s.apiKeyHandler.Get).Methods("GET", "OPTIONS")
+	api.HandleFunc("/keys/{id}", s.apiKeyHandler.Delete).Methods("DELETE", "OPTIONS")
+
+	// Sign-in routes
+	api.HandleFunc("/auth/login", s.authHandler.Login).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/logout", s.authHandler.Logout).Methods("POST", "OPTIONS")
+	api.HandleFunc("/auth/me", s.authHandler.Me).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/password", s.authHandler.ChangePassword).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/auth/oidc/login", s.authHandler.OIDCLogin).Methods("GET", "OPTIONS")
+	api.HandleFunc("/auth/oidc/callback", s.authHandler.OIDCCallback).Methods("GET", "OPTIONS")
+
+	// User routes
+	api.HandleFunc("/users", s.userHandler.List).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users", s.userHandler.Create).Methods("POST", "OPTIONS")
+	api.HandleFunc("/users/{id}", s.userHandler.Get).Methods("GET", "OPTIONS")
+	api.HandleFunc("/users/{id}", s.userHandler.Update).Methods("PUT", "OPTIONS")
+	api.HandleFunc("/users/{id}", s.userHandler.Delete).Methods("DELETE", "OPTIONS")
+
 	// Task routes
 	api.HandleFunc("/tasks", s.taskHandler.List).Methods("GET", "OPTIONS")
 	api.HandleFunc("/tasks/{id}", s.taskHandler.GetStatus).Methods("GET", "OPTIONS")
+	api.HandleFunc("/tasks/{id}/cancel", s.taskHandler.Cancel).Methods("POST", "OPTIONS")
 
 	// Root endpoint - API info
 	s.router.HandleFunc("/", s.healthHandler.Info).Methods("GET", "OPTIONS")
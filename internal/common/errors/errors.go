@@ -16,6 +16,10 @@ const (
 	ErrInvalidKey     ErrorCode = "INVALID_KEY"
 	ErrInvalidPath    ErrorCode = "INVALID_PATH"
 
+	// 401, 403 errors
+	ErrUnauthorized ErrorCode = "UNAUTHORIZED"
+	ErrForbidden    ErrorCode = "FORBIDDEN"
+
 	// 404 errors
 	ErrBucketNotFound ErrorCode = "BUCKET_NOT_FOUND"
 	ErrObjectNotFound ErrorCode = "OBJECT_NOT_FOUND"
@@ -28,6 +32,9 @@ const (
 	ErrBucketNotEmpty ErrorCode = "BUCKET_NOT_EMPTY"
 	ErrDirNotEmpty    ErrorCode = "DIR_NOT_EMPTY"
 
+	// 412 errors
+	ErrPreconditionFailed ErrorCode = "PRECONDITION_FAILED"
+
 	// 413, 507 errors
 	ErrFileTooLarge ErrorCode = "FILE_TOO_LARGE"
 	ErrStorageFull  ErrorCode = "STORAGE_FULL"
@@ -125,10 +132,22 @@ func FileTooLarge(size, maxSize int64) *AppError {
 		WithDetails("max_size", maxSize)
 }
 
+func PreconditionFailed(message string) *AppError {
+	return NewAppError(ErrPreconditionFailed, message, http.StatusPreconditionFailed)
+}
+
 func StorageFull() *AppError {
 	return NewAppError(ErrStorageFull, "Insufficient storage space", http.StatusInsufficientStorage)
 }
 
+func Unauthorized(message string) *AppError {
+	return NewAppError(ErrUnauthorized, message, http.StatusUnauthorized)
+}
+
+func Forbidden(message string) *AppError {
+	return NewAppError(ErrForbidden, message, http.StatusForbidden)
+}
+
 func InternalError(message string) *AppError {
 	return NewAppError(ErrInternal, message, http.StatusInternalServerError)
 }
@@ -137,6 +156,10 @@ func UpstreamError(message string) *AppError {
 	return NewAppError(ErrUpstreamError, message, http.StatusBadGateway)
 }
 
+func ServiceUnavailable(message string) *AppError {
+	return NewAppError(ErrServiceUnavail, message, http.StatusServiceUnavailable)
+}
+
 // NewInvalidRequestError creates a new invalid request error
 func NewInvalidRequestError(message string) *AppError {
 	return InvalidRequest(message)
@@ -17,12 +17,16 @@ const (
 type TaskType string
 
 const (
-	TaskTypeCopy     TaskType = "copy"
-	TaskTypeMove     TaskType = "move"
-	TaskTypeDelete   TaskType = "delete"
-	TaskTypeSync     TaskType = "sync"
-	TaskTypeUpload   TaskType = "upload"
-	TaskTypeDownload TaskType = "download"
+	TaskTypeCopy      TaskType = "copy"
+	TaskTypeMove      TaskType = "move"
+	TaskTypeDelete    TaskType = "delete"
+	TaskTypeSync      TaskType = "sync"
+	TaskTypeUpload    TaskType = "upload"
+	TaskTypeDownload  TaskType = "download"
+	TaskTypeRepair    TaskType = "repair"
+	TaskTypeMigrate   TaskType = "migrate"
+	TaskTypeRebalance TaskType = "rebalance"
+	TaskTypeBatch     TaskType = "batch"
 )
 
 // Task represents an asynchronous background task
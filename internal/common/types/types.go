@@ -10,6 +10,7 @@ type Config struct {
 	Storage  StorageConfig  `yaml:"storage"`
 	Token    TokenConfig    `yaml:"token"`
 	Logging  LoggingConfig  `yaml:"logging"`
+	Auth     AuthConfig     `yaml:"auth"`
 }
 
 // ServerConfig represents HTTP server configuration
@@ -18,6 +19,8 @@ type ServerConfig struct {
 	Port      int    `yaml:"port"`
 	APIPrefix string `yaml:"api_prefix"`
 	BaseURL   string `yaml:"base_url"`
+	// OAuthStateSecret signs OAuth state values; a random secret is used when empty
+	OAuthStateSecret string `yaml:"oauth_state_secret"`
 }
 
 // DatabaseConfig represents database configuration
@@ -57,6 +60,9 @@ type StorageConfig struct {
 	Upload      UploadConfig      `yaml:"upload"`
 	LoadBalance LoadBalanceConfig `yaml:"load_balance"`
 	Retry       RetryConfig       `yaml:"retry"`
+	Rebalance   RebalanceConfig   `yaml:"rebalance"`
+	Trash       TrashConfig       `yaml:"trash"`
+	Versions    VersionConfig     `yaml:"versions"`
 }
 
 // UploadConfig represents upload configuration
@@ -69,8 +75,43 @@ type UploadConfig struct {
 
 // LoadBalanceConfig represents load balance configuration
 type LoadBalanceConfig struct {
-	Strategy            string `yaml:"strategy"`
-	HealthCheckInterval int    `yaml:"health_check_interval"`
+	Strategy            string               `yaml:"strategy"`
+	HealthCheckInterval int                  `yaml:"health_check_interval"`
+	LatencyAlpha        float64              `yaml:"latency_alpha"`     // smoothing factor for the latency strategy
+	AffinityAccounts    int                  `yaml:"affinity_accounts"` // accounts per bucket for the affinity strategy
+	CircuitBreaker      CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig represents per-account circuit breaker configuration
+type CircuitBreakerConfig struct {
+	WindowSize          int     `yaml:"window_size"`
+	MinCalls            int     `yaml:"min_calls"`
+	FailureRate         float64 `yaml:"failure_rate"`
+	ConsecutiveFailures int     `yaml:"consecutive_failures"`
+	SlowCallThreshold   int     `yaml:"slow_call_threshold"` // milliseconds
+	OpenTimeout         int     `yaml:"open_timeout"`        // milliseconds
+}
+
+// RebalanceConfig represents configuration of the job that evens out usage across accounts
+type RebalanceConfig struct {
+	Tolerance         float64 `yaml:"tolerance"`            // allowed deviation from the target, as a fraction of the quota
+	BatchSize         int     `yaml:"batch_size"`           // moves between throttle pauses
+	MaxMoves          int     `yaml:"max_moves"`            // moves planned per round
+	MaxBytesPerSecond int64   `yaml:"max_bytes_per_second"` // bandwidth limit, 0 for unlimited
+}
+
+// TrashConfig represents trash configuration
+type TrashConfig struct {
+	RetentionDays int `yaml:"retention_days"` // days deleted items stay restorable
+	PurgeInterval int `yaml:"purge_interval"` // seconds between purges of expired items, 0 disables them
+}
+
+// VersionConfig represents file version configuration. The limits apply to buckets
+// without their own version rules.
+type VersionConfig struct {
+	KeepVersions  int `yaml:"keep_versions"`  // previous versions kept per file, 0 for all
+	KeepDays      int `yaml:"keep_days"`      // days previous versions are kept, 0 for ever
+	PruneInterval int `yaml:"prune_interval"` // seconds between prunes, 0 disables them
 }
 
 // RetryConfig represents retry configuration
@@ -87,6 +128,24 @@ type TokenConfig struct {
 	RefreshCheckInterval int `yaml:"refresh_check_interval"`
 }
 
+// AuthConfig represents API authentication configuration
+type AuthConfig struct {
+	Enabled    bool       `yaml:"enabled"`
+	AdminKey   string     `yaml:"admin_key"`   // bootstrap key with admin scope on all buckets
+	SessionTTL int        `yaml:"session_ttl"` // hours a login session lasts
+	OIDC       OIDCConfig `yaml:"oidc"`
+}
+
+// OIDCConfig represents OpenID Connect login configuration
+type OIDCConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	AutoCreate   bool     `yaml:"auto_create"` // create users on their first login
+}
+
 // LoggingConfig represents logging configuration
 type LoggingConfig struct {
 	Level  string            `yaml:"level"`
@@ -105,11 +164,77 @@ type LoggingFileConfig struct {
 
 // Bucket represents a storage bucket
 type Bucket struct {
-	Name        string    `json:"name"`
-	ObjectCount int64     `json:"object_count"`
-	TotalSize   int64     `json:"total_size"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	Name        string          `json:"name"`
+	ObjectCount int64           `json:"object_count"`
+	TotalSize   int64           `json:"total_size"`
+	Policy      PlacementPolicy `json:"policy"`
+	OwnerID     string          `json:"owner_id,omitempty"` // empty for buckets created without authentication
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// Storage classes of a bucket
+const (
+	StorageClassSingle     = "single"     // one copy
+	StorageClassReplicated = "replicated" // ReplicationFactor copies on distinct accounts
+	StorageClassErasure    = "erasure"    // chunks split into DataShards+ParityShards shards
+)
+
+// PlacementPolicy controls which accounts a bucket's data is stored on
+type PlacementPolicy struct {
+	AllowedAccounts   []string `json:"allowed_accounts,omitempty"` // account IDs, empty allows all
+	AllowedTags       []string `json:"allowed_tags,omitempty"`     // account tags, empty allows all
+	ReplicationFactor int      `json:"replication_factor"`
+	Strategy          string   `json:"strategy,omitempty"` // overrides the global load balancing strategy
+	PinLocal          bool     `json:"pin_local"`          // store on local disk instead of OneDrive
+	StorageClass      string   `json:"storage_class"`
+	DataShards        int      `json:"data_shards,omitempty"`
+	ParityShards      int      `json:"parity_shards,omitempty"`
+}
+
+// Copies returns how many full copies of non-erasure-coded data to keep.
+// Erasure-coded buckets keep ParityShards+1 copies of small objects so they survive
+// as many account losses as their chunked objects.
+func (p *PlacementPolicy) Copies() int {
+	if p.StorageClass == StorageClassErasure {
+		return p.ParityShards + 1
+	}
+	if p.ReplicationFactor < 1 {
+		return 1
+	}
+	return p.ReplicationFactor
+}
+
+// IsRestricted reports whether the policy limits the set of accounts
+func (p *PlacementPolicy) IsRestricted() bool {
+	return len(p.AllowedAccounts) > 0 || len(p.AllowedTags) > 0
+}
+
+// AllowsAccount reports whether the policy permits storing data on the account.
+// An account must be listed in AllowedAccounts (if set) and carry one of AllowedTags (if set).
+func (p *PlacementPolicy) AllowsAccount(account *StorageAccount) bool {
+	if len(p.AllowedAccounts) > 0 && !containsString(p.AllowedAccounts, account.ID) {
+		return false
+	}
+	if len(p.AllowedTags) > 0 {
+		for _, tag := range account.Tags {
+			if containsString(p.AllowedTags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Object represents a storage object
@@ -142,8 +267,42 @@ type ObjectChunk struct {
 	Checksum   string    `json:"checksum,omitempty"`
 	Status     string    `json:"status"`
 	CreatedAt  time.Time `json:"created_at"`
+
+	// Erasure coding: each stripe (chunk index) is stored as DataShards+ParityShards shard rows
+	ShardIndex   int   `json:"shard_index"`
+	DataShards   int   `json:"data_shards,omitempty"`
+	ParityShards int   `json:"parity_shards,omitempty"`
+	StripeSize   int64 `json:"stripe_size,omitempty"` // length of the stripe before encoding
+}
+
+// IsShard reports whether the chunk is an erasure-coded shard
+func (c *ObjectChunk) IsShard() bool {
+	return c.DataShards > 0
+}
+
+// WholeObjectIndex is the chunk index of replicas that hold a whole, non-chunked object
+const WholeObjectIndex = -1
+
+// ObjectReplica represents an additional copy of an object or chunk on another account.
+// The primary copy stays referenced by the object or chunk row itself.
+type ObjectReplica struct {
+	ID         string    `json:"id"`
+	Bucket     string    `json:"bucket"`
+	Key        string    `json:"key"`
+	ChunkIndex int       `json:"chunk_index"` // WholeObjectIndex for non-chunked objects
+	AccountID  string    `json:"account_id"`
+	RemoteID   string    `json:"remote_id,omitempty"`
+	RemotePath string    `json:"remote_path,omitempty"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
+// Account authentication types
+const (
+	AuthTypeDelegated         = "delegated"          // user consent, tokens refreshed with a refresh token
+	AuthTypeClientCredentials = "client_credentials" // app-only tokens, no user consent
+)
+
 // StorageAccount represents a OneDrive storage account
 type StorageAccount struct {
 	ID           string    `json:"id"`
@@ -159,6 +318,10 @@ type StorageAccount struct {
 	UsedSpace    int64     `json:"used_space"`
 	Status       string    `json:"status"`
 	Priority     int       `json:"priority"`
+	Tags         []string  `json:"tags,omitempty"`
+	AuthType     string    `json:"auth_type,omitempty"`  // "delegated" (default) or "client_credentials"
+	DriveType    string    `json:"drive_type,omitempty"` // "me" (default), "drive", "user" or "site"
+	DriveID      string    `json:"drive_id,omitempty"`   // drive ID, user ID or UPN, or site ID, depending on drive_type
 	LastSync     time.Time `json:"last_sync,omitempty"`
 	ErrorMessage string    `json:"error_message,omitempty"`
 	CreatedAt    time.Time `json:"created_at"`
@@ -172,6 +335,10 @@ type VirtualDirectory struct {
 	ParentID  *string   `json:"parent_id,omitempty"`
 	Name      string    `json:"name"`
 	FullPath  string    `json:"full_path"`
+	OwnerID   string    `json:"owner_id,omitempty"`
+	TotalSize int64     `json:"total_size"` // of all files below, at any depth
+	FileCount int64     `json:"file_count"`
+	DirCount  int64     `json:"dir_count"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -185,23 +352,78 @@ type VirtualFile struct {
 	ObjectKey   string    `json:"object_key"`
 	Size        int64     `json:"size"`
 	MimeType    string    `json:"mime_type,omitempty"`
+	OwnerID     string    `json:"owner_id,omitempty"`
+	Version     int       `json:"version,omitempty"`      // number of the current content, counting overwrites
+	ContentHash string    `json:"content_hash,omitempty"` // hex SHA-256 of the content, when known
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 }
 
+// FileVersion is a previous content of a VFS file, kept when the file is overwritten
+type FileVersion struct {
+	ID          string    `json:"id"`
+	FileID      string    `json:"file_id"`
+	Bucket      string    `json:"bucket"`
+	Version     int       `json:"version"`
+	ObjectKey   string    `json:"-"`
+	Size        int64     `json:"size"`
+	MimeType    string    `json:"mime_type,omitempty"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`  // when this content was uploaded
+	ArchivedAt  time.Time `json:"archived_at"` // when it was replaced
+}
+
+// VersionRules limit the previous versions kept per file in a bucket. Zero means no limit.
+type VersionRules struct {
+	Bucket       string `json:"bucket"`
+	KeepVersions int    `json:"keep_versions"`
+	KeepDays     int    `json:"keep_days"`
+	Default      bool   `json:"default"` // the bucket has no rules of its own
+}
+
 // VFSItem represents a virtual file system item (file or directory)
 type VFSItem struct {
 	ID        string     `json:"id"`
 	Name      string     `json:"name"`
 	Path      string     `json:"path"`
-	Type      string     `json:"type"` // "file" or "directory"
-	Size      int64      `json:"size,omitempty"`
+	Type      string     `json:"type"`           // "file" or "directory"
+	Size      int64      `json:"size,omitempty"` // for directories, the total of all files below
 	MimeType  string     `json:"mime_type,omitempty"`
+	FileCount int64      `json:"file_count,omitempty"` // directories only: files below, at any depth
+	DirCount  int64      `json:"dir_count,omitempty"`  // directories only: subdirectories, at any depth
 	CreatedAt time.Time  `json:"created_at"`
 	UpdatedAt *time.Time `json:"updated_at,omitempty"`
 	IsStarred bool       `json:"is_starred,omitempty"`
 }
 
+// ListOptions select a page of a directory listing. Directories always come before files.
+type ListOptions struct {
+	Sort       string // "name" (default), "size", "mtime" or "type" (MIME type)
+	Desc       bool
+	Limit      int      // 0 for every item
+	Cursor     string   // next_cursor of the previous page
+	Type       string   // "file" or "directory" to list only those, empty for both
+	Extensions []string // lower-case file extensions without the dot; only files match them
+}
+
+// ListCursor is the position of an item in a sorted directory listing
+type ListCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d,omitempty"`
+	Kind int    `json:"k"` // 0 for directories, 1 for files
+	Key  string `json:"v"` // the item's sort value
+	Name string `json:"n"`
+	ID   string `json:"i"`
+}
+
+// DirectoryPage is a page of a directory listing
+type DirectoryPage struct {
+	Path       string    `json:"path"`
+	Items      []VFSItem `json:"items"`
+	Total      int64     `json:"total"`                 // items matching the filters on all pages
+	NextCursor string    `json:"next_cursor,omitempty"` // empty on the last page
+}
+
 // StarredFile represents a starred/favorited file
 type StarredFile struct {
 	ID        string    `json:"id"`
@@ -222,10 +444,86 @@ type TrashItem struct {
 	ObjectKey    string    `json:"object_key,omitempty"`
 	Size         int64     `json:"size,omitempty"`
 	MimeType     string    `json:"mime_type,omitempty"`
+	OwnerID      string    `json:"owner_id,omitempty"`
 	DeletedAt    time.Time `json:"deleted_at"`
 	ExpiresAt    time.Time `json:"expires_at"`
 }
 
+// TrashEntry is a file or directory deleted with a directory in the trash. Its path is
+// relative to that directory.
+type TrashEntry struct {
+	Type      string `json:"type"` // "file" or "directory"
+	ID        string `json:"id"`
+	Path      string `json:"path"`
+	Name      string `json:"name"`
+	ObjectKey string `json:"object_key,omitempty"`
+	Size      int64  `json:"size,omitempty"`
+	MimeType  string `json:"mime_type,omitempty"`
+	OwnerID   string `json:"owner_id,omitempty"`
+}
+
+// CopyJob is a directory copy in progress. Its checkpoint is recorded after every file,
+// so a copy interrupted by a restart resumes where it stopped.
+type CopyJob struct {
+	ID          string    `json:"id"`
+	Bucket      string    `json:"bucket"`
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	OwnerID     string    `json:"owner_id,omitempty"`
+	Conflict    string    `json:"conflict,omitempty"` // policy applied to a directory at the destination
+	Checkpoint  string    `json:"checkpoint"`         // full path of the last source file copied
+	Copied      int64     `json:"copied"`
+	Total       int64     `json:"total"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ObjectDeletion is an object queued for deletion from storage after its last reference
+// was removed
+type ObjectDeletion struct {
+	Bucket    string    `json:"bucket"`
+	ObjectKey string    `json:"object_key"`
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+// BatchRequest is a list of operations run one after another in a single task
+type BatchRequest struct {
+	Operations  []BatchOperation `json:"operations"`
+	StopOnError bool             `json:"stop_on_error"`      // skip the remaining operations after a failure
+	Conflict    string           `json:"conflict,omitempty"` // policy for operations that set none
+}
+
+// BatchOperation is one operation of a batch. Moves and copies take a source and a
+// destination, the others a path.
+type BatchOperation struct {
+	Op          string `json:"op"` // "move", "copy", "delete", "star" or "unstar"
+	Path        string `json:"path,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+	Recursive   bool   `json:"recursive,omitempty"` // needed to delete a non-empty directory
+	Conflict    string `json:"conflict,omitempty"`  // moves and copies: "fail", "overwrite", "rename", "skip" or "merge"
+}
+
+// BatchResult is the outcome of one operation of a batch
+type BatchResult struct {
+	Index       int    `json:"index"`
+	Op          string `json:"op"`
+	Status      string `json:"status"`                // "succeeded", "failed" or "skipped"
+	Destination string `json:"destination,omitempty"` // where a moved or copied item ended up
+	Conflict    string `json:"conflict,omitempty"`    // policy applied to a conflict at the destination
+	Code        string `json:"code,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// ArchiveRequest selects files and directories to download as one archive
+type ArchiveRequest struct {
+	Paths   []string `json:"paths"`
+	Format  string   `json:"format,omitempty"`  // "zip" (default) or "tar.gz"
+	Name    string   `json:"name,omitempty"`    // file name of the download, without extension
+	Include []string `json:"include,omitempty"` // glob patterns of the files to keep
+	Exclude []string `json:"exclude,omitempty"` // glob patterns of the entries to leave out
+}
+
 // RecentFile represents a recently accessed file
 type RecentFile struct {
 	ID         string    `json:"id"`
@@ -258,12 +556,13 @@ type AuditReport struct {
 	TotalChunks   int64         `json:"total_chunks"`
 	CheckedCount  int64         `json:"checked_count"`
 	Issues        []AuditIssue  `json:"issues"`
+	RepairTasks   []string      `json:"repair_tasks,omitempty"`
 	Summary       string        `json:"summary,omitempty"`
 }
 
 // AuditIssue represents an issue found during audit
 type AuditIssue struct {
-	Type        string `json:"type"` // "missing_file", "invalid_token", "size_mismatch"
+	Type        string `json:"type"` // "missing_file", "missing_replica", "missing_shard", "under_replicated", "invalid_token", "size_mismatch"
 	Bucket      string `json:"bucket"`
 	Key         string `json:"key"`
 	ChunkIndex  *int   `json:"chunk_index,omitempty"`
@@ -271,3 +570,326 @@ type AuditIssue struct {
 	RemoteID    string `json:"remote_id,omitempty"`
 	Description string `json:"description"`
 }
+
+// MigrationResult summarizes moving data off a storage account
+type MigrationResult struct {
+	AccountID string   `json:"account_id"`
+	Total     int64    `json:"total"`
+	Moved     int64    `json:"moved"`
+	Failed    int64    `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// RebalanceMove describes moving an object or chunk from one account to another
+type RebalanceMove struct {
+	Bucket        string `json:"bucket"`
+	Key           string `json:"key"`
+	ChunkID       string `json:"chunk_id,omitempty"` // empty for a non-chunked object
+	ChunkIndex    *int   `json:"chunk_index,omitempty"`
+	Size          int64  `json:"size"`
+	FromAccountID string `json:"from_account_id"`
+	ToAccountID   string `json:"to_account_id"`
+}
+
+// AccountTarget describes the current, target and planned usage of an account
+type AccountTarget struct {
+	AccountID    string `json:"account_id"`
+	Name         string `json:"name"`
+	TotalSpace   int64  `json:"total_space"`
+	UsedSpace    int64  `json:"used_space"`
+	TargetSpace  int64  `json:"target_space"`
+	PlannedSpace int64  `json:"planned_space"` // usage once the planned moves are done
+}
+
+// RebalancePlan lists the moves that even out usage across accounts
+type RebalancePlan struct {
+	Tolerance  float64         `json:"tolerance"`
+	Balanced   bool            `json:"balanced"` // every account is within tolerance once the moves are done
+	TotalBytes int64           `json:"total_bytes"`
+	Accounts   []AccountTarget `json:"accounts"`
+	Moves      []RebalanceMove `json:"moves"`
+}
+
+// AccountDefinition describes an account in an import or export file
+type AccountDefinition struct {
+	Name         string   `json:"name" yaml:"name"`
+	Email        string   `json:"email" yaml:"email"`
+	ClientID     string   `json:"client_id" yaml:"client_id"`
+	ClientSecret string   `json:"client_secret,omitempty" yaml:"client_secret,omitempty"`
+	TenantID     string   `json:"tenant_id,omitempty" yaml:"tenant_id,omitempty"`
+	RefreshToken string   `json:"refresh_token,omitempty" yaml:"refresh_token,omitempty"`
+	Priority     int      `json:"priority,omitempty" yaml:"priority,omitempty"`
+	Tags         []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	AuthType     string   `json:"auth_type,omitempty" yaml:"auth_type,omitempty"`
+	DriveType    string   `json:"drive_type,omitempty" yaml:"drive_type,omitempty"`
+	DriveID      string   `json:"drive_id,omitempty" yaml:"drive_id,omitempty"`
+	Status       string   `json:"status,omitempty" yaml:"status,omitempty"` // informational, ignored on import
+}
+
+// AccountManifest is the file format of account imports and exports
+type AccountManifest struct {
+	Version  int                 `json:"version" yaml:"version"`
+	Secrets  string              `json:"secrets,omitempty" yaml:"secrets,omitempty"` // "redacted" or "encrypted" on export
+	Accounts []AccountDefinition `json:"accounts" yaml:"accounts"`
+}
+
+// AccountImportResult is the outcome of importing one account definition
+type AccountImportResult struct {
+	Email      string `json:"email"`
+	ID         string `json:"id,omitempty"`
+	Result     string `json:"result"` // "created", "updated", "skipped", "failed"
+	Authorized bool   `json:"authorized"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AccountAuthStatus reports whether an account can reach OneDrive or still needs consent
+type AccountAuthStatus struct {
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	Status        string    `json:"status"`
+	HasToken      bool      `json:"has_refresh_token"`
+	TokenExpires  time.Time `json:"token_expires,omitempty"`
+	NeedsConsent  bool      `json:"needs_consent"`
+	Reason        string    `json:"reason,omitempty"`
+	AuthorizePath string    `json:"authorize_path,omitempty"`
+}
+
+// Device authorization states
+const (
+	DeviceAuthPending    = "pending"
+	DeviceAuthAuthorized = "authorized"
+	DeviceAuthDeclined   = "declined"
+	DeviceAuthExpired    = "expired"
+	DeviceAuthCancelled  = "cancelled"
+	DeviceAuthFailed     = "failed"
+)
+
+// DeviceAuthorization describes an OAuth2 device code flow in progress
+type DeviceAuthorization struct {
+	AccountID       string    `json:"account_id"`
+	UserCode        string    `json:"user_code"`
+	VerificationURI string    `json:"verification_uri"`
+	Message         string    `json:"message,omitempty"`
+	Interval        int       `json:"interval"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Status          string    `json:"status"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// API key scopes. Each scope includes the ones before it.
+const (
+	ScopeRead  = "read"  // list and download
+	ScopeWrite = "write" // upload, change and delete data
+	ScopeAdmin = "admin" // manage accounts, keys, space and background jobs
+)
+
+// APIKey represents an API key. Only a hash of the key is stored; the key itself is
+// returned once, when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // first characters of the key, to recognize it
+	Key        string     `json:"key,omitempty"`
+	KeyHash    string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Buckets    []string   `json:"buckets,omitempty"` // buckets the key is limited to, all buckets when empty
+	UserID     string     `json:"user_id,omitempty"` // user the key acts for, none for service keys
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreateAPIKeyRequest is the request body for creating an API key
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Buckets   []string   `json:"buckets,omitempty"`
+	UserID    string     `json:"user_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// User represents a person who signs in to the API
+type User struct {
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email,omitempty"`
+	DisplayName  string     `json:"display_name,omitempty"`
+	IsAdmin      bool       `json:"is_admin"`
+	PasswordHash string     `json:"-"`
+	OIDCSubject  string     `json:"-"` // "<issuer>|<sub>" of users who sign in with OIDC
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// UserRequest is the request body for creating or updating a user
+type UserRequest struct {
+	Username    string `json:"username"`
+	Email       string `json:"email,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Password    string `json:"password,omitempty"`
+	OIDCSubject string `json:"oidc_subject,omitempty"` // "sub" claim of the OIDC account to link
+	IsAdmin     *bool  `json:"is_admin,omitempty"`
+}
+
+// Session represents a login session. Only a hash of the token is stored.
+type Session struct {
+	Token     string    `json:"token,omitempty"`
+	TokenHash string    `json:"-"`
+	UserID    string    `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Principal is the authenticated caller of a request: a user signed in with a session,
+// or an API key, which may act for a user
+type Principal struct {
+	UserID  string   // empty for the bootstrap admin key and service keys
+	KeyID   string   // empty for sessions
+	Scopes  []string // scopes granted, see ScopeRead, ScopeWrite and ScopeAdmin
+	Buckets []string // buckets the caller is limited to, all buckets when empty
+}
+
+// HasScope reports whether the principal has a scope
+func (p *Principal) HasScope(scope string) bool {
+	rank := map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+	for _, s := range p.Scopes {
+		if rank[s] >= rank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// IsBucketScoped reports whether the principal is limited to some buckets
+func (p *Principal) IsBucketScoped() bool {
+	return len(p.Buckets) > 0
+}
+
+// AllowsBucket reports whether the principal's bucket scope includes a bucket
+func (p *Principal) AllowsBucket(bucket string) bool {
+	if !p.IsBucketScoped() {
+		return true
+	}
+	for _, b := range p.Buckets {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// SeesAll reports whether the principal is not limited to what a user owns:
+// admins, and keys that do not act for a user
+func (p *Principal) SeesAll() bool {
+	return p.UserID == "" || p.HasScope(ScopeAdmin)
+}
+
+// CanAccessOwned reports whether the principal may access something owned by ownerID
+func (p *Principal) CanAccessOwned(ownerID string) bool {
+	return p.SeesAll() || p.UserID == ownerID
+}
+
+// Roles granted on a bucket or a VFS directory. A role covers everything below the directory
+// it is granted on, and each role includes the ones before it.
+const (
+	RoleViewer = "viewer" // list and download
+	RoleEditor = "editor" // upload, move and delete
+	RoleOwner  = "owner"  // grant and revoke roles, delete the bucket
+)
+
+// ACLGrant represents a role granted to a user on a bucket or a VFS directory
+type ACLGrant struct {
+	ID          string    `json:"id"`
+	Bucket      string    `json:"bucket"`
+	Path        string    `json:"path"` // "/" for the whole bucket
+	DirectoryID string    `json:"directory_id,omitempty"`
+	UserID      string    `json:"user_id"`
+	Role        string    `json:"role"`
+	GrantedBy   string    `json:"granted_by,omitempty"`
+	Inherited   bool      `json:"inherited"` // granted on a directory above the path asked about
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GrantRequest is the request body for granting a role
+type GrantRequest struct {
+	Path   string `json:"path"`
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// UserRole is the effective role of a user on a path
+type UserRole struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// PathPermissions lists who can reach a bucket path and how
+type PathPermissions struct {
+	Bucket    string      `json:"bucket"`
+	Path      string      `json:"path"`
+	Role      string      `json:"role,omitempty"` // effective role of the caller
+	OwnerID   string      `json:"owner_id,omitempty"`
+	Grants    []*ACLGrant `json:"grants"`
+	Effective []UserRole  `json:"effective"`
+}
+
+// Share link modes
+const (
+	ShareModeRead   = "read"   // download and browse
+	ShareModeUpload = "upload" // also upload into a shared directory
+)
+
+// ShareLink represents a public link to a VFS file or directory. Only a hash of the token is
+// stored; the token and its URL are returned once, when the link is created.
+type ShareLink struct {
+	ID             string     `json:"id"`
+	Bucket         string     `json:"bucket"`
+	Path           string     `json:"path"`                // current path of the target, which the link follows when it moves
+	TargetType     string     `json:"target_type"`         // "file" or "directory"
+	TargetID       string     `json:"target_id,omitempty"` // empty for the bucket root
+	Token          string     `json:"token,omitempty"`
+	URL            string     `json:"url,omitempty"`
+	TokenHash      string     `json:"-"`
+	Prefix         string     `json:"prefix"` // first characters of the token, to recognize it
+	PasswordHash   string     `json:"-"`
+	HasPassword    bool       `json:"has_password"`
+	Mode           string     `json:"mode"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads   int        `json:"max_downloads,omitempty"` // 0 for no limit
+	Downloads      int64      `json:"downloads"`
+	Views          int64      `json:"views"`
+	Uploads        int64      `json:"uploads"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
+// CreateShareRequest is the request body for creating a share link
+type CreateShareRequest struct {
+	Path         string     `json:"path"`
+	Password     string     `json:"password,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads int        `json:"max_downloads,omitempty"`
+	Mode         string     `json:"mode,omitempty"` // defaults to ShareModeRead
+}
+
+// Share link access actions
+const (
+	ShareAccessView     = "view"
+	ShareAccessDownload = "download"
+	ShareAccessUpload   = "upload"
+	ShareAccessDenied   = "denied" // wrong or missing password, expired link or download limit reached
+)
+
+// ShareAccess records one use of a share link
+type ShareAccess struct {
+	ID         string    `json:"id"`
+	ShareID    string    `json:"share_id"`
+	Action     string    `json:"action"`
+	Path       string    `json:"path,omitempty"` // relative to the shared item
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
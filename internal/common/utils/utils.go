@@ -68,6 +68,16 @@ func defaultConfig() *types.Config {
 			LoadBalance: types.LoadBalanceConfig{
 				Strategy:            "least_used",
 				HealthCheckInterval: 60,
+				LatencyAlpha:        0.3,
+				AffinityAccounts:    2,
+				CircuitBreaker: types.CircuitBreakerConfig{
+					WindowSize:          20,
+					MinCalls:            5,
+					FailureRate:         0.5,
+					ConsecutiveFailures: 5,
+					SlowCallThreshold:   30000,
+					OpenTimeout:         30000,
+				},
 			},
 			Retry: types.RetryConfig{
 				MaxAttempts:  3,
@@ -75,11 +85,29 @@ func defaultConfig() *types.Config {
 				MaxDelay:     30000,
 				Multiplier:   2,
 			},
+			Rebalance: types.RebalanceConfig{
+				Tolerance:         0.05,
+				BatchSize:         20,
+				MaxMoves:          1000,
+				MaxBytesPerSecond: 10485760, // 10MB/s
+			},
+			Trash: types.TrashConfig{
+				RetentionDays: 30,
+				PurgeInterval: 3600, // hourly
+			},
+			Versions: types.VersionConfig{
+				KeepVersions:  10,
+				KeepDays:      0,
+				PruneInterval: 3600, // hourly
+			},
 		},
 		Token: types.TokenConfig{
 			RefreshBeforeExpire:  300,
 			RefreshCheckInterval: 60,
 		},
+		Auth: types.AuthConfig{
+			SessionTTL: 168, // 7 days
+		},
 		Logging: types.LoggingConfig{
 			Level:  "info",
 			Format: "json",
@@ -96,6 +124,15 @@ func applyEnvOverrides(config *types.Config) {
 	if redisPassword := os.Getenv("REDIS_PASSWORD"); redisPassword != "" {
 		config.Cache.Redis.Password = redisPassword
 	}
+	if adminKey := os.Getenv("API_ADMIN_KEY"); adminKey != "" {
+		config.Auth.AdminKey = adminKey
+	}
+	if stateSecret := os.Getenv("OAUTH_STATE_SECRET"); stateSecret != "" {
+		config.Server.OAuthStateSecret = stateSecret
+	}
+	if oidcSecret := os.Getenv("OIDC_CLIENT_SECRET"); oidcSecret != "" {
+		config.Auth.OIDC.ClientSecret = oidcSecret
+	}
 }
 
 // ValidateBucketName validates bucket name format
@@ -0,0 +1,64 @@
+// Package acl resolves the roles users hold on bucket paths from the grants made on
+// buckets and VFS directories.
+package acl
+
+import (
+	"strings"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+var roleRank = map[string]int{
+	types.RoleViewer: 1,
+	types.RoleEditor: 2,
+	types.RoleOwner:  3,
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// AtLeast reports whether role includes want. The empty role includes nothing.
+func AtLeast(role, want string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[want]
+}
+
+// Stronger returns the stronger of two roles
+func Stronger(a, b string) string {
+	if roleRank[b] > roleRank[a] {
+		return b
+	}
+	return a
+}
+
+// Covers reports whether a grant on dir applies to path, that is whether path is dir
+// or lies below it
+func Covers(dir, path string) bool {
+	dir = clean(dir)
+	path = clean(path)
+	if dir == "/" || dir == path {
+		return true
+	}
+	return strings.HasPrefix(path, dir+"/")
+}
+
+// Effective returns the strongest role the grants give on path, empty when none applies
+func Effective(grants []*types.ACLGrant, path string) string {
+	role := ""
+	for _, g := range grants {
+		if Covers(g.Path, path) {
+			role = Stronger(role, g.Role)
+		}
+	}
+	return role
+}
+
+// clean gives a path a leading slash and drops a trailing one
+func clean(path string) string {
+	path = strings.TrimSuffix(path, "/")
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
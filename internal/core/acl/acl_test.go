@@ -0,0 +1,95 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+func TestAtLeast(t *testing.T) {
+	tests := []struct {
+		role, want string
+		expected   bool
+	}{
+		{types.RoleViewer, types.RoleViewer, true},
+		{types.RoleViewer, types.RoleEditor, false},
+		{types.RoleEditor, types.RoleViewer, true},
+		{types.RoleOwner, types.RoleEditor, true},
+		{types.RoleEditor, types.RoleOwner, false},
+		{"", types.RoleViewer, false},
+		{"superuser", types.RoleViewer, false},
+	}
+
+	for _, tt := range tests {
+		if got := AtLeast(tt.role, tt.want); got != tt.expected {
+			t.Errorf("AtLeast(%q, %q) = %v, want %v", tt.role, tt.want, got, tt.expected)
+		}
+	}
+}
+
+func TestCovers(t *testing.T) {
+	tests := []struct {
+		dir, path string
+		expected  bool
+	}{
+		{"/", "/", true},
+		{"/", "/a/b.txt", true},
+		{"/docs", "/docs", true},
+		{"/docs", "/docs/", true},
+		{"/docs", "/docs/a/b.txt", true},
+		{"/docs/", "/docs/a", true},
+		{"docs", "/docs/a", true},
+		{"/docs", "/documents", false},
+		{"/docs", "/", false},
+		{"/docs/a", "/docs", false},
+	}
+
+	for _, tt := range tests {
+		if got := Covers(tt.dir, tt.path); got != tt.expected {
+			t.Errorf("Covers(%q, %q) = %v, want %v", tt.dir, tt.path, got, tt.expected)
+		}
+	}
+}
+
+func TestEffective(t *testing.T) {
+	grants := []*types.ACLGrant{
+		{Path: "/", Role: types.RoleViewer},
+		{Path: "/team", Role: types.RoleEditor},
+		{Path: "/team/private", Role: types.RoleViewer},
+		{Path: "/team/shared", Role: types.RoleOwner},
+	}
+
+	tests := []struct {
+		path, expected string
+	}{
+		{"/", types.RoleViewer},
+		{"/other/file.txt", types.RoleViewer},
+		{"/team", types.RoleEditor},
+		// A weaker grant lower down does not take away an inherited role
+		{"/team/private/file.txt", types.RoleEditor},
+		{"/team/shared/deep/file.txt", types.RoleOwner},
+	}
+
+	for _, tt := range tests {
+		if got := Effective(grants, tt.path); got != tt.expected {
+			t.Errorf("Effective(%q) = %q, want %q", tt.path, got, tt.expected)
+		}
+	}
+
+	if got := Effective(grants[1:], "/other"); got != "" {
+		t.Errorf("Effective(/other) = %q, want none", got)
+	}
+}
+
+func TestValidRole(t *testing.T) {
+	for _, role := range []string{types.RoleViewer, types.RoleEditor, types.RoleOwner} {
+		if !ValidRole(role) {
+			t.Errorf("ValidRole(%q) = false", role)
+		}
+	}
+	for _, role := range []string{"", "admin", "Viewer"} {
+		if ValidRole(role) {
+			t.Errorf("ValidRole(%q) = true", role)
+		}
+	}
+}
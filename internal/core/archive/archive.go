@@ -0,0 +1,135 @@
+// Package archive writes files and directories as streamed zip or gzipped tar archives
+// and selects their entries with glob patterns.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"time"
+)
+
+// Archive formats
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+// ValidFormat reports whether archives can be written in a format
+func ValidFormat(format string) bool {
+	return format == FormatZip || format == FormatTarGz
+}
+
+// Extension returns the file name extension of a format, with the dot
+func Extension(format string) string {
+	return "." + format
+}
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	if format == FormatTarGz {
+		return "application/gzip"
+	}
+	return "application/zip"
+}
+
+// Writer writes the entries of an archive one after another, streaming file content
+// without buffering it. Entry names are slash-separated paths inside the archive.
+type Writer interface {
+	// Dir adds an entry for a directory, so it survives when empty
+	Dir(name string, modified time.Time) error
+	// File adds a file of size bytes with the content read from r
+	File(name string, size int64, modified time.Time, r io.Reader) error
+	// Close writes what ends the archive; it does not close the underlying writer
+	Close() error
+}
+
+// NewWriter returns a writer of archives in a format, FormatZip if unknown
+func NewWriter(format string, w io.Writer) Writer {
+	if format == FormatTarGz {
+		gz := gzip.NewWriter(w)
+		return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz)}
+	}
+	return &zipWriter{zw: zip.NewWriter(w)}
+}
+
+// zipWriter writes zip archives. Entries are streamed with data descriptors; files of
+// 4 GiB or more and archives of more than 65535 entries use ZIP64.
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) Dir(name string, modified time.Time) error {
+	_, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name + "/",
+		Modified: modified,
+	})
+	return err
+}
+
+func (z *zipWriter) File(name string, size int64, modified time.Time, r io.Reader) error {
+	// The sizes go into the data descriptor and central directory once the content is
+	// written, in ZIP64 fields when they pass 4 GiB
+	entry, err := z.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(entry, r)
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// tarGzWriter writes gzipped tar archives in the PAX format, which has no limit on
+// file sizes or name lengths
+type tarGzWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (t *tarGzWriter) Dir(name string, modified time.Time) error {
+	return t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modified,
+		Format:   tar.FormatPAX,
+	})
+}
+
+func (t *tarGzWriter) File(name string, size int64, modified time.Time, r io.Reader) error {
+	err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0644,
+		ModTime:  modified,
+		Format:   tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	// The header announced size bytes, so content of another length breaks the archive
+	n, err := io.Copy(t.tw, r)
+	if err == nil && n != size {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (t *tarGzWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
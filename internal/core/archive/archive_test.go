@@ -0,0 +1,81 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeEntries(t *testing.T, format string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(format, &buf)
+	modified := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := w.Dir("docs", modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.File("docs/a.txt", 5, modified, strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipWriter(t *testing.T) {
+	data := writeEntries(t, FormatZip)
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != 2 || zr.File[0].Name != "docs/" || zr.File[1].Name != "docs/a.txt" {
+		t.Fatalf("unexpected entries: %v", zr.File)
+	}
+	rc, err := zr.File[1].Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	content, _ := io.ReadAll(rc)
+	if string(content) != "hello" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestTarGzWriter(t *testing.T) {
+	data := writeEntries(t, FormatTarGz)
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+
+	header, err := tr.Next()
+	if err != nil || header.Name != "docs/" || header.Typeflag != tar.TypeDir {
+		t.Fatalf("first entry = %v, %v", header, err)
+	}
+	header, err = tr.Next()
+	if err != nil || header.Name != "docs/a.txt" || header.Size != 5 {
+		t.Fatalf("second entry = %v, %v", header, err)
+	}
+	content, _ := io.ReadAll(tr)
+	if string(content) != "hello" {
+		t.Errorf("content = %q", content)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the archive, got %v", err)
+	}
+}
+
+func TestTarGzWriterShortContent(t *testing.T) {
+	w := NewWriter(FormatTarGz, io.Discard)
+	if err := w.File("a.txt", 10, time.Now(), strings.NewReader("short")); err == nil {
+		t.Error("File accepted content shorter than its size")
+	}
+}
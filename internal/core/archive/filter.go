@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter selects the entries of an archive by glob patterns on their names. Patterns
+// follow path.Match, with "**" matching any number of directories; a pattern without a
+// slash matches names at any depth, so "*.jpg" selects every JPEG. A nil Filter keeps all.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+// NewFilter returns a filter keeping files that match one of the include patterns, or
+// all files if there are none, unless they match an exclude pattern. An excluded
+// directory excludes everything below it.
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.include, err = normalizePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = normalizePatterns(exclude); err != nil {
+		return nil, err
+	}
+	if len(f.include) == 0 && len(f.exclude) == 0 {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// normalizePatterns checks patterns and anchors those without a slash at any depth
+func normalizePatterns(patterns []string) ([]string, error) {
+	var normalized []string
+	for _, pattern := range patterns {
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q", pattern)
+		}
+		if !strings.Contains(pattern, "/") {
+			pattern = "**/" + pattern
+		}
+		normalized = append(normalized, pattern)
+	}
+	return normalized, nil
+}
+
+// File reports whether a file is kept
+func (f *Filter) File(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.excluded(name) {
+		return false
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if Match(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dir reports whether a directory gets an entry of its own. With include patterns only
+// the directories of the kept files are in the archive, implied by the files' names.
+func (f *Filter) Dir(name string) bool {
+	if f == nil {
+		return true
+	}
+	return len(f.include) == 0 && !f.excluded(name)
+}
+
+// excluded reports whether an entry or one of the directories it is in matches an
+// exclude pattern
+func (f *Filter) excluded(name string) bool {
+	for _, pattern := range f.exclude {
+		for p := name; p != "."; p = path.Dir(p) {
+			if Match(pattern, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether a slash-separated name matches a pattern in which "**" matches
+// any number of path elements, including none
+func Match(pattern, name string) bool {
+	return matchParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchParts(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchParts(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
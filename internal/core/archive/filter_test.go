@@ -0,0 +1,77 @@
+package archive
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.jpg", "a.jpg", true},
+		{"*.jpg", "photos/a.jpg", false},
+		{"photos/*.jpg", "photos/a.jpg", true},
+		{"photos/*.jpg", "photos/2024/a.jpg", false},
+		{"photos/**/*.jpg", "photos/a.jpg", true},
+		{"photos/**/*.jpg", "photos/2024/06/a.jpg", true},
+		{"photos/**", "photos/2024/a.jpg", true},
+		{"**/node_modules", "web/node_modules", true},
+		{"**/node_modules", "node_modules", true},
+		{"**", "anything/at/all", true},
+		{"docs/?.md", "docs/a.md", true},
+		{"docs/?.md", "docs/ab.md", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := NewFilter([]string{"*.jpg", "docs/**"}, []string{"tmp", "*.tmp.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]bool{
+		"a.jpg":             true,
+		"photos/2024/b.jpg": true,
+		"docs/readme.md":    true,
+		"notes.txt":         false,
+		"tmp/c.jpg":         false,
+		"photos/tmp/d.jpg":  false,
+		"photos/e.tmp.jpg":  false,
+	}
+	for name, want := range files {
+		if got := f.File(name); got != want {
+			t.Errorf("File(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if f.Dir("photos") {
+		t.Error("Dir with include patterns = true")
+	}
+
+	f, err = NewFilter(nil, []string{"node_modules"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Dir("web") || f.Dir("web/node_modules") || f.Dir("web/node_modules/x") {
+		t.Error("Dir does not prune excluded directories")
+	}
+	if !f.File("web/index.js") || f.File("web/node_modules/x/index.js") {
+		t.Error("File does not prune excluded directories")
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	f, err := NewFilter([]string{" ", "/"}, nil)
+	if err != nil || f != nil {
+		t.Errorf("NewFilter of blank patterns = %v, %v; want nil, nil", f, err)
+	}
+	if !f.File("any") || !f.Dir("any") {
+		t.Error("nil filter does not keep everything")
+	}
+	if _, err := NewFilter([]string{"[a-"}, nil); err == nil {
+		t.Error("NewFilter accepted a malformed pattern")
+	}
+}
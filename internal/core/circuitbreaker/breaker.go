@@ -0,0 +1,324 @@
+package circuitbreaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// State represents the state of a circuit breaker
+type State string
+
+const (
+	// StateClosed lets all calls through
+	StateClosed State = "closed"
+	// StateOpen rejects calls until the open timeout elapses
+	StateOpen State = "open"
+	// StateHalfOpen lets a single probe call through to test recovery
+	StateHalfOpen State = "half_open"
+)
+
+// Config holds circuit breaker configuration
+type Config struct {
+	WindowSize          int           // Number of recent calls used to compute the failure rate
+	MinCalls            int           // Minimum calls in the window before the failure rate is evaluated
+	FailureRate         float64       // Failure ratio (0-1) that opens the breaker
+	ConsecutiveFailures int           // Consecutive failures that open the breaker regardless of rate
+	SlowCallThreshold   time.Duration // Successful calls slower than this count as failures (0 disables)
+	OpenTimeout         time.Duration // How long the breaker stays open before probing
+}
+
+// DefaultConfig returns default circuit breaker configuration
+func DefaultConfig() *Config {
+	return &Config{
+		WindowSize:          20,
+		MinCalls:            5,
+		FailureRate:         0.5,
+		ConsecutiveFailures: 5,
+		SlowCallThreshold:   30 * time.Second,
+		OpenTimeout:         30 * time.Second,
+	}
+}
+
+// FromConfig builds a breaker configuration from application config, using defaults for unset values
+func FromConfig(cfg types.CircuitBreakerConfig) *Config {
+	config := DefaultConfig()
+	if cfg.WindowSize > 0 {
+		config.WindowSize = cfg.WindowSize
+	}
+	if cfg.MinCalls > 0 {
+		config.MinCalls = cfg.MinCalls
+	}
+	if cfg.FailureRate > 0 {
+		config.FailureRate = cfg.FailureRate
+	}
+	if cfg.ConsecutiveFailures > 0 {
+		config.ConsecutiveFailures = cfg.ConsecutiveFailures
+	}
+	if cfg.SlowCallThreshold > 0 {
+		config.SlowCallThreshold = time.Duration(cfg.SlowCallThreshold) * time.Millisecond
+	}
+	if cfg.OpenTimeout > 0 {
+		config.OpenTimeout = time.Duration(cfg.OpenTimeout) * time.Millisecond
+	}
+	return config
+}
+
+// Breaker tracks recent call outcomes for a single account
+type Breaker struct {
+	config      *Config
+	mu          sync.Mutex
+	state       State
+	outcomes    []bool // ring buffer, true = failure
+	next        int
+	filled      int
+	consecutive int
+	openedAt    time.Time
+	probing     bool      // a half-open probe is in flight
+	probedAt    time.Time // when the probe was let through
+	lastError   string
+	lastLatency time.Duration
+	now         func() time.Time
+}
+
+// NewBreaker creates a new circuit breaker
+func NewBreaker(config *Config) *Breaker {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	windowSize := config.WindowSize
+	if windowSize <= 0 {
+		windowSize = 1
+	}
+	return &Breaker{
+		config:   config,
+		state:    StateClosed,
+		outcomes: make([]bool, windowSize),
+		now:      time.Now,
+	}
+}
+
+// Allow reports whether a call may be attempted, and reserves the probe when it lets one
+// through. An open breaker moves to half-open once the open timeout has elapsed; a
+// half-open breaker lets a single probe through at a time, and another once the probe
+// has gone unrecorded for the open timeout.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.available() {
+		return false
+	}
+	if b.state != StateClosed {
+		b.state = StateHalfOpen
+		b.probing = true
+		b.probedAt = b.now()
+	}
+	return true
+}
+
+// Available reports whether Allow would let a call through, without changing the breaker
+func (b *Breaker) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available()
+}
+
+func (b *Breaker) available() bool {
+	switch b.state {
+	case StateOpen:
+		return b.now().Sub(b.openedAt) >= b.config.OpenTimeout
+	case StateHalfOpen:
+		return !b.probing || b.now().Sub(b.probedAt) >= b.config.OpenTimeout
+	default:
+		return true
+	}
+}
+
+// Record records the outcome of a call
+func (b *Breaker) Record(err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := err != nil
+	if !failed && b.config.SlowCallThreshold > 0 && latency > b.config.SlowCallThreshold {
+		failed = true
+	}
+
+	b.lastLatency = latency
+	if err != nil {
+		b.lastError = err.Error()
+	}
+
+	b.outcomes[b.next] = failed
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.filled < len(b.outcomes) {
+		b.filled++
+	}
+
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		// A single probe decides whether the account has recovered
+		if failed {
+			b.trip()
+		} else {
+			b.reset()
+		}
+	case StateClosed:
+		if b.shouldTrip() {
+			b.trip()
+		}
+	}
+}
+
+// shouldTrip checks whether the failure thresholds have been reached
+func (b *Breaker) shouldTrip() bool {
+	if b.config.ConsecutiveFailures > 0 && b.consecutive >= b.config.ConsecutiveFailures {
+		return true
+	}
+	if b.filled < b.config.MinCalls || b.config.FailureRate <= 0 {
+		return false
+	}
+	return b.failureRate() >= b.config.FailureRate
+}
+
+// failureRate returns the failure ratio over the recorded window
+func (b *Breaker) failureRate() float64 {
+	if b.filled == 0 {
+		return 0
+	}
+	failures := 0
+	for i := 0; i < b.filled; i++ {
+		if b.outcomes[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(b.filled)
+}
+
+// trip opens the breaker
+func (b *Breaker) trip() {
+	b.state = StateOpen
+	b.probing = false
+	b.openedAt = b.now()
+}
+
+// reset closes the breaker and clears the window
+func (b *Breaker) reset() {
+	b.state = StateClosed
+	b.probing = false
+	b.consecutive = 0
+	b.filled = 0
+	b.next = 0
+	for i := range b.outcomes {
+		b.outcomes[i] = false
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Snapshot describes the state of a breaker
+type Snapshot struct {
+	State               State      `json:"state"`
+	FailureRate         float64    `json:"failure_rate"`
+	Calls               int        `json:"calls"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastLatencyMs       int64      `json:"last_latency_ms"`
+}
+
+// Snapshot returns a point-in-time view of the breaker
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := Snapshot{
+		State:               b.state,
+		FailureRate:         b.failureRate(),
+		Calls:               b.filled,
+		ConsecutiveFailures: b.consecutive,
+		LastError:           b.lastError,
+		LastLatencyMs:       b.lastLatency.Milliseconds(),
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		snapshot.OpenedAt = &openedAt
+	}
+	return snapshot
+}
+
+// Registry holds one breaker per account
+type Registry struct {
+	config   *Config
+	breakers map[string]*Breaker
+	mu       sync.Mutex
+}
+
+// NewRegistry creates a new breaker registry
+func NewRegistry(config *Config) *Registry {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	return &Registry{
+		config:   config,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get returns the breaker for an account, creating it if necessary
+func (r *Registry) Get(accountID string) *Breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	breaker, exists := r.breakers[accountID]
+	if !exists {
+		breaker = NewBreaker(r.config)
+		r.breakers[accountID] = breaker
+	}
+	return breaker
+}
+
+// Allow reports whether a call to an account may be attempted, reserving the probe of a
+// recovering account
+func (r *Registry) Allow(accountID string) bool {
+	return r.Get(accountID).Allow()
+}
+
+// Available reports whether calls to an account would be allowed, without changing its breaker
+func (r *Registry) Available(accountID string) bool {
+	return r.Get(accountID).Available()
+}
+
+// Record records the outcome of a call to an account
+func (r *Registry) Record(accountID string, err error, latency time.Duration) {
+	r.Get(accountID).Record(err, latency)
+}
+
+// Snapshots returns the state of every known breaker keyed by account ID
+func (r *Registry) Snapshots() map[string]Snapshot {
+	r.mu.Lock()
+	breakers := make(map[string]*Breaker, len(r.breakers))
+	for id, breaker := range r.breakers {
+		breakers[id] = breaker
+	}
+	r.mu.Unlock()
+
+	snapshots := make(map[string]Snapshot, len(breakers))
+	for id, breaker := range breakers {
+		snapshots[id] = breaker.Snapshot()
+	}
+	return snapshots
+}
@@ -0,0 +1,175 @@
+package circuitbreaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func testConfig() *Config {
+	return &Config{
+		WindowSize:          10,
+		MinCalls:            4,
+		FailureRate:         0.5,
+		ConsecutiveFailures: 3,
+		SlowCallThreshold:   time.Second,
+		OpenTimeout:         time.Minute,
+	}
+}
+
+func TestBreaker_StartsClosed(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+
+	if breaker.State() != StateClosed {
+		t.Errorf("state = %v, want %v", breaker.State(), StateClosed)
+	}
+	if !breaker.Allow() {
+		t.Error("closed breaker should allow calls")
+	}
+}
+
+func TestBreaker_OpensOnConsecutiveFailures(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+	errUpstream := errors.New("API error (status: 503)")
+
+	breaker.Record(errUpstream, 0)
+	breaker.Record(errUpstream, 0)
+	if breaker.State() != StateClosed {
+		t.Fatalf("state = %v after 2 failures, want %v", breaker.State(), StateClosed)
+	}
+
+	breaker.Record(errUpstream, 0)
+	if breaker.State() != StateOpen {
+		t.Errorf("state = %v after 3 failures, want %v", breaker.State(), StateOpen)
+	}
+	if breaker.Allow() {
+		t.Error("open breaker should reject calls")
+	}
+}
+
+func TestBreaker_OpensOnFailureRate(t *testing.T) {
+	config := testConfig()
+	config.ConsecutiveFailures = 0
+	breaker := NewBreaker(config)
+	errUpstream := errors.New("timeout")
+
+	breaker.Record(nil, 0)
+	breaker.Record(errUpstream, 0)
+	breaker.Record(nil, 0)
+	if breaker.State() != StateClosed {
+		t.Fatalf("state = %v below MinCalls, want %v", breaker.State(), StateClosed)
+	}
+
+	breaker.Record(errUpstream, 0)
+	if breaker.State() != StateOpen {
+		t.Errorf("state = %v at 50%% failure rate, want %v", breaker.State(), StateOpen)
+	}
+}
+
+func TestBreaker_SlowCallsCountAsFailures(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+
+	for i := 0; i < 3; i++ {
+		breaker.Record(nil, 2*time.Second)
+	}
+
+	if breaker.State() != StateOpen {
+		t.Errorf("state = %v after slow calls, want %v", breaker.State(), StateOpen)
+	}
+}
+
+func TestBreaker_HalfOpenRecovery(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	errUpstream := errors.New("API error (status: 500)")
+
+	for i := 0; i < 3; i++ {
+		breaker.Record(errUpstream, 0)
+	}
+	if breaker.Allow() {
+		t.Fatal("breaker should reject calls before the open timeout")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a probe after the open timeout")
+	}
+	if breaker.State() != StateHalfOpen {
+		t.Fatalf("state = %v, want %v", breaker.State(), StateHalfOpen)
+	}
+
+	breaker.Record(nil, 10*time.Millisecond)
+	if breaker.State() != StateClosed {
+		t.Errorf("state = %v after successful probe, want %v", breaker.State(), StateClosed)
+	}
+}
+
+func TestBreaker_HalfOpenFailureReopens(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	errUpstream := errors.New("API error (status: 502)")
+
+	for i := 0; i < 3; i++ {
+		breaker.Record(errUpstream, 0)
+	}
+	now = now.Add(2 * time.Minute)
+	breaker.Allow()
+
+	breaker.Record(errUpstream, 0)
+	if breaker.State() != StateOpen {
+		t.Errorf("state = %v after failed probe, want %v", breaker.State(), StateOpen)
+	}
+}
+
+func TestBreaker_HalfOpenSingleProbe(t *testing.T) {
+	breaker := NewBreaker(testConfig())
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		breaker.Record(errors.New("API error (status: 503)"), 0)
+	}
+	now = now.Add(2 * time.Minute)
+
+	if !breaker.Available() || breaker.State() != StateOpen {
+		t.Fatal("Available should report the probe without taking it")
+	}
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a probe after the open timeout")
+	}
+	if breaker.Allow() || breaker.Available() {
+		t.Fatal("breaker should reject calls while the probe is in flight")
+	}
+
+	// A probe that never reports back is replaced after the open timeout
+	now = now.Add(2 * time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a new probe once the last one went unrecorded")
+	}
+}
+
+func TestRegistry_PerAccount(t *testing.T) {
+	registry := NewRegistry(testConfig())
+	errUpstream := errors.New("API error (status: 503)")
+
+	for i := 0; i < 3; i++ {
+		registry.Record("account-1", errUpstream, 0)
+	}
+
+	if registry.Allow("account-1") {
+		t.Error("account-1 should be rejected")
+	}
+	if !registry.Allow("account-2") {
+		t.Error("account-2 should be allowed")
+	}
+
+	snapshots := registry.Snapshots()
+	if snapshots["account-1"].State != StateOpen {
+		t.Errorf("account-1 state = %v, want %v", snapshots["account-1"].State, StateOpen)
+	}
+	if snapshots["account-1"].LastError == "" {
+		t.Error("account-1 snapshot should include last error")
+	}
+}
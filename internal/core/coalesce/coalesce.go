@@ -0,0 +1,36 @@
+// Package coalesce runs background passes one at a time without losing the requests
+// made while a pass is running.
+package coalesce
+
+import "sync"
+
+// Runner runs a pass at a time. A request made while a pass runs returns at once and
+// makes the running pass go again once it ends, so work it missed is not left behind;
+// any number of such requests cause a single further pass.
+type Runner struct {
+	mu      sync.Mutex
+	running bool
+	pending bool
+}
+
+// Run runs pass, or has the pass that is running go again after it ends
+func (r *Runner) Run(pass func()) {
+	r.mu.Lock()
+	if r.running {
+		r.pending = true
+		r.mu.Unlock()
+		return
+	}
+	r.running = true
+	for {
+		r.pending = false
+		r.mu.Unlock()
+		pass()
+		r.mu.Lock()
+		if !r.pending {
+			r.running = false
+			r.mu.Unlock()
+			return
+		}
+	}
+}
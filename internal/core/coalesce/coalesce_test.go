@@ -0,0 +1,91 @@
+package coalesce
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRunner_Sequential(t *testing.T) {
+	var r Runner
+	passes := 0
+	r.Run(func() { passes++ })
+	r.Run(func() { passes++ })
+	if passes != 2 {
+		t.Errorf("passes = %d, want 2", passes)
+	}
+}
+
+// A pass drains a queue in batches. An item queued after the pass listed its last batch
+// must still be drained before the pass gives up the runner.
+func TestRunner_RequestWhileFinishing(t *testing.T) {
+	var (
+		r     Runner
+		mu    sync.Mutex
+		queue = []string{"a"}
+	)
+	finishing := make(chan struct{})
+	resume := make(chan struct{})
+	first := true
+
+	drain := func() {
+		mu.Lock()
+		queue = nil
+		mu.Unlock()
+		if first {
+			// The last batch is listed; hold the pass while another item is queued
+			first = false
+			close(finishing)
+			<-resume
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(drain)
+		close(done)
+	}()
+
+	<-finishing
+	mu.Lock()
+	queue = append(queue, "b")
+	mu.Unlock()
+	r.Run(drain) // returns at once, the running pass goes again
+	close(resume)
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(queue) != 0 {
+		t.Errorf("queue = %v, want it drained", queue)
+	}
+}
+
+func TestRunner_CoalescesRequests(t *testing.T) {
+	var r Runner
+	started := make(chan struct{})
+	resume := make(chan struct{})
+	passes := 0
+
+	done := make(chan struct{})
+	go func() {
+		r.Run(func() {
+			passes++
+			if passes == 1 {
+				close(started)
+				<-resume
+			}
+		})
+		close(done)
+	}()
+
+	<-started
+	for i := 0; i < 5; i++ {
+		r.Run(func() { t.Error("a request ran a second pass at the same time") })
+	}
+	close(resume)
+	<-done
+
+	if passes != 2 {
+		t.Errorf("passes = %d, want 2", passes)
+	}
+}
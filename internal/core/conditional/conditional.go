@@ -0,0 +1,101 @@
+// Package conditional evaluates HTTP conditional request headers (RFC 9110 section 13)
+// against the current state of a resource.
+package conditional
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Result is the outcome of evaluating the preconditions of a request
+type Result int
+
+const (
+	// Proceed means the request may be performed
+	Proceed Result = iota
+	// NotModified means a GET or HEAD should be answered with 304 Not Modified
+	NotModified
+	// Failed means the request should be answered with 412 Precondition Failed
+	Failed
+)
+
+// Present reports whether a request carries any precondition header
+func Present(h http.Header) bool {
+	return h.Get("If-Match") != "" || h.Get("If-None-Match") != "" ||
+		h.Get("If-Modified-Since") != "" || h.Get("If-Unmodified-Since") != ""
+}
+
+// Evaluate evaluates the preconditions of a request in the order of RFC 9110 section 13.2.2.
+// etag is the quoted entity tag of the resource and modTime its last modification; exists is
+// false when the resource does not exist, in which case only "*" tags are considered.
+func Evaluate(method string, h http.Header, etag string, modTime time.Time, exists bool) Result {
+	readOnly := method == http.MethodGet || method == http.MethodHead
+
+	if ifMatch := h.Get("If-Match"); ifMatch != "" {
+		if !exists || !matches(ifMatch, etag, false) {
+			return Failed
+		}
+	} else if since, ok := parseTime(h.Get("If-Unmodified-Since")); ok && exists {
+		if truncate(modTime).After(since) {
+			return Failed
+		}
+	}
+
+	if ifNoneMatch := h.Get("If-None-Match"); ifNoneMatch != "" {
+		if exists && matches(ifNoneMatch, etag, true) {
+			if readOnly {
+				return NotModified
+			}
+			return Failed
+		}
+	} else if since, ok := parseTime(h.Get("If-Modified-Since")); ok && exists && readOnly {
+		if !truncate(modTime).After(since) {
+			return NotModified
+		}
+	}
+
+	return Proceed
+}
+
+// matches reports whether a list of entity tags, or "*", matches etag. Weak comparison
+// ignores the W/ prefix; strong comparison never matches weak tags.
+func matches(list, etag string, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+	if etag == "" {
+		return false
+	}
+
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if weak {
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+			continue
+		}
+		if tag == etag && !strings.HasPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTime parses an HTTP date, reporting whether there was a valid one
+func parseTime(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// truncate drops what HTTP dates cannot express
+func truncate(t time.Time) time.Time {
+	return t.Truncate(time.Second)
+}
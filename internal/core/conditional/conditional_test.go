@@ -0,0 +1,69 @@
+package conditional
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestEvaluate(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	before := modTime.Add(-time.Hour).Format(http.TimeFormat)
+	at := modTime.Format(http.TimeFormat)
+	after := modTime.Add(time.Hour).Format(http.TimeFormat)
+	etag := `"abc"`
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		exists  bool
+		want    Result
+	}{
+		{"no preconditions", "GET", nil, true, Proceed},
+		{"if-match same tag", "PUT", map[string]string{"If-Match": `"abc"`}, true, Proceed},
+		{"if-match in list", "PUT", map[string]string{"If-Match": `"x", "abc"`}, true, Proceed},
+		{"if-match other tag", "PUT", map[string]string{"If-Match": `"xyz"`}, true, Failed},
+		{"if-match weak tag", "PUT", map[string]string{"If-Match": `W/"abc"`}, true, Failed},
+		{"if-match star", "DELETE", map[string]string{"If-Match": "*"}, true, Proceed},
+		{"if-match missing resource", "PUT", map[string]string{"If-Match": "*"}, false, Failed},
+		{"if-none-match star creates", "PUT", map[string]string{"If-None-Match": "*"}, false, Proceed},
+		{"if-none-match star exists", "PUT", map[string]string{"If-None-Match": "*"}, true, Failed},
+		{"if-none-match same tag on get", "GET", map[string]string{"If-None-Match": `"abc"`}, true, NotModified},
+		{"if-none-match weak tag on get", "GET", map[string]string{"If-None-Match": `W/"abc"`}, true, NotModified},
+		{"if-none-match other tag", "GET", map[string]string{"If-None-Match": `"xyz"`}, true, Proceed},
+		{"if-none-match same tag on delete", "DELETE", map[string]string{"If-None-Match": `"abc"`}, true, Failed},
+		{"if-modified-since before", "GET", map[string]string{"If-Modified-Since": before}, true, Proceed},
+		{"if-modified-since at", "GET", map[string]string{"If-Modified-Since": at}, true, NotModified},
+		{"if-modified-since ignored on put", "PUT", map[string]string{"If-Modified-Since": after}, true, Proceed},
+		{"if-modified-since ignored with if-none-match", "GET", map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": after}, true, Proceed},
+		{"if-unmodified-since after", "PUT", map[string]string{"If-Unmodified-Since": after}, true, Proceed},
+		{"if-unmodified-since at", "PUT", map[string]string{"If-Unmodified-Since": at}, true, Proceed},
+		{"if-unmodified-since before", "PUT", map[string]string{"If-Unmodified-Since": before}, true, Failed},
+		{"if-unmodified-since ignored with if-match", "PUT", map[string]string{"If-Match": `"abc"`, "If-Unmodified-Since": before}, true, Proceed},
+		{"invalid date ignored", "PUT", map[string]string{"If-Unmodified-Since": "yesterday"}, true, Proceed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			for k, v := range tt.headers {
+				h.Set(k, v)
+			}
+			if got := Evaluate(tt.method, h, etag, modTime, tt.exists); got != tt.want {
+				t.Errorf("Evaluate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresent(t *testing.T) {
+	if Present(http.Header{}) {
+		t.Error("Present() = true without headers")
+	}
+	h := http.Header{}
+	h.Set("If-Unmodified-Since", time.Now().UTC().Format(http.TimeFormat))
+	if !Present(h) {
+		t.Error("Present() = false with If-Unmodified-Since")
+	}
+}
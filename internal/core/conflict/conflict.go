@@ -0,0 +1,43 @@
+// Package conflict defines the policies for resolving name conflicts when an item is
+// uploaded, moved or copied to a path that is already taken.
+package conflict
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies for a destination that is already taken
+const (
+	// Fail rejects the operation with a conflict; it is the default
+	Fail = "fail"
+	// Overwrite replaces what is at the destination
+	Overwrite = "overwrite"
+	// Rename picks the first free name of the form "name (1).ext"
+	Rename = "rename"
+	// Skip leaves both items as they are
+	Skip = "skip"
+	// Merge combines colliding directories; colliding files inside them are renamed
+	Merge = "merge"
+)
+
+// Valid reports whether a policy is known; empty means Fail
+func Valid(policy string) bool {
+	switch policy {
+	case "", Fail, Overwrite, Rename, Skip, Merge:
+		return true
+	}
+	return false
+}
+
+// Candidate returns the n-th alternative to a name, such as "report (2).pdf". Files keep
+// their extension; directories and dotfiles like ".env" have none.
+func Candidate(name string, n int, dir bool) string {
+	base, ext := name, ""
+	if !dir {
+		if i := strings.LastIndex(name, "."); i > 0 {
+			base, ext = name[:i], name[i:]
+		}
+	}
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
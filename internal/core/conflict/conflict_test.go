@@ -0,0 +1,39 @@
+package conflict
+
+import "testing"
+
+func TestValid(t *testing.T) {
+	for _, policy := range []string{"", Fail, Overwrite, Rename, Skip, Merge} {
+		if !Valid(policy) {
+			t.Errorf("Valid(%q) = false", policy)
+		}
+	}
+	for _, policy := range []string{"replace", "MERGE", "keep"} {
+		if Valid(policy) {
+			t.Errorf("Valid(%q) = true", policy)
+		}
+	}
+}
+
+func TestCandidate(t *testing.T) {
+	tests := []struct {
+		name string
+		n    int
+		dir  bool
+		want string
+	}{
+		{"report.pdf", 1, false, "report (1).pdf"},
+		{"report.pdf", 12, false, "report (12).pdf"},
+		{"archive.tar.gz", 1, false, "archive.tar (1).gz"},
+		{"README", 2, false, "README (2)"},
+		{".env", 1, false, ".env (1)"},
+		{"report (1).pdf", 1, false, "report (1) (1).pdf"},
+		{"photos", 1, true, "photos (1)"},
+		{"v1.2", 1, true, "v1.2 (1)"},
+	}
+	for _, tt := range tests {
+		if got := Candidate(tt.name, tt.n, tt.dir); got != tt.want {
+			t.Errorf("Candidate(%q, %d, %v) = %q, want %q", tt.name, tt.n, tt.dir, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package erasure
+
+// Arithmetic in GF(2^8) using the polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d)
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	// Duplicate the table so gfMul does not need a modulo
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMul multiplies two field elements
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfDiv divides a by b, b must not be zero
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// gfPow raises a to the power n
+func gfPow(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return gfExp[(int(gfLog[a])*n)%255]
+}
+
+// matrix is a row-major matrix over GF(2^8)
+type matrix [][]byte
+
+// newMatrix creates a zero matrix
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// identityMatrix creates an identity matrix
+func identityMatrix(size int) matrix {
+	m := newMatrix(size, size)
+	for i := 0; i < size; i++ {
+		m[i][i] = 1
+	}
+	return m
+}
+
+// vandermonde creates a matrix with m[r][c] = r^c, any `cols` rows of which are independent
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			m[r][c] = gfPow(byte(r), c)
+		}
+	}
+	return m
+}
+
+// multiply returns m * other
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for r := range m {
+		for c := range other[0] {
+			var value byte
+			for i := range other {
+				value ^= gfMul(m[r][i], other[i][c])
+			}
+			result[r][c] = value
+		}
+	}
+	return result
+}
+
+// invert returns the inverse of a square matrix using Gauss-Jordan elimination
+func (m matrix) invert() (matrix, error) {
+	size := len(m)
+	work := newMatrix(size, size*2)
+	for r := 0; r < size; r++ {
+		copy(work[r], m[r])
+		work[r][size+r] = 1
+	}
+
+	for col := 0; col < size; col++ {
+		// Find a pivot row
+		pivot := -1
+		for r := col; r < size; r++ {
+			if work[r][col] != 0 {
+				pivot = r
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, errSingularMatrix
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		// Scale the pivot row to 1
+		scale := work[col][col]
+		for c := range work[col] {
+			work[col][c] = gfDiv(work[col][c], scale)
+		}
+
+		// Eliminate the column from the other rows
+		for r := 0; r < size; r++ {
+			if r == col || work[r][col] == 0 {
+				continue
+			}
+			factor := work[r][col]
+			for c := range work[r] {
+				work[r][c] ^= gfMul(factor, work[col][c])
+			}
+		}
+	}
+
+	inverse := newMatrix(size, size)
+	for r := 0; r < size; r++ {
+		copy(inverse[r], work[r][size:])
+	}
+	return inverse, nil
+}
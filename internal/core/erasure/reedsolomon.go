@@ -0,0 +1,217 @@
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrTooFewShards is returned when fewer than the data shard count are available
+	ErrTooFewShards = errors.New("too few shards to reconstruct data")
+	// ErrShardSize is returned when shards have different sizes
+	ErrShardSize = errors.New("shards must all have the same size")
+
+	errSingularMatrix = errors.New("matrix is singular")
+)
+
+// MaxShards is the largest total number of shards a codec supports
+const MaxShards = 256
+
+// Codec splits data into data shards and computes parity shards with Reed-Solomon coding.
+// Any DataShards of the DataShards+ParityShards shards are enough to rebuild the data.
+type Codec struct {
+	dataShards   int
+	parityShards int
+	matrix       matrix // systematic encoding matrix, top rows are the identity
+}
+
+// New creates a codec with k data shards and m parity shards
+func New(dataShards, parityShards int) (*Codec, error) {
+	if dataShards < 1 || parityShards < 0 {
+		return nil, fmt.Errorf("invalid shard counts: %d data, %d parity", dataShards, parityShards)
+	}
+	if dataShards+parityShards > MaxShards {
+		return nil, fmt.Errorf("too many shards: %d, maximum is %d", dataShards+parityShards, MaxShards)
+	}
+
+	// Make the Vandermonde matrix systematic so data shards are stored unchanged
+	v := vandermonde(dataShards+parityShards, dataShards)
+	top := matrix(v[:dataShards])
+	topInverse, err := top.invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Codec{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		matrix:       v.multiply(topInverse),
+	}, nil
+}
+
+// DataShards returns the number of data shards
+func (c *Codec) DataShards() int {
+	return c.dataShards
+}
+
+// ParityShards returns the number of parity shards
+func (c *Codec) ParityShards() int {
+	return c.parityShards
+}
+
+// TotalShards returns the number of data and parity shards
+func (c *Codec) TotalShards() int {
+	return c.dataShards + c.parityShards
+}
+
+// ShardSize returns the size of each shard for data of the given length
+func (c *Codec) ShardSize(length int) int {
+	if length == 0 {
+		return 0
+	}
+	return (length + c.dataShards - 1) / c.dataShards
+}
+
+// Split divides data into data shards, zero-padding the last one, and allocates parity shards.
+// Call Encode to fill in the parity shards.
+func (c *Codec) Split(data []byte) [][]byte {
+	shardSize := c.ShardSize(len(data))
+	padded := make([]byte, shardSize*c.TotalShards())
+	copy(padded, data)
+
+	shards := make([][]byte, c.TotalShards())
+	for i := range shards {
+		shards[i] = padded[i*shardSize : (i+1)*shardSize : (i+1)*shardSize]
+	}
+	return shards
+}
+
+// Encode computes the parity shards from the data shards
+func (c *Codec) Encode(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("expected %d shards, got %d", c.TotalShards(), len(shards))
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	for i := c.dataShards; i < c.TotalShards(); i++ {
+		if len(shards[i]) != size {
+			shards[i] = make([]byte, size)
+		}
+		c.encodeRow(c.matrix[i], shards[:c.dataShards], shards[i])
+	}
+	return nil
+}
+
+// Reconstruct rebuilds missing shards in place. Missing shards are nil or empty.
+func (c *Codec) Reconstruct(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("expected %d shards, got %d", c.TotalShards(), len(shards))
+	}
+	size, err := shardSize(shards)
+	if err != nil {
+		return err
+	}
+
+	// Pick the first k available shards
+	var present []int
+	for i, shard := range shards {
+		if len(shard) > 0 {
+			present = append(present, i)
+		}
+		if len(present) == c.dataShards {
+			break
+		}
+	}
+	if len(present) < c.dataShards {
+		return ErrTooFewShards
+	}
+
+	// Rebuild the data shards from the rows of the available shards
+	sub := newMatrix(c.dataShards, c.dataShards)
+	inputs := make([][]byte, c.dataShards)
+	for i, index := range present {
+		copy(sub[i], c.matrix[index])
+		inputs[i] = shards[index]
+	}
+	decode, err := sub.invert()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < c.dataShards; i++ {
+		if len(shards[i]) == 0 {
+			shards[i] = make([]byte, size)
+			c.encodeRow(decode[i], inputs, shards[i])
+		}
+	}
+
+	// Recompute missing parity from the complete data shards
+	for i := c.dataShards; i < c.TotalShards(); i++ {
+		if len(shards[i]) == 0 {
+			shards[i] = make([]byte, size)
+			c.encodeRow(c.matrix[i], shards[:c.dataShards], shards[i])
+		}
+	}
+	return nil
+}
+
+// Join concatenates the data shards and trims the padding to the original length
+func (c *Codec) Join(shards [][]byte, length int) ([]byte, error) {
+	if len(shards) < c.dataShards {
+		return nil, ErrTooFewShards
+	}
+
+	data := make([]byte, 0, length)
+	for i := 0; i < c.dataShards && len(data) < length; i++ {
+		if len(shards[i]) == 0 {
+			return nil, ErrTooFewShards
+		}
+		remaining := length - len(data)
+		if remaining > len(shards[i]) {
+			remaining = len(shards[i])
+		}
+		data = append(data, shards[i][:remaining]...)
+	}
+	if len(data) < length {
+		return nil, fmt.Errorf("shards hold %d bytes, expected %d", len(data), length)
+	}
+	return data, nil
+}
+
+// encodeRow writes the linear combination of inputs given by coefficients into output
+func (c *Codec) encodeRow(coefficients []byte, inputs [][]byte, output []byte) {
+	for b := range output {
+		output[b] = 0
+	}
+	for i, input := range inputs {
+		coefficient := coefficients[i]
+		if coefficient == 0 {
+			continue
+		}
+		for b, value := range input {
+			output[b] ^= gfMul(coefficient, value)
+		}
+	}
+}
+
+// shardSize returns the common size of the present shards
+func shardSize(shards [][]byte) (int, error) {
+	size := 0
+	for _, shard := range shards {
+		if len(shard) == 0 {
+			continue
+		}
+		if size == 0 {
+			size = len(shard)
+		} else if len(shard) != size {
+			return 0, ErrShardSize
+		}
+	}
+	if size == 0 {
+		return 0, ErrTooFewShards
+	}
+	return size, nil
+}
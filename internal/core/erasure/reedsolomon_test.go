@@ -0,0 +1,118 @@
+package erasure
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	return data
+}
+
+func TestNew_InvalidShardCounts(t *testing.T) {
+	tests := []struct {
+		data   int
+		parity int
+	}{
+		{0, 2},
+		{4, -1},
+		{200, 100},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.data, tt.parity); err == nil {
+			t.Errorf("New(%d, %d) expected error", tt.data, tt.parity)
+		}
+	}
+}
+
+func TestEncode_DataShardsUnchanged(t *testing.T) {
+	codec, err := New(4, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data := testData(1000)
+
+	shards := codec.Split(data)
+	if err := codec.Encode(shards); err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	if len(shards) != 6 {
+		t.Fatalf("shards = %d, want 6", len(shards))
+	}
+	if len(shards[0]) != 250 {
+		t.Errorf("shard size = %d, want 250", len(shards[0]))
+	}
+	if !bytes.Equal(shards[0], data[:250]) {
+		t.Error("first data shard should hold the first bytes of the data")
+	}
+}
+
+func TestReconstruct_AnyDataShardCount(t *testing.T) {
+	codec, _ := New(4, 2)
+	data := testData(1003)
+
+	original := codec.Split(data)
+	if err := codec.Encode(original); err != nil {
+		t.Fatalf("Encode error: %v", err)
+	}
+
+	// Every combination of two lost shards
+	for a := 0; a < 6; a++ {
+		for b := a + 1; b < 6; b++ {
+			shards := make([][]byte, len(original))
+			for i := range original {
+				shards[i] = append([]byte(nil), original[i]...)
+			}
+			shards[a] = nil
+			shards[b] = nil
+
+			if err := codec.Reconstruct(shards); err != nil {
+				t.Fatalf("Reconstruct without shards %d,%d: %v", a, b, err)
+			}
+			for i := range shards {
+				if !bytes.Equal(shards[i], original[i]) {
+					t.Errorf("shard %d differs after losing shards %d,%d", i, a, b)
+				}
+			}
+
+			joined, err := codec.Join(shards, len(data))
+			if err != nil {
+				t.Fatalf("Join error: %v", err)
+			}
+			if !bytes.Equal(joined, data) {
+				t.Errorf("joined data differs after losing shards %d,%d", a, b)
+			}
+		}
+	}
+}
+
+func TestReconstruct_TooFewShards(t *testing.T) {
+	codec, _ := New(3, 2)
+	shards := codec.Split(testData(300))
+	codec.Encode(shards)
+
+	shards[0] = nil
+	shards[2] = nil
+	shards[4] = nil
+
+	if err := codec.Reconstruct(shards); err != ErrTooFewShards {
+		t.Errorf("err = %v, want %v", err, ErrTooFewShards)
+	}
+}
+
+func TestReconstruct_ShardSizeMismatch(t *testing.T) {
+	codec, _ := New(2, 1)
+	shards := codec.Split(testData(100))
+	codec.Encode(shards)
+
+	shards[1] = shards[1][:10]
+	if err := codec.Reconstruct(shards); err != ErrShardSize {
+		t.Errorf("err = %v, want %v", err, ErrShardSize)
+	}
+}
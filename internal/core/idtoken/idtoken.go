@@ -0,0 +1,287 @@
+// Package idtoken verifies OpenID Connect ID tokens: their signature against the
+// provider's JSON Web Key Set and the claims that bind them to a sign-in.
+package idtoken
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256" // hashes used by the supported algorithms
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// ClockSkew is how far the provider's clock may be off when checking expiry
+const ClockSkew = time.Minute
+
+// ErrUnknownKey is returned for tokens signed with a key that is not in the key set,
+// which happens when the provider has rotated its keys
+var ErrUnknownKey = errors.New("ID token is signed with an unknown key")
+
+// Claims are the claims of an ID token used to sign a user in
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	PreferredUsername string   `json:"preferred_username"`
+}
+
+// Audience is the aud claim, which is a single string or an array of them
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the claim
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return fmt.Errorf("invalid aud claim")
+	}
+	*a = many
+	return nil
+}
+
+// contains reports whether the audience includes a client
+func (a Audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Expected holds what a token must state to be accepted
+type Expected struct {
+	Issuer   string
+	ClientID string
+	Nonce    string // the nonce sent with the authorization request
+	Now      time.Time
+}
+
+// Verify checks the signature of a compact-serialized ID token with the key set, then
+// its issuer, audience, expiry and nonce, and returns its claims
+func Verify(raw string, keys *KeySet, expected Expected) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("ID token is malformed")
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("ID token header is malformed")
+	}
+	alg, ok := algorithms[header.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("ID token algorithm %q is not supported", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID token signature is malformed")
+	}
+	if err := keys.verify(header.KeyID, alg, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("ID token claims are malformed")
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(expected.Issuer, "/") {
+		return nil, fmt.Errorf("ID token issuer %q is not %q", claims.Issuer, expected.Issuer)
+	}
+	if !claims.Audience.contains(expected.ClientID) {
+		return nil, fmt.Errorf("ID token is not issued for this client")
+	}
+	// A token for several audiences names the one it was issued to (OpenID Connect Core 3.1.3.7)
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != expected.ClientID {
+		return nil, fmt.Errorf("ID token is authorized for another party")
+	}
+	if claims.Expiry == 0 || expected.Now.After(time.Unix(claims.Expiry, 0).Add(ClockSkew)) {
+		return nil, fmt.Errorf("ID token has expired")
+	}
+	if expected.Nonce == "" || claims.Nonce != expected.Nonce {
+		return nil, fmt.Errorf("ID token nonce does not match the sign-in")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("ID token has no subject")
+	}
+	return &claims, nil
+}
+
+// decodeSegment decodes a base64url JSON segment of a token
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// algorithm is a JWS signature algorithm
+type algorithm struct {
+	hash  crypto.Hash
+	kind  string // "RSA" or "EC"
+	pss   bool
+	curve elliptic.Curve
+}
+
+// algorithms are the asymmetric JWS algorithms tokens may be signed with. Symmetric
+// and unsigned tokens are never accepted.
+var algorithms = map[string]algorithm{
+	"RS256": {hash: crypto.SHA256, kind: "RSA"},
+	"RS384": {hash: crypto.SHA384, kind: "RSA"},
+	"RS512": {hash: crypto.SHA512, kind: "RSA"},
+	"PS256": {hash: crypto.SHA256, kind: "RSA", pss: true},
+	"PS384": {hash: crypto.SHA384, kind: "RSA", pss: true},
+	"PS512": {hash: crypto.SHA512, kind: "RSA", pss: true},
+	"ES256": {hash: crypto.SHA256, kind: "EC", curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, kind: "EC", curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, kind: "EC", curve: elliptic.P521()},
+}
+
+// KeySet is the set of public keys a provider signs tokens with
+type KeySet struct {
+	keys []key
+}
+
+// key is a public signing key of a key set
+type key struct {
+	id     string
+	public crypto.PublicKey
+}
+
+// ParseKeySet parses a JSON Web Key Set, keeping the RSA and EC keys meant for signatures
+func ParseKeySet(data []byte) (*KeySet, error) {
+	var set struct {
+		Keys []struct {
+			KeyType string `json:"kty"`
+			KeyID   string `json:"kid"`
+			Use     string `json:"use"`
+			N       string `json:"n"`
+			E       string `json:"e"`
+			Curve   string `json:"crv"`
+			X       string `json:"x"`
+			Y       string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := &KeySet{}
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var public crypto.PublicKey
+		switch jwk.KeyType {
+		case "RSA":
+			n, errN := decodeInt(jwk.N)
+			e, errE := decodeInt(jwk.E)
+			if errN != nil || errE != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("invalid RSA key %q", jwk.KeyID)
+			}
+			public = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curve := curves[jwk.Curve]
+			x, errX := decodeInt(jwk.X)
+			y, errY := decodeInt(jwk.Y)
+			if curve == nil || errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid EC key %q", jwk.KeyID)
+			}
+			public = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		default:
+			continue
+		}
+		keys.keys = append(keys.keys, key{id: jwk.KeyID, public: public})
+	}
+	return keys, nil
+}
+
+// curves are the elliptic curves of EC keys by JWK name
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// decodeInt decodes a base64url big-endian integer
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, fmt.Errorf("invalid integer")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// verify checks a signature with the key of an ID, or with any key of the right type
+// when the token names none
+func (k *KeySet) verify(keyID string, alg algorithm, signed string, signature []byte) error {
+	h := alg.hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	found := false
+	if k != nil {
+		for _, key := range k.keys {
+			if keyID != "" && key.id != keyID {
+				continue
+			}
+			switch public := key.public.(type) {
+			case *rsa.PublicKey:
+				if alg.kind != "RSA" {
+					continue
+				}
+				found = true
+				var err error
+				if alg.pss {
+					err = rsa.VerifyPSS(public, alg.hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+				} else {
+					err = rsa.VerifyPKCS1v15(public, alg.hash, digest, signature)
+				}
+				if err == nil {
+					return nil
+				}
+			case *ecdsa.PublicKey:
+				if alg.kind != "EC" || public.Curve != alg.curve {
+					continue
+				}
+				found = true
+				// ES signatures are the two integers r and s of the curve's size, concatenated
+				size := (public.Curve.Params().BitSize + 7) / 8
+				if len(signature) != 2*size {
+					continue
+				}
+				r := new(big.Int).SetBytes(signature[:size])
+				s := new(big.Int).SetBytes(signature[size:])
+				if ecdsa.Verify(public, digest, r, s) {
+					return nil
+				}
+			}
+		}
+	}
+	if !found {
+		return ErrUnknownKey
+	}
+	return fmt.Errorf("ID token signature is invalid")
+}
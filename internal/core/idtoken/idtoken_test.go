@@ -0,0 +1,154 @@
+package idtoken
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Unix(1700000000, 0)
+
+func testKeys(t *testing.T) (*rsa.PrivateKey, *ecdsa.PrivateKey, *KeySet) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b64 := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+			{"kty": "oct", "kid": "secret", "k": "c2VjcmV0"},
+		},
+	})
+	keys, err := ParseKeySet(jwks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsaKey, ecKey, keys
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   "https://idp.example.com",
+		"sub":   "user-1",
+		"aud":   "client-1",
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": "nonce-1",
+	}
+}
+
+func sign(t *testing.T, alg, kid string, claims map[string]interface{}, signer crypto.Signer) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		signature, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+var expected = Expected{Issuer: "https://idp.example.com/", ClientID: "client-1", Nonce: "nonce-1", Now: now}
+
+func TestVerify_Valid(t *testing.T) {
+	rsaKey, ecKey, keys := testKeys(t)
+
+	for _, raw := range []string{
+		sign(t, "RS256", "rsa-1", validClaims(), rsaKey),
+		sign(t, "RS256", "", validClaims(), rsaKey),
+		sign(t, "ES256", "ec-1", validClaims(), ecKey),
+	} {
+		claims, err := Verify(raw, keys, expected)
+		if err != nil {
+			t.Fatalf("Verify: %v", err)
+		}
+		if claims.Subject != "user-1" {
+			t.Errorf("subject = %q, want user-1", claims.Subject)
+		}
+	}
+}
+
+func TestVerify_RejectsClaims(t *testing.T) {
+	rsaKey, _, keys := testKeys(t)
+
+	tests := map[string]func(map[string]interface{}){
+		"issuer":   func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" },
+		"audience": func(c map[string]interface{}) { c["aud"] = "client-2" },
+		"azp":      func(c map[string]interface{}) { c["aud"] = []string{"client-1", "client-2"}; c["azp"] = "client-2" },
+		"expired":  func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() },
+		"nonce":    func(c map[string]interface{}) { c["nonce"] = "nonce-2" },
+		"subject":  func(c map[string]interface{}) { delete(c, "sub") },
+	}
+	for name, mutate := range tests {
+		claims := validClaims()
+		mutate(claims)
+		if _, err := Verify(sign(t, "RS256", "rsa-1", claims, rsaKey), keys, expected); err == nil {
+			t.Errorf("%s: Verify accepted the token", name)
+		}
+	}
+
+	claims := validClaims()
+	claims["aud"] = []string{"client-1", "client-2"}
+	claims["azp"] = "client-1"
+	if _, err := Verify(sign(t, "RS256", "rsa-1", claims, rsaKey), keys, expected); err != nil {
+		t.Errorf("multiple audiences with azp: %v", err)
+	}
+}
+
+func TestVerify_RejectsSignatures(t *testing.T) {
+	rsaKey, _, keys := testKeys(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	raw := sign(t, "RS256", "rsa-1", validClaims(), rsaKey)
+	parts := strings.Split(raw, ".")
+
+	forged := validClaims()
+	forged["sub"] = "admin"
+	payload, _ := json.Marshal(forged)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	hs := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"secret"}`)) + "." + parts[1] + "." + parts[2]
+
+	for name, token := range map[string]string{
+		"tampered":  tampered,
+		"other key": sign(t, "RS256", "rsa-1", validClaims(), otherKey),
+		"none":      none,
+		"hmac":      hs,
+		"malformed": "not-a-token",
+	} {
+		if _, err := Verify(token, keys, expected); err == nil {
+			t.Errorf("%s: Verify accepted the token", name)
+		}
+	}
+
+	if _, err := Verify(sign(t, "RS256", "rsa-2", validClaims(), rsaKey), keys, expected); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown kid: err = %v, want %v", err, ErrUnknownKey)
+	}
+}
@@ -0,0 +1,127 @@
+// Package listing implements the sort orders and cursors of paginated directory listings.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// Sort fields of directory listings
+const (
+	SortName  = "name"
+	SortSize  = "size"
+	SortMtime = "mtime"
+	SortType  = "type"
+)
+
+// TimeLayout formats modification times in cursors. It keeps the microseconds the database
+// stores and no zone, like the database's timestamps.
+const TimeLayout = "2006-01-02 15:04:05.999999"
+
+// ValidSort reports whether a listing can be sorted by a field
+func ValidSort(field string) bool {
+	switch field {
+	case SortName, SortSize, SortMtime, SortType:
+		return true
+	}
+	return false
+}
+
+// ValidType reports whether a listing can be filtered by an item type; empty means both
+func ValidType(itemType string) bool {
+	return itemType == "" || itemType == "file" || itemType == "directory"
+}
+
+// ParseExtensions parses a comma-separated list of file extensions such as "jpg,.PNG" into
+// lower-case extensions without the dot
+func ParseExtensions(list string) []string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// Kind returns the rank of an item's type in a listing; directories come first
+func Kind(item types.VFSItem) int {
+	if item.Type == "directory" {
+		return 0
+	}
+	return 1
+}
+
+// SortKey returns the value an item is sorted by. Directories are as large as all the
+// files below them, have no type and are last modified when they were created.
+func SortKey(item types.VFSItem, field string) string {
+	switch field {
+	case SortSize:
+		return strconv.FormatInt(item.Size, 10)
+	case SortMtime:
+		if item.UpdatedAt != nil {
+			return item.UpdatedAt.Format(TimeLayout)
+		}
+		return item.CreatedAt.Format(TimeLayout)
+	case SortType:
+		return item.MimeType
+	default:
+		return item.Name
+	}
+}
+
+// CursorAfter returns the cursor of the page that starts after an item
+func CursorAfter(item types.VFSItem, opts types.ListOptions) *types.ListCursor {
+	return &types.ListCursor{
+		Sort: opts.Sort,
+		Desc: opts.Desc,
+		Kind: Kind(item),
+		Key:  SortKey(item, opts.Sort),
+		Name: item.Name,
+		ID:   item.ID,
+	}
+}
+
+// Encode encodes a cursor for clients, who pass it back unchanged
+func Encode(c *types.ListCursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Decode decodes a cursor and checks that it belongs to a listing with the given options
+func Decode(s string, opts types.ListOptions) (*types.ListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	c := &types.ListCursor{}
+	if err := json.Unmarshal(data, c); err != nil || (c.Kind != 0 && c.Kind != 1) {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, fmt.Errorf("malformed cursor")
+	}
+	if c.Sort != opts.Sort || c.Desc != opts.Desc {
+		return nil, fmt.Errorf("cursor belongs to a listing with a different sort order")
+	}
+	// Keys end up in SQL casts, which must not fail
+	switch c.Sort {
+	case SortSize:
+		if _, err := strconv.ParseInt(c.Key, 10, 64); err != nil {
+			return nil, fmt.Errorf("malformed cursor")
+		}
+	case SortMtime:
+		if _, err := time.Parse(TimeLayout, c.Key); err != nil {
+			return nil, fmt.Errorf("malformed cursor")
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package listing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+func TestSortKey(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	updated := created.Add(time.Hour)
+	file := types.VFSItem{Name: "a.txt", Type: "file", Size: 42, MimeType: "text/plain", CreatedAt: created, UpdatedAt: &updated}
+	dir := types.VFSItem{Name: "docs", Type: "directory", Size: 2048, FileCount: 3, CreatedAt: created}
+
+	tests := []struct {
+		item  types.VFSItem
+		field string
+		want  string
+	}{
+		{file, SortName, "a.txt"},
+		{file, SortSize, "42"},
+		{file, SortMtime, "2024-05-01 13:00:00.123456"},
+		{file, SortType, "text/plain"},
+		{dir, SortSize, "2048"},
+		{dir, SortMtime, "2024-05-01 12:00:00.123456"},
+		{dir, SortType, ""},
+	}
+	for _, tt := range tests {
+		if got := SortKey(tt.item, tt.field); got != tt.want {
+			t.Errorf("SortKey(%s, %s) = %q, want %q", tt.item.Name, tt.field, got, tt.want)
+		}
+	}
+
+	if Kind(dir) != 0 || Kind(file) != 1 {
+		t.Error("directories should rank before files")
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	opts := types.ListOptions{Sort: SortSize, Desc: true}
+	item := types.VFSItem{ID: "0b6c1a9e-8f0e-4d43-9d7e-5d2f0c3b1a2f", Name: "big.iso", Type: "file", Size: 1 << 32}
+
+	c, err := Decode(Encode(CursorAfter(item, opts)), opts)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := types.ListCursor{Sort: SortSize, Desc: true, Kind: 1, Key: "4294967296", Name: "big.iso", ID: item.ID}
+	if *c != want {
+		t.Errorf("Decode() = %+v, want %+v", *c, want)
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	const id = "0b6c1a9e-8f0e-4d43-9d7e-5d2f0c3b1a2f"
+	opts := types.ListOptions{Sort: SortName}
+	valid := Encode(&types.ListCursor{Sort: SortName, Kind: 1, Key: "a", Name: "a", ID: id})
+
+	tests := []struct {
+		name   string
+		cursor string
+		opts   types.ListOptions
+	}{
+		{"not base64", "%%%", opts},
+		{"not json", Encode(nil)[:2], opts},
+		{"other sort", valid, types.ListOptions{Sort: SortSize}},
+		{"other direction", valid, types.ListOptions{Sort: SortName, Desc: true}},
+		{"bad size", Encode(&types.ListCursor{Sort: SortSize, Kind: 1, Key: "big", ID: id}), types.ListOptions{Sort: SortSize}},
+		{"bad time", Encode(&types.ListCursor{Sort: SortMtime, Kind: 1, Key: "yesterday", ID: id}), types.ListOptions{Sort: SortMtime}},
+		{"bad kind", Encode(&types.ListCursor{Sort: SortName, Kind: 7, ID: id}), opts},
+		{"bad id", Encode(&types.ListCursor{Sort: SortName, Kind: 1, Key: "a", ID: "1; DROP"}), opts},
+	}
+	for _, tt := range tests {
+		if _, err := Decode(tt.cursor, tt.opts); err == nil {
+			t.Errorf("Decode(%s) succeeded, want error", tt.name)
+		}
+	}
+
+	if _, err := Decode(valid, opts); err != nil {
+		t.Errorf("Decode(valid) error = %v", err)
+	}
+}
+
+func TestValidSort(t *testing.T) {
+	for _, field := range []string{SortName, SortSize, SortMtime, SortType} {
+		if !ValidSort(field) {
+			t.Errorf("ValidSort(%s) = false", field)
+		}
+	}
+	if ValidSort("owner") {
+		t.Error("ValidSort(owner) = true")
+	}
+}
+
+func TestValidType(t *testing.T) {
+	for _, itemType := range []string{"", "file", "directory"} {
+		if !ValidType(itemType) {
+			t.Errorf("ValidType(%q) = false", itemType)
+		}
+	}
+	if ValidType("link") {
+		t.Error("ValidType(link) = true")
+	}
+}
+
+func TestParseExtensions(t *testing.T) {
+	got := ParseExtensions(" jpg,.PNG,, tar.gz ,")
+	want := []string{"jpg", "png", "tar.gz"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseExtensions() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseExtensions()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+	if exts := ParseExtensions(""); len(exts) != 0 {
+		t.Errorf("ParseExtensions(\"\") = %v, want none", exts)
+	}
+}
@@ -2,12 +2,17 @@ package loadbalancer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/circuitbreaker"
 )
 
 // Strategy represents load balancing strategy
@@ -20,26 +25,122 @@ const (
 	StrategyRoundRobin Strategy = "round_robin"
 	// StrategyWeighted uses priority-based weighted random selection
 	StrategyWeighted Strategy = "weighted"
+	// StrategyLatency selects account with lowest average upload time per byte
+	StrategyLatency Strategy = "latency"
+	// StrategyAffinity keeps a bucket's objects on a pinned set of accounts
+	StrategyAffinity Strategy = "affinity"
 )
 
+const (
+	// DefaultLatencyAlpha is the smoothing factor of the upload time moving average
+	DefaultLatencyAlpha = 0.3
+	// latencyUnit is the payload size upload times are scaled to, so uploads of any size
+	// compare by speed
+	latencyUnit = 1 << 20
+	// DefaultAffinityAccounts is the number of accounts a bucket is pinned to
+	DefaultAffinityAccounts = 2
+)
+
+// ErrNoHealthyAccounts is returned when every candidate account has an open circuit breaker
+var ErrNoHealthyAccounts = errors.New("no healthy accounts available")
+
+// Strategies returns all supported strategies
+func Strategies() []Strategy {
+	return []Strategy{StrategyLeastUsed, StrategyRoundRobin, StrategyWeighted, StrategyLatency, StrategyAffinity}
+}
+
+// ParseStrategy converts a strategy name into a Strategy
+func ParseStrategy(name string) (Strategy, error) {
+	for _, strategy := range Strategies() {
+		if string(strategy) == name {
+			return strategy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown load balancing strategy: %s", name)
+}
+
 // Balancer handles account selection for load balancing
 type Balancer struct {
-	strategy     Strategy
-	currentIndex int
-	mu           sync.Mutex
-	rand         *rand.Rand
+	strategy         Strategy
+	currentIndex     int
+	mu               sync.Mutex
+	rand             *rand.Rand
+	breakers         *circuitbreaker.Registry
+	latency          map[string]time.Duration // EWMA of upload times per MiB per account
+	latencyAlpha     float64
+	affinityAccounts int
 }
 
 // NewBalancer creates a new load balancer
 func NewBalancer(strategy Strategy) *Balancer {
+	return NewBalancerWithBreakers(strategy, circuitbreaker.DefaultConfig())
+}
+
+// NewBalancerWithBreakers creates a new load balancer with custom circuit breaker configuration
+func NewBalancerWithBreakers(strategy Strategy, breakerConfig *circuitbreaker.Config) *Balancer {
 	return &Balancer{
-		strategy: strategy,
-		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		strategy:         strategy,
+		rand:             rand.New(rand.NewSource(time.Now().UnixNano())),
+		breakers:         circuitbreaker.NewRegistry(breakerConfig),
+		latency:          make(map[string]time.Duration),
+		latencyAlpha:     DefaultLatencyAlpha,
+		affinityAccounts: DefaultAffinityAccounts,
 	}
 }
 
+// NewBalancerFromConfig creates a load balancer from application config
+func NewBalancerFromConfig(cfg types.LoadBalanceConfig) (*Balancer, error) {
+	strategy := StrategyLeastUsed
+	if cfg.Strategy != "" {
+		parsed, err := ParseStrategy(cfg.Strategy)
+		if err != nil {
+			return nil, err
+		}
+		strategy = parsed
+	}
+
+	balancer := NewBalancerWithBreakers(strategy, circuitbreaker.FromConfig(cfg.CircuitBreaker))
+	if cfg.LatencyAlpha > 0 && cfg.LatencyAlpha <= 1 {
+		balancer.latencyAlpha = cfg.LatencyAlpha
+	}
+	if cfg.AffinityAccounts > 0 {
+		balancer.affinityAccounts = cfg.AffinityAccounts
+	}
+	return balancer, nil
+}
+
+// Strategy returns the current load balancing strategy
+func (b *Balancer) Strategy() Strategy {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.strategy
+}
+
+// SetStrategy switches the load balancing strategy at runtime
+func (b *Balancer) SetStrategy(strategy Strategy) error {
+	if _, err := ParseStrategy(string(strategy)); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.strategy = strategy
+	return nil
+}
+
+// SelectOptions customizes a single account selection
+type SelectOptions struct {
+	Bucket   string   // Bucket the data belongs to, used by the affinity strategy
+	Strategy Strategy // Overrides the balancer strategy when set
+}
+
 // SelectAccount selects an account based on the load balancing strategy
 func (b *Balancer) SelectAccount(ctx context.Context, accounts []*types.StorageAccount, requiredSpace int64) (*types.StorageAccount, error) {
+	return b.SelectAccountWithOptions(ctx, accounts, requiredSpace, SelectOptions{})
+}
+
+// SelectAccountWithOptions selects an account using per-call options
+func (b *Balancer) SelectAccountWithOptions(ctx context.Context, accounts []*types.StorageAccount, requiredSpace int64, opts SelectOptions) (*types.StorageAccount, error) {
 	if len(accounts) == 0 {
 		return nil, fmt.Errorf("no accounts available")
 	}
@@ -50,16 +151,56 @@ func (b *Balancer) SelectAccount(ctx context.Context, accounts []*types.StorageA
 		return nil, fmt.Errorf("no accounts with enough space available")
 	}
 
-	switch b.strategy {
+	// Skip accounts whose circuit breaker is open
+	availableAccounts = b.filterHealthyAccounts(availableAccounts)
+	if len(availableAccounts) == 0 {
+		return nil, ErrNoHealthyAccounts
+	}
+
+	strategy := opts.Strategy
+	if strategy == "" {
+		strategy = b.Strategy()
+	}
+
+	// A recovering account takes a single probe, so another caller may have claimed it
+	// since the filter ran
+	for len(availableAccounts) > 0 {
+		account := b.selectWithStrategy(strategy, opts.Bucket, availableAccounts)
+		if b.breakers.Allow(account.ID) {
+			return account, nil
+		}
+		availableAccounts = withoutAccount(availableAccounts, account.ID)
+	}
+	return nil, ErrNoHealthyAccounts
+}
+
+// selectWithStrategy picks one of the accounts with a strategy
+func (b *Balancer) selectWithStrategy(strategy Strategy, bucket string, accounts []*types.StorageAccount) *types.StorageAccount {
+	switch strategy {
 	case StrategyLeastUsed:
-		return b.selectLeastUsed(availableAccounts), nil
+		return b.selectLeastUsed(accounts)
 	case StrategyRoundRobin:
-		return b.selectRoundRobin(availableAccounts), nil
+		return b.selectRoundRobin(accounts)
 	case StrategyWeighted:
-		return b.selectWeighted(availableAccounts), nil
+		return b.selectWeighted(accounts)
+	case StrategyLatency:
+		return b.selectLowestLatency(accounts)
+	case StrategyAffinity:
+		return b.selectAffinity(bucket, accounts)
 	default:
-		return b.selectLeastUsed(availableAccounts), nil
+		return b.selectLeastUsed(accounts)
+	}
+}
+
+// withoutAccount returns the accounts other than the one with the given ID
+func withoutAccount(accounts []*types.StorageAccount, accountID string) []*types.StorageAccount {
+	remaining := make([]*types.StorageAccount, 0, len(accounts))
+	for _, account := range accounts {
+		if account.ID != accountID {
+			remaining = append(remaining, account)
+		}
 	}
+	return remaining
 }
 
 // filterAvailableAccounts filters accounts that have enough space
@@ -81,6 +222,84 @@ func (b *Balancer) filterAvailableAccounts(accounts []*types.StorageAccount, req
 	return available
 }
 
+// filterHealthyAccounts filters out accounts whose circuit breaker would reject a call.
+// It only looks at the breakers, so accounts that are not chosen keep their probe.
+func (b *Balancer) filterHealthyAccounts(accounts []*types.StorageAccount) []*types.StorageAccount {
+	var healthy []*types.StorageAccount
+	for _, account := range accounts {
+		if b.breakers.Available(account.ID) {
+			healthy = append(healthy, account)
+		}
+	}
+	return healthy
+}
+
+// RecordResult feeds the outcome and latency of a OneDrive call into the account's circuit breaker
+func (b *Balancer) RecordResult(accountID string, err error, latency time.Duration) {
+	b.breakers.Record(accountID, err, latency)
+}
+
+// IsHealthy reports whether the account's circuit breaker would allow calls, without
+// changing it
+func (b *Balancer) IsHealthy(accountID string) bool {
+	return b.breakers.Available(accountID)
+}
+
+// HealthSnapshot returns circuit breaker state keyed by account ID
+func (b *Balancer) HealthSnapshot() map[string]circuitbreaker.Snapshot {
+	return b.breakers.Snapshots()
+}
+
+// RecordUploadLatency feeds the time a successful upload of size bytes took into the
+// account's moving average, scaled to one MiB so large uploads do not make an account
+// look slow. Empty uploads say nothing about speed and are ignored.
+func (b *Balancer) RecordUploadLatency(accountID string, latency time.Duration, size int64) {
+	if size <= 0 {
+		return
+	}
+	latency = time.Duration(math.Round(float64(latency) * latencyUnit / float64(size)))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	average, exists := b.latency[accountID]
+	if !exists {
+		b.latency[accountID] = latency
+		return
+	}
+	b.latency[accountID] = time.Duration(b.latencyAlpha*float64(latency) + (1-b.latencyAlpha)*float64(average))
+}
+
+// LatencySnapshot returns the average upload time per MiB keyed by account ID
+func (b *Balancer) LatencySnapshot() map[string]time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := make(map[string]time.Duration, len(b.latency))
+	for id, latency := range b.latency {
+		snapshot[id] = latency
+	}
+	return snapshot
+}
+
+// AffinityAccounts returns the accounts a bucket is pinned to, in preference order
+func (b *Balancer) AffinityAccounts(bucket string, accounts []*types.StorageAccount) []*types.StorageAccount {
+	ranked := make([]*types.StorageAccount, len(accounts))
+	copy(ranked, accounts)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return affinityScore(bucket, ranked[i].ID) > affinityScore(bucket, ranked[j].ID)
+	})
+
+	b.mu.Lock()
+	size := b.affinityAccounts
+	b.mu.Unlock()
+
+	if len(ranked) > size {
+		ranked = ranked[:size]
+	}
+	return ranked
+}
+
 // selectLeastUsed selects account with lowest usage percentage
 func (b *Balancer) selectLeastUsed(accounts []*types.StorageAccount) *types.StorageAccount {
 	if len(accounts) == 0 {
@@ -157,6 +376,47 @@ func (b *Balancer) selectWeighted(accounts []*types.StorageAccount) *types.Stora
 	return accounts[0]
 }
 
+// selectLowestLatency selects account with the lowest average upload time per MiB.
+// Accounts without samples are preferred so every account gets measured.
+func (b *Balancer) selectLowestLatency(accounts []*types.StorageAccount) *types.StorageAccount {
+	b.mu.Lock()
+	var unmeasured []*types.StorageAccount
+	var selected *types.StorageAccount
+	var minLatency time.Duration
+	for _, account := range accounts {
+		latency, exists := b.latency[account.ID]
+		if !exists {
+			unmeasured = append(unmeasured, account)
+			continue
+		}
+		if selected == nil || latency < minLatency {
+			selected = account
+			minLatency = latency
+		}
+	}
+	b.mu.Unlock()
+
+	if len(unmeasured) > 0 {
+		return b.selectLeastUsed(unmeasured)
+	}
+	return selected
+}
+
+// selectAffinity selects the least used account among those pinned to the bucket.
+// Pinning uses rendezvous hashing, so the set stays stable as long as its accounts are available.
+func (b *Balancer) selectAffinity(bucket string, accounts []*types.StorageAccount) *types.StorageAccount {
+	return b.selectLeastUsed(b.AffinityAccounts(bucket, accounts))
+}
+
+// affinityScore returns the rendezvous hash weight of an account for a bucket
+func affinityScore(bucket, accountID string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(bucket))
+	h.Write([]byte{0})
+	h.Write([]byte(accountID))
+	return h.Sum64()
+}
+
 // GetUsageStats returns usage statistics for accounts
 func (b *Balancer) GetUsageStats(accounts []*types.StorageAccount) map[string]interface{} {
 	if len(accounts) == 0 {
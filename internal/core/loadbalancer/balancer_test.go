@@ -2,9 +2,12 @@ package loadbalancer
 
 import (
 	"context"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/circuitbreaker"
 )
 
 func createTestAccounts() []*types.StorageAccount {
@@ -175,6 +178,23 @@ func TestSelectAccount_InactiveAccountsFiltered(t *testing.T) {
 	}
 }
 
+func TestSelectAccount_DrainingAccountsFiltered(t *testing.T) {
+	balancer := NewBalancer(StrategyLeastUsed)
+	ctx := context.Background()
+
+	accounts := createTestAccounts()
+	accounts[2].Status = "draining"
+
+	selected, err := balancer.SelectAccount(ctx, accounts, 100)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected.ID != "account-1" {
+		t.Errorf("selected = %v, want account-1 (least used account that is not draining)", selected.ID)
+	}
+}
+
 func TestSelectAccount_UnsyncedAccountAccepted(t *testing.T) {
 	balancer := NewBalancer(StrategyLeastUsed)
 	ctx := context.Background()
@@ -310,3 +330,258 @@ func TestSelectRoundRobin_ThreadSafety(t *testing.T) {
 		// No errors
 	}
 }
+
+func TestSelectAccount_SkipsOpenCircuit(t *testing.T) {
+	balancer := NewBalancer(StrategyLeastUsed)
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	// Trip the breaker of the least used account
+	for i := 0; i < 5; i++ {
+		balancer.RecordResult("account-3", errors.New("API error (status: 503)"), 0)
+	}
+
+	selected, err := balancer.SelectAccount(ctx, accounts, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Account 1 is the next least used (20%)
+	if selected.ID != "account-1" {
+		t.Errorf("selected = %v, want account-1 (account-3 circuit is open)", selected.ID)
+	}
+	if balancer.IsHealthy("account-3") {
+		t.Error("account-3 should be reported unhealthy")
+	}
+}
+
+func TestSelectAccount_FilterKeepsProbes(t *testing.T) {
+	balancer := NewBalancerWithBreakers(StrategyLeastUsed, &circuitbreaker.Config{
+		WindowSize:          10,
+		ConsecutiveFailures: 1,
+		OpenTimeout:         time.Millisecond,
+	})
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	balancer.RecordResult("account-2", errors.New("API error (status: 503)"), 0)
+	time.Sleep(5 * time.Millisecond)
+
+	selected, err := balancer.SelectAccount(ctx, accounts, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected.ID != "account-3" {
+		t.Errorf("selected = %v, want account-3", selected.ID)
+	}
+	// Account 2 was a candidate but not chosen, so its breaker did not spend the probe
+	if state := balancer.HealthSnapshot()["account-2"].State; state != circuitbreaker.StateOpen {
+		t.Errorf("account-2 state = %v, want %v", state, circuitbreaker.StateOpen)
+	}
+}
+
+func TestSelectAccount_AllCircuitsOpen(t *testing.T) {
+	balancer := NewBalancer(StrategyLeastUsed)
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	for _, account := range accounts {
+		for i := 0; i < 5; i++ {
+			balancer.RecordResult(account.ID, errors.New("timeout"), 0)
+		}
+	}
+
+	_, err := balancer.SelectAccount(ctx, accounts, 100)
+	if err != ErrNoHealthyAccounts {
+		t.Errorf("err = %v, want %v", err, ErrNoHealthyAccounts)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Strategy
+		wantErr bool
+	}{
+		{"least_used", StrategyLeastUsed, false},
+		{"round_robin", StrategyRoundRobin, false},
+		{"weighted", StrategyWeighted, false},
+		{"latency", StrategyLatency, false},
+		{"affinity", StrategyAffinity, false},
+		{"fastest", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseStrategy(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseStrategy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseStrategy(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewBalancerFromConfig(t *testing.T) {
+	balancer, err := NewBalancerFromConfig(types.LoadBalanceConfig{Strategy: "latency", AffinityAccounts: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if balancer.Strategy() != StrategyLatency {
+		t.Errorf("strategy = %v, want %v", balancer.Strategy(), StrategyLatency)
+	}
+	if balancer.affinityAccounts != 3 {
+		t.Errorf("affinityAccounts = %v, want 3", balancer.affinityAccounts)
+	}
+
+	if _, err := NewBalancerFromConfig(types.LoadBalanceConfig{Strategy: "fastest"}); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestSetStrategy(t *testing.T) {
+	balancer := NewBalancer(StrategyLeastUsed)
+
+	if err := balancer.SetStrategy(StrategyRoundRobin); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if balancer.Strategy() != StrategyRoundRobin {
+		t.Errorf("strategy = %v, want %v", balancer.Strategy(), StrategyRoundRobin)
+	}
+
+	if err := balancer.SetStrategy("fastest"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+	if balancer.Strategy() != StrategyRoundRobin {
+		t.Errorf("strategy = %v after invalid switch, want %v", balancer.Strategy(), StrategyRoundRobin)
+	}
+}
+
+func TestSelectLowestLatency(t *testing.T) {
+	balancer := NewBalancer(StrategyLatency)
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	balancer.RecordUploadLatency("account-1", 300*time.Millisecond, mib)
+	balancer.RecordUploadLatency("account-2", 100*time.Millisecond, mib)
+
+	// Account 3 has no samples yet and is tried first
+	selected, err := balancer.SelectAccount(ctx, accounts, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected.ID != "account-3" {
+		t.Errorf("selected = %v, want account-3 (unmeasured)", selected.ID)
+	}
+
+	balancer.RecordUploadLatency("account-3", 500*time.Millisecond, mib)
+	selected, _ = balancer.SelectAccount(ctx, accounts, 100)
+	if selected.ID != "account-2" {
+		t.Errorf("selected = %v, want account-2 (fastest)", selected.ID)
+	}
+}
+
+// mib is the payload size latencies are compared at
+const mib = 1 << 20
+
+func TestRecordUploadLatency_EWMA(t *testing.T) {
+	balancer := NewBalancer(StrategyLatency)
+
+	balancer.RecordUploadLatency("account-1", 100*time.Millisecond, mib)
+	balancer.RecordUploadLatency("account-1", 200*time.Millisecond, mib)
+
+	// 0.3*200 + 0.7*100 = 130
+	got := balancer.LatencySnapshot()["account-1"]
+	if got != 130*time.Millisecond {
+		t.Errorf("latency = %v, want %v", got, 130*time.Millisecond)
+	}
+}
+
+func TestRecordUploadLatency_PerByte(t *testing.T) {
+	balancer := NewBalancer(StrategyLatency)
+	ctx := context.Background()
+	accounts := createTestAccounts()[:2]
+
+	// The same speed: 64 MiB in 6.4s and 1 MiB in 100ms
+	balancer.RecordUploadLatency("account-1", 6400*time.Millisecond, 64*mib)
+	balancer.RecordUploadLatency("account-2", 100*time.Millisecond, mib)
+
+	snapshot := balancer.LatencySnapshot()
+	if snapshot["account-1"] != snapshot["account-2"] {
+		t.Errorf("latencies = %v and %v, want them equal", snapshot["account-1"], snapshot["account-2"])
+	}
+
+	// A large upload that is faster per byte ranks first despite taking longer
+	balancer.RecordUploadLatency("account-1", 3200*time.Millisecond, 64*mib)
+	selected, err := balancer.SelectAccount(ctx, accounts, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected.ID != "account-1" {
+		t.Errorf("selected = %v, want account-1 (fastest per byte)", selected.ID)
+	}
+
+	balancer.RecordUploadLatency("account-2", time.Second, 0)
+	if got := balancer.LatencySnapshot()["account-2"]; got != snapshot["account-2"] {
+		t.Errorf("latency = %v after an empty upload, want %v", got, snapshot["account-2"])
+	}
+}
+
+func TestSelectAffinity(t *testing.T) {
+	balancer := NewBalancer(StrategyAffinity)
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	pinned := balancer.AffinityAccounts("photos", accounts)
+	if len(pinned) != DefaultAffinityAccounts {
+		t.Fatalf("pinned accounts = %d, want %d", len(pinned), DefaultAffinityAccounts)
+	}
+
+	pinnedIDs := make(map[string]bool)
+	for _, account := range pinned {
+		pinnedIDs[account.ID] = true
+	}
+
+	for i := 0; i < 10; i++ {
+		selected, err := balancer.SelectAccountWithOptions(ctx, accounts, 100, SelectOptions{Bucket: "photos"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !pinnedIDs[selected.ID] {
+			t.Errorf("selected = %v, not in pinned set", selected.ID)
+		}
+	}
+
+	// Pinning does not depend on account order
+	reversed := []*types.StorageAccount{accounts[2], accounts[1], accounts[0]}
+	for _, account := range balancer.AffinityAccounts("photos", reversed) {
+		if !pinnedIDs[account.ID] {
+			t.Errorf("account %v pinned only after reordering", account.ID)
+		}
+	}
+}
+
+func TestSelectAccountWithOptions_StrategyOverride(t *testing.T) {
+	balancer := NewBalancer(StrategyLeastUsed)
+	ctx := context.Background()
+	accounts := createTestAccounts()
+
+	balancer.RecordUploadLatency("account-1", 50*time.Millisecond, mib)
+	balancer.RecordUploadLatency("account-2", 100*time.Millisecond, mib)
+	balancer.RecordUploadLatency("account-3", 900*time.Millisecond, mib)
+
+	selected, err := balancer.SelectAccountWithOptions(ctx, accounts, 100, SelectOptions{Strategy: StrategyLatency})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if selected.ID != "account-1" {
+		t.Errorf("selected = %v, want account-1 (lowest latency)", selected.ID)
+	}
+
+	// The balancer's own strategy is unchanged
+	selected, _ = balancer.SelectAccount(ctx, accounts, 100)
+	if selected.ID != "account-3" {
+		t.Errorf("selected = %v, want account-3 (least used)", selected.ID)
+	}
+}
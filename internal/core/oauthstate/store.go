@@ -0,0 +1,164 @@
+package oauthstate
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long an authorization may take before its state expires
+const DefaultTTL = 10 * time.Minute
+
+var (
+	// ErrInvalidState is returned for states that were not issued by the store or were tampered with
+	ErrInvalidState = errors.New("invalid OAuth state")
+	// ErrStateExpired is returned for states older than the TTL
+	ErrStateExpired = errors.New("OAuth state expired")
+	// ErrStateUsed is returned when a state is presented a second time
+	ErrStateUsed = errors.New("OAuth state already used")
+)
+
+// Entry is the server-side data bound to an issued state
+type Entry struct {
+	AccountID    string
+	RedirectURI  string
+	CodeVerifier string // PKCE code verifier sent with the token exchange
+	Nonce        string // OpenID Connect nonce the ID token must carry
+	ExpiresAt    time.Time
+}
+
+// Store issues signed, single-use, expiring OAuth state values and keeps their entries in memory
+type Store struct {
+	secret  []byte
+	ttl     time.Duration
+	entries map[string]*Entry
+	used    map[string]time.Time // consumed states, kept until they would have expired to detect replays
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+// NewStore creates a state store. An empty secret is replaced by a random one,
+// which invalidates pending authorizations on restart.
+func NewStore(secret string, ttl time.Duration) *Store {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+	}
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &Store{
+		secret:  key,
+		ttl:     ttl,
+		entries: make(map[string]*Entry),
+		used:    make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Issue creates a state for an account authorization along with a fresh PKCE code verifier
+// and OpenID Connect nonce
+func (s *Store) Issue(accountID, redirectURI string) (string, *Entry, error) {
+	nonce, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		return "", nil, err
+	}
+	oidcNonce, err := randomString(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	state := nonce + "." + s.sign(nonce)
+	entry := &Entry{
+		AccountID:    accountID,
+		RedirectURI:  redirectURI,
+		CodeVerifier: verifier,
+		Nonce:        oidcNonce,
+		ExpiresAt:    s.now().Add(s.ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup()
+	s.entries[state] = entry
+
+	return state, entry, nil
+}
+
+// Consume validates a state and removes it, so it can only be used once
+func (s *Store) Consume(state string) (*Entry, error) {
+	nonce, signature, found := strings.Cut(state, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(nonce))) {
+		return nil, ErrInvalidState
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, replayed := s.used[state]; replayed {
+		return nil, ErrStateUsed
+	}
+	entry, exists := s.entries[state]
+	if !exists {
+		return nil, ErrInvalidState
+	}
+	delete(s.entries, state)
+	s.used[state] = entry.ExpiresAt
+
+	if s.now().After(entry.ExpiresAt) {
+		return nil, ErrStateExpired
+	}
+	return entry, nil
+}
+
+// sign returns the HMAC-SHA256 signature of a nonce
+func (s *Store) sign(nonce string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cleanup drops expired entries and replay markers. The caller must hold the lock.
+func (s *Store) cleanup() {
+	now := s.now()
+	for state, entry := range s.entries {
+		if now.After(entry.ExpiresAt) {
+			delete(s.entries, state)
+		}
+	}
+	for state, expiresAt := range s.used {
+		if now.After(expiresAt) {
+			delete(s.used, state)
+		}
+	}
+}
+
+// NewCodeVerifier returns a random PKCE code verifier (RFC 7636)
+func NewCodeVerifier() (string, error) {
+	return randomString(32)
+}
+
+// CodeChallenge returns the S256 PKCE code challenge of a verifier
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// randomString returns n random bytes encoded as unpadded base64url
+func randomString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
@@ -0,0 +1,93 @@
+package oauthstate
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStore_IssueAndConsume(t *testing.T) {
+	store := NewStore("secret", time.Minute)
+
+	state, issued, err := store.Issue("account-1", "https://example.com/callback")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if strings.Contains(state, "account-1") {
+		t.Error("state should not contain the account ID")
+	}
+
+	entry, err := store.Consume(state)
+	if err != nil {
+		t.Fatalf("Consume() error = %v", err)
+	}
+	if entry.AccountID != "account-1" || entry.RedirectURI != "https://example.com/callback" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if entry.CodeVerifier == "" || entry.CodeVerifier != issued.CodeVerifier {
+		t.Error("entry should carry the issued code verifier")
+	}
+}
+
+func TestStore_SingleUse(t *testing.T) {
+	store := NewStore("secret", time.Minute)
+	state, _, _ := store.Issue("account-1", "")
+
+	store.Consume(state)
+	if _, err := store.Consume(state); err != ErrStateUsed {
+		t.Errorf("second Consume() error = %v, want %v", err, ErrStateUsed)
+	}
+}
+
+func TestStore_Expired(t *testing.T) {
+	store := NewStore("secret", time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	state, _, _ := store.Issue("account-1", "")
+
+	now = now.Add(2 * time.Minute)
+	if _, err := store.Consume(state); err != ErrStateExpired {
+		t.Errorf("Consume() error = %v, want %v", err, ErrStateExpired)
+	}
+}
+
+func TestStore_RejectsForgedState(t *testing.T) {
+	store := NewStore("secret", time.Minute)
+	state, _, _ := store.Issue("account-1", "")
+	nonce, _, _ := strings.Cut(state, ".")
+
+	for _, forged := range []string{"account-1", nonce + ".forged", nonce} {
+		if _, err := store.Consume(forged); err != ErrInvalidState {
+			t.Errorf("Consume(%q) error = %v, want %v", forged, err, ErrInvalidState)
+		}
+	}
+}
+
+func TestStore_RejectsStateFromOtherSecret(t *testing.T) {
+	other := NewStore("other", time.Minute)
+	state, _, _ := other.Issue("account-1", "")
+
+	if _, err := NewStore("secret", time.Minute).Consume(state); err != ErrInvalidState {
+		t.Errorf("Consume() error = %v, want %v", err, ErrInvalidState)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// Example from RFC 7636 appendix B
+	challenge := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+
+	if challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("CodeChallenge() = %s", challenge)
+	}
+}
+
+func TestNewCodeVerifier_Length(t *testing.T) {
+	verifier, err := NewCodeVerifier()
+	if err != nil {
+		t.Fatalf("NewCodeVerifier() error = %v", err)
+	}
+	// RFC 7636 requires 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		t.Errorf("verifier length = %d", len(verifier))
+	}
+}
@@ -0,0 +1,87 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scheme names the hash format, so it can be changed without breaking stored hashes
+const scheme = "pbkdf2-sha256"
+
+const (
+	saltSize = 16
+	keySize  = 32
+	// DefaultIterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256
+	DefaultIterations = 600000
+)
+
+// MinLength is the shortest password accepted by Validate
+const MinLength = 8
+
+var (
+	// ErrTooShort is returned for passwords shorter than MinLength
+	ErrTooShort = fmt.Errorf("password must be at least %d characters", MinLength)
+	// ErrInvalidHash is returned for stored hashes that cannot be parsed
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// Validate checks that a password is acceptable
+func Validate(password string) error {
+	if len(password) < MinLength {
+		return ErrTooShort
+	}
+	return nil
+}
+
+// Hash hashes a password with PBKDF2-SHA256 and a random salt. The result has the form
+// "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func Hash(password string) (string, error) {
+	return hashWithIterations(password, DefaultIterations)
+}
+
+func hashWithIterations(password string, iterations int) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, keySize)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s$%d$%s$%s", scheme, iterations,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether a password matches a hash produced by Hash
+func Verify(password, encoded string) (bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != scheme {
+		return false, ErrInvalidHash
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false, ErrInvalidHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false, ErrInvalidHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(want) == 0 {
+		return false, ErrInvalidHash
+	}
+
+	got, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
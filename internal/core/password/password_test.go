@@ -0,0 +1,61 @@
+package password
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHashAndVerify(t *testing.T) {
+	hash, err := hashWithIterations("correct horse", 1000)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("hash = %s", hash)
+	}
+
+	ok, err := Verify("correct horse", hash)
+	if err != nil || !ok {
+		t.Errorf("Verify(correct) = %v, %v", ok, err)
+	}
+	ok, err = Verify("wrong horse", hash)
+	if err != nil || ok {
+		t.Errorf("Verify(wrong) = %v, %v", ok, err)
+	}
+}
+
+func TestHash_UsesRandomSalt(t *testing.T) {
+	a, _ := hashWithIterations("password", 1000)
+	b, _ := hashWithIterations("password", 1000)
+
+	if a == b {
+		t.Error("hashes of the same password should differ")
+	}
+}
+
+func TestHash_DefaultIterations(t *testing.T) {
+	hash, err := Hash("password")
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$600000$") {
+		t.Errorf("hash = %s", hash)
+	}
+}
+
+func TestVerify_InvalidHash(t *testing.T) {
+	for _, encoded := range []string{"", "plain", "bcrypt$10$a$b", "pbkdf2-sha256$x$a$b", "pbkdf2-sha256$1000$!!$b"} {
+		if _, err := Verify("password", encoded); err != ErrInvalidHash {
+			t.Errorf("Verify(%q) error = %v, want %v", encoded, err, ErrInvalidHash)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Validate("short"); err != ErrTooShort {
+		t.Errorf("Validate(short) error = %v", err)
+	}
+	if err := Validate("long enough"); err != nil {
+		t.Errorf("Validate(long enough) error = %v", err)
+	}
+}
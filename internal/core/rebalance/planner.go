@@ -0,0 +1,186 @@
+package rebalance
+
+import (
+	"sort"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// DefaultTolerance is the allowed deviation from the target usage, as a fraction of an account's quota
+const DefaultTolerance = 0.05
+
+// Candidate is an object or chunk that may be moved off its account
+type Candidate struct {
+	Move    types.RebalanceMove // ToAccountID is filled in by the planner
+	Exclude []string            // accounts that already hold another copy of the same data
+	Allowed []string            // accounts permitted by the bucket's placement policy, nil for any
+}
+
+// allows reports whether the candidate may be placed on an account
+func (c *Candidate) allows(accountID string) bool {
+	if accountID == c.Move.FromAccountID {
+		return false
+	}
+	for _, id := range c.Exclude {
+		if id == accountID {
+			return false
+		}
+	}
+	if c.Allowed == nil {
+		return true
+	}
+	for _, id := range c.Allowed {
+		if id == accountID {
+			return true
+		}
+	}
+	return false
+}
+
+// Targets computes the target usage of each account. Data is shared out in proportion to
+// quota times priority, and an account never gets more than its quota; the excess is
+// redistributed over the remaining accounts. Accounts without a known quota are ignored.
+func Targets(accounts []*types.StorageAccount) map[string]int64 {
+	targets := make(map[string]int64)
+
+	var used int64
+	var open []*types.StorageAccount
+	for _, account := range accounts {
+		if account.TotalSpace <= 0 {
+			continue
+		}
+		used += account.UsedSpace
+		open = append(open, account)
+	}
+
+	remaining := used
+	for len(open) > 0 {
+		var totalWeight float64
+		for _, account := range open {
+			totalWeight += weight(account)
+		}
+
+		var uncapped []*types.StorageAccount
+		capped := false
+		for _, account := range open {
+			share := int64(float64(remaining) * weight(account) / totalWeight)
+			if share > account.TotalSpace {
+				targets[account.ID] = account.TotalSpace
+				remaining -= account.TotalSpace
+				capped = true
+				continue
+			}
+			uncapped = append(uncapped, account)
+		}
+
+		if !capped {
+			for _, account := range open {
+				targets[account.ID] = int64(float64(remaining) * weight(account) / totalWeight)
+			}
+			break
+		}
+		open = uncapped
+	}
+
+	return targets
+}
+
+// weight returns the share weight of an account
+func weight(account *types.StorageAccount) float64 {
+	priority := account.Priority
+	if priority <= 0 {
+		priority = 1
+	}
+	return float64(account.TotalSpace) * float64(priority)
+}
+
+// Plan computes the moves that bring every account within tolerance of its target usage.
+// Larger candidates are moved first. A move is only planned when it neither pushes the source
+// below nor the destination above its tolerance band, and the destination is the allowed account
+// furthest below its target. At most maxMoves moves are planned (0 for no limit).
+func Plan(accounts []*types.StorageAccount, candidates []Candidate, tolerance float64, maxMoves int) *types.RebalancePlan {
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+
+	targets := Targets(accounts)
+	used := make(map[string]int64)
+	band := make(map[string]int64)
+	var eligible []*types.StorageAccount
+	for _, account := range accounts {
+		if _, ok := targets[account.ID]; !ok {
+			continue
+		}
+		used[account.ID] = account.UsedSpace
+		band[account.ID] = int64(tolerance * float64(account.TotalSpace))
+		eligible = append(eligible, account)
+	}
+
+	sorted := make([]Candidate, len(candidates))
+	copy(sorted, candidates)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Move.Size > sorted[j].Move.Size
+	})
+
+	plan := &types.RebalancePlan{Tolerance: tolerance, Moves: []types.RebalanceMove{}}
+	for i := range sorted {
+		if maxMoves > 0 && len(plan.Moves) >= maxMoves {
+			break
+		}
+		candidate := &sorted[i]
+		from := candidate.Move.FromAccountID
+		size := candidate.Move.Size
+
+		target, ok := targets[from]
+		if !ok || used[from]-target <= band[from] || used[from]-size < target-band[from] {
+			continue
+		}
+
+		var destination *types.StorageAccount
+		var deficit int64
+		for _, account := range eligible {
+			if !candidate.allows(account.ID) {
+				continue
+			}
+			after := used[account.ID] + size
+			if after > targets[account.ID]+band[account.ID] || after > account.TotalSpace {
+				continue
+			}
+			if gap := targets[account.ID] - used[account.ID]; destination == nil || gap > deficit {
+				destination = account
+				deficit = gap
+			}
+		}
+		if destination == nil {
+			continue
+		}
+
+		move := candidate.Move
+		move.ToAccountID = destination.ID
+		plan.Moves = append(plan.Moves, move)
+		plan.TotalBytes += size
+		used[from] -= size
+		used[destination.ID] += size
+	}
+
+	plan.Balanced = true
+	for _, account := range eligible {
+		deviation := used[account.ID] - targets[account.ID]
+		if deviation < 0 {
+			deviation = -deviation
+		}
+		if deviation > band[account.ID] {
+			plan.Balanced = false
+		}
+		plan.Accounts = append(plan.Accounts, types.AccountTarget{
+			AccountID:    account.ID,
+			Name:         account.Name,
+			TotalSpace:   account.TotalSpace,
+			UsedSpace:    account.UsedSpace,
+			TargetSpace:  targets[account.ID],
+			PlannedSpace: used[account.ID],
+		})
+	}
+
+	return plan
+}
@@ -0,0 +1,162 @@
+package rebalance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+const gb = int64(1 << 30)
+
+func testAccounts() []*types.StorageAccount {
+	return []*types.StorageAccount{
+		{ID: "full", Name: "Full", Status: "active", TotalSpace: 100 * gb, UsedSpace: 90 * gb, Priority: 10},
+		{ID: "new", Name: "New", Status: "active", TotalSpace: 100 * gb, UsedSpace: 10 * gb, Priority: 10},
+	}
+}
+
+func candidates(from string, count int, size int64) []Candidate {
+	var result []Candidate
+	for i := 0; i < count; i++ {
+		result = append(result, Candidate{
+			Move: types.RebalanceMove{
+				Bucket:        "bucket",
+				Key:           string(rune('a' + i)),
+				Size:          size,
+				FromAccountID: from,
+			},
+		})
+	}
+	return result
+}
+
+func TestTargets_ProportionalToQuotaAndPriority(t *testing.T) {
+	accounts := []*types.StorageAccount{
+		{ID: "a", TotalSpace: 100 * gb, UsedSpace: 60 * gb, Priority: 10},
+		{ID: "b", TotalSpace: 100 * gb, UsedSpace: 0, Priority: 20},
+		{ID: "unsynced", TotalSpace: 0, UsedSpace: 0, Priority: 10},
+	}
+
+	targets := Targets(accounts)
+
+	if targets["a"] != 20*gb || targets["b"] != 40*gb {
+		t.Errorf("targets = %v, want a=20GB b=40GB", targets)
+	}
+	if _, ok := targets["unsynced"]; ok {
+		t.Error("accounts without a quota should have no target")
+	}
+}
+
+func TestTargets_CappedAtQuota(t *testing.T) {
+	accounts := []*types.StorageAccount{
+		{ID: "small", TotalSpace: 10 * gb, UsedSpace: 0, Priority: 100},
+		{ID: "large", TotalSpace: 100 * gb, UsedSpace: 50 * gb, Priority: 1},
+	}
+
+	targets := Targets(accounts)
+
+	if targets["small"] != 10*gb {
+		t.Errorf("small target = %d, want its quota", targets["small"])
+	}
+	if targets["large"] != 40*gb {
+		t.Errorf("large target = %d, want the remaining 40GB", targets["large"])
+	}
+}
+
+func TestPlan_MovesToEmptyAccount(t *testing.T) {
+	plan := Plan(testAccounts(), candidates("full", 10, 5*gb), 0.05, 0)
+
+	// Moving stops once both accounts are within 5GB of the 50GB target
+	if len(plan.Moves) != 7 {
+		t.Fatalf("moves = %d, want 7", len(plan.Moves))
+	}
+	for _, move := range plan.Moves {
+		if move.FromAccountID != "full" || move.ToAccountID != "new" {
+			t.Errorf("move %s: %s -> %s, want full -> new", move.Key, move.FromAccountID, move.ToAccountID)
+		}
+	}
+	if !plan.Balanced {
+		t.Error("plan should balance the accounts")
+	}
+	if plan.TotalBytes != 35*gb {
+		t.Errorf("total bytes = %d, want 35GB", plan.TotalBytes)
+	}
+}
+
+func TestPlan_AlreadyBalanced(t *testing.T) {
+	accounts := testAccounts()
+	accounts[0].UsedSpace = 52 * gb
+	accounts[1].UsedSpace = 48 * gb
+
+	plan := Plan(accounts, candidates("full", 5, gb), 0.05, 0)
+
+	if len(plan.Moves) != 0 {
+		t.Errorf("moves = %d, want 0 within tolerance", len(plan.Moves))
+	}
+	if !plan.Balanced {
+		t.Error("plan should report balanced accounts")
+	}
+}
+
+func TestPlan_DoesNotOvershoot(t *testing.T) {
+	// A single item larger than the whole imbalance must not be moved
+	plan := Plan(testAccounts(), candidates("full", 1, 85*gb), 0.05, 0)
+
+	if len(plan.Moves) != 0 {
+		t.Errorf("moves = %d, want 0", len(plan.Moves))
+	}
+	if plan.Balanced {
+		t.Error("plan should report that accounts stay unbalanced")
+	}
+}
+
+func TestPlan_RespectsExcludeAndAllowed(t *testing.T) {
+	accounts := append(testAccounts(), &types.StorageAccount{ID: "other", TotalSpace: 100 * gb, UsedSpace: 10 * gb, Priority: 10})
+	items := candidates("full", 2, 5*gb)
+	items[0].Exclude = []string{"new"}
+	items[1].Allowed = []string{"full", "new"}
+
+	plan := Plan(accounts, items, 0.05, 0)
+
+	if len(plan.Moves) != 2 {
+		t.Fatalf("moves = %d, want 2", len(plan.Moves))
+	}
+	for _, move := range plan.Moves {
+		if move.Key == items[0].Move.Key && move.ToAccountID != "other" {
+			t.Errorf("excluded account chosen: %s", move.ToAccountID)
+		}
+		if move.Key == items[1].Move.Key && move.ToAccountID != "new" {
+			t.Errorf("disallowed account chosen: %s", move.ToAccountID)
+		}
+	}
+}
+
+func TestPlan_MaxMoves(t *testing.T) {
+	plan := Plan(testAccounts(), candidates("full", 10, 5*gb), 0.05, 3)
+
+	if len(plan.Moves) != 3 {
+		t.Errorf("moves = %d, want 3", len(plan.Moves))
+	}
+}
+
+func TestThrottle_Delay(t *testing.T) {
+	throttle := NewThrottle(1000)
+	now := time.Now()
+	throttle.now = func() time.Time { return now }
+
+	if delay := throttle.Delay(2000); delay != 2*time.Second {
+		t.Errorf("delay = %v, want 2s", delay)
+	}
+
+	now = now.Add(3 * time.Second)
+	if delay := throttle.Delay(500); delay != 0 {
+		t.Errorf("delay = %v, want 0 when under the limit", delay)
+	}
+}
+
+func TestThrottle_Disabled(t *testing.T) {
+	if delay := NewThrottle(0).Delay(1 << 30); delay != 0 {
+		t.Errorf("delay = %v, want 0", delay)
+	}
+}
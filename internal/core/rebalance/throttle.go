@@ -0,0 +1,62 @@
+package rebalance
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Throttle limits the average transfer rate of a job
+type Throttle struct {
+	bytesPerSecond int64
+	start          time.Time
+	transferred    int64
+	mu             sync.Mutex
+	now            func() time.Time
+}
+
+// NewThrottle creates a throttle. A limit of 0 disables throttling.
+func NewThrottle(bytesPerSecond int64) *Throttle {
+	return &Throttle{
+		bytesPerSecond: bytesPerSecond,
+		now:            time.Now,
+	}
+}
+
+// Delay records transferred bytes and returns how long to pause to stay within the limit
+func (t *Throttle) Delay(bytes int64) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.bytesPerSecond <= 0 {
+		return 0
+	}
+	if t.start.IsZero() {
+		t.start = t.now()
+	}
+
+	t.transferred += bytes
+	expected := time.Duration(float64(t.transferred) / float64(t.bytesPerSecond) * float64(time.Second))
+	elapsed := t.now().Sub(t.start)
+	if expected <= elapsed {
+		return 0
+	}
+	return expected - elapsed
+}
+
+// Wait records transferred bytes and pauses as needed, returning early if ctx is done
+func (t *Throttle) Wait(ctx context.Context, bytes int64) error {
+	delay := t.Delay(bytes)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
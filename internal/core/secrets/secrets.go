@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Prefix marks values encrypted by Encrypt
+const Prefix = "enc:v1:"
+
+const (
+	saltSize   = 16
+	keySize    = 32
+	iterations = 100000
+)
+
+var (
+	// ErrNoPassphrase is returned when encrypting or decrypting without a passphrase
+	ErrNoPassphrase = errors.New("passphrase required")
+	// ErrInvalidValue is returned when a value is not a valid encrypted value
+	ErrInvalidValue = errors.New("invalid encrypted value")
+	// ErrDecrypt is returned when a value cannot be decrypted with the passphrase
+	ErrDecrypt = errors.New("wrong passphrase or corrupted value")
+)
+
+// IsEncrypted reports whether a value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// Encrypt encrypts a value with AES-256-GCM using a key derived from the passphrase
+// with PBKDF2-SHA256 and a random salt. Empty values stay empty.
+func Encrypt(value, passphrase string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nil, nonce, []byte(value), nil)
+	payload := make([]byte, 0, len(salt)+len(nonce)+len(sealed))
+	payload = append(payload, salt...)
+	payload = append(payload, nonce...)
+	payload = append(payload, sealed...)
+	return Prefix + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Decrypt decrypts a value produced by Encrypt. Values without the prefix are returned unchanged.
+func Decrypt(value, passphrase string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	if passphrase == "" {
+		return "", ErrNoPassphrase
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, Prefix))
+	if err != nil || len(payload) < saltSize {
+		return "", ErrInvalidValue
+	}
+	salt := payload[:saltSize]
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+	if len(payload) < saltSize+gcm.NonceSize() {
+		return "", ErrInvalidValue
+	}
+	nonce := payload[saltSize : saltSize+gcm.NonceSize()]
+
+	plain, err := gcm.Open(nil, nonce, payload[saltSize+gcm.NonceSize():], nil)
+	if err != nil {
+		return "", ErrDecrypt
+	}
+	return string(plain), nil
+}
+
+// newGCM derives a key from the passphrase and salt and returns an AES-GCM cipher
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,69 @@
+package secrets
+
+import "testing"
+
+func TestEncryptDecrypt(t *testing.T) {
+	encrypted, err := Encrypt("client-secret", "passphrase")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(encrypted) {
+		t.Errorf("encrypted value %q has no prefix", encrypted)
+	}
+
+	decrypted, err := Decrypt(encrypted, "passphrase")
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if decrypted != "client-secret" {
+		t.Errorf("Decrypt() = %q, want client-secret", decrypted)
+	}
+}
+
+func TestEncrypt_RandomSalt(t *testing.T) {
+	first, _ := Encrypt("value", "passphrase")
+	second, _ := Encrypt("value", "passphrase")
+
+	if first == second {
+		t.Error("encrypting twice should give different values")
+	}
+}
+
+func TestDecrypt_WrongPassphrase(t *testing.T) {
+	encrypted, _ := Encrypt("value", "passphrase")
+
+	if _, err := Decrypt(encrypted, "other"); err != ErrDecrypt {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrDecrypt)
+	}
+}
+
+func TestDecrypt_PlainValueUnchanged(t *testing.T) {
+	value, err := Decrypt("plain", "")
+	if err != nil || value != "plain" {
+		t.Errorf("Decrypt() = %q, %v, want plain value", value, err)
+	}
+}
+
+func TestPassphraseRequired(t *testing.T) {
+	if _, err := Encrypt("value", ""); err != ErrNoPassphrase {
+		t.Errorf("Encrypt() error = %v, want %v", err, ErrNoPassphrase)
+	}
+
+	encrypted, _ := Encrypt("value", "passphrase")
+	if _, err := Decrypt(encrypted, ""); err != ErrNoPassphrase {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrNoPassphrase)
+	}
+}
+
+func TestDecrypt_InvalidValue(t *testing.T) {
+	if _, err := Decrypt(Prefix+"not base64!", "passphrase"); err != ErrInvalidValue {
+		t.Errorf("Decrypt() error = %v, want %v", err, ErrInvalidValue)
+	}
+}
+
+func TestEncrypt_EmptyValue(t *testing.T) {
+	encrypted, err := Encrypt("", "")
+	if err != nil || encrypted != "" {
+		t.Errorf("Encrypt() = %q, %v, want empty value", encrypted, err)
+	}
+}
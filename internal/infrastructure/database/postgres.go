@@ -48,6 +48,22 @@ func RunMigrations(db *sql.DB) error {
 		createStarredFilesTable,
 		createTrashTable,
 		createRecentFilesTable,
+		addPlacementPolicies,
+		createObjectReplicasTable,
+		addErasureCoding,
+		addDriveTargets,
+		createAPIKeysTable,
+		createUsersTable,
+		addOwnership,
+		createACLGrantsTable,
+		createShareLinksTable,
+		createTrashEntriesTable,
+		createFileVersionsTable,
+		addContentHash,
+		createVFSJobTables,
+		createTreeIndexes,
+		createDirectoryAggregates,
+		addCopyJobConflict,
 		insertDummyAccount,
 	}
 
@@ -276,6 +292,432 @@ CREATE INDEX IF NOT EXISTS idx_recent_bucket ON recent_files(bucket);
 CREATE INDEX IF NOT EXISTS idx_recent_accessed ON recent_files(accessed_at DESC);
 `
 
+const addPlacementPolicies = `
+ALTER TABLE storage_accounts ADD COLUMN IF NOT EXISTS tags TEXT[] DEFAULT '{}';
+
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS allowed_accounts   TEXT[] DEFAULT '{}';
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS allowed_tags       TEXT[] DEFAULT '{}';
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS replication_factor INT DEFAULT 1;
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS placement_strategy VARCHAR(50);
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS pin_local          BOOLEAN DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_accounts_tags ON storage_accounts USING GIN(tags);
+`
+
+const createObjectReplicasTable = `
+CREATE TABLE IF NOT EXISTS object_replicas (
+    id              UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    
+    bucket          VARCHAR(63) NOT NULL,
+    key             VARCHAR(1024) NOT NULL,
+    chunk_index     INT NOT NULL DEFAULT -1,
+    
+    account_id      UUID NOT NULL,
+    remote_id       VARCHAR(255),
+    remote_path     TEXT,
+    
+    status          VARCHAR(50) DEFAULT 'active',
+    
+    created_at      TIMESTAMP DEFAULT NOW(),
+    
+    FOREIGN KEY (account_id) REFERENCES storage_accounts(id),
+    UNIQUE(bucket, key, chunk_index, account_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_replicas_object ON object_replicas(bucket, key, chunk_index);
+CREATE INDEX IF NOT EXISTS idx_replicas_account ON object_replicas(account_id);
+`
+
+const addErasureCoding = `
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS storage_class VARCHAR(20) DEFAULT 'single';
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS data_shards   INT DEFAULT 0;
+ALTER TABLE buckets ADD COLUMN IF NOT EXISTS parity_shards INT DEFAULT 0;
+
+ALTER TABLE object_chunks ADD COLUMN IF NOT EXISTS shard_index   INT NOT NULL DEFAULT 0;
+ALTER TABLE object_chunks ADD COLUMN IF NOT EXISTS data_shards   INT DEFAULT 0;
+ALTER TABLE object_chunks ADD COLUMN IF NOT EXISTS parity_shards INT DEFAULT 0;
+ALTER TABLE object_chunks ADD COLUMN IF NOT EXISTS stripe_size   BIGINT DEFAULT 0;
+
+ALTER TABLE object_chunks DROP CONSTRAINT IF EXISTS object_chunks_bucket_key_chunk_index_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_chunks_shard ON object_chunks(bucket, key, chunk_index, shard_index);
+`
+
+const addDriveTargets = `
+ALTER TABLE storage_accounts ADD COLUMN IF NOT EXISTS auth_type  VARCHAR(50) DEFAULT 'delegated';
+ALTER TABLE storage_accounts ADD COLUMN IF NOT EXISTS drive_type VARCHAR(20) DEFAULT 'me';
+ALTER TABLE storage_accounts ADD COLUMN IF NOT EXISTS drive_id   VARCHAR(255) DEFAULT '';
+`
+
+const createAPIKeysTable = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id              UUID PRIMARY KEY,
+    name            VARCHAR(255) NOT NULL,
+    prefix          VARCHAR(20) NOT NULL,
+    key_hash        VARCHAR(64) UNIQUE NOT NULL,
+    scopes          TEXT[] NOT NULL DEFAULT '{}',
+    buckets         TEXT[] DEFAULT '{}',
+    expires_at      TIMESTAMP,
+    last_used_at    TIMESTAMP,
+    created_at      TIMESTAMP DEFAULT NOW()
+);
+`
+
+const createUsersTable = `
+CREATE TABLE IF NOT EXISTS users (
+    id              UUID PRIMARY KEY,
+    username        VARCHAR(255) UNIQUE NOT NULL,
+    email           VARCHAR(255),
+    display_name    VARCHAR(255),
+    password_hash   TEXT,
+    oidc_subject    VARCHAR(512) UNIQUE,
+    is_admin        BOOLEAN DEFAULT FALSE,
+    last_login_at   TIMESTAMP,
+    created_at      TIMESTAMP DEFAULT NOW(),
+    updated_at      TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    id              VARCHAR(64) PRIMARY KEY,  -- SHA-256 of the session token
+    user_id         UUID NOT NULL,
+    expires_at      TIMESTAMP NOT NULL,
+    created_at      TIMESTAMP DEFAULT NOW(),
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(user_id);
+CREATE INDEX IF NOT EXISTS idx_sessions_expires ON sessions(expires_at);
+`
+
+const addOwnership = `
+ALTER TABLE buckets             ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE virtual_directories ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE virtual_files       ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE trash               ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE api_keys            ADD COLUMN IF NOT EXISTS user_id  UUID REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS idx_buckets_owner ON buckets(owner_id);
+
+-- Stars and recent files belong to the user who made them, '' for callers without a user
+ALTER TABLE starred_files ADD COLUMN IF NOT EXISTS user_id VARCHAR(36) NOT NULL DEFAULT '';
+ALTER TABLE recent_files  ADD COLUMN IF NOT EXISTS user_id VARCHAR(36) NOT NULL DEFAULT '';
+
+ALTER TABLE starred_files DROP CONSTRAINT IF EXISTS starred_files_bucket_file_id_key;
+ALTER TABLE recent_files  DROP CONSTRAINT IF EXISTS recent_files_bucket_file_id_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_starred_user_file ON starred_files(bucket, file_id, user_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_recent_user_file ON recent_files(bucket, file_id, user_id);
+`
+
+const createACLGrantsTable = `
+CREATE TABLE IF NOT EXISTS acl_grants (
+    id              UUID PRIMARY KEY,
+    bucket          VARCHAR(63) NOT NULL,
+    directory_id    UUID,                  -- NULL for a grant on the whole bucket
+    user_id         UUID NOT NULL,
+    role            VARCHAR(20) NOT NULL,  -- 'viewer', 'editor' or 'owner'
+    granted_by      VARCHAR(36) NOT NULL DEFAULT '',
+    created_at      TIMESTAMP DEFAULT NOW(),
+
+    FOREIGN KEY (bucket) REFERENCES buckets(name) ON DELETE CASCADE,
+    FOREIGN KEY (directory_id) REFERENCES virtual_directories(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- One grant per user and directory; NULL directories are made comparable for the bucket grant
+CREATE UNIQUE INDEX IF NOT EXISTS idx_acl_grants_target ON acl_grants(
+    bucket, COALESCE(directory_id, '00000000-0000-0000-0000-000000000000'::uuid), user_id
+);
+CREATE INDEX IF NOT EXISTS idx_acl_grants_user ON acl_grants(user_id, bucket);
+CREATE INDEX IF NOT EXISTS idx_acl_grants_directory ON acl_grants(directory_id);
+`
+
+const createShareLinksTable = `
+CREATE TABLE IF NOT EXISTS share_links (
+    id                UUID PRIMARY KEY,
+    token_hash        VARCHAR(64) NOT NULL UNIQUE,  -- SHA-256 of the token
+    prefix            VARCHAR(16) NOT NULL,
+    bucket            VARCHAR(63) NOT NULL,
+    file_id           UUID,                         -- the shared file, or
+    directory_id      UUID,                         -- the shared directory, both NULL for the bucket root
+    password_hash     TEXT,
+    mode              VARCHAR(10) NOT NULL DEFAULT 'read',  -- 'read' or 'upload'
+    expires_at        TIMESTAMP,
+    max_downloads     INT NOT NULL DEFAULT 0,       -- 0 for no limit
+    downloads         BIGINT NOT NULL DEFAULT 0,
+    views             BIGINT NOT NULL DEFAULT 0,
+    uploads           BIGINT NOT NULL DEFAULT 0,
+    last_accessed_at  TIMESTAMP,
+    created_by        VARCHAR(36) NOT NULL DEFAULT '',
+    created_at        TIMESTAMP DEFAULT NOW(),
+
+    FOREIGN KEY (bucket) REFERENCES buckets(name) ON DELETE CASCADE,
+    FOREIGN KEY (file_id) REFERENCES virtual_files(id) ON DELETE CASCADE,
+    FOREIGN KEY (directory_id) REFERENCES virtual_directories(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_bucket ON share_links(bucket);
+
+CREATE TABLE IF NOT EXISTS share_accesses (
+    id              UUID PRIMARY KEY,
+    share_id        UUID NOT NULL,
+    action          VARCHAR(20) NOT NULL,  -- 'view', 'download', 'upload' or 'denied'
+    path            TEXT NOT NULL DEFAULT '',
+    remote_addr     VARCHAR(64) NOT NULL DEFAULT '',
+    user_agent      TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP DEFAULT NOW(),
+
+    FOREIGN KEY (share_id) REFERENCES share_links(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_accesses_share ON share_accesses(share_id, created_at DESC);
+`
+
+const createTrashEntriesTable = `
+-- Files and directories deleted along with a directory, kept so it can be restored whole
+CREATE TABLE IF NOT EXISTS trash_entries (
+    trash_id        UUID NOT NULL,
+    entry_type      VARCHAR(20) NOT NULL,  -- 'file' or 'directory'
+    original_id     UUID NOT NULL,
+    path            TEXT NOT NULL,         -- relative to the deleted directory
+    name            VARCHAR(255) NOT NULL,
+
+    -- For files only
+    object_key      VARCHAR(1024),
+    size            BIGINT,
+    mime_type       VARCHAR(255),
+
+    owner_id        UUID REFERENCES users(id) ON DELETE SET NULL,
+
+    PRIMARY KEY (trash_id, original_id),
+    FOREIGN KEY (trash_id) REFERENCES trash(id) ON DELETE CASCADE
+);
+`
+
+const createFileVersionsTable = `
+ALTER TABLE virtual_files ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+
+-- Previous contents of files. file_id has no foreign key so versions stay with a file
+-- while it is in the trash; they are deleted when it is purged.
+CREATE TABLE IF NOT EXISTS file_versions (
+    id              UUID PRIMARY KEY,
+    file_id         UUID NOT NULL,
+    bucket          VARCHAR(63) NOT NULL,
+    version         INT NOT NULL,
+    object_key      VARCHAR(1024) NOT NULL,
+    size            BIGINT NOT NULL,
+    mime_type       VARCHAR(255),
+    created_at      TIMESTAMP NOT NULL,     -- when the content was uploaded
+    archived_at     TIMESTAMP DEFAULT NOW(), -- when it was replaced
+
+    UNIQUE (file_id, version),
+    FOREIGN KEY (bucket) REFERENCES buckets(name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_file_versions_bucket ON file_versions(bucket, archived_at);
+
+CREATE TABLE IF NOT EXISTS version_rules (
+    bucket          VARCHAR(63) PRIMARY KEY REFERENCES buckets(name) ON DELETE CASCADE,
+    keep_versions   INT NOT NULL DEFAULT 0,
+    keep_days       INT NOT NULL DEFAULT 0,
+    updated_at      TIMESTAMP DEFAULT NOW()
+);
+`
+
+// SHA-256 of file contents, used for entity tags. NULL for content uploaded before it was recorded.
+const addContentHash = `
+ALTER TABLE virtual_files ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+ALTER TABLE file_versions ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64);
+`
+
+const createVFSJobTables = `
+-- Directory copies in progress, resumed from their checkpoint after a restart
+CREATE TABLE IF NOT EXISTS copy_jobs (
+    id              UUID PRIMARY KEY,
+    bucket          VARCHAR(63) NOT NULL,
+    source          TEXT NOT NULL,
+    destination     TEXT NOT NULL,
+    owner_id        UUID,
+    checkpoint      TEXT NOT NULL DEFAULT '',  -- full path of the last source file copied
+    copied          BIGINT NOT NULL DEFAULT 0,
+    total           BIGINT NOT NULL DEFAULT 0,
+    created_at      TIMESTAMP DEFAULT NOW(),
+    updated_at      TIMESTAMP DEFAULT NOW(),
+
+    FOREIGN KEY (bucket) REFERENCES buckets(name) ON DELETE CASCADE
+);
+
+-- Objects no virtual file refers to any more. They are queued in the transaction that
+-- removes their last reference and deleted from storage afterwards, so a crash in between
+-- cannot leave them orphaned.
+CREATE TABLE IF NOT EXISTS object_deletions (
+    bucket          VARCHAR(63) NOT NULL,
+    object_key      VARCHAR(1024) NOT NULL,
+    queued_at       TIMESTAMP DEFAULT NOW(),
+
+    PRIMARY KEY (bucket, object_key)
+);
+`
+
+// The tree is a materialized path: full_path plus parent links. text_pattern_ops lets
+// subtree queries (full_path LIKE 'prefix/%') run as index range scans in any collation,
+// and the listing indexes return a directory's children already sorted.
+const createTreeIndexes = `
+CREATE INDEX IF NOT EXISTS idx_vdir_subtree ON virtual_directories(bucket, full_path text_pattern_ops);
+CREATE INDEX IF NOT EXISTS idx_vfile_subtree ON virtual_files(bucket, full_path text_pattern_ops);
+
+CREATE INDEX IF NOT EXISTS idx_vdir_list_created ON virtual_directories(bucket, parent_id, created_at, name, id);
+CREATE INDEX IF NOT EXISTS idx_vfile_list_size ON virtual_files(bucket, directory_id, size, name, id);
+CREATE INDEX IF NOT EXISTS idx_vfile_list_updated ON virtual_files(bucket, directory_id, updated_at, name, id);
+CREATE INDEX IF NOT EXISTS idx_vfile_list_type ON virtual_files(bucket, directory_id, COALESCE(mime_type, ''), name, id);
+`
+
+// createDirectoryAggregates keeps the total size, file count and subdirectory count of
+// every directory's subtree. Triggers add each change to the directory it happens in and
+// all its ancestors, so the totals stay right for every statement that changes the tree.
+// Rows removed by a cascading delete find their parent already gone and change nothing:
+// the deleted directory took its whole subtree off its ancestors.
+const createDirectoryAggregates = `
+CREATE OR REPLACE FUNCTION vfs_adjust_aggregates(p_dir UUID, p_bytes BIGINT, p_files BIGINT, p_dirs BIGINT)
+RETURNS void AS $$
+BEGIN
+    IF p_dir IS NULL OR (p_bytes = 0 AND p_files = 0 AND p_dirs = 0) THEN
+        RETURN;
+    END IF;
+    WITH RECURSIVE chain(id, parent_id) AS (
+        SELECT id, parent_id FROM virtual_directories WHERE id = p_dir
+        UNION ALL
+        SELECT d.id, d.parent_id FROM virtual_directories d JOIN chain c ON d.id = c.parent_id
+    )
+    UPDATE virtual_directories v
+    SET total_size = v.total_size + p_bytes,
+        file_count = v.file_count + p_files,
+        dir_count = v.dir_count + p_dirs
+    FROM chain
+    WHERE v.id = chain.id;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Recomputes the aggregates of a bucket's directories, or of all of them for NULL, and
+-- returns how many were wrong
+CREATE OR REPLACE FUNCTION vfs_repair_aggregates(p_bucket VARCHAR)
+RETURNS BIGINT AS $$
+DECLARE
+    fixed BIGINT;
+BEGIN
+    WITH RECURSIVE file_up(dir_id, size) AS (
+        SELECT directory_id, size FROM virtual_files
+        WHERE directory_id IS NOT NULL AND (p_bucket IS NULL OR bucket = p_bucket)
+        UNION ALL
+        SELECT d.parent_id, f.size FROM file_up f JOIN virtual_directories d ON d.id = f.dir_id
+        WHERE d.parent_id IS NOT NULL
+    ),
+    dir_up(dir_id) AS (
+        SELECT parent_id FROM virtual_directories
+        WHERE parent_id IS NOT NULL AND (p_bucket IS NULL OR bucket = p_bucket)
+        UNION ALL
+        SELECT d.parent_id FROM dir_up u JOIN virtual_directories d ON d.id = u.dir_id
+        WHERE d.parent_id IS NOT NULL
+    ),
+    files AS (
+        SELECT dir_id, SUM(size)::bigint AS total_size, COUNT(*) AS file_count FROM file_up GROUP BY dir_id
+    ),
+    dirs AS (
+        SELECT dir_id, COUNT(*) AS dir_count FROM dir_up GROUP BY dir_id
+    ),
+    actual AS (
+        SELECT v.id,
+            COALESCE(f.total_size, 0) AS total_size,
+            COALESCE(f.file_count, 0) AS file_count,
+            COALESCE(d.dir_count, 0) AS dir_count
+        FROM virtual_directories v
+        LEFT JOIN files f ON f.dir_id = v.id
+        LEFT JOIN dirs d ON d.dir_id = v.id
+        WHERE p_bucket IS NULL OR v.bucket = p_bucket
+    )
+    UPDATE virtual_directories v
+    SET total_size = a.total_size, file_count = a.file_count, dir_count = a.dir_count
+    FROM actual a
+    WHERE v.id = a.id
+      AND (v.total_size, v.file_count, v.dir_count) IS DISTINCT FROM (a.total_size, a.file_count, a.dir_count);
+    GET DIAGNOSTICS fixed = ROW_COUNT;
+    RETURN fixed;
+END;
+$$ LANGUAGE plpgsql;
+
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'virtual_directories' AND column_name = 'total_size'
+    ) THEN
+        ALTER TABLE virtual_directories
+            ADD COLUMN total_size BIGINT NOT NULL DEFAULT 0,
+            ADD COLUMN file_count BIGINT NOT NULL DEFAULT 0,
+            ADD COLUMN dir_count BIGINT NOT NULL DEFAULT 0;
+        PERFORM vfs_repair_aggregates(NULL);
+    END IF;
+END $$;
+
+CREATE OR REPLACE FUNCTION vfs_file_aggregates()
+RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM vfs_adjust_aggregates(OLD.directory_id, -OLD.size, -1, 0);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM vfs_adjust_aggregates(NEW.directory_id, NEW.size, 1, 0);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION vfs_directory_aggregates()
+RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM vfs_adjust_aggregates(OLD.parent_id, -OLD.total_size, -OLD.file_count, -OLD.dir_count - 1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM vfs_adjust_aggregates(NEW.parent_id, NEW.total_size, NEW.file_count, NEW.dir_count + 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS vfs_file_aggregates ON virtual_files;
+CREATE TRIGGER vfs_file_aggregates
+    AFTER INSERT OR DELETE ON virtual_files
+    FOR EACH ROW EXECUTE FUNCTION vfs_file_aggregates();
+
+DROP TRIGGER IF EXISTS vfs_file_aggregates_update ON virtual_files;
+CREATE TRIGGER vfs_file_aggregates_update
+    AFTER UPDATE OF directory_id, size ON virtual_files
+    FOR EACH ROW
+    WHEN (OLD.directory_id IS DISTINCT FROM NEW.directory_id OR OLD.size <> NEW.size)
+    EXECUTE FUNCTION vfs_file_aggregates();
+
+DROP TRIGGER IF EXISTS vfs_directory_aggregates ON virtual_directories;
+CREATE TRIGGER vfs_directory_aggregates
+    AFTER INSERT OR DELETE ON virtual_directories
+    FOR EACH ROW EXECUTE FUNCTION vfs_directory_aggregates();
+
+DROP TRIGGER IF EXISTS vfs_directory_aggregates_update ON virtual_directories;
+CREATE TRIGGER vfs_directory_aggregates_update
+    AFTER UPDATE OF parent_id ON virtual_directories
+    FOR EACH ROW
+    WHEN (OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+    EXECUTE FUNCTION vfs_directory_aggregates();
+
+CREATE INDEX IF NOT EXISTS idx_vdir_total_size ON virtual_directories(bucket, total_size DESC);
+CREATE INDEX IF NOT EXISTS idx_vdir_list_size ON virtual_directories(bucket, parent_id, total_size, name, id);
+`
+
+// addCopyJobConflict records how a directory copy resolves name conflicts, so a resumed
+// copy keeps resolving them the same way
+const addCopyJobConflict = `
+ALTER TABLE copy_jobs ADD COLUMN IF NOT EXISTS conflict VARCHAR(16) NOT NULL DEFAULT '';
+`
+
 const insertDummyAccount = `
 INSERT INTO storage_accounts (
     id, name, email, client_id, client_secret, tenant_id, status
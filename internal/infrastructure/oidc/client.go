@@ -0,0 +1,277 @@
+// Package oidc signs users in with an OpenID Connect provider using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/core/idtoken"
+)
+
+// Config represents OpenID Connect client configuration
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // defaults to openid, profile and email
+}
+
+// Provider holds the endpoints of an OpenID Connect provider, read from its discovery document
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// TokenResponse represents the token response of the provider
+type TokenResponse struct {
+	TokenType   string `json:"token_type"`
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// UserInfo represents the claims returned by the userinfo endpoint
+type UserInfo struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+}
+
+// Client talks to an OpenID Connect provider
+type Client struct {
+	config     Config
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	provider *Provider
+
+	keysMu    sync.Mutex
+	keys      *idtoken.KeySet
+	keysFetch time.Time
+}
+
+// keyRefreshInterval is the least time between two fetches of the provider's keys, so
+// tokens with unknown keys cannot make the server hammer the provider
+const keyRefreshInterval = time.Minute
+
+// NewClient creates a new OpenID Connect client. The discovery document is fetched on first use.
+func NewClient(config Config) *Client {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "profile", "email"}
+	}
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+	return &Client{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Issuer returns the issuer URL of the provider
+func (c *Client) Issuer() string {
+	return c.config.Issuer
+}
+
+// Discover returns the endpoints of the provider, fetching the discovery document once
+func (c *Client) Discover(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	var provider Provider
+	if err := c.getJSON(ctx, c.config.Issuer+"/.well-known/openid-configuration", "", &provider); err != nil {
+		return nil, fmt.Errorf("discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != c.config.Issuer {
+		return nil, fmt.Errorf("discovery returned issuer %q, expected %q", provider.Issuer, c.config.Issuer)
+	}
+	if provider.AuthorizationEndpoint == "" || provider.TokenEndpoint == "" || provider.UserinfoEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document is missing endpoints")
+	}
+
+	c.provider = &provider
+	return c.provider, nil
+}
+
+// AuthorizationURL returns the URL that starts a sign-in.
+// codeChallenge is the S256 PKCE challenge of the verifier later passed to Exchange,
+// and nonce is echoed in the ID token later passed to VerifyIDToken.
+func (c *Client) AuthorizationURL(ctx context.Context, redirectURI, state, codeChallenge, nonce string) (string, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Add("client_id", c.config.ClientID)
+	params.Add("response_type", "code")
+	params.Add("redirect_uri", redirectURI)
+	params.Add("scope", strings.Join(c.config.Scopes, " "))
+	params.Add("state", state)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
+	params.Add("nonce", nonce)
+
+	sep := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return provider.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange exchanges an authorization code for tokens, proving possession of the PKCE code verifier
+func (c *Client) Exchange(ctx context.Context, code, redirectURI, codeVerifier string) (*TokenResponse, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data := url.Values{}
+	data.Set("client_id", c.config.ClientID)
+	data.Set("client_secret", c.config.ClientSecret)
+	data.Set("code", code)
+	data.Set("redirect_uri", redirectURI)
+	data.Set("grant_type", "authorization_code")
+	data.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token exchange failed: %s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("token response has no access token")
+	}
+	if tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("token response has no ID token")
+	}
+
+	return &tokenResp, nil
+}
+
+// VerifyIDToken checks the signature of an ID token against the provider's keys and its
+// issuer, audience, expiry and nonce, and returns its claims. The keys are fetched again
+// when the token is signed with one they do not include.
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (*idtoken.Claims, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	expected := idtoken.Expected{
+		Issuer:   provider.Issuer,
+		ClientID: c.config.ClientID,
+		Nonce:    nonce,
+		Now:      time.Now(),
+	}
+
+	keys, err := c.keySet(ctx, provider, false)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := idtoken.Verify(rawIDToken, keys, expected)
+	if err == idtoken.ErrUnknownKey {
+		if keys, err = c.keySet(ctx, provider, true); err != nil {
+			return nil, err
+		}
+		claims, err = idtoken.Verify(rawIDToken, keys, expected)
+	}
+	return claims, err
+}
+
+// keySet returns the provider's signing keys, fetching them when none are cached or
+// when refresh is set and the last fetch is old enough
+func (c *Client) keySet(ctx context.Context, provider *Provider, refresh bool) (*idtoken.KeySet, error) {
+	c.keysMu.Lock()
+	defer c.keysMu.Unlock()
+	if c.keys != nil && (!refresh || time.Since(c.keysFetch) < keyRefreshInterval) {
+		return c.keys, nil
+	}
+
+	var raw json.RawMessage
+	if err := c.getJSON(ctx, provider.JWKSURI, "", &raw); err != nil {
+		return nil, fmt.Errorf("key set request failed: %w", err)
+	}
+	keys, err := idtoken.ParseKeySet(raw)
+	if err != nil {
+		return nil, err
+	}
+	c.keys = keys
+	c.keysFetch = time.Now()
+	return keys, nil
+}
+
+// UserInfo returns the profile claims of the signed-in user. Who the user is comes from
+// the verified ID token; callers check that the subjects match.
+func (c *Client) UserInfo(ctx context.Context, accessToken string) (*UserInfo, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var info UserInfo
+	if err := c.getJSON(ctx, provider.UserinfoEndpoint, accessToken, &info); err != nil {
+		return nil, fmt.Errorf("userinfo request failed: %w", err)
+	}
+	if info.Subject == "" {
+		return nil, fmt.Errorf("userinfo response has no subject")
+	}
+
+	return &info, nil
+}
+
+// getJSON fetches a JSON document, with a bearer token when one is given
+func (c *Client) getJSON(ctx context.Context, target, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -3,6 +3,7 @@ package onedrive
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -44,8 +45,9 @@ func NewAuth(config AuthConfig) *Auth {
 	}
 }
 
-// GetAuthorizationURL returns the URL for user authorization
-func (a *Auth) GetAuthorizationURL(state string) string {
+// GetAuthorizationURL returns the URL for user authorization.
+// codeChallenge is the S256 PKCE challenge of the verifier later passed to ExchangeCode.
+func (a *Auth) GetAuthorizationURL(state, codeChallenge string) string {
 	params := url.Values{}
 	params.Add("client_id", a.config.ClientID)
 	params.Add("response_type", "code")
@@ -53,13 +55,15 @@ func (a *Auth) GetAuthorizationURL(state string) string {
 	params.Add("response_mode", "query")
 	params.Add("scope", "offline_access Files.ReadWrite.All")
 	params.Add("state", state)
+	params.Add("code_challenge", codeChallenge)
+	params.Add("code_challenge_method", "S256")
 
 	baseURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/authorize", a.config.TenantID)
 	return fmt.Sprintf("%s?%s", baseURL, params.Encode())
 }
 
-// ExchangeCode exchanges authorization code for access token
-func (a *Auth) ExchangeCode(ctx context.Context, code string) (*TokenResponse, error) {
+// ExchangeCode exchanges authorization code for access token, proving possession of the PKCE code verifier
+func (a *Auth) ExchangeCode(ctx context.Context, code, codeVerifier string) (*TokenResponse, error) {
 	tokenURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", a.config.TenantID)
 
 	data := url.Values{}
@@ -68,6 +72,7 @@ func (a *Auth) ExchangeCode(ctx context.Context, code string) (*TokenResponse, e
 	data.Set("code", code)
 	data.Set("redirect_uri", a.config.RedirectURI)
 	data.Set("grant_type", "authorization_code")
+	data.Set("code_verifier", codeVerifier)
 	data.Set("scope", "offline_access Files.ReadWrite.All")
 
 	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
@@ -133,6 +138,184 @@ func (a *Auth) RefreshToken(ctx context.Context, refreshToken string) (*TokenRes
 	return &tokenResp, nil
 }
 
+// DeviceCodeResponse represents an OAuth2 device authorization response
+type DeviceCodeResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+	Message         string `json:"message"`
+}
+
+// Device flow polling errors (RFC 8628)
+var (
+	ErrAuthorizationPending  = errors.New("authorization pending")
+	ErrSlowDown              = errors.New("polling too fast")
+	ErrAuthorizationDeclined = errors.New("authorization declined by the user")
+	ErrDeviceCodeExpired     = errors.New("device code expired")
+)
+
+// RequestDeviceCode starts the OAuth2 device authorization grant.
+// The user enters the returned user code at the verification URI on any device.
+func (a *Auth) RequestDeviceCode(ctx context.Context) (*DeviceCodeResponse, error) {
+	codeURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/devicecode", a.config.TenantID)
+
+	data := url.Values{}
+	data.Set("client_id", a.config.ClientID)
+	data.Set("scope", "offline_access Files.ReadWrite.All")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", codeURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("device code request failed: %s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	var codeResp DeviceCodeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&codeResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &codeResp, nil
+}
+
+// PollDeviceToken asks once whether the user has completed a device authorization.
+// It returns ErrAuthorizationPending or ErrSlowDown while the user has not finished.
+func (a *Auth) PollDeviceToken(ctx context.Context, deviceCode string) (*TokenResponse, error) {
+	tokenURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", a.config.TenantID)
+
+	data := url.Values{}
+	data.Set("client_id", a.config.ClientID)
+	if a.config.ClientSecret != "" {
+		data.Set("client_secret", a.config.ClientSecret)
+	}
+	data.Set("device_code", deviceCode)
+	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var oauthErr struct {
+			Error string `json:"error"`
+		}
+		json.Unmarshal(body, &oauthErr)
+		switch oauthErr.Error {
+		case "authorization_pending":
+			return nil, ErrAuthorizationPending
+		case "slow_down":
+			return nil, ErrSlowDown
+		case "authorization_declined", "access_denied":
+			return nil, ErrAuthorizationDeclined
+		case "expired_token":
+			return nil, ErrDeviceCodeExpired
+		}
+		return nil, fmt.Errorf("device token request failed: %s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &tokenResp, nil
+}
+
+// WaitForDeviceToken polls until the user completes a device authorization, the code
+// expires or ctx is done. The polling interval grows when the server asks to slow down.
+func (a *Auth) WaitForDeviceToken(ctx context.Context, code *DeviceCodeResponse) (*TokenResponse, error) {
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+
+	for {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		tokenResp, err := a.PollDeviceToken(ctx, code.DeviceCode)
+		switch {
+		case err == nil:
+			return tokenResp, nil
+		case errors.Is(err, ErrSlowDown):
+			interval += 5 * time.Second
+		case !errors.Is(err, ErrAuthorizationPending):
+			return nil, err
+		}
+
+		if code.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, ErrDeviceCodeExpired
+		}
+	}
+}
+
+// ClientCredentialsToken gets an app-only access token with the client credentials grant.
+// The app registration needs the Files.ReadWrite.All or Sites.ReadWrite.All application
+// permission with admin consent. No refresh token is issued; request a new token instead.
+func (a *Auth) ClientCredentialsToken(ctx context.Context) (*TokenResponse, error) {
+	tokenURL := fmt.Sprintf("https://login.microsoftonline.com/%s/oauth2/v2.0/token", a.config.TenantID)
+
+	data := url.Values{}
+	data.Set("client_id", a.config.ClientID)
+	data.Set("client_secret", a.config.ClientSecret)
+	data.Set("grant_type", "client_credentials")
+	data.Set("scope", "https://graph.microsoft.com/.default")
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("client credentials grant failed: %s (status: %d)", string(body), resp.StatusCode)
+	}
+
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &tokenResp, nil
+}
+
 // ValidateToken checks if a token is valid
 func (a *Auth) ValidateToken(ctx context.Context, accessToken string) (bool, error) {
 	// Try to get drive info to validate token
@@ -15,19 +15,31 @@ type Client struct {
 	httpClient  *http.Client
 	accessToken string
 	baseURL     string
+	drive       DriveRef
 }
 
-// NewClient creates a new OneDrive client
+// NewClient creates a new OneDrive client for the signed-in user's drive
 func NewClient(accessToken string) *Client {
+	return NewDriveClient(accessToken, MeDrive)
+}
+
+// NewDriveClient creates a new OneDrive client for a specific drive
+func NewDriveClient(accessToken string, drive DriveRef) *Client {
 	return &Client{
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
 		accessToken: accessToken,
 		baseURL:     "https://graph.microsoft.com/v1.0",
+		drive:       drive,
 	}
 }
 
+// driveURL returns the URL of the client's drive
+func (c *Client) driveURL() string {
+	return c.baseURL + c.drive.Path()
+}
+
 // DriveItem represents a OneDrive item (file or folder)
 type DriveItem struct {
 	ID               string                 `json:"id"`
@@ -95,7 +107,7 @@ type UploadSession struct {
 
 // GetDrive retrieves drive information
 func (c *Client) GetDrive(ctx context.Context) (*Drive, error) {
-	url := fmt.Sprintf("%s/me/drive", c.baseURL)
+	url := c.driveURL()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -125,7 +137,7 @@ func (c *Client) GetDrive(ctx context.Context) (*Drive, error) {
 
 // UploadSmallFile uploads a file smaller than 4MB
 func (c *Client) UploadSmallFile(ctx context.Context, path string, data []byte) (*DriveItem, error) {
-	url := fmt.Sprintf("%s/me/drive/root:/%s:/content", c.baseURL, path)
+	url := fmt.Sprintf("%s/root:/%s:/content", c.driveURL(), path)
 
 	req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(data))
 	if err != nil {
@@ -157,7 +169,7 @@ func (c *Client) UploadSmallFile(ctx context.Context, path string, data []byte)
 // DownloadFile downloads a file from OneDrive
 func (c *Client) DownloadFile(ctx context.Context, itemID string) ([]byte, error) {
 	// First get the download URL
-	url := fmt.Sprintf("%s/me/drive/items/%s", c.baseURL, itemID)
+	url := fmt.Sprintf("%s/items/%s", c.driveURL(), itemID)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -208,7 +220,7 @@ func (c *Client) DownloadFile(ctx context.Context, itemID string) ([]byte, error
 
 // DeleteFile deletes a file from OneDrive
 func (c *Client) DeleteFile(ctx context.Context, itemID string) error {
-	url := fmt.Sprintf("%s/me/drive/items/%s", c.baseURL, itemID)
+	url := fmt.Sprintf("%s/items/%s", c.driveURL(), itemID)
 
 	req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
 	if err != nil {
@@ -233,7 +245,7 @@ func (c *Client) DeleteFile(ctx context.Context, itemID string) error {
 
 // CreateUploadSession creates an upload session for large files
 func (c *Client) CreateUploadSession(ctx context.Context, path string) (*UploadSession, error) {
-	url := fmt.Sprintf("%s/me/drive/root:/%s:/createUploadSession", c.baseURL, path)
+	url := fmt.Sprintf("%s/root:/%s:/createUploadSession", c.driveURL(), path)
 
 	body := map[string]interface{}{
 		"item": map[string]interface{}{
@@ -299,7 +311,7 @@ func (c *Client) UploadChunk(ctx context.Context, uploadURL string, chunk []byte
 
 // GetItem retrieves item metadata
 func (c *Client) GetItem(ctx context.Context, itemID string) (*DriveItem, error) {
-	url := fmt.Sprintf("%s/me/drive/items/%s", c.baseURL, itemID)
+	url := fmt.Sprintf("%s/items/%s", c.driveURL(), itemID)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
@@ -339,7 +351,7 @@ func (c *Client) GetThumbnail(ctx context.Context, itemID string, size string) (
 	// For simplicity, we use the standard sizes
 	// https://graph.microsoft.com/v1.0/me/drive/items/{item-id}/thumbnails/0/{size}/content
 	
-	url := fmt.Sprintf("%s/me/drive/items/%s/thumbnails/0/%s/content", c.baseURL, itemID, size)
+	url := fmt.Sprintf("%s/items/%s/thumbnails/0/%s/content", c.driveURL(), itemID, size)
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
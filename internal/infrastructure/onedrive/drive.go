@@ -0,0 +1,54 @@
+package onedrive
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Drive reference kinds
+const (
+	DriveKindMe    = "me"    // the signed-in user's OneDrive, delegated tokens only
+	DriveKindDrive = "drive" // a drive by ID
+	DriveKindUser  = "user"  // a user's OneDrive for Business, by user ID or UPN
+	DriveKindSite  = "site"  // the default document library of a SharePoint site
+)
+
+// DriveRef identifies the drive a client works on
+type DriveRef struct {
+	Kind string
+	ID   string
+}
+
+// MeDrive is the drive of the user a delegated token belongs to
+var MeDrive = DriveRef{Kind: DriveKindMe}
+
+// Validate checks that the reference names a drive. App-only tokens have no
+// signed-in user, so they need a reference other than "me".
+func (d DriveRef) Validate() error {
+	switch d.Kind {
+	case "", DriveKindMe:
+		return nil
+	case DriveKindDrive, DriveKindUser, DriveKindSite:
+		if d.ID == "" {
+			return fmt.Errorf("drive_id is required for drive_type %q", d.Kind)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown drive_type %q", d.Kind)
+	}
+}
+
+// Path returns the Graph API path of the drive, relative to the API base URL
+func (d DriveRef) Path() string {
+	id := url.PathEscape(d.ID)
+	switch d.Kind {
+	case DriveKindDrive:
+		return "/drives/" + id
+	case DriveKindUser:
+		return "/users/" + id + "/drive"
+	case DriveKindSite:
+		return "/sites/" + id + "/drive"
+	default:
+		return "/me/drive"
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 )
 
@@ -24,9 +25,10 @@ func (r *AccountRepository) Create(ctx context.Context, account *types.StorageAc
 		INSERT INTO storage_accounts (
 			id, name, email, client_id, client_secret, tenant_id,
 			refresh_token, access_token, token_expires,
-			total_space, used_space, status, priority,
+			total_space, used_space, status, priority, tags,
+			auth_type, drive_type, drive_id,
 			created_at, updated_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 	`
 
 	now := time.Now()
@@ -35,7 +37,8 @@ func (r *AccountRepository) Create(ctx context.Context, account *types.StorageAc
 		account.ClientID, account.ClientSecret, account.TenantID,
 		account.RefreshToken, account.AccessToken, account.TokenExpires,
 		account.TotalSpace, account.UsedSpace,
-		account.Status, account.Priority,
+		account.Status, account.Priority, pq.Array(account.Tags),
+		account.AuthType, account.DriveType, account.DriveID,
 		now, now,
 	)
 
@@ -56,7 +59,9 @@ func (r *AccountRepository) Get(ctx context.Context, id string) (*types.StorageA
 		       COALESCE(refresh_token, ''), COALESCE(access_token, ''), token_expires,
 		       COALESCE(total_space, 0), COALESCE(used_space, 0), 
 		       COALESCE(status, 'pending'), COALESCE(priority, 0),
-		       last_sync, error_message, created_at, updated_at
+		       last_sync, error_message, COALESCE(tags, '{}'),
+		       COALESCE(auth_type, 'delegated'), COALESCE(drive_type, 'me'), COALESCE(drive_id, ''),
+		       created_at, updated_at
 		FROM storage_accounts
 		WHERE id = $1
 	`
@@ -71,7 +76,8 @@ func (r *AccountRepository) Get(ctx context.Context, id string) (*types.StorageA
 		&account.RefreshToken, &account.AccessToken, &tokenExpires,
 		&account.TotalSpace, &account.UsedSpace,
 		&account.Status, &account.Priority,
-		&lastSync, &errorMessage,
+		&lastSync, &errorMessage, pq.Array(&account.Tags),
+		&account.AuthType, &account.DriveType, &account.DriveID,
 		&account.CreatedAt, &account.UpdatedAt,
 	)
 
@@ -99,7 +105,9 @@ func (r *AccountRepository) List(ctx context.Context) ([]*types.StorageAccount,
 		       COALESCE(refresh_token, ''), COALESCE(access_token, ''), token_expires,
 		       COALESCE(total_space, 0), COALESCE(used_space, 0), 
 		       COALESCE(status, 'pending'), COALESCE(priority, 0),
-		       last_sync, error_message, created_at, updated_at
+		       last_sync, error_message, COALESCE(tags, '{}'),
+		       COALESCE(auth_type, 'delegated'), COALESCE(drive_type, 'me'), COALESCE(drive_id, ''),
+		       created_at, updated_at
 		FROM storage_accounts
 		WHERE id != '00000000-0000-0000-0000-000000000000'
 		ORDER BY priority DESC, created_at ASC
@@ -123,7 +131,8 @@ func (r *AccountRepository) List(ctx context.Context) ([]*types.StorageAccount,
 			&account.RefreshToken, &account.AccessToken, &tokenExpires,
 			&account.TotalSpace, &account.UsedSpace,
 			&account.Status, &account.Priority,
-			&lastSync, &errorMessage,
+			&lastSync, &errorMessage, pq.Array(&account.Tags),
+			&account.AuthType, &account.DriveType, &account.DriveID,
 			&account.CreatedAt, &account.UpdatedAt,
 		); err != nil {
 			return nil, err
@@ -152,7 +161,8 @@ func (r *AccountRepository) Update(ctx context.Context, account *types.StorageAc
 		SET name = $2, email = $3, client_id = $4, client_secret = $5, tenant_id = $6,
 		    refresh_token = $7, access_token = $8, token_expires = $9,
 		    total_space = $10, used_space = $11, status = $12, priority = $13,
-		    last_sync = $14, error_message = $15, updated_at = $16
+		    last_sync = $14, error_message = $15, tags = $16,
+		    auth_type = $17, drive_type = $18, drive_id = $19, updated_at = $20
 		WHERE id = $1
 	`
 
@@ -163,7 +173,8 @@ func (r *AccountRepository) Update(ctx context.Context, account *types.StorageAc
 		account.RefreshToken, account.AccessToken, account.TokenExpires,
 		account.TotalSpace, account.UsedSpace,
 		account.Status, account.Priority,
-		account.LastSync, account.ErrorMessage,
+		account.LastSync, account.ErrorMessage, pq.Array(account.Tags),
+		account.AuthType, account.DriveType, account.DriveID,
 		now,
 	)
 
@@ -233,6 +244,19 @@ func (r *AccountRepository) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// CountReferences returns how many objects, chunks and replicas are stored on an account
+func (r *AccountRepository) CountReferences(ctx context.Context, id string) (int64, error) {
+	query := `
+		SELECT (SELECT COUNT(*) FROM objects WHERE account_id = $1)
+		     + (SELECT COUNT(*) FROM object_chunks WHERE account_id = $1)
+		     + (SELECT COUNT(*) FROM object_replicas WHERE account_id = $1)
+	`
+
+	var count int64
+	err := r.db.QueryRowContext(ctx, query, id).Scan(&count)
+	return count, err
+}
+
 // GetActiveAccounts retrieves all active accounts
 func (r *AccountRepository) GetActiveAccounts(ctx context.Context) ([]*types.StorageAccount, error) {
 	query := `
@@ -240,7 +264,9 @@ func (r *AccountRepository) GetActiveAccounts(ctx context.Context) ([]*types.Sto
 		       COALESCE(refresh_token, ''), COALESCE(access_token, ''), token_expires,
 		       COALESCE(total_space, 0), COALESCE(used_space, 0), 
 		       COALESCE(status, 'pending'), COALESCE(priority, 0),
-		       last_sync, error_message, created_at, updated_at
+		       last_sync, error_message, COALESCE(tags, '{}'),
+		       COALESCE(auth_type, 'delegated'), COALESCE(drive_type, 'me'), COALESCE(drive_id, ''),
+		       created_at, updated_at
 		FROM storage_accounts
 		WHERE status = 'active' AND id != '00000000-0000-0000-0000-000000000000'
 		ORDER BY priority DESC, used_space ASC
@@ -264,7 +290,8 @@ func (r *AccountRepository) GetActiveAccounts(ctx context.Context) ([]*types.Sto
 			&account.RefreshToken, &account.AccessToken, &tokenExpires,
 			&account.TotalSpace, &account.UsedSpace,
 			&account.Status, &account.Priority,
-			&lastSync, &errorMessage,
+			&lastSync, &errorMessage, pq.Array(&account.Tags),
+			&account.AuthType, &account.DriveType, &account.DriveID,
 			&account.CreatedAt, &account.UpdatedAt,
 		); err != nil {
 			return nil, err
@@ -293,7 +320,9 @@ func (r *AccountRepository) GetAccountByEmail(ctx context.Context, email string)
 		       COALESCE(refresh_token, ''), COALESCE(access_token, ''), token_expires,
 		       COALESCE(total_space, 0), COALESCE(used_space, 0), 
 		       COALESCE(status, 'pending'), COALESCE(priority, 0),
-		       last_sync, error_message, created_at, updated_at
+		       last_sync, error_message, COALESCE(tags, '{}'),
+		       COALESCE(auth_type, 'delegated'), COALESCE(drive_type, 'me'), COALESCE(drive_id, ''),
+		       created_at, updated_at
 		FROM storage_accounts
 		WHERE email = $1
 	`
@@ -308,7 +337,8 @@ func (r *AccountRepository) GetAccountByEmail(ctx context.Context, email string)
 		&account.RefreshToken, &account.AccessToken, &tokenExpires,
 		&account.TotalSpace, &account.UsedSpace,
 		&account.Status, &account.Priority,
-		&lastSync, &errorMessage,
+		&lastSync, &errorMessage, pq.Array(&account.Tags),
+		&account.AuthType, &account.DriveType, &account.DriveID,
 		&account.CreatedAt, &account.UpdatedAt,
 	)
 
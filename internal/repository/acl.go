@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// ACLRepository handles access to the roles granted on buckets and VFS directories
+type ACLRepository struct {
+	db *sql.DB
+}
+
+// NewACLRepository creates a new ACL repository
+func NewACLRepository(db *sql.DB) *ACLRepository {
+	return &ACLRepository{db: db}
+}
+
+// grantColumns are the columns read by scanGrant. The path is read from the directory,
+// so grants follow directories when they move.
+const grantColumns = `g.id, g.bucket, COALESCE(d.full_path, '/'), COALESCE(g.directory_id::text, ''),
+		g.user_id, g.role, g.granted_by, g.created_at
+	FROM acl_grants g
+	LEFT JOIN virtual_directories d ON d.id = g.directory_id`
+
+// Upsert grants a role, replacing the role the user already holds on the same directory
+func (r *ACLRepository) Upsert(ctx context.Context, grant *types.ACLGrant) error {
+	update := `
+		UPDATE acl_grants SET role = $4, granted_by = $5
+		WHERE bucket = $1 AND directory_id IS NOT DISTINCT FROM NULLIF($2, '')::uuid AND user_id = $3
+		RETURNING id, created_at
+	`
+	err := r.db.QueryRowContext(ctx, update,
+		grant.Bucket, grant.DirectoryID, grant.UserID, grant.Role, grant.GrantedBy,
+	).Scan(&grant.ID, &grant.CreatedAt)
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	insert := `
+		INSERT INTO acl_grants (id, bucket, directory_id, user_id, role, granted_by, created_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5, $6, $7)
+	`
+	grant.ID = uuid.New().String()
+	grant.CreatedAt = time.Now()
+	_, err = r.db.ExecContext(ctx, insert,
+		grant.ID, grant.Bucket, grant.DirectoryID, grant.UserID, grant.Role, grant.GrantedBy, grant.CreatedAt,
+	)
+	return err
+}
+
+// Get retrieves a grant by ID
+func (r *ACLRepository) Get(ctx context.Context, id string) (*types.ACLGrant, error) {
+	query := `SELECT ` + grantColumns + ` WHERE g.id = $1`
+	return scanGrant(r.db.QueryRowContext(ctx, query, id))
+}
+
+// List lists the grants in a bucket, only those of one user when userID is not empty
+func (r *ACLRepository) List(ctx context.Context, bucket, userID string) ([]*types.ACLGrant, error) {
+	query := `SELECT ` + grantColumns + `
+		WHERE g.bucket = $1 AND ($2 = '' OR g.user_id::text = $2)
+		ORDER BY COALESCE(d.full_path, '/') ASC, g.created_at ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, bucket, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var grants []*types.ACLGrant
+	for rows.Next() {
+		grant, err := scanGrant(rows)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+
+	return grants, rows.Err()
+}
+
+// HasAny reports whether a user holds any role in a bucket
+func (r *ACLRepository) HasAny(ctx context.Context, bucket, userID string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM acl_grants WHERE bucket = $1 AND user_id = $2)`
+
+	var exists bool
+	err := r.db.QueryRowContext(ctx, query, bucket, userID).Scan(&exists)
+	return exists, err
+}
+
+// ListBuckets lists the buckets in which a user holds a role
+func (r *ACLRepository) ListBuckets(ctx context.Context, userID string) ([]string, error) {
+	query := `SELECT DISTINCT bucket FROM acl_grants WHERE user_id = $1`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []string
+	for rows.Next() {
+		var bucket string
+		if err := rows.Scan(&bucket); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, rows.Err()
+}
+
+// Delete deletes a grant
+func (r *ACLRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM acl_grants WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanGrant scans a grant row selected with grantColumns
+func scanGrant(row rowScanner) (*types.ACLGrant, error) {
+	grant := &types.ACLGrant{}
+	err := row.Scan(
+		&grant.ID, &grant.Bucket, &grant.Path, &grant.DirectoryID,
+		&grant.UserID, &grant.Role, &grant.GrantedBy, &grant.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
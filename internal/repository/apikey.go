@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// APIKeyRepository handles API key data access
+type APIKeyRepository struct {
+	db *sql.DB
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *sql.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+// Create creates a new API key
+func (r *APIKeyRepository) Create(ctx context.Context, key *types.APIKey) error {
+	query := `
+		INSERT INTO api_keys (id, name, prefix, key_hash, scopes, buckets, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, '')::uuid, $8, $9)
+	`
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query,
+		key.ID, key.Name, key.Prefix, key.KeyHash,
+		pq.Array(key.Scopes), pq.Array(key.Buckets), key.UserID, key.ExpiresAt, now,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	key.CreatedAt = now
+	return nil
+}
+
+// Get retrieves an API key by ID
+func (r *APIKeyRepository) Get(ctx context.Context, id string) (*types.APIKey, error) {
+	query := `
+		SELECT id, name, prefix, key_hash, scopes, COALESCE(buckets, '{}'), COALESCE(user_id::text, ''), expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE id = $1
+	`
+
+	return r.scan(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByHash retrieves an API key by the hash of the key
+func (r *APIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*types.APIKey, error) {
+	query := `
+		SELECT id, name, prefix, key_hash, scopes, COALESCE(buckets, '{}'), COALESCE(user_id::text, ''), expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE key_hash = $1
+	`
+
+	return r.scan(r.db.QueryRowContext(ctx, query, keyHash))
+}
+
+// List retrieves all API keys, or only those of a user when userID is not empty
+func (r *APIKeyRepository) List(ctx context.Context, userID string) ([]*types.APIKey, error) {
+	query := `
+		SELECT id, name, prefix, key_hash, scopes, COALESCE(buckets, '{}'), COALESCE(user_id::text, ''), expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE $1 = '' OR user_id::text = $1
+		ORDER BY created_at ASC
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*types.APIKey
+	for rows.Next() {
+		key, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// TouchLastUsed records when an API key was last used
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id string, usedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, usedAt)
+	return err
+}
+
+// Delete deletes an API key
+func (r *APIKeyRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM api_keys WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scan reads an API key from a row
+func (r *APIKeyRepository) scan(row rowScanner) (*types.APIKey, error) {
+	key := &types.APIKey{}
+	var expiresAt, lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID, &key.Name, &key.Prefix, &key.KeyHash,
+		pq.Array(&key.Scopes), pq.Array(&key.Buckets), &key.UserID,
+		&expiresAt, &lastUsedAt, &key.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+
+	return key, nil
+}
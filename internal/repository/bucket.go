@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 )
 
@@ -18,61 +19,33 @@ func NewBucketRepository(db *sql.DB) *BucketRepository {
 	return &BucketRepository{db: db}
 }
 
-// Create creates a new bucket
-func (r *BucketRepository) Create(ctx context.Context, name string) (*types.Bucket, error) {
+// Create creates a new bucket. ownerID may be empty for buckets without an owner.
+func (r *BucketRepository) Create(ctx context.Context, name, ownerID string) (*types.Bucket, error) {
 	query := `
-		INSERT INTO buckets (name, created_at, updated_at)
-		VALUES ($1, $2, $3)
-		RETURNING name, object_count, total_size, created_at, updated_at
+		INSERT INTO buckets (name, owner_id, created_at, updated_at)
+		VALUES ($1, NULLIF($2, '')::uuid, $3, $4)
+		RETURNING name, object_count, total_size, ` + bucketPolicyColumns + `, COALESCE(owner_id::text, ''), created_at, updated_at
 	`
 
 	now := time.Now()
-	bucket := &types.Bucket{}
-
-	err := r.db.QueryRowContext(ctx, query, name, now, now).Scan(
-		&bucket.Name,
-		&bucket.ObjectCount,
-		&bucket.TotalSize,
-		&bucket.CreatedAt,
-		&bucket.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return bucket, nil
+	return scanBucket(r.db.QueryRowContext(ctx, query, name, ownerID, now, now))
 }
 
 // Get retrieves a bucket by name
 func (r *BucketRepository) Get(ctx context.Context, name string) (*types.Bucket, error) {
 	query := `
-		SELECT name, object_count, total_size, created_at, updated_at
+		SELECT name, object_count, total_size, ` + bucketPolicyColumns + `, COALESCE(owner_id::text, ''), created_at, updated_at
 		FROM buckets
 		WHERE name = $1
 	`
 
-	bucket := &types.Bucket{}
-
-	err := r.db.QueryRowContext(ctx, query, name).Scan(
-		&bucket.Name,
-		&bucket.ObjectCount,
-		&bucket.TotalSize,
-		&bucket.CreatedAt,
-		&bucket.UpdatedAt,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return bucket, nil
+	return scanBucket(r.db.QueryRowContext(ctx, query, name))
 }
 
 // List retrieves all buckets
 func (r *BucketRepository) List(ctx context.Context) ([]*types.Bucket, error) {
 	query := `
-		SELECT name, object_count, total_size, created_at, updated_at
+		SELECT name, object_count, total_size, ` + bucketPolicyColumns + `, COALESCE(owner_id::text, ''), created_at, updated_at
 		FROM buckets
 		ORDER BY created_at DESC
 	`
@@ -85,14 +58,8 @@ func (r *BucketRepository) List(ctx context.Context) ([]*types.Bucket, error) {
 
 	var buckets []*types.Bucket
 	for rows.Next() {
-		bucket := &types.Bucket{}
-		if err := rows.Scan(
-			&bucket.Name,
-			&bucket.ObjectCount,
-			&bucket.TotalSize,
-			&bucket.CreatedAt,
-			&bucket.UpdatedAt,
-		); err != nil {
+		bucket, err := scanBucket(rows)
+		if err != nil {
 			return nil, err
 		}
 		buckets = append(buckets, bucket)
@@ -101,6 +68,63 @@ func (r *BucketRepository) List(ctx context.Context) ([]*types.Bucket, error) {
 	return buckets, nil
 }
 
+// UpdatePolicy updates the placement policy of a bucket
+func (r *BucketRepository) UpdatePolicy(ctx context.Context, name string, policy *types.PlacementPolicy) error {
+	query := `
+		UPDATE buckets
+		SET allowed_accounts = $2, allowed_tags = $3, replication_factor = $4,
+		    placement_strategy = $5, pin_local = $6, storage_class = $7,
+		    data_shards = $8, parity_shards = $9, updated_at = $10
+		WHERE name = $1
+	`
+
+	var strategy sql.NullString
+	if policy.Strategy != "" {
+		strategy = sql.NullString{String: policy.Strategy, Valid: true}
+	}
+
+	result, err := r.db.ExecContext(ctx, query, name,
+		pq.Array(policy.AllowedAccounts), pq.Array(policy.AllowedTags), policy.ReplicationFactor,
+		strategy, policy.PinLocal, policy.StorageClass,
+		policy.DataShards, policy.ParityShards, time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetOwner changes the owner of a bucket
+func (r *BucketRepository) SetOwner(ctx context.Context, name, ownerID string) error {
+	query := `UPDATE buckets SET owner_id = NULLIF($2, '')::uuid, updated_at = $3 WHERE name = $1`
+
+	result, err := r.db.ExecContext(ctx, query, name, ownerID, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
 // Delete deletes a bucket
 func (r *BucketRepository) Delete(ctx context.Context, name string) error {
 	query := `DELETE FROM buckets WHERE name = $1`
@@ -147,3 +171,38 @@ func (r *BucketRepository) IsEmpty(ctx context.Context, name string) (bool, erro
 
 	return count == 0, nil
 }
+
+// bucketPolicyColumns lists the placement policy columns in scan order
+const bucketPolicyColumns = `COALESCE(allowed_accounts, '{}'), COALESCE(allowed_tags, '{}'),
+		       COALESCE(replication_factor, 1), COALESCE(placement_strategy, ''), COALESCE(pin_local, FALSE),
+		       COALESCE(storage_class, 'single'), COALESCE(data_shards, 0), COALESCE(parity_shards, 0)`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBucket scans a bucket row selected with bucketPolicyColumns and the owner
+func scanBucket(row rowScanner) (*types.Bucket, error) {
+	bucket := &types.Bucket{}
+	err := row.Scan(
+		&bucket.Name,
+		&bucket.ObjectCount,
+		&bucket.TotalSize,
+		pq.Array(&bucket.Policy.AllowedAccounts),
+		pq.Array(&bucket.Policy.AllowedTags),
+		&bucket.Policy.ReplicationFactor,
+		&bucket.Policy.Strategy,
+		&bucket.Policy.PinLocal,
+		&bucket.Policy.StorageClass,
+		&bucket.Policy.DataShards,
+		&bucket.Policy.ParityShards,
+		&bucket.OwnerID,
+		&bucket.CreatedAt,
+		&bucket.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return bucket, nil
+}
@@ -19,42 +19,42 @@ func NewEnhancedVFSRepository(db *sql.DB) *EnhancedVFSRepository {
 
 // ==================== Starred Files ====================
 
-// StarFile adds a file to starred
-func (r *EnhancedVFSRepository) StarFile(bucket, fileID, filePath string) error {
+// StarFile adds a file to a user's starred files
+func (r *EnhancedVFSRepository) StarFile(bucket, fileID, filePath, userID string) error {
 	query := `
-		INSERT INTO starred_files (bucket, file_id, file_path, starred_at)
-		VALUES ($1, $2, $3, NOW())
-		ON CONFLICT (bucket, file_id) DO UPDATE SET starred_at = NOW()
+		INSERT INTO starred_files (bucket, file_id, file_path, user_id, starred_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (bucket, file_id, user_id) DO UPDATE SET starred_at = NOW()
 	`
-	_, err := r.db.Exec(query, bucket, fileID, filePath)
+	_, err := r.db.Exec(query, bucket, fileID, filePath, userID)
 	return err
 }
 
-// UnstarFile removes a file from starred
-func (r *EnhancedVFSRepository) UnstarFile(bucket, fileID string) error {
-	query := `DELETE FROM starred_files WHERE bucket = $1 AND file_id = $2`
-	_, err := r.db.Exec(query, bucket, fileID)
+// UnstarFile removes a file from a user's starred files
+func (r *EnhancedVFSRepository) UnstarFile(bucket, fileID, userID string) error {
+	query := `DELETE FROM starred_files WHERE bucket = $1 AND file_id = $2 AND user_id = $3`
+	_, err := r.db.Exec(query, bucket, fileID, userID)
 	return err
 }
 
-// IsFileStarred checks if a file is starred
-func (r *EnhancedVFSRepository) IsFileStarred(bucket, fileID string) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM starred_files WHERE bucket = $1 AND file_id = $2)`
+// IsFileStarred checks if a user starred a file
+func (r *EnhancedVFSRepository) IsFileStarred(bucket, fileID, userID string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM starred_files WHERE bucket = $1 AND file_id = $2 AND user_id = $3)`
 	var exists bool
-	err := r.db.QueryRow(query, bucket, fileID).Scan(&exists)
+	err := r.db.QueryRow(query, bucket, fileID, userID).Scan(&exists)
 	return exists, err
 }
 
-// GetStarredFiles returns all starred files for a bucket
-func (r *EnhancedVFSRepository) GetStarredFiles(bucket string) ([]types.VFSItem, error) {
+// GetStarredFiles returns the files a user starred in a bucket
+func (r *EnhancedVFSRepository) GetStarredFiles(bucket, userID string) ([]types.VFSItem, error) {
 	query := `
 		SELECT sf.id, vf.name, vf.full_path, 'file' as type, vf.size, vf.mime_type, vf.created_at, vf.updated_at
 		FROM starred_files sf
 		JOIN virtual_files vf ON sf.file_id = vf.id
-		WHERE sf.bucket = $1
+		WHERE sf.bucket = $1 AND sf.user_id = $2
 		ORDER BY sf.starred_at DESC
 	`
-	rows, err := r.db.Query(query, bucket)
+	rows, err := r.db.Query(query, bucket, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -77,10 +77,10 @@ func (r *EnhancedVFSRepository) GetStarredFiles(bucket string) ([]types.VFSItem,
 	return items, nil
 }
 
-// GetStarredFileIDs returns IDs of starred files for a bucket
-func (r *EnhancedVFSRepository) GetStarredFileIDs(bucket string) (map[string]bool, error) {
-	query := `SELECT file_id FROM starred_files WHERE bucket = $1`
-	rows, err := r.db.Query(query, bucket)
+// GetStarredFileIDs returns IDs of the files a user starred in a bucket
+func (r *EnhancedVFSRepository) GetStarredFileIDs(bucket, userID string) (map[string]bool, error) {
+	query := `SELECT file_id FROM starred_files WHERE bucket = $1 AND user_id = $2`
+	rows, err := r.db.Query(query, bucket, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -99,107 +99,102 @@ func (r *EnhancedVFSRepository) GetStarredFileIDs(bucket string) (map[string]boo
 
 // ==================== Trash/Recycle Bin ====================
 
-// MoveToTrash moves an item to trash
-func (r *EnhancedVFSRepository) MoveToTrash(item *types.TrashItem) error {
-	query := `
-		INSERT INTO trash (bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, deleted_at, expires_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW(), NOW() + INTERVAL '30 days')
-	`
-	_, err := r.db.Exec(query, item.Bucket, item.OriginalType, item.OriginalID, item.OriginalPath, item.OriginalName, item.ObjectKey, item.Size, item.MimeType)
-	return err
-}
+// trashColumns are the columns read by scanTrashItem
+const trashColumns = `id, bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, COALESCE(owner_id::text, ''), deleted_at, expires_at`
 
-// GetTrashItems returns all items in trash for a bucket
-func (r *EnhancedVFSRepository) GetTrashItems(bucket string) ([]*types.TrashItem, error) {
+// MoveToTrashTx records an item in the trash within a transaction, with the files and
+// directories deleted along with it. The item gets its ID and deletion time.
+func (r *EnhancedVFSRepository) MoveToTrashTx(tx *sql.Tx, item *types.TrashItem, entries []*types.TrashEntry) error {
 	query := `
-		SELECT id, bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, deleted_at, expires_at
-		FROM trash
-		WHERE bucket = $1 AND expires_at > NOW()
-		ORDER BY deleted_at DESC
+		INSERT INTO trash (bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, owner_id, deleted_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), NULLIF($9, '')::uuid, NOW(), $10)
+		RETURNING id, deleted_at
 	`
-	rows, err := r.db.Query(query, bucket)
+	err := tx.QueryRow(query, item.Bucket, item.OriginalType, item.OriginalID, item.OriginalPath, item.OriginalName, item.ObjectKey, item.Size, item.MimeType, item.OwnerID, item.ExpiresAt).
+		Scan(&item.ID, &item.DeletedAt)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var items []*types.TrashItem
-	for rows.Next() {
-		item := &types.TrashItem{}
-		var objectKey sql.NullString
-		var size sql.NullInt64
-		var mimeType sql.NullString
-		err := rows.Scan(&item.ID, &item.Bucket, &item.OriginalType, &item.OriginalID, &item.OriginalPath, &item.OriginalName, &objectKey, &size, &mimeType, &item.DeletedAt, &item.ExpiresAt)
-		if err != nil {
-			return nil, err
-		}
-		if objectKey.Valid {
-			item.ObjectKey = objectKey.String
-		}
-		if size.Valid {
-			item.Size = size.Int64
-		}
-		if mimeType.Valid {
-			item.MimeType = mimeType.String
+	entryQuery := `
+		INSERT INTO trash_entries (trash_id, entry_type, original_id, path, name, object_key, size, mime_type, owner_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), NULLIF($9, '')::uuid)
+	`
+	for _, e := range entries {
+		if _, err := tx.Exec(entryQuery, item.ID, e.Type, e.ID, e.Path, e.Name, e.ObjectKey, e.Size, e.MimeType, e.OwnerID); err != nil {
+			return err
 		}
-		items = append(items, item)
 	}
-	return items, nil
+	return nil
+}
+
+// GetTrashItems returns all items in trash for a bucket
+func (r *EnhancedVFSRepository) GetTrashItems(bucket string) ([]*types.TrashItem, error) {
+	query := `SELECT ` + trashColumns + ` FROM trash WHERE bucket = $1 AND expires_at > NOW() ORDER BY deleted_at DESC`
+	return r.queryTrashItems(query, bucket)
+}
+
+// ListAllTrashItems returns every item in trash for a bucket, expired ones included
+func (r *EnhancedVFSRepository) ListAllTrashItems(bucket string) ([]*types.TrashItem, error) {
+	query := `SELECT ` + trashColumns + ` FROM trash WHERE bucket = $1`
+	return r.queryTrashItems(query, bucket)
+}
+
+// ListExpiredTrashItems returns the items of all buckets whose time in trash is over
+func (r *EnhancedVFSRepository) ListExpiredTrashItems() ([]*types.TrashItem, error) {
+	query := `SELECT ` + trashColumns + ` FROM trash WHERE expires_at <= NOW()`
+	return r.queryTrashItems(query)
 }
 
 // GetTrashItem returns a single trash item by ID
 func (r *EnhancedVFSRepository) GetTrashItem(id string) (*types.TrashItem, error) {
+	query := `SELECT ` + trashColumns + ` FROM trash WHERE id = $1`
+	return scanTrashItem(r.db.QueryRow(query, id))
+}
+
+// GetTrashEntries returns the files and directories deleted along with a trash item,
+// directories first, each after its parent
+func (r *EnhancedVFSRepository) GetTrashEntries(trashID string) ([]*types.TrashEntry, error) {
 	query := `
-		SELECT id, bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, deleted_at, expires_at
-		FROM trash
-		WHERE id = $1
+		SELECT entry_type, original_id, path, name, COALESCE(object_key, ''), COALESCE(size, 0), COALESCE(mime_type, ''), COALESCE(owner_id::text, '')
+		FROM trash_entries
+		WHERE trash_id = $1
+		ORDER BY entry_type = 'file', path
 	`
-	item := &types.TrashItem{}
-	var objectKey sql.NullString
-	var size sql.NullInt64
-	var mimeType sql.NullString
-	err := r.db.QueryRow(query, id).Scan(&item.ID, &item.Bucket, &item.OriginalType, &item.OriginalID, &item.OriginalPath, &item.OriginalName, &objectKey, &size, &mimeType, &item.DeletedAt, &item.ExpiresAt)
+	rows, err := r.db.Query(query, trashID)
 	if err != nil {
 		return nil, err
 	}
-	if objectKey.Valid {
-		item.ObjectKey = objectKey.String
-	}
-	if size.Valid {
-		item.Size = size.Int64
-	}
-	if mimeType.Valid {
-		item.MimeType = mimeType.String
+	defer rows.Close()
+
+	var entries []*types.TrashEntry
+	for rows.Next() {
+		e := &types.TrashEntry{}
+		if err := rows.Scan(&e.Type, &e.ID, &e.Path, &e.Name, &e.ObjectKey, &e.Size, &e.MimeType, &e.OwnerID); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
 	}
-	return item, nil
+	return entries, rows.Err()
 }
 
-// DeleteFromTrash permanently deletes an item from trash
+// DeleteFromTrash removes an item from trash, with its entries. Its objects are left to the caller.
 func (r *EnhancedVFSRepository) DeleteFromTrash(id string) error {
 	query := `DELETE FROM trash WHERE id = $1`
 	_, err := r.db.Exec(query, id)
 	return err
 }
 
-// EmptyTrash removes all items from trash for a bucket
-func (r *EnhancedVFSRepository) EmptyTrash(bucket string) (int64, error) {
-	query := `DELETE FROM trash WHERE bucket = $1`
-	result, err := r.db.Exec(query, bucket)
-	if err != nil {
-		return 0, err
-	}
-	return result.RowsAffected()
+// DeleteFromTrashTx removes an item and its entries from the trash within a transaction
+func (r *EnhancedVFSRepository) DeleteFromTrashTx(tx *sql.Tx, id string) error {
+	query := `DELETE FROM trash WHERE id = $1`
+	_, err := tx.Exec(query, id)
+	return err
 }
 
-// CleanupExpiredTrash removes expired items from trash
-func (r *EnhancedVFSRepository) CleanupExpiredTrash() ([]*types.TrashItem, error) {
-	// First get the items to return (for object cleanup)
-	query := `
-		SELECT id, bucket, original_type, original_id, original_path, original_name, object_key, size, mime_type, deleted_at, expires_at
-		FROM trash
-		WHERE expires_at <= NOW()
-	`
-	rows, err := r.db.Query(query)
+// queryTrashItems runs a query selecting trashColumns
+func (r *EnhancedVFSRepository) queryTrashItems(query string, args ...interface{}) ([]*types.TrashItem, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -207,50 +202,52 @@ func (r *EnhancedVFSRepository) CleanupExpiredTrash() ([]*types.TrashItem, error
 
 	var items []*types.TrashItem
 	for rows.Next() {
-		item := &types.TrashItem{}
-		var objectKey sql.NullString
-		var size sql.NullInt64
-		var mimeType sql.NullString
-		err := rows.Scan(&item.ID, &item.Bucket, &item.OriginalType, &item.OriginalID, &item.OriginalPath, &item.OriginalName, &objectKey, &size, &mimeType, &item.DeletedAt, &item.ExpiresAt)
+		item, err := scanTrashItem(rows)
 		if err != nil {
 			return nil, err
 		}
-		if objectKey.Valid {
-			item.ObjectKey = objectKey.String
-		}
-		if size.Valid {
-			item.Size = size.Int64
-		}
-		if mimeType.Valid {
-			item.MimeType = mimeType.String
-		}
 		items = append(items, item)
 	}
+	return items, rows.Err()
+}
 
-	// Then delete them
-	_, err = r.db.Exec(`DELETE FROM trash WHERE expires_at <= NOW()`)
+// scanTrashItem scans a trash row selected with trashColumns
+func scanTrashItem(row rowScanner) (*types.TrashItem, error) {
+	item := &types.TrashItem{}
+	var objectKey sql.NullString
+	var size sql.NullInt64
+	var mimeType sql.NullString
+	err := row.Scan(&item.ID, &item.Bucket, &item.OriginalType, &item.OriginalID, &item.OriginalPath, &item.OriginalName, &objectKey, &size, &mimeType, &item.OwnerID, &item.DeletedAt, &item.ExpiresAt)
 	if err != nil {
 		return nil, err
 	}
-
-	return items, nil
+	if objectKey.Valid {
+		item.ObjectKey = objectKey.String
+	}
+	if size.Valid {
+		item.Size = size.Int64
+	}
+	if mimeType.Valid {
+		item.MimeType = mimeType.String
+	}
+	return item, nil
 }
 
 // ==================== Recent Files ====================
 
-// RecordFileAccess records a file access
-func (r *EnhancedVFSRepository) RecordFileAccess(bucket, fileID, filePath, fileName string) error {
+// RecordFileAccess records a user's access to a file
+func (r *EnhancedVFSRepository) RecordFileAccess(bucket, fileID, filePath, fileName, userID string) error {
 	query := `
-		INSERT INTO recent_files (bucket, file_id, file_path, file_name, accessed_at)
-		VALUES ($1, $2, $3, $4, NOW())
-		ON CONFLICT (bucket, file_id) DO UPDATE SET accessed_at = NOW(), file_path = EXCLUDED.file_path, file_name = EXCLUDED.file_name
+		INSERT INTO recent_files (bucket, file_id, file_path, file_name, user_id, accessed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (bucket, file_id, user_id) DO UPDATE SET accessed_at = NOW(), file_path = EXCLUDED.file_path, file_name = EXCLUDED.file_name
 	`
-	_, err := r.db.Exec(query, bucket, fileID, filePath, fileName)
+	_, err := r.db.Exec(query, bucket, fileID, filePath, fileName, userID)
 	return err
 }
 
-// GetRecentFiles returns recently accessed files for a bucket
-func (r *EnhancedVFSRepository) GetRecentFiles(bucket string, limit int) ([]types.VFSItem, error) {
+// GetRecentFiles returns the files a user recently accessed in a bucket
+func (r *EnhancedVFSRepository) GetRecentFiles(bucket, userID string, limit int) ([]types.VFSItem, error) {
 	if limit <= 0 {
 		limit = 20
 	}
@@ -258,11 +255,11 @@ func (r *EnhancedVFSRepository) GetRecentFiles(bucket string, limit int) ([]type
 		SELECT rf.id, vf.name, vf.full_path, 'file' as type, vf.size, vf.mime_type, vf.created_at, vf.updated_at
 		FROM recent_files rf
 		JOIN virtual_files vf ON rf.file_id = vf.id
-		WHERE rf.bucket = $1
+		WHERE rf.bucket = $1 AND rf.user_id = $2
 		ORDER BY rf.accessed_at DESC
-		LIMIT $2
+		LIMIT $3
 	`
-	rows, err := r.db.Query(query, bucket, limit)
+	rows, err := r.db.Query(query, bucket, userID, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -315,7 +312,7 @@ func (r *EnhancedVFSRepository) SearchFiles(bucket, query string, limit int) ([]
 		FROM virtual_files
 		WHERE bucket = $1 AND (name ILIKE $2 OR full_path ILIKE $2)
 		UNION ALL
-		SELECT id, name, full_path, 'directory' as type, 0 as size, '' as mime_type, created_at, 'name' as match_type
+		SELECT id, name, full_path, 'directory' as type, total_size as size, '' as mime_type, created_at, 'name' as match_type
 		FROM virtual_directories
 		WHERE bucket = $1 AND (name ILIKE $2 OR full_path ILIKE $2)
 		ORDER BY name
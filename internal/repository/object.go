@@ -224,15 +224,17 @@ func (r *ObjectRepository) CreateChunk(ctx context.Context, chunk *types.ObjectC
 	query := `
 		INSERT INTO object_chunks (
 			id, bucket, key, chunk_index, account_id, remote_id, remote_path,
-			chunk_size, checksum, status, created_at
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			chunk_size, checksum, status, shard_index, data_shards, parity_shards,
+			stripe_size, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
 	`
 
 	now := time.Now()
 	_, err := r.db.ExecContext(ctx, query,
 		chunk.ID, chunk.Bucket, chunk.Key, chunk.ChunkIndex, chunk.AccountID,
 		chunk.RemoteID, chunk.RemotePath, chunk.ChunkSize, chunk.Checksum,
-		chunk.Status, now,
+		chunk.Status, chunk.ShardIndex, chunk.DataShards, chunk.ParityShards,
+		chunk.StripeSize, now,
 	)
 
 	if err != nil {
@@ -247,10 +249,12 @@ func (r *ObjectRepository) CreateChunk(ctx context.Context, chunk *types.ObjectC
 func (r *ObjectRepository) GetChunks(ctx context.Context, bucket, key string) ([]*types.ObjectChunk, error) {
 	query := `
 		SELECT id, bucket, key, chunk_index, account_id, remote_id, remote_path,
-		       chunk_size, checksum, status, created_at
+		       chunk_size, checksum, status, created_at,
+		       COALESCE(shard_index, 0), COALESCE(data_shards, 0), COALESCE(parity_shards, 0),
+		       COALESCE(stripe_size, 0)
 		FROM object_chunks
 		WHERE bucket = $1 AND key = $2
-		ORDER BY chunk_index ASC
+		ORDER BY chunk_index ASC, shard_index ASC
 	`
 
 	rows, err := r.db.QueryContext(ctx, query, bucket, key)
@@ -266,6 +270,221 @@ func (r *ObjectRepository) GetChunks(ctx context.Context, bucket, key string) ([
 			&chunk.ID, &chunk.Bucket, &chunk.Key, &chunk.ChunkIndex, &chunk.AccountID,
 			&chunk.RemoteID, &chunk.RemotePath, &chunk.ChunkSize, &chunk.Checksum,
 			&chunk.Status, &chunk.CreatedAt,
+			&chunk.ShardIndex, &chunk.DataShards, &chunk.ParityShards, &chunk.StripeSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return chunks, nil
+}
+
+// GetChunk retrieves a single chunk of an object
+func (r *ObjectRepository) GetChunk(ctx context.Context, bucket, key string, chunkIndex int) (*types.ObjectChunk, error) {
+	query := `
+		SELECT id, bucket, key, chunk_index, account_id, remote_id, remote_path,
+		       chunk_size, checksum, status, created_at,
+		       COALESCE(shard_index, 0), COALESCE(data_shards, 0), COALESCE(parity_shards, 0),
+		       COALESCE(stripe_size, 0)
+		FROM object_chunks
+		WHERE bucket = $1 AND key = $2 AND chunk_index = $3
+		ORDER BY shard_index ASC
+		LIMIT 1
+	`
+
+	chunk := &types.ObjectChunk{}
+	err := r.db.QueryRowContext(ctx, query, bucket, key, chunkIndex).Scan(
+		&chunk.ID, &chunk.Bucket, &chunk.Key, &chunk.ChunkIndex, &chunk.AccountID,
+		&chunk.RemoteID, &chunk.RemotePath, &chunk.ChunkSize, &chunk.Checksum,
+		&chunk.Status, &chunk.CreatedAt,
+		&chunk.ShardIndex, &chunk.DataShards, &chunk.ParityShards, &chunk.StripeSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
+}
+
+// UpdateLocation moves the primary copy of an object to another account
+func (r *ObjectRepository) UpdateLocation(ctx context.Context, bucket, key, accountID, remoteID, remotePath string) error {
+	query := `
+		UPDATE objects
+		SET account_id = $3, remote_id = $4, remote_path = $5, updated_at = $6
+		WHERE bucket = $1 AND key = $2
+	`
+
+	result, err := r.db.ExecContext(ctx, query, bucket, key, accountID, remoteID, remotePath, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// UpdateChunkLocation moves the primary copy of a chunk to another account
+func (r *ObjectRepository) UpdateChunkLocation(ctx context.Context, id, accountID, remoteID, remotePath string) error {
+	query := `
+		UPDATE object_chunks
+		SET account_id = $2, remote_id = $3, remote_path = $4
+		WHERE id = $1
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, accountID, remoteID, remotePath)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// MoveObject moves the primary copy of an object away from an account.
+// The update only applies while the object is still on fromAccountID, so a concurrent change is not overwritten.
+func (r *ObjectRepository) MoveObject(ctx context.Context, bucket, key, fromAccountID, accountID, remoteID, remotePath string) error {
+	query := `
+		UPDATE objects
+		SET account_id = $4, remote_id = $5, remote_path = $6, updated_at = $7
+		WHERE bucket = $1 AND key = $2 AND account_id = $3
+	`
+
+	result, err := r.db.ExecContext(ctx, query, bucket, key, fromAccountID, accountID, remoteID, remotePath, time.Now())
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// MoveChunk moves a chunk away from an account, like MoveObject
+func (r *ObjectRepository) MoveChunk(ctx context.Context, id, fromAccountID, accountID, remoteID, remotePath string) error {
+	query := `
+		UPDATE object_chunks
+		SET account_id = $3, remote_id = $4, remote_path = $5
+		WHERE id = $1 AND account_id = $2
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, fromAccountID, accountID, remoteID, remotePath)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ListObjectsByAccount retrieves non-chunked objects stored on an account, ordered by bucket and key
+func (r *ObjectRepository) ListObjectsByAccount(ctx context.Context, accountID string, limit, offset int) ([]*types.Object, error) {
+	query := `
+		SELECT bucket, key, account_id, remote_id, remote_path,
+		       size, etag, mime_type, is_chunked, chunk_count,
+		       metadata, created_at, updated_at
+		FROM objects
+		WHERE account_id = $1 AND is_chunked = FALSE
+		ORDER BY bucket, key
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []*types.Object
+	for rows.Next() {
+		obj := &types.Object{}
+		var metadataJSON []byte
+
+		if err := rows.Scan(
+			&obj.Bucket, &obj.Key, &obj.AccountID, &obj.RemoteID, &obj.RemotePath,
+			&obj.Size, &obj.ETag, &obj.MimeType, &obj.IsChunked, &obj.ChunkCount,
+			&metadataJSON, &obj.CreatedAt, &obj.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+
+		if len(metadataJSON) > 0 {
+			json.Unmarshal(metadataJSON, &obj.Metadata)
+		}
+
+		objects = append(objects, obj)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// ListChunksByAccount retrieves chunks stored on an account, ordered by ID
+func (r *ObjectRepository) ListChunksByAccount(ctx context.Context, accountID string, limit, offset int) ([]*types.ObjectChunk, error) {
+	query := `
+		SELECT id, bucket, key, chunk_index, account_id, remote_id, remote_path,
+		       chunk_size, checksum, status, created_at,
+		       COALESCE(shard_index, 0), COALESCE(data_shards, 0), COALESCE(parity_shards, 0),
+		       COALESCE(stripe_size, 0)
+		FROM object_chunks
+		WHERE account_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, accountID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chunks []*types.ObjectChunk
+	for rows.Next() {
+		chunk := &types.ObjectChunk{}
+		err := rows.Scan(
+			&chunk.ID, &chunk.Bucket, &chunk.Key, &chunk.ChunkIndex, &chunk.AccountID,
+			&chunk.RemoteID, &chunk.RemotePath, &chunk.ChunkSize, &chunk.Checksum,
+			&chunk.Status, &chunk.CreatedAt,
+			&chunk.ShardIndex, &chunk.DataShards, &chunk.ParityShards, &chunk.StripeSize,
 		)
 		if err != nil {
 			return nil, err
@@ -331,7 +550,9 @@ func (r *ObjectRepository) ListAllObjects(ctx context.Context, limit, offset int
 func (r *ObjectRepository) ListAllChunks(ctx context.Context, limit, offset int) ([]*types.ObjectChunk, error) {
 	query := `
 		SELECT id, bucket, key, chunk_index, account_id, remote_id, remote_path,
-		       chunk_size, checksum, status, created_at
+		       chunk_size, checksum, status, created_at,
+		       COALESCE(shard_index, 0), COALESCE(data_shards, 0), COALESCE(parity_shards, 0),
+		       COALESCE(stripe_size, 0)
 		FROM object_chunks
 		ORDER BY created_at DESC
 		LIMIT $1 OFFSET $2
@@ -350,6 +571,7 @@ func (r *ObjectRepository) ListAllChunks(ctx context.Context, limit, offset int)
 			&chunk.ID, &chunk.Bucket, &chunk.Key, &chunk.ChunkIndex, &chunk.AccountID,
 			&chunk.RemoteID, &chunk.RemotePath, &chunk.ChunkSize, &chunk.Checksum,
 			&chunk.Status, &chunk.CreatedAt,
+			&chunk.ShardIndex, &chunk.DataShards, &chunk.ParityShards, &chunk.StripeSize,
 		)
 		if err != nil {
 			return nil, err
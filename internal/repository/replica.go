@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// ReplicaRepository handles object replica data access
+type ReplicaRepository struct {
+	db *sql.DB
+}
+
+// NewReplicaRepository creates a new replica repository
+func NewReplicaRepository(db *sql.DB) *ReplicaRepository {
+	return &ReplicaRepository{db: db}
+}
+
+// Create creates a new replica
+func (r *ReplicaRepository) Create(ctx context.Context, replica *types.ObjectReplica) error {
+	query := `
+		INSERT INTO object_replicas (
+			id, bucket, key, chunk_index, account_id, remote_id, remote_path, status, created_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query,
+		replica.ID, replica.Bucket, replica.Key, replica.ChunkIndex, replica.AccountID,
+		replica.RemoteID, replica.RemotePath, replica.Status, now,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	replica.CreatedAt = now
+	return nil
+}
+
+// List retrieves the replicas of an object or chunk
+func (r *ReplicaRepository) List(ctx context.Context, bucket, key string, chunkIndex int) ([]*types.ObjectReplica, error) {
+	query := `
+		SELECT id, bucket, key, chunk_index, account_id,
+		       COALESCE(remote_id, ''), COALESCE(remote_path, ''), COALESCE(status, 'active'), created_at
+		FROM object_replicas
+		WHERE bucket = $1 AND key = $2 AND chunk_index = $3
+		ORDER BY created_at ASC
+	`
+
+	return r.query(ctx, query, bucket, key, chunkIndex)
+}
+
+// ListForObject retrieves the replicas of an object and all of its chunks
+func (r *ReplicaRepository) ListForObject(ctx context.Context, bucket, key string) ([]*types.ObjectReplica, error) {
+	query := `
+		SELECT id, bucket, key, chunk_index, account_id,
+		       COALESCE(remote_id, ''), COALESCE(remote_path, ''), COALESCE(status, 'active'), created_at
+		FROM object_replicas
+		WHERE bucket = $1 AND key = $2
+		ORDER BY chunk_index ASC, created_at ASC
+	`
+
+	return r.query(ctx, query, bucket, key)
+}
+
+// ListByAccount retrieves replicas stored on an account, ordered by ID
+func (r *ReplicaRepository) ListByAccount(ctx context.Context, accountID string, limit, offset int) ([]*types.ObjectReplica, error) {
+	query := `
+		SELECT id, bucket, key, chunk_index, account_id,
+		       COALESCE(remote_id, ''), COALESCE(remote_path, ''), COALESCE(status, 'active'), created_at
+		FROM object_replicas
+		WHERE account_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3
+	`
+
+	return r.query(ctx, query, accountID, limit, offset)
+}
+
+// Move moves a replica away from an account.
+// The update only applies while the replica is still on fromAccountID.
+func (r *ReplicaRepository) Move(ctx context.Context, id, fromAccountID, accountID, remoteID, remotePath string) error {
+	query := `
+		UPDATE object_replicas
+		SET account_id = $3, remote_id = $4, remote_path = $5
+		WHERE id = $1 AND account_id = $2
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id, fromAccountID, accountID, remoteID, remotePath)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// Delete deletes a replica
+func (r *ReplicaRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM object_replicas WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteForObject deletes all replicas of an object and its chunks
+func (r *ReplicaRepository) DeleteForObject(ctx context.Context, bucket, key string) error {
+	query := `DELETE FROM object_replicas WHERE bucket = $1 AND key = $2`
+	_, err := r.db.ExecContext(ctx, query, bucket, key)
+	return err
+}
+
+// query runs a replica query and scans the rows
+func (r *ReplicaRepository) query(ctx context.Context, query string, args ...interface{}) ([]*types.ObjectReplica, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replicas []*types.ObjectReplica
+	for rows.Next() {
+		replica := &types.ObjectReplica{}
+		if err := rows.Scan(
+			&replica.ID, &replica.Bucket, &replica.Key, &replica.ChunkIndex, &replica.AccountID,
+			&replica.RemoteID, &replica.RemotePath, &replica.Status, &replica.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return replicas, nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// SessionRepository handles login session data access
+type SessionRepository struct {
+	db *sql.DB
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *sql.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// Create creates a new session
+func (r *SessionRepository) Create(ctx context.Context, session *types.Session) error {
+	query := `
+		INSERT INTO sessions (id, user_id, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	now := time.Now()
+	if _, err := r.db.ExecContext(ctx, query, session.TokenHash, session.UserID, session.ExpiresAt, now); err != nil {
+		return err
+	}
+
+	session.CreatedAt = now
+	return nil
+}
+
+// GetByHash retrieves an unexpired session by the hash of its token
+func (r *SessionRepository) GetByHash(ctx context.Context, tokenHash string) (*types.Session, error) {
+	query := `
+		SELECT id, user_id, expires_at, created_at
+		FROM sessions
+		WHERE id = $1 AND expires_at > NOW()
+	`
+
+	session := &types.Session{}
+	err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(
+		&session.TokenHash, &session.UserID, &session.ExpiresAt, &session.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// Delete deletes a session by the hash of its token
+func (r *SessionRepository) Delete(ctx context.Context, tokenHash string) error {
+	query := `DELETE FROM sessions WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, tokenHash)
+	return err
+}
+
+// DeleteByUser deletes all sessions of a user
+func (r *SessionRepository) DeleteByUser(ctx context.Context, userID string) error {
+	query := `DELETE FROM sessions WHERE user_id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteExpired removes expired sessions
+func (r *SessionRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	query := `DELETE FROM sessions WHERE expires_at <= NOW()`
+
+	result, err := r.db.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
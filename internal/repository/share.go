@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// ShareRepository handles share link data access
+type ShareRepository struct {
+	db *sql.DB
+}
+
+// NewShareRepository creates a new share link repository
+func NewShareRepository(db *sql.DB) *ShareRepository {
+	return &ShareRepository{db: db}
+}
+
+// shareColumns are the columns read by scanShare. The path is read from the target,
+// so links follow files and directories when they move.
+const shareColumns = `s.id, s.bucket, COALESCE(f.full_path, d.full_path, '/'),
+		CASE WHEN s.file_id IS NOT NULL THEN 'file' ELSE 'directory' END,
+		COALESCE(s.file_id::text, s.directory_id::text, ''),
+		s.token_hash, s.prefix, COALESCE(s.password_hash, ''), s.mode, s.expires_at, s.max_downloads,
+		s.downloads, s.views, s.uploads, s.last_accessed_at, s.created_by, s.created_at
+	FROM share_links s
+	LEFT JOIN virtual_files f ON f.id = s.file_id
+	LEFT JOIN virtual_directories d ON d.id = s.directory_id`
+
+// Create creates a new share link
+func (r *ShareRepository) Create(ctx context.Context, link *types.ShareLink) error {
+	query := `
+		INSERT INTO share_links (id, token_hash, prefix, bucket, file_id, directory_id, password_hash,
+			mode, expires_at, max_downloads, created_by, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, NULLIF($6, '')::uuid, NULLIF($7, ''), $8, $9, $10, $11, $12)
+	`
+
+	var fileID, directoryID string
+	if link.TargetType == "file" {
+		fileID = link.TargetID
+	} else {
+		directoryID = link.TargetID
+	}
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query,
+		link.ID, link.TokenHash, link.Prefix, link.Bucket, fileID, directoryID, link.PasswordHash,
+		link.Mode, link.ExpiresAt, link.MaxDownloads, link.CreatedBy, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	link.CreatedAt = now
+	return nil
+}
+
+// Get retrieves a share link by ID
+func (r *ShareRepository) Get(ctx context.Context, id string) (*types.ShareLink, error) {
+	query := `SELECT ` + shareColumns + ` WHERE s.id = $1`
+	return scanShare(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByHash retrieves a share link by the hash of its token
+func (r *ShareRepository) GetByHash(ctx context.Context, tokenHash string) (*types.ShareLink, error) {
+	query := `SELECT ` + shareColumns + ` WHERE s.token_hash = $1`
+	return scanShare(r.db.QueryRowContext(ctx, query, tokenHash))
+}
+
+// List lists the share links of a bucket
+func (r *ShareRepository) List(ctx context.Context, bucket string) ([]*types.ShareLink, error) {
+	query := `SELECT ` + shareColumns + ` WHERE s.bucket = $1 ORDER BY s.created_at DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, bucket)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var links []*types.ShareLink
+	for rows.Next() {
+		link, err := scanShare(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+
+	return links, rows.Err()
+}
+
+// Delete deletes a share link
+func (r *ShareRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM share_links WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CountDownload counts a download, returning sql.ErrNoRows when the link has no downloads left
+func (r *ShareRepository) CountDownload(ctx context.Context, id string) error {
+	query := `
+		UPDATE share_links SET downloads = downloads + 1, last_accessed_at = NOW()
+		WHERE id = $1 AND (max_downloads = 0 OR downloads < max_downloads)
+	`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CountView counts a view of a link
+func (r *ShareRepository) CountView(ctx context.Context, id string) error {
+	query := `UPDATE share_links SET views = views + 1, last_accessed_at = NOW() WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// CountUpload counts an upload through a link
+func (r *ShareRepository) CountUpload(ctx context.Context, id string) error {
+	query := `UPDATE share_links SET uploads = uploads + 1, last_accessed_at = NOW() WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id)
+	return err
+}
+
+// RecordAccess records a use of a link
+func (r *ShareRepository) RecordAccess(ctx context.Context, access *types.ShareAccess) error {
+	query := `
+		INSERT INTO share_accesses (id, share_id, action, path, remote_addr, user_agent, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	access.ID = uuid.New().String()
+	access.CreatedAt = time.Now()
+	_, err := r.db.ExecContext(ctx, query,
+		access.ID, access.ShareID, access.Action, access.Path, access.RemoteAddr, access.UserAgent, access.CreatedAt,
+	)
+	return err
+}
+
+// ListAccesses lists the most recent uses of a link
+func (r *ShareRepository) ListAccesses(ctx context.Context, shareID string, limit int) ([]*types.ShareAccess, error) {
+	query := `
+		SELECT id, share_id, action, path, remote_addr, user_agent, created_at
+		FROM share_accesses
+		WHERE share_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`
+
+	rows, err := r.db.QueryContext(ctx, query, shareID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accesses := []*types.ShareAccess{}
+	for rows.Next() {
+		access := &types.ShareAccess{}
+		err := rows.Scan(
+			&access.ID, &access.ShareID, &access.Action, &access.Path,
+			&access.RemoteAddr, &access.UserAgent, &access.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, access)
+	}
+
+	return accesses, rows.Err()
+}
+
+// scanShare scans a share link row selected with shareColumns
+func scanShare(row rowScanner) (*types.ShareLink, error) {
+	link := &types.ShareLink{}
+	var expiresAt, lastAccessedAt sql.NullTime
+	err := row.Scan(
+		&link.ID, &link.Bucket, &link.Path, &link.TargetType, &link.TargetID,
+		&link.TokenHash, &link.Prefix, &link.PasswordHash, &link.Mode, &expiresAt, &link.MaxDownloads,
+		&link.Downloads, &link.Views, &link.Uploads, &lastAccessedAt, &link.CreatedBy, &link.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	link.HasPassword = link.PasswordHash != ""
+	if expiresAt.Valid {
+		link.ExpiresAt = &expiresAt.Time
+	}
+	if lastAccessedAt.Valid {
+		link.LastAccessedAt = &lastAccessedAt.Time
+	}
+	return link, nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// UserRepository handles user data access
+type UserRepository struct {
+	db *sql.DB
+}
+
+// NewUserRepository creates a new user repository
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+// userColumns are the columns read by scanUser
+const userColumns = `id, username, COALESCE(email, ''), COALESCE(display_name, ''), COALESCE(password_hash, ''),
+		COALESCE(oidc_subject, ''), is_admin, last_login_at, created_at, updated_at`
+
+// Create creates a new user
+func (r *UserRepository) Create(ctx context.Context, user *types.User) error {
+	query := `
+		INSERT INTO users (id, username, email, display_name, password_hash, oidc_subject, is_admin, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9)
+	`
+
+	now := time.Now()
+	_, err := r.db.ExecContext(ctx, query,
+		user.ID, user.Username, user.Email, user.DisplayName,
+		user.PasswordHash, user.OIDCSubject, user.IsAdmin, now, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	return nil
+}
+
+// Get retrieves a user by ID
+func (r *UserRepository) Get(ctx context.Context, id string) (*types.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+	return scanUser(r.db.QueryRowContext(ctx, query, id))
+}
+
+// GetByUsername retrieves a user by username
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*types.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE username = $1`
+	return scanUser(r.db.QueryRowContext(ctx, query, username))
+}
+
+// GetByOIDCSubject retrieves a user by OIDC subject
+func (r *UserRepository) GetByOIDCSubject(ctx context.Context, subject string) (*types.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE oidc_subject = $1`
+	return scanUser(r.db.QueryRowContext(ctx, query, subject))
+}
+
+// List retrieves all users
+func (r *UserRepository) List(ctx context.Context) ([]*types.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users ORDER BY username ASC`
+
+	rows, err := r.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*types.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+// Update updates a user
+func (r *UserRepository) Update(ctx context.Context, user *types.User) error {
+	query := `
+		UPDATE users
+		SET username = $2, email = $3, display_name = $4, password_hash = NULLIF($5, ''),
+		    oidc_subject = NULLIF($6, ''), is_admin = $7, updated_at = $8
+		WHERE id = $1
+	`
+
+	now := time.Now()
+	result, err := r.db.ExecContext(ctx, query,
+		user.ID, user.Username, user.Email, user.DisplayName,
+		user.PasswordHash, user.OIDCSubject, user.IsAdmin, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	user.UpdatedAt = now
+	return nil
+}
+
+// TouchLastLogin records when a user last signed in
+func (r *UserRepository) TouchLastLogin(ctx context.Context, id string, at time.Time) error {
+	query := `UPDATE users SET last_login_at = $2 WHERE id = $1`
+
+	_, err := r.db.ExecContext(ctx, query, id, at)
+	return err
+}
+
+// Delete deletes a user. Their sessions and API keys go with them; what they owned is kept without an owner.
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	query := `DELETE FROM users WHERE id = $1`
+
+	result, err := r.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// scanUser scans a user row selected with userColumns
+func scanUser(row rowScanner) (*types.User, error) {
+	user := &types.User{}
+	var lastLoginAt sql.NullTime
+
+	err := row.Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.PasswordHash,
+		&user.OIDCSubject, &user.IsAdmin, &lastLoginAt, &user.CreatedAt, &user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if lastLoginAt.Valid {
+		user.LastLoginAt = &lastLoginAt.Time
+	}
+
+	return user, nil
+}
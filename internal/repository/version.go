@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// VersionRepository handles file version data access
+type VersionRepository struct {
+	db *sql.DB
+}
+
+// NewVersionRepository creates a new file version repository
+func NewVersionRepository(db *sql.DB) *VersionRepository {
+	return &VersionRepository{db: db}
+}
+
+// versionColumns are the columns read by scanVersion
+const versionColumns = `id, file_id, bucket, version, object_key, size, COALESCE(mime_type, ''), COALESCE(content_hash, ''), created_at, archived_at`
+
+// CreateTx records a previous content of a file within a transaction
+func (r *VersionRepository) CreateTx(tx *sql.Tx, v *types.FileVersion) error {
+	query := `
+		INSERT INTO file_versions (id, file_id, bucket, version, object_key, size, mime_type, content_hash, created_at, archived_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), $9, $10)
+	`
+	_, err := tx.Exec(query, v.ID, v.FileID, v.Bucket, v.Version, v.ObjectKey, v.Size, v.MimeType, v.ContentHash, v.CreatedAt, v.ArchivedAt)
+	return err
+}
+
+// Get retrieves a file version by ID
+func (r *VersionRepository) Get(id string) (*types.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions WHERE id = $1`
+	return scanVersion(r.db.QueryRow(query, id))
+}
+
+// List lists the previous versions of a file, newest first
+func (r *VersionRepository) List(fileID string) ([]*types.FileVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM file_versions WHERE file_id = $1 ORDER BY version DESC`
+
+	rows, err := r.db.Query(query, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []*types.FileVersion{}
+	for rows.Next() {
+		v, err := scanVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// NextVersion returns the number following the newest previous version of a file
+func (r *VersionRepository) NextVersion(fileID string) (int, error) {
+	query := `SELECT COALESCE(MAX(version), 0) + 1 FROM file_versions WHERE file_id = $1`
+	var next int
+	err := r.db.QueryRow(query, fileID).Scan(&next)
+	return next, err
+}
+
+// DeleteTx deletes a file version within a transaction
+func (r *VersionRepository) DeleteTx(tx *sql.Tx, id string) error {
+	query := `DELETE FROM file_versions WHERE id = $1`
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteByFilesTx deletes every version of some files within a transaction and returns
+// their object keys
+func (r *VersionRepository) DeleteByFilesTx(tx *sql.Tx, fileIDs []string) ([]string, error) {
+	query := `DELETE FROM file_versions WHERE file_id = ANY($1) RETURNING object_key`
+	return deleteReturningKeys(tx, query, pq.Array(fileIDs))
+}
+
+// Prune deletes the versions of a bucket beyond the newest keepVersions of each file or
+// archived more than keepDays ago within a transaction, and returns their object keys.
+// Zero limits are ignored.
+func (r *VersionRepository) PruneTx(tx *sql.Tx, bucket string, keepVersions, keepDays int) ([]string, error) {
+	query := `
+		DELETE FROM file_versions WHERE id IN (
+			SELECT id FROM (
+				SELECT id, archived_at, ROW_NUMBER() OVER (PARTITION BY file_id ORDER BY version DESC) AS rank
+				FROM file_versions
+				WHERE bucket = $1
+			) v
+			WHERE ($2::int > 0 AND v.rank > $2::int)
+			   OR ($3::int > 0 AND v.archived_at < NOW() - make_interval(days => $3::int))
+		)
+		RETURNING object_key
+	`
+	return deleteReturningKeys(tx, query, bucket, keepVersions, keepDays)
+}
+
+// GetRules returns the version rules of a bucket, sql.ErrNoRows when it has none
+func (r *VersionRepository) GetRules(bucket string) (*types.VersionRules, error) {
+	query := `SELECT bucket, keep_versions, keep_days FROM version_rules WHERE bucket = $1`
+	rules := &types.VersionRules{}
+	err := r.db.QueryRow(query, bucket).Scan(&rules.Bucket, &rules.KeepVersions, &rules.KeepDays)
+	if err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// SetRules sets the version rules of a bucket
+func (r *VersionRepository) SetRules(rules *types.VersionRules) error {
+	query := `
+		INSERT INTO version_rules (bucket, keep_versions, keep_days, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (bucket) DO UPDATE SET keep_versions = $2, keep_days = $3, updated_at = $4
+	`
+	_, err := r.db.Exec(query, rules.Bucket, rules.KeepVersions, rules.KeepDays, time.Now())
+	return err
+}
+
+// DeleteRules removes the version rules of a bucket, so the defaults apply again
+func (r *VersionRepository) DeleteRules(bucket string) error {
+	query := `DELETE FROM version_rules WHERE bucket = $1`
+	_, err := r.db.Exec(query, bucket)
+	return err
+}
+
+// deleteReturningKeys runs a DELETE returning object keys within a transaction
+func deleteReturningKeys(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// scanVersion scans a file version row selected with versionColumns
+func scanVersion(row rowScanner) (*types.FileVersion, error) {
+	v := &types.FileVersion{}
+	err := row.Scan(&v.ID, &v.FileID, &v.Bucket, &v.Version, &v.ObjectKey, &v.Size, &v.MimeType, &v.ContentHash, &v.CreatedAt, &v.ArchivedAt)
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
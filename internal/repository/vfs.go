@@ -3,8 +3,10 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 )
 
@@ -21,24 +23,34 @@ func NewVFSRepository(db *sql.DB) *VFSRepository {
 // CreateDirectory creates a new virtual directory
 func (r *VFSRepository) CreateDirectory(dir *types.VirtualDirectory) error {
 	query := `
-		INSERT INTO virtual_directories (id, bucket, parent_id, name, full_path, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO virtual_directories (id, bucket, parent_id, name, full_path, owner_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7)
 	`
-	_, err := r.db.Exec(query, dir.ID, dir.Bucket, dir.ParentID, dir.Name, dir.FullPath, dir.CreatedAt)
+	_, err := r.db.Exec(query, dir.ID, dir.Bucket, dir.ParentID, dir.Name, dir.FullPath, dir.OwnerID, dir.CreatedAt)
+	return err
+}
+
+// CreateDirectoryTx creates a new virtual directory within a transaction
+func (r *VFSRepository) CreateDirectoryTx(tx *sql.Tx, dir *types.VirtualDirectory) error {
+	query := `
+		INSERT INTO virtual_directories (id, bucket, parent_id, name, full_path, owner_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::uuid, $7)
+	`
+	_, err := tx.Exec(query, dir.ID, dir.Bucket, dir.ParentID, dir.Name, dir.FullPath, dir.OwnerID, dir.CreatedAt)
 	return err
 }
 
 // GetDirectory retrieves a directory by bucket and full path
 func (r *VFSRepository) GetDirectory(bucket, fullPath string) (*types.VirtualDirectory, error) {
 	query := `
-		SELECT id, bucket, parent_id, name, full_path, created_at
+		SELECT id, bucket, parent_id, name, full_path, COALESCE(owner_id::text, ''), total_size, file_count, dir_count, created_at
 		FROM virtual_directories
 		WHERE bucket = $1 AND full_path = $2
 	`
 	dir := &types.VirtualDirectory{}
 	var parentID sql.NullString
 	err := r.db.QueryRow(query, bucket, fullPath).Scan(
-		&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.CreatedAt,
+		&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.OwnerID, &dir.TotalSize, &dir.FileCount, &dir.DirCount, &dir.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -52,14 +64,14 @@ func (r *VFSRepository) GetDirectory(bucket, fullPath string) (*types.VirtualDir
 // GetDirectoryByID retrieves a directory by its ID
 func (r *VFSRepository) GetDirectoryByID(id string) (*types.VirtualDirectory, error) {
 	query := `
-		SELECT id, bucket, parent_id, name, full_path, created_at
+		SELECT id, bucket, parent_id, name, full_path, COALESCE(owner_id::text, ''), total_size, file_count, dir_count, created_at
 		FROM virtual_directories
 		WHERE id = $1
 	`
 	dir := &types.VirtualDirectory{}
 	var parentID sql.NullString
 	err := r.db.QueryRow(query, id).Scan(
-		&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.CreatedAt,
+		&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.OwnerID, &dir.TotalSize, &dir.FileCount, &dir.DirCount, &dir.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -70,56 +82,148 @@ func (r *VFSRepository) GetDirectoryByID(id string) (*types.VirtualDirectory, er
 	return dir, nil
 }
 
-// ListDirectoryContents lists all files and subdirectories in a directory
-func (r *VFSRepository) ListDirectoryContents(bucket string, parentID *string) ([]types.VFSItem, error) {
-	var items []types.VFSItem
+// listSortColumns are the columns directory listings sort by, per sort field: the
+// expression for directories, the one for files and the type cursor keys are cast to
+var listSortColumns = map[string]struct{ dir, file, cast string }{
+	"name":  {"name", "name", "text"},
+	"size":  {"total_size", "size", "bigint"},
+	"mtime": {"created_at", "updated_at", "timestamp"},
+	"type":  {"''", "COALESCE(mime_type, '')", "text"},
+}
 
-	// List subdirectories
-	dirQuery := `
-		SELECT id, name, full_path, 'directory' as type, 0 as size, '' as mime_type, created_at, created_at as updated_at
-		FROM virtual_directories
-		WHERE bucket = $1 AND ($2::uuid IS NULL AND parent_id IS NULL OR parent_id = $2)
-		ORDER BY name
-	`
-	rows, err := r.db.Query(dirQuery, bucket, parentID)
+// ListDirectoryContents lists the files and subdirectories of a directory that match
+// opts' filters, directories first, sorted by opts.Sort with name and ID breaking ties.
+// Only items after the cursor, if any, are listed, at most opts.Limit of them when it is positive.
+func (r *VFSRepository) ListDirectoryContents(bucket string, parentID *string, opts types.ListOptions, after *types.ListCursor) ([]types.VFSItem, error) {
+	sort, ok := listSortColumns[opts.Sort]
+	if !ok {
+		sort = listSortColumns["name"]
+	}
+	dirWhere, fileWhere, args := listConditions(bucket, parentID, opts)
+
+	// Each branch can be read in order from an index on its parent and sort column
+	query := `
+		SELECT id, name, full_path, type, size, mime_type, file_count, dir_count, created_at, updated_at FROM (
+			SELECT id, name, full_path, 'directory' AS type, total_size AS size, '' AS mime_type,
+				file_count, dir_count, created_at, created_at AS updated_at, 0 AS kind, ` + sort.dir + ` AS sort_key
+			FROM virtual_directories
+			WHERE ` + dirWhere + `
+			UNION ALL
+			SELECT id, name, full_path, 'file', size, COALESCE(mime_type, ''),
+				0, 0, created_at, updated_at, 1, ` + sort.file + `
+			FROM virtual_files
+			WHERE ` + fileWhere + `
+		) items`
+
+	direction, compare := "ASC", ">"
+	if opts.Desc {
+		direction, compare = "DESC", "<"
+	}
+	if after != nil {
+		n := len(args)
+		query += fmt.Sprintf(`
+		WHERE kind > $%d OR (kind = $%d AND (sort_key, name, id) %s ($%d::%s, $%d, $%d::uuid))`,
+			n+1, n+1, compare, n+2, sort.cast, n+3, n+4)
+		args = append(args, after.Kind, after.Key, after.Name, after.ID)
+	}
+	query += fmt.Sprintf(`
+		ORDER BY kind, sort_key %s, name %s, id %s`, direction, direction, direction)
+	if opts.Limit > 0 {
+		query += fmt.Sprintf(` LIMIT %d`, opts.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	items := []types.VFSItem{}
 	for rows.Next() {
 		var item types.VFSItem
 		var updatedAt time.Time
-		err := rows.Scan(&item.ID, &item.Name, &item.Path, &item.Type, &item.Size, &item.MimeType, &item.CreatedAt, &updatedAt)
+		err := rows.Scan(&item.ID, &item.Name, &item.Path, &item.Type, &item.Size, &item.MimeType, &item.FileCount, &item.DirCount, &item.CreatedAt, &updatedAt)
 		if err != nil {
 			return nil, err
 		}
+		if item.Type == "file" {
+			item.UpdatedAt = &updatedAt
+		}
 		items = append(items, item)
 	}
+	return items, rows.Err()
+}
 
-	// List files
-	fileQuery := `
-		SELECT id, name, full_path, 'file' as type, size, mime_type, created_at, updated_at
-		FROM virtual_files
-		WHERE bucket = $1 AND ($2::uuid IS NULL AND directory_id IS NULL OR directory_id = $2)
-		ORDER BY name
+// CountDirectoryContents counts the files and subdirectories of a directory that match
+// opts' filters. Both counts read only the listing indexes.
+func (r *VFSRepository) CountDirectoryContents(bucket string, parentID *string, opts types.ListOptions) (int64, error) {
+	dirWhere, fileWhere, args := listConditions(bucket, parentID, opts)
+	query := `
+		SELECT (SELECT COUNT(*) FROM virtual_directories WHERE ` + dirWhere + `)
+		     + (SELECT COUNT(*) FROM virtual_files WHERE ` + fileWhere + `)
+	`
+	var count int64
+	err := r.db.QueryRow(query, args...).Scan(&count)
+	return count, err
+}
+
+// listConditions returns the WHERE conditions selecting the directories and the files a
+// listing shows, and their arguments
+func listConditions(bucket string, parentID *string, opts types.ListOptions) (string, string, []interface{}) {
+	args := []interface{}{bucket}
+	dirWhere, fileWhere := "bucket = $1 AND parent_id IS NULL", "bucket = $1 AND directory_id IS NULL"
+	if parentID != nil {
+		args = append(args, *parentID)
+		dirWhere, fileWhere = "bucket = $1 AND parent_id = $2", "bucket = $1 AND directory_id = $2"
+	}
+
+	// Extensions select files only
+	if opts.Type == "file" || len(opts.Extensions) > 0 {
+		dirWhere += " AND false"
+	}
+	if opts.Type == "directory" {
+		fileWhere += " AND false"
+	}
+	if len(opts.Extensions) > 0 {
+		patterns := make([]string, len(opts.Extensions))
+		for i, ext := range opts.Extensions {
+			patterns[i] = "%" + likeEscaper.Replace("."+ext)
+		}
+		args = append(args, pq.Array(patterns))
+		fileWhere += fmt.Sprintf(" AND lower(name) LIKE ANY($%d)", len(args))
+	}
+	return dirWhere, fileWhere, args
+}
+
+// GetDirectoriesByPaths retrieves the directories of a bucket at any of some paths in one
+// query, such as all ancestors of a path
+func (r *VFSRepository) GetDirectoriesByPaths(bucket string, paths []string) ([]*types.VirtualDirectory, error) {
+	query := `
+		SELECT id, bucket, parent_id, name, full_path, COALESCE(owner_id::text, ''), total_size, file_count, dir_count, created_at
+		FROM virtual_directories
+		WHERE bucket = $1 AND full_path = ANY($2)
+		ORDER BY full_path
 	`
-	rows, err = r.db.Query(fileQuery, bucket, parentID)
+	rows, err := r.db.Query(query, bucket, pq.Array(paths))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	var dirs []*types.VirtualDirectory
 	for rows.Next() {
-		var item types.VFSItem
-		err := rows.Scan(&item.ID, &item.Name, &item.Path, &item.Type, &item.Size, &item.MimeType, &item.CreatedAt, &item.UpdatedAt)
+		dir := &types.VirtualDirectory{}
+		var parentID sql.NullString
+		err := rows.Scan(&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.OwnerID, &dir.TotalSize, &dir.FileCount, &dir.DirCount, &dir.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
-		items = append(items, item)
+		if parentID.Valid {
+			dir.ParentID = &parentID.String
+		}
+		dirs = append(dirs, dir)
 	}
-
-	return items, nil
+	return dirs, rows.Err()
 }
 
 // DeleteDirectory deletes a directory
@@ -142,24 +246,34 @@ func (r *VFSRepository) DeleteDirectory(id string) error {
 // CreateFile creates a new virtual file
 func (r *VFSRepository) CreateFile(file *types.VirtualFile) error {
 	query := `
-		INSERT INTO virtual_files (id, bucket, directory_id, name, full_path, object_key, size, mime_type, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		INSERT INTO virtual_files (id, bucket, directory_id, name, full_path, object_key, size, mime_type, owner_id, version, content_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, GREATEST($10, 1), NULLIF($11, ''), $12, $13)
+	`
+	_, err := r.db.Exec(query, file.ID, file.Bucket, file.DirectoryID, file.Name, file.FullPath, file.ObjectKey, file.Size, file.MimeType, file.OwnerID, file.Version, file.ContentHash, file.CreatedAt, file.UpdatedAt)
+	return err
+}
+
+// CreateFileTx creates a new virtual file within a transaction
+func (r *VFSRepository) CreateFileTx(tx *sql.Tx, file *types.VirtualFile) error {
+	query := `
+		INSERT INTO virtual_files (id, bucket, directory_id, name, full_path, object_key, size, mime_type, owner_id, version, content_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, '')::uuid, GREATEST($10, 1), NULLIF($11, ''), $12, $13)
 	`
-	_, err := r.db.Exec(query, file.ID, file.Bucket, file.DirectoryID, file.Name, file.FullPath, file.ObjectKey, file.Size, file.MimeType, file.CreatedAt, file.UpdatedAt)
+	_, err := tx.Exec(query, file.ID, file.Bucket, file.DirectoryID, file.Name, file.FullPath, file.ObjectKey, file.Size, file.MimeType, file.OwnerID, file.Version, file.ContentHash, file.CreatedAt, file.UpdatedAt)
 	return err
 }
 
 // GetFile retrieves a file by bucket and full path
 func (r *VFSRepository) GetFile(bucket, fullPath string) (*types.VirtualFile, error) {
 	query := `
-		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, created_at, updated_at
+		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, COALESCE(owner_id::text, ''), version, COALESCE(content_hash, ''), created_at, updated_at
 		FROM virtual_files
 		WHERE bucket = $1 AND full_path = $2
 	`
 	file := &types.VirtualFile{}
 	var directoryID sql.NullString
 	err := r.db.QueryRow(query, bucket, fullPath).Scan(
-		&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.CreatedAt, &file.UpdatedAt,
+		&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.OwnerID, &file.Version, &file.ContentHash, &file.CreatedAt, &file.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -173,14 +287,14 @@ func (r *VFSRepository) GetFile(bucket, fullPath string) (*types.VirtualFile, er
 // GetFileByID retrieves a file by its ID
 func (r *VFSRepository) GetFileByID(id string) (*types.VirtualFile, error) {
 	query := `
-		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, created_at, updated_at
+		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, COALESCE(owner_id::text, ''), version, COALESCE(content_hash, ''), created_at, updated_at
 		FROM virtual_files
 		WHERE id = $1
 	`
 	file := &types.VirtualFile{}
 	var directoryID sql.NullString
 	err := r.db.QueryRow(query, id).Scan(
-		&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.CreatedAt, &file.UpdatedAt,
+		&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.OwnerID, &file.Version, &file.ContentHash, &file.CreatedAt, &file.UpdatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -229,6 +343,28 @@ func (r *VFSRepository) UpdateFile(file *types.VirtualFile) error {
 	return nil
 }
 
+// UpdateFileContentTx points a file at new content within a transaction. It fails with
+// sql.ErrNoRows when the file no longer has the version it was read with.
+func (r *VFSRepository) UpdateFileContentTx(tx *sql.Tx, file *types.VirtualFile, readVersion int) error {
+	query := `
+		UPDATE virtual_files
+		SET object_key = $1, size = $2, mime_type = $3, version = $4, content_hash = NULLIF($5, ''), updated_at = $6
+		WHERE id = $7 AND version = $8
+	`
+	result, err := tx.Exec(query, file.ObjectKey, file.Size, file.MimeType, file.Version, file.ContentHash, file.UpdatedAt, file.ID, readVersion)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // UpdateDirectory updates a virtual directory's metadata
 func (r *VFSRepository) UpdateDirectory(dir *types.VirtualDirectory) error {
 	query := `
@@ -250,6 +386,70 @@ func (r *VFSRepository) UpdateDirectory(dir *types.VirtualDirectory) error {
 	return nil
 }
 
+// MoveDirectoryTx moves a directory within a transaction: it takes the directory's new
+// parent, name and path, and every path below oldPath is rewritten in one statement per table.
+func (r *VFSRepository) MoveDirectoryTx(tx *sql.Tx, dir *types.VirtualDirectory, oldPath string) error {
+	// Only the leading oldPath is replaced, so names that happen to contain it are left alone
+	dirQuery := `
+		UPDATE virtual_directories
+		SET full_path = $3 || substr(full_path, char_length($2) + 1)
+		WHERE bucket = $1 AND full_path LIKE $4
+	`
+	if _, err := tx.Exec(dirQuery, dir.Bucket, oldPath, dir.FullPath, likePrefix(oldPath+"/")); err != nil {
+		return err
+	}
+
+	fileQuery := `
+		UPDATE virtual_files
+		SET full_path = $3 || substr(full_path, char_length($2) + 1), updated_at = $5
+		WHERE bucket = $1 AND full_path LIKE $4
+	`
+	if _, err := tx.Exec(fileQuery, dir.Bucket, oldPath, dir.FullPath, likePrefix(oldPath+"/"), time.Now()); err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE virtual_directories
+		SET parent_id = $1, name = $2, full_path = $3
+		WHERE id = $4 AND full_path = $5
+	`
+	result, err := tx.Exec(query, dir.ParentID, dir.Name, dir.FullPath, dir.ID, oldPath)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteEmptyDirectory deletes a directory if it has no files or subdirectories. It returns
+// sql.ErrNoRows if the directory is gone or not empty.
+func (r *VFSRepository) DeleteEmptyDirectory(id string) error {
+	query := `
+		DELETE FROM virtual_directories d
+		WHERE d.id = $1
+		  AND NOT EXISTS (SELECT 1 FROM virtual_directories c WHERE c.parent_id = d.id)
+		  AND NOT EXISTS (SELECT 1 FROM virtual_files f WHERE f.directory_id = d.id)
+	`
+	result, err := r.db.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
 // CountDirectoryChildren counts files and subdirectories in a directory
 func (r *VFSRepository) CountDirectoryChildren(id string) (int, error) {
 	var count int
@@ -275,12 +475,12 @@ func (r *VFSRepository) CountDirectoryChildren(id string) (int, error) {
 // ListDirectoriesByPath lists all directories matching a path prefix
 func (r *VFSRepository) ListDirectoriesByPath(bucket, pathPrefix string) ([]*types.VirtualDirectory, error) {
 	query := `
-		SELECT id, bucket, parent_id, name, full_path, created_at
+		SELECT id, bucket, parent_id, name, full_path, COALESCE(owner_id::text, ''), total_size, file_count, dir_count, created_at
 		FROM virtual_directories
 		WHERE bucket = $1 AND full_path LIKE $2
 		ORDER BY full_path
 	`
-	rows, err := r.db.Query(query, bucket, pathPrefix+"%")
+	rows, err := r.db.Query(query, bucket, likePrefix(pathPrefix))
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +490,7 @@ func (r *VFSRepository) ListDirectoriesByPath(bucket, pathPrefix string) ([]*typ
 	for rows.Next() {
 		dir := &types.VirtualDirectory{}
 		var parentID sql.NullString
-		err := rows.Scan(&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.CreatedAt)
+		err := rows.Scan(&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.OwnerID, &dir.TotalSize, &dir.FileCount, &dir.DirCount, &dir.CreatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -305,12 +505,12 @@ func (r *VFSRepository) ListDirectoriesByPath(bucket, pathPrefix string) ([]*typ
 // ListFilesByDirectory lists all files in a directory and its subdirectories
 func (r *VFSRepository) ListFilesByDirectory(bucket, pathPrefix string) ([]*types.VirtualFile, error) {
 	query := `
-		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, created_at, updated_at
+		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, COALESCE(owner_id::text, ''), created_at, updated_at
 		FROM virtual_files
 		WHERE bucket = $1 AND full_path LIKE $2
 		ORDER BY full_path
 	`
-	rows, err := r.db.Query(query, bucket, pathPrefix+"%")
+	rows, err := r.db.Query(query, bucket, likePrefix(pathPrefix))
 	if err != nil {
 		return nil, err
 	}
@@ -320,7 +520,7 @@ func (r *VFSRepository) ListFilesByDirectory(bucket, pathPrefix string) ([]*type
 	for rows.Next() {
 		file := &types.VirtualFile{}
 		var directoryID sql.NullString
-		err := rows.Scan(&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.CreatedAt, &file.UpdatedAt)
+		err := rows.Scan(&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.OwnerID, &file.CreatedAt, &file.UpdatedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -386,3 +586,29 @@ func (r *VFSRepository) DeleteFileTx(tx *sql.Tx, id string) error {
 	}
 	return nil
 }
+
+// DeleteFileVersionTx deletes a file within a transaction. It fails with sql.ErrNoRows
+// when the file no longer has the version it was read with.
+func (r *VFSRepository) DeleteFileVersionTx(tx *sql.Tx, id string, version int) error {
+	query := `DELETE FROM virtual_files WHERE id = $1 AND version = $2`
+	result, err := tx.Exec(query, id, version)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// likeEscaper escapes the characters LIKE treats specially
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// likePrefix returns a LIKE pattern matching paths that start with prefix
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(prefix) + "%"
+}
@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// ListLargestDirectories lists up to limit directories below a path prefix with the most
+// bytes in their subtrees, largest first
+func (r *VFSRepository) ListLargestDirectories(bucket, pathPrefix string, limit int) ([]*types.VirtualDirectory, error) {
+	query := `
+		SELECT id, bucket, parent_id, name, full_path, COALESCE(owner_id::text, ''), total_size, file_count, dir_count, created_at
+		FROM virtual_directories
+		WHERE bucket = $1 AND full_path LIKE $2
+		ORDER BY total_size DESC, full_path
+		LIMIT $3
+	`
+	rows, err := r.db.Query(query, bucket, likePrefix(pathPrefix), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dirs []*types.VirtualDirectory
+	for rows.Next() {
+		dir := &types.VirtualDirectory{}
+		var parentID sql.NullString
+		err := rows.Scan(&dir.ID, &dir.Bucket, &parentID, &dir.Name, &dir.FullPath, &dir.OwnerID, &dir.TotalSize, &dir.FileCount, &dir.DirCount, &dir.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			dir.ParentID = &parentID.String
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs, rows.Err()
+}
+
+// RepairAggregates recomputes the size and count aggregates of a bucket's directories from
+// their files and returns how many directories were wrong. Writes to the tree wait until
+// it is done, so none of them is lost between reading the tree and writing the totals.
+func (r *VFSRepository) RepairAggregates(bucket string) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`LOCK TABLE virtual_directories, virtual_files IN SHARE MODE`); err != nil {
+		return 0, err
+	}
+
+	var fixed int64
+	if err := tx.QueryRow(`SELECT vfs_repair_aggregates($1)`, bucket).Scan(&fixed); err != nil {
+		return 0, err
+	}
+	return fixed, tx.Commit()
+}
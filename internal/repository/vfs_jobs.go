@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// copyJobColumns are the columns read by scanCopyJob
+const copyJobColumns = `id, bucket, source, destination, COALESCE(owner_id::text, ''), conflict, checkpoint, copied, total, created_at, updated_at`
+
+// CountFilesByPath counts the files below a path prefix
+func (r *VFSRepository) CountFilesByPath(bucket, pathPrefix string) (int64, error) {
+	query := `SELECT COUNT(*) FROM virtual_files WHERE bucket = $1 AND full_path LIKE $2`
+	var count int64
+	err := r.db.QueryRow(query, bucket, likePrefix(pathPrefix)).Scan(&count)
+	return count, err
+}
+
+// ListFilesAfter lists up to limit files below a path prefix whose path sorts after the
+// given one, in path order. An empty after starts from the first file.
+func (r *VFSRepository) ListFilesAfter(bucket, pathPrefix, after string, limit int) ([]*types.VirtualFile, error) {
+	query := `
+		SELECT id, bucket, directory_id, name, full_path, object_key, size, mime_type, COALESCE(owner_id::text, ''), COALESCE(content_hash, ''), created_at, updated_at
+		FROM virtual_files
+		WHERE bucket = $1 AND full_path LIKE $2 AND full_path > $3
+		ORDER BY full_path
+		LIMIT $4
+	`
+	rows, err := r.db.Query(query, bucket, likePrefix(pathPrefix), after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var files []*types.VirtualFile
+	for rows.Next() {
+		file := &types.VirtualFile{}
+		var directoryID sql.NullString
+		err := rows.Scan(&file.ID, &file.Bucket, &directoryID, &file.Name, &file.FullPath, &file.ObjectKey, &file.Size, &file.MimeType, &file.OwnerID, &file.ContentHash, &file.CreatedAt, &file.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if directoryID.Valid {
+			file.DirectoryID = &directoryID.String
+		}
+		files = append(files, file)
+	}
+	return files, rows.Err()
+}
+
+// CreateCopyJob records a directory copy before it starts
+func (r *VFSRepository) CreateCopyJob(job *types.CopyJob) error {
+	query := `
+		INSERT INTO copy_jobs (id, bucket, source, destination, owner_id, conflict, checkpoint, copied, total, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, '')::uuid, $6, $7, $8, $9, $10, $11)
+	`
+	_, err := r.db.Exec(query, job.ID, job.Bucket, job.Source, job.Destination, job.OwnerID, job.Conflict, job.Checkpoint, job.Copied, job.Total, job.CreatedAt, job.UpdatedAt)
+	return err
+}
+
+// ListCopyJobs lists the directory copies that have not finished, oldest first
+func (r *VFSRepository) ListCopyJobs() ([]*types.CopyJob, error) {
+	query := `SELECT ` + copyJobColumns + ` FROM copy_jobs ORDER BY created_at`
+
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*types.CopyJob
+	for rows.Next() {
+		job, err := scanCopyJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// CheckpointCopyJob records the progress of a directory copy
+func (r *VFSRepository) CheckpointCopyJob(job *types.CopyJob) error {
+	job.UpdatedAt = time.Now()
+	query := `UPDATE copy_jobs SET checkpoint = $1, copied = $2, updated_at = $3 WHERE id = $4`
+	_, err := r.db.Exec(query, job.Checkpoint, job.Copied, job.UpdatedAt, job.ID)
+	return err
+}
+
+// DeleteCopyJob removes a directory copy that finished or was given up
+func (r *VFSRepository) DeleteCopyJob(id string) error {
+	query := `DELETE FROM copy_jobs WHERE id = $1`
+	_, err := r.db.Exec(query, id)
+	return err
+}
+
+// QueueObjectDeletionsTx queues objects of a bucket for deletion from storage within the
+// transaction that removes their last reference
+func (r *VFSRepository) QueueObjectDeletionsTx(tx *sql.Tx, bucket string, objectKeys []string) error {
+	if len(objectKeys) == 0 {
+		return nil
+	}
+	query := `
+		INSERT INTO object_deletions (bucket, object_key)
+		SELECT $1, unnest($2::text[])
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.Exec(query, bucket, pq.Array(objectKeys))
+	return err
+}
+
+// ListObjectDeletions lists up to limit queued object deletions, oldest first
+func (r *VFSRepository) ListObjectDeletions(limit int) ([]*types.ObjectDeletion, error) {
+	query := `SELECT bucket, object_key, queued_at FROM object_deletions ORDER BY queued_at LIMIT $1`
+
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deletions []*types.ObjectDeletion
+	for rows.Next() {
+		d := &types.ObjectDeletion{}
+		if err := rows.Scan(&d.Bucket, &d.ObjectKey, &d.QueuedAt); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, d)
+	}
+	return deletions, rows.Err()
+}
+
+// DeleteObjectDeletion removes an object deletion from the queue once it is done
+func (r *VFSRepository) DeleteObjectDeletion(bucket, objectKey string) error {
+	query := `DELETE FROM object_deletions WHERE bucket = $1 AND object_key = $2`
+	_, err := r.db.Exec(query, bucket, objectKey)
+	return err
+}
+
+// scanCopyJob scans a copy job row selected with copyJobColumns
+func scanCopyJob(row rowScanner) (*types.CopyJob, error) {
+	job := &types.CopyJob{}
+	err := row.Scan(&job.ID, &job.Bucket, &job.Source, &job.Destination, &job.OwnerID, &job.Conflict, &job.Checkpoint, &job.Copied, &job.Total, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
@@ -0,0 +1,258 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/secrets"
+	"github.com/xuecangming/onedrive-storage/internal/infrastructure/onedrive"
+)
+
+// ManifestVersion is the version of the account import and export format
+const ManifestVersion = 1
+
+// localAccountID is the placeholder account of data kept on local disk
+const localAccountID = "00000000-0000-0000-0000-000000000000"
+
+// redacted replaces secrets in exports without a passphrase
+const redacted = "REDACTED"
+
+// ImportOptions controls how account definitions are imported
+type ImportOptions struct {
+	Update     bool   // update accounts whose email already exists instead of skipping them
+	Passphrase string // decrypts encrypted secrets
+}
+
+// Import creates accounts from definitions. Each definition is handled on its own, so one
+// invalid entry does not stop the others. Accounts imported with a refresh token, and
+// client_credentials accounts, are synced right away, which activates them without consent.
+func (s *Service) Import(ctx context.Context, manifest *types.AccountManifest, opts ImportOptions) ([]types.AccountImportResult, error) {
+	if manifest.Version > ManifestVersion {
+		return nil, errors.InvalidRequest(fmt.Sprintf("unsupported manifest version %d", manifest.Version))
+	}
+
+	results := make([]types.AccountImportResult, 0, len(manifest.Accounts))
+	seen := make(map[string]bool)
+	for _, def := range manifest.Accounts {
+		result := types.AccountImportResult{Email: def.Email}
+		email := strings.ToLower(def.Email)
+		if seen[email] {
+			result.Result = "failed"
+			result.Error = "duplicate email in import"
+			results = append(results, result)
+			continue
+		}
+		seen[email] = true
+
+		id, action, err := s.importAccount(ctx, def, opts)
+		result.ID = id
+		result.Result = action
+		if err != nil {
+			result.Result = "failed"
+			result.Error = err.Error()
+		} else if action != "skipped" && (def.RefreshToken != "" || def.AuthType == types.AuthTypeClientCredentials) {
+			if err := s.SyncSpaceInfo(ctx, id); err != nil {
+				result.Error = fmt.Sprintf("imported, but authorization failed: %v", err)
+			} else {
+				result.Authorized = true
+			}
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// importAccount creates or updates a single account and returns its ID and the action taken
+func (s *Service) importAccount(ctx context.Context, def types.AccountDefinition, opts ImportOptions) (string, string, error) {
+	if def.Name == "" || def.Email == "" || def.ClientID == "" {
+		return "", "", fmt.Errorf("name, email and client_id are required")
+	}
+	if def.ClientSecret == redacted || def.RefreshToken == redacted {
+		return "", "", fmt.Errorf("secrets were redacted on export")
+	}
+
+	clientSecret, err := secrets.Decrypt(def.ClientSecret, opts.Passphrase)
+	if err != nil {
+		return "", "", fmt.Errorf("client_secret: %w", err)
+	}
+	refreshToken, err := secrets.Decrypt(def.RefreshToken, opts.Passphrase)
+	if err != nil {
+		return "", "", fmt.Errorf("refresh_token: %w", err)
+	}
+
+	existing, err := s.repo.GetAccountByEmail(ctx, def.Email)
+	if err != nil && err != sql.ErrNoRows {
+		return "", "", err
+	}
+
+	if existing != nil {
+		if !opts.Update {
+			return existing.ID, "skipped", nil
+		}
+		existing.Name = def.Name
+		existing.ClientID = def.ClientID
+		existing.TenantID = def.TenantID
+		if def.AuthType != "" {
+			existing.AuthType = def.AuthType
+		}
+		if def.DriveType != "" {
+			existing.DriveType = def.DriveType
+			existing.DriveID = def.DriveID
+		}
+		if clientSecret != "" {
+			existing.ClientSecret = clientSecret
+		}
+		if refreshToken != "" {
+			existing.RefreshToken = refreshToken
+			existing.AccessToken = ""
+			existing.TokenExpires = time.Time{}
+		}
+		if def.Priority > 0 {
+			existing.Priority = def.Priority
+		}
+		if def.Tags != nil {
+			existing.Tags = def.Tags
+		}
+		if err := s.Update(ctx, existing); err != nil {
+			return existing.ID, "", err
+		}
+		return existing.ID, "updated", nil
+	}
+
+	account := &types.StorageAccount{
+		Name:         def.Name,
+		Email:        def.Email,
+		ClientID:     def.ClientID,
+		ClientSecret: clientSecret,
+		TenantID:     def.TenantID,
+		RefreshToken: refreshToken,
+		Priority:     def.Priority,
+		Tags:         def.Tags,
+		AuthType:     def.AuthType,
+		DriveType:    def.DriveType,
+		DriveID:      def.DriveID,
+	}
+	if err := s.Create(ctx, account); err != nil {
+		return "", "", err
+	}
+	return account.ID, "created", nil
+}
+
+// Export returns all accounts as a manifest. Secrets are encrypted with the passphrase,
+// or redacted when no passphrase is given; refresh tokens are only exported encrypted.
+func (s *Service) Export(ctx context.Context, passphrase string) (*types.AccountManifest, error) {
+	accounts, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &types.AccountManifest{
+		Version:  ManifestVersion,
+		Secrets:  "redacted",
+		Accounts: []types.AccountDefinition{},
+	}
+	if passphrase != "" {
+		manifest.Secrets = "encrypted"
+	}
+
+	for _, account := range accounts {
+		if account.ID == localAccountID {
+			continue
+		}
+		def := types.AccountDefinition{
+			Name:     account.Name,
+			Email:    account.Email,
+			ClientID: account.ClientID,
+			TenantID: account.TenantID,
+			Priority: account.Priority,
+			Tags:     account.Tags,
+			Status:   account.Status,
+		}
+		if IsAppOnly(account) {
+			def.AuthType = account.AuthType
+		}
+		if account.DriveType != onedrive.DriveKindMe {
+			def.DriveType = account.DriveType
+			def.DriveID = account.DriveID
+		}
+
+		if passphrase == "" {
+			if account.ClientSecret != "" {
+				def.ClientSecret = redacted
+			}
+		} else {
+			if def.ClientSecret, err = secrets.Encrypt(account.ClientSecret, passphrase); err != nil {
+				return nil, errors.InternalError(err.Error())
+			}
+			if def.RefreshToken, err = secrets.Encrypt(account.RefreshToken, passphrase); err != nil {
+				return nil, errors.InternalError(err.Error())
+			}
+		}
+
+		manifest.Accounts = append(manifest.Accounts, def)
+	}
+
+	return manifest, nil
+}
+
+// AuthStatus reports which accounts still need consent. With check set, each authorized
+// account's token is refreshed when needed, so revoked consents are detected too.
+func (s *Service) AuthStatus(ctx context.Context, check bool) ([]types.AccountAuthStatus, error) {
+	accounts, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]types.AccountAuthStatus, 0, len(accounts))
+	for _, account := range accounts {
+		if account.ID == localAccountID {
+			continue
+		}
+		status := types.AccountAuthStatus{
+			ID:           account.ID,
+			Name:         account.Name,
+			Email:        account.Email,
+			Status:       account.Status,
+			HasToken:     account.RefreshToken != "" || (IsAppOnly(account) && account.AccessToken != ""),
+			TokenExpires: account.TokenExpires,
+		}
+
+		switch {
+		case IsAppOnly(account) && !check:
+			// App-only accounts never need consent, only a valid client secret
+			if account.Status == "error" {
+				status.Reason = account.ErrorMessage
+			}
+		case IsAppOnly(account):
+			if err := s.EnsureTokenValid(ctx, account.ID); err != nil {
+				status.Reason = fmt.Sprintf("token request failed: %v", err)
+				status.Status = "error"
+			}
+		case account.RefreshToken == "":
+			status.NeedsConsent = true
+			status.Reason = "never authorized"
+		case check:
+			if err := s.EnsureTokenValid(ctx, account.ID); err != nil {
+				status.NeedsConsent = true
+				status.Reason = fmt.Sprintf("token refresh failed: %v", err)
+				status.Status = "error"
+			}
+		case account.Status == "error":
+			status.NeedsConsent = true
+			status.Reason = account.ErrorMessage
+		}
+
+		if status.NeedsConsent {
+			status.AuthorizePath = fmt.Sprintf("/api/v1/oauth/authorize/%s", account.ID)
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
@@ -0,0 +1,84 @@
+package account
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/infrastructure/onedrive"
+)
+
+// Client creates a OneDrive client for the drive an account targets
+func (s *Service) Client(account *types.StorageAccount) *onedrive.Client {
+	return onedrive.NewDriveClient(account.AccessToken, Drive(account))
+}
+
+// Drive returns the drive reference of an account
+func Drive(account *types.StorageAccount) onedrive.DriveRef {
+	return onedrive.DriveRef{Kind: account.DriveType, ID: account.DriveID}
+}
+
+// IsAppOnly reports whether an account authenticates with the client credentials grant
+func IsAppOnly(account *types.StorageAccount) bool {
+	return account.AuthType == types.AuthTypeClientCredentials
+}
+
+// validateAuth fills in the default authentication settings of an account and checks that they fit together
+func validateAuth(account *types.StorageAccount) error {
+	if account.AuthType == "" {
+		account.AuthType = types.AuthTypeDelegated
+	}
+	if account.DriveType == "" {
+		account.DriveType = onedrive.DriveKindMe
+	}
+	if account.DriveType == onedrive.DriveKindMe {
+		account.DriveID = ""
+	}
+
+	if err := Drive(account).Validate(); err != nil {
+		return errors.InvalidRequest(err.Error())
+	}
+
+	switch account.AuthType {
+	case types.AuthTypeDelegated:
+	case types.AuthTypeClientCredentials:
+		// App-only tokens have no signed-in user and are issued by a specific tenant
+		if account.DriveType == onedrive.DriveKindMe {
+			return errors.InvalidRequest("client_credentials accounts need a drive_type of drive, user or site")
+		}
+		switch strings.ToLower(account.TenantID) {
+		case "", "common", "organizations", "consumers":
+			return errors.InvalidRequest("client_credentials accounts need a specific tenant_id")
+		}
+		if account.ClientSecret == "" {
+			return errors.InvalidRequest("client_credentials accounts need a client_secret")
+		}
+	default:
+		return errors.InvalidRequest(fmt.Sprintf("unknown auth_type %q", account.AuthType))
+	}
+	return nil
+}
+
+// requestAppToken gets a new app-only access token for an account and stores it
+func (s *Service) requestAppToken(ctx context.Context, account *types.StorageAccount) error {
+	auth := onedrive.NewAuth(onedrive.AuthConfig{
+		ClientID:     account.ClientID,
+		ClientSecret: account.ClientSecret,
+		TenantID:     account.TenantID,
+	})
+
+	tokenResp, err := auth.ClientCredentialsToken(ctx)
+	if err != nil {
+		s.repo.UpdateStatus(ctx, account.ID, "error", err.Error())
+		return errors.UpstreamError(err.Error())
+	}
+
+	expiresAt := time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	if err := s.repo.UpdateToken(ctx, account.ID, tokenResp.AccessToken, "", expiresAt); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
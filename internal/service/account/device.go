@@ -0,0 +1,111 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	apperrors "github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/infrastructure/onedrive"
+)
+
+// deviceSession is a device authorization being polled in the background
+type deviceSession struct {
+	info   types.DeviceAuthorization
+	cancel context.CancelFunc
+}
+
+// StartDeviceAuthorization starts the OAuth2 device code flow for an account, for servers that
+// cannot receive the browser redirect. The user enters the returned code at the verification URI,
+// and a background poller stores the tokens like the redirect callback once consent is given.
+// Starting again replaces a pending authorization of the same account.
+func (s *Service) StartDeviceAuthorization(ctx context.Context, id string) (*types.DeviceAuthorization, error) {
+	account, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if IsAppOnly(account) {
+		return nil, apperrors.InvalidRequest("client_credentials accounts do not need user consent")
+	}
+
+	auth := onedrive.NewAuth(onedrive.AuthConfig{
+		ClientID:     account.ClientID,
+		ClientSecret: account.ClientSecret,
+		TenantID:     account.TenantID,
+	})
+	code, err := auth.RequestDeviceCode(ctx)
+	if err != nil {
+		return nil, apperrors.UpstreamError(err.Error())
+	}
+
+	pollCtx, cancel := context.WithTimeout(context.Background(), time.Duration(code.ExpiresIn)*time.Second)
+	session := &deviceSession{
+		info: types.DeviceAuthorization{
+			AccountID:       id,
+			UserCode:        code.UserCode,
+			VerificationURI: code.VerificationURI,
+			Message:         code.Message,
+			Interval:        code.Interval,
+			ExpiresAt:       time.Now().Add(time.Duration(code.ExpiresIn) * time.Second),
+			Status:          types.DeviceAuthPending,
+		},
+		cancel: cancel,
+	}
+
+	s.mu.Lock()
+	if previous, exists := s.deviceSessions[id]; exists {
+		previous.cancel()
+	}
+	s.deviceSessions[id] = session
+	info := session.info
+	s.mu.Unlock()
+
+	go s.pollDeviceAuthorization(pollCtx, session, auth, code)
+
+	return &info, nil
+}
+
+// DeviceAuthorizationStatus returns the state of the latest device authorization of an account
+func (s *Service) DeviceAuthorizationStatus(id string) (*types.DeviceAuthorization, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.deviceSessions[id]
+	if !exists {
+		return nil, apperrors.NewNotFoundError("no device authorization for this account")
+	}
+	info := session.info
+	return &info, nil
+}
+
+// pollDeviceAuthorization waits for the user to complete a device authorization and stores the tokens
+func (s *Service) pollDeviceAuthorization(ctx context.Context, session *deviceSession, auth *onedrive.Auth, code *onedrive.DeviceCodeResponse) {
+	defer session.cancel()
+
+	tokenResp, err := auth.WaitForDeviceToken(ctx, code)
+	if err == nil {
+		err = s.StoreTokens(context.Background(), session.info.AccountID, tokenResp)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case err == nil:
+		session.info.Status = types.DeviceAuthAuthorized
+		log.Printf("Account %s authorized through device code", session.info.AccountID)
+	case errors.Is(err, context.Canceled) && s.deviceSessions[session.info.AccountID] != session:
+		// Replaced by a newer authorization
+		session.info.Status = types.DeviceAuthCancelled
+	case errors.Is(err, onedrive.ErrAuthorizationDeclined):
+		session.info.Status = types.DeviceAuthDeclined
+	case errors.Is(err, onedrive.ErrDeviceCodeExpired), errors.Is(err, context.DeadlineExceeded):
+		session.info.Status = types.DeviceAuthExpired
+	default:
+		session.info.Status = types.DeviceAuthFailed
+		session.info.Error = err.Error()
+		log.Printf("Device authorization of account %s failed: %v", session.info.AccountID, err)
+	}
+}
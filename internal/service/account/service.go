@@ -3,6 +3,8 @@ package account
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,14 +14,23 @@ import (
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 )
 
+// StatusDraining marks an account whose data is being migrated away before it is deleted.
+// Only active accounts receive writes, so a draining account is still read from but never written to.
+const StatusDraining = "draining"
+
 // Service provides account management operations
 type Service struct {
-	repo *repository.AccountRepository
+	repo           *repository.AccountRepository
+	deviceSessions map[string]*deviceSession // account ID -> pending device authorization
+	mu             sync.Mutex
 }
 
 // NewService creates a new account service
 func NewService(repo *repository.AccountRepository) *Service {
-	return &Service{repo: repo}
+	return &Service{
+		repo:           repo,
+		deviceSessions: make(map[string]*deviceSession),
+	}
 }
 
 // Create creates a new storage account
@@ -32,6 +43,9 @@ func (s *Service) Create(ctx context.Context, account *types.StorageAccount) err
 	if existing != nil {
 		return errors.NewAppError("ACCOUNT_EXISTS", "Account with this email already exists", 409)
 	}
+	if err := validateAuth(account); err != nil {
+		return err
+	}
 
 	// Generate UUID if not provided
 	if account.ID == "" {
@@ -51,6 +65,13 @@ func (s *Service) Create(ctx context.Context, account *types.StorageAccount) err
 		return errors.InternalError(err.Error())
 	}
 
+	// App-only accounts need no consent, so they can be activated right away
+	if IsAppOnly(account) {
+		if err := s.SyncSpaceInfo(ctx, account.ID); err == nil {
+			account.Status = "active"
+		}
+	}
+
 	return nil
 }
 
@@ -91,6 +112,10 @@ func (s *Service) Update(ctx context.Context, account *types.StorageAccount) err
 	// Preserve created_at
 	account.CreatedAt = existing.CreatedAt
 
+	if err := validateAuth(account); err != nil {
+		return err
+	}
+
 	// Update account
 	if err := s.repo.Update(ctx, account); err != nil {
 		return errors.InternalError(err.Error())
@@ -99,8 +124,16 @@ func (s *Service) Update(ctx context.Context, account *types.StorageAccount) err
 	return nil
 }
 
-// Delete deletes an account
+// Delete deletes an account. Accounts that still store data must be drained first.
 func (s *Service) Delete(ctx context.Context, id string) error {
+	count, err := s.repo.CountReferences(ctx, id)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+	if count > 0 {
+		return errors.NewAppError("ACCOUNT_NOT_EMPTY", fmt.Sprintf("Account still stores %d objects or chunks, drain it first", count), 409)
+	}
+
 	if err := s.repo.Delete(ctx, id); err != nil {
 		if err == sql.ErrNoRows {
 			return errors.NewAppError("ACCOUNT_NOT_FOUND", "Account not found", 404)
@@ -111,6 +144,45 @@ func (s *Service) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+// CountReferences returns how many objects, chunks and replicas are stored on an account
+func (s *Service) CountReferences(ctx context.Context, id string) (int64, error) {
+	count, err := s.repo.CountReferences(ctx, id)
+	if err != nil {
+		return 0, errors.InternalError(err.Error())
+	}
+	return count, nil
+}
+
+// SetStatus updates the status of an account
+func (s *Service) SetStatus(ctx context.Context, id, status string) error {
+	if err := s.repo.UpdateStatus(ctx, id, status, ""); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// StoreTokens saves the tokens of a completed authorization, activates the account and syncs its space
+func (s *Service) StoreTokens(ctx context.Context, id string, tokenResp *onedrive.TokenResponse) error {
+	account, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	account.AccessToken = tokenResp.AccessToken
+	account.RefreshToken = tokenResp.RefreshToken
+	account.TokenExpires = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+	account.Status = "active"
+
+	if err := s.Update(ctx, account); err != nil {
+		return err
+	}
+
+	// Try to sync space info
+	_ = s.SyncSpaceInfo(ctx, id)
+
+	return nil
+}
+
 // RefreshToken refreshes an account's access token
 func (s *Service) RefreshToken(ctx context.Context, id string) error {
 	account, err := s.repo.Get(ctx, id)
@@ -121,6 +193,11 @@ func (s *Service) RefreshToken(ctx context.Context, id string) error {
 		return errors.InternalError(err.Error())
 	}
 
+	// App-only accounts have no refresh token and request a new token instead
+	if IsAppOnly(account) {
+		return s.requestAppToken(ctx, account)
+	}
+
 	if account.RefreshToken == "" {
 		return errors.NewAppError("NO_REFRESH_TOKEN", "No refresh token available", 400)
 	}
@@ -173,7 +250,7 @@ func (s *Service) SyncSpaceInfo(ctx context.Context, id string) error {
 	}
 
 	// Create OneDrive client
-	client := onedrive.NewClient(account.AccessToken)
+	client := s.Client(account)
 
 	// Get drive info
 	drive, err := client.GetDrive(ctx)
@@ -188,8 +265,10 @@ func (s *Service) SyncSpaceInfo(ctx context.Context, id string) error {
 		return errors.InternalError(err.Error())
 	}
 
-	// Mark account as active
-	s.repo.UpdateStatus(ctx, id, "active", "")
+	// Mark account as active, unless it is being drained
+	if account.Status != StatusDraining {
+		s.repo.UpdateStatus(ctx, id, "active", "")
+	}
 
 	return nil
 }
@@ -0,0 +1,297 @@
+package acl
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/acl"
+	"github.com/xuecangming/onedrive-storage/internal/repository"
+)
+
+// Service grants roles on buckets and VFS directories and checks them
+type Service struct {
+	repo       *repository.ACLRepository
+	bucketRepo *repository.BucketRepository
+	vfsRepo    *repository.VFSRepository
+	userRepo   *repository.UserRepository
+}
+
+// NewService creates a new ACL service
+func NewService(repo *repository.ACLRepository, bucketRepo *repository.BucketRepository, vfsRepo *repository.VFSRepository, userRepo *repository.UserRepository) *Service {
+	return &Service{
+		repo:       repo,
+		bucketRepo: bucketRepo,
+		vfsRepo:    vfsRepo,
+		userRepo:   userRepo,
+	}
+}
+
+// Resolver answers role checks for one caller in one bucket from grants loaded once
+type Resolver struct {
+	role   string // role held on the whole bucket without a grant
+	grants []*types.ACLGrant
+}
+
+// Role returns the caller's role on a path, empty when they have none
+func (r *Resolver) Role(p string) string {
+	return acl.Stronger(r.role, acl.Effective(r.grants, p))
+}
+
+// Allows reports whether the caller's role on a path includes want
+func (r *Resolver) Allows(p, want string) bool {
+	return acl.AtLeast(r.Role(p), want)
+}
+
+// Resolver loads the roles of a caller in a bucket. Admins, keys that do not act for a user
+// and the bucket owner are owners of the whole bucket, as is everyone when authentication is
+// disabled. Missing buckets resolve to owner as well, so handlers report them as not found.
+func (s *Service) Resolver(ctx context.Context, principal *types.Principal, bucket string) (*Resolver, error) {
+	if principal == nil || principal.SeesAll() {
+		return &Resolver{role: types.RoleOwner}, nil
+	}
+
+	b, err := s.bucketRepo.Get(ctx, bucket)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &Resolver{role: types.RoleOwner}, nil
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	if b.OwnerID == principal.UserID {
+		return &Resolver{role: types.RoleOwner}, nil
+	}
+
+	grants, err := s.repo.List(ctx, bucket, principal.UserID)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	return &Resolver{grants: grants}, nil
+}
+
+// Check checks that a caller holds at least the role want on a bucket path
+func (s *Service) Check(ctx context.Context, principal *types.Principal, bucket, p, want string) error {
+	resolver, err := s.Resolver(ctx, principal, bucket)
+	if err != nil {
+		return err
+	}
+	if !resolver.Allows(p, want) {
+		return errors.Forbidden(fmt.Sprintf("the %s role is required on %s", want, cleanPath(p)))
+	}
+	return nil
+}
+
+// AuthorizeBucket checks that a caller may reach a bucket at all: admins and keys that do not
+// act for a user reach every bucket, users the buckets they own or hold a role in. What they
+// may do inside is checked per path by the handlers. Missing buckets pass, so they can be created.
+func (s *Service) AuthorizeBucket(ctx context.Context, principal *types.Principal, name string) error {
+	if principal.SeesAll() {
+		return nil
+	}
+
+	bucket, err := s.bucketRepo.Get(ctx, name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return errors.InternalError(err.Error())
+	}
+	if bucket.OwnerID == principal.UserID {
+		return nil
+	}
+
+	granted, err := s.repo.HasAny(ctx, name, principal.UserID)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+	if !granted {
+		return errors.Forbidden("no access to bucket " + name)
+	}
+	return nil
+}
+
+// GrantedBuckets returns the buckets in which a user holds a role
+func (s *Service) GrantedBuckets(ctx context.Context, userID string) (map[string]bool, error) {
+	buckets := make(map[string]bool)
+	if userID == "" {
+		return buckets, nil
+	}
+
+	names, err := s.repo.ListBuckets(ctx, userID)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	for _, name := range names {
+		buckets[name] = true
+	}
+	return buckets, nil
+}
+
+// Grant grants a user a role on a bucket or a directory, replacing the role they held there.
+// The caller must be an owner of the path.
+func (s *Service) Grant(ctx context.Context, principal *types.Principal, bucket string, req *types.GrantRequest) (*types.ACLGrant, error) {
+	if !acl.ValidRole(req.Role) {
+		return nil, errors.InvalidRequest("role must be viewer, editor or owner")
+	}
+	if err := s.checkUser(ctx, req.UserID); err != nil {
+		return nil, err
+	}
+	if err := s.checkBucket(ctx, bucket); err != nil {
+		return nil, err
+	}
+
+	p := cleanPath(req.Path)
+	if err := s.Check(ctx, principal, bucket, p, types.RoleOwner); err != nil {
+		return nil, err
+	}
+
+	grant := &types.ACLGrant{
+		Bucket: bucket,
+		Path:   p,
+		UserID: req.UserID,
+		Role:   req.Role,
+	}
+	if principal != nil {
+		grant.GrantedBy = principal.UserID
+	}
+
+	// Roles are granted on directories, so they follow them when they move
+	if p != "/" {
+		dir, err := s.vfsRepo.GetDirectory(bucket, p)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.NewNotFoundError("directory not found: " + p)
+			}
+			return nil, errors.InternalError(err.Error())
+		}
+		grant.DirectoryID = dir.ID
+	}
+
+	if err := s.repo.Upsert(ctx, grant); err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	return grant, nil
+}
+
+// Revoke removes a grant. The caller must be an owner of the path it was made on.
+func (s *Service) Revoke(ctx context.Context, principal *types.Principal, bucket, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return errors.NewNotFoundError("grant not found: " + id)
+	}
+
+	grant, err := s.repo.Get(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && grant.Bucket != bucket) {
+		return errors.NewNotFoundError("grant not found: " + id)
+	}
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	if err := s.Check(ctx, principal, bucket, grant.Path, types.RoleOwner); err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewNotFoundError("grant not found: " + id)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// Permissions lists the grants that apply to a bucket path and the effective role of every
+// user who can reach it. The caller needs at least the viewer role on the path.
+func (s *Service) Permissions(ctx context.Context, principal *types.Principal, bucket, p string) (*types.PathPermissions, error) {
+	p = cleanPath(p)
+
+	b, err := s.bucketRepo.Get(ctx, bucket)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.BucketNotFound(bucket)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+
+	resolver, err := s.Resolver(ctx, principal, bucket)
+	if err != nil {
+		return nil, err
+	}
+	role := resolver.Role(p)
+	if !acl.AtLeast(role, types.RoleViewer) {
+		return nil, errors.Forbidden("no access to " + p)
+	}
+
+	grants, err := s.repo.List(ctx, bucket, "")
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	perms := &types.PathPermissions{
+		Bucket:    bucket,
+		Path:      p,
+		Role:      role,
+		OwnerID:   b.OwnerID,
+		Grants:    []*types.ACLGrant{},
+		Effective: []types.UserRole{},
+	}
+
+	// Effective roles keep the order users first appear in, the bucket owner first
+	index := make(map[string]int)
+	addRole := func(userID, role string) {
+		if i, ok := index[userID]; ok {
+			perms.Effective[i].Role = acl.Stronger(perms.Effective[i].Role, role)
+			return
+		}
+		index[userID] = len(perms.Effective)
+		perms.Effective = append(perms.Effective, types.UserRole{UserID: userID, Role: role})
+	}
+	if b.OwnerID != "" {
+		addRole(b.OwnerID, types.RoleOwner)
+	}
+
+	for _, g := range grants {
+		if !acl.Covers(g.Path, p) {
+			continue
+		}
+		g.Inherited = g.Path != p
+		perms.Grants = append(perms.Grants, g)
+		addRole(g.UserID, g.Role)
+	}
+
+	return perms, nil
+}
+
+// checkBucket checks that a bucket exists
+func (s *Service) checkBucket(ctx context.Context, bucket string) error {
+	if _, err := s.bucketRepo.Get(ctx, bucket); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.BucketNotFound(bucket)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// checkUser checks that a role is granted to an existing user
+func (s *Service) checkUser(ctx context.Context, userID string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return errors.InvalidRequest("user_id must be a user ID")
+	}
+	if _, err := s.userRepo.Get(ctx, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewAppError("USER_NOT_FOUND", "user not found", 404)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// cleanPath normalizes a bucket path the way the VFS does
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
@@ -0,0 +1,214 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/repository"
+)
+
+// KeyPrefix starts every generated key, so leaked keys are easy to search for
+const KeyPrefix = "ods_"
+
+// prefixLength is how many characters of a key are kept to recognize it
+const prefixLength = len(KeyPrefix) + 8
+
+// lastUsedInterval limits how often the last-used time of a key is written
+const lastUsedInterval = time.Minute
+
+// adminKeyID identifies the bootstrap admin key from the configuration
+const adminKeyID = "admin"
+
+// Service manages API keys and authenticates requests
+type Service struct {
+	repo     *repository.APIKeyRepository
+	userRepo *repository.UserRepository
+	adminKey string
+}
+
+// NewService creates a new API key service. adminKey is an optional bootstrap key
+// with admin scope on all buckets, used to create the first stored keys.
+func NewService(repo *repository.APIKeyRepository, userRepo *repository.UserRepository, adminKey string) *Service {
+	return &Service{
+		repo:     repo,
+		userRepo: userRepo,
+		adminKey: adminKey,
+	}
+}
+
+// Create creates an API key. The returned key is the only time the key itself is available.
+func (s *Service) Create(ctx context.Context, req *types.CreateAPIKeyRequest) (*types.APIKey, error) {
+	if strings.TrimSpace(req.Name) == "" {
+		return nil, errors.InvalidRequest("name is required")
+	}
+	if len(req.Scopes) == 0 {
+		return nil, errors.InvalidRequest("at least one scope is required")
+	}
+	for _, scope := range req.Scopes {
+		if scope != types.ScopeRead && scope != types.ScopeWrite && scope != types.ScopeAdmin {
+			return nil, errors.InvalidRequest(fmt.Sprintf("unknown scope %q", scope))
+		}
+	}
+	for _, bucket := range req.Buckets {
+		if !utils.ValidateBucketName(bucket) {
+			return nil, errors.InvalidBucket(bucket)
+		}
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return nil, errors.InvalidRequest("expires_at must be in the future")
+	}
+	if req.UserID != "" {
+		if err := s.checkUser(ctx, req.UserID, req.Scopes); err != nil {
+			return nil, err
+		}
+	}
+
+	raw, err := generateKey()
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	key := &types.APIKey{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Prefix:    raw[:prefixLength],
+		KeyHash:   hashKey(raw),
+		Scopes:    req.Scopes,
+		Buckets:   req.Buckets,
+		UserID:    req.UserID,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	key.Key = raw
+	return key, nil
+}
+
+// Get retrieves an API key by ID
+func (s *Service) Get(ctx context.Context, id string) (*types.APIKey, error) {
+	key, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewAppError("API_KEY_NOT_FOUND", "API key not found", 404)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	return key, nil
+}
+
+// List retrieves all API keys, or only those of a user when userID is not empty
+func (s *Service) List(ctx context.Context, userID string) ([]*types.APIKey, error) {
+	keys, err := s.repo.List(ctx, userID)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	if keys == nil {
+		keys = []*types.APIKey{}
+	}
+	return keys, nil
+}
+
+// Delete revokes an API key
+func (s *Service) Delete(ctx context.Context, id string) error {
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewAppError("API_KEY_NOT_FOUND", "API key not found", 404)
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// Authenticate returns the caller behind an API key, recording when the key was used
+func (s *Service) Authenticate(ctx context.Context, raw string) (*types.Principal, error) {
+	key, err := s.authenticate(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	return &types.Principal{UserID: key.UserID, KeyID: key.ID, Scopes: key.Scopes, Buckets: key.Buckets}, nil
+}
+
+// authenticate returns the API key a request presented
+func (s *Service) authenticate(ctx context.Context, raw string) (*types.APIKey, error) {
+	if raw == "" {
+		return nil, errors.Unauthorized("API key required")
+	}
+
+	if s.adminKey != "" && subtle.ConstantTimeCompare([]byte(raw), []byte(s.adminKey)) == 1 {
+		return &types.APIKey{ID: adminKeyID, Name: "admin", Scopes: []string{types.ScopeAdmin}}, nil
+	}
+
+	key, err := s.repo.GetByHash(ctx, hashKey(raw))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.Unauthorized("invalid API key")
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, errors.Unauthorized("API key expired")
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedInterval {
+		if err := s.repo.TouchLastUsed(ctx, key.ID, now); err != nil {
+			log.Printf("Warning: failed to record use of API key %s: %v", key.ID, err)
+		}
+		key.LastUsedAt = &now
+	}
+
+	return key, nil
+}
+
+// checkUser checks that a key may act for a user. Only admins may hold admin keys.
+func (s *Service) checkUser(ctx context.Context, userID string, scopes []string) error {
+	if _, err := uuid.Parse(userID); err != nil {
+		return errors.InvalidRequest("unknown user " + userID)
+	}
+	user, err := s.userRepo.Get(ctx, userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.InvalidRequest("unknown user " + userID)
+		}
+		return errors.InternalError(err.Error())
+	}
+	if !user.IsAdmin {
+		for _, scope := range scopes {
+			if scope == types.ScopeAdmin {
+				return errors.InvalidRequest("only admin users can hold admin keys")
+			}
+		}
+	}
+	return nil
+}
+
+// generateKey returns a new random key
+func generateKey() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashKey returns the stored hash of a key. Keys are random, so a fast hash is enough.
+func hashKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
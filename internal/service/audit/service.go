@@ -9,24 +9,48 @@ import (
 
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/common/utils"
-	"github.com/xuecangming/onedrive-storage/internal/infrastructure/onedrive"
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 	"github.com/xuecangming/onedrive-storage/internal/service/account"
+	"github.com/xuecangming/onedrive-storage/internal/service/task"
 )
 
+// ReplicaRepairer re-copies under-replicated data from a surviving replica
+type ReplicaRepairer interface {
+	RepairReplicas(ctx context.Context, bucket, key string, chunkIndex int) (int, error)
+}
+
+// repairRequest identifies data queued for repair
+type repairRequest struct {
+	taskID     string
+	target     string
+	bucket     string
+	key        string
+	chunkIndex int
+}
+
 // Service handles audit operations
 type Service struct {
 	objectRepo     *repository.ObjectRepository
+	replicaRepo    *repository.ReplicaRepository
+	bucketRepo     *repository.BucketRepository
 	accountService *account.Service
+	taskService    *task.Service
+	repairer       ReplicaRepairer
 	currentReport  *types.AuditReport
+	pendingRepairs map[string]bool
 	mu             sync.Mutex
 }
 
 // NewService creates a new audit service
-func NewService(objectRepo *repository.ObjectRepository, accountService *account.Service) *Service {
+func NewService(objectRepo *repository.ObjectRepository, replicaRepo *repository.ReplicaRepository, bucketRepo *repository.BucketRepository, accountService *account.Service, taskService *task.Service, repairer ReplicaRepairer) *Service {
 	return &Service{
 		objectRepo:     objectRepo,
+		replicaRepo:    replicaRepo,
+		bucketRepo:     bucketRepo,
 		accountService: accountService,
+		taskService:    taskService,
+		repairer:       repairer,
+		pendingRepairs: make(map[string]bool),
 	}
 }
 
@@ -61,7 +85,9 @@ func (s *Service) GetStatus() *types.AuditReport {
 
 func (s *Service) runAudit(ctx context.Context, report *types.AuditReport) {
 	log.Printf("Starting audit %s", report.ID)
-	
+	factors := make(map[string]int)
+	var repairs []repairRequest
+
 	// Audit Objects
 	offset := 0
 	limit := 100
@@ -78,7 +104,9 @@ func (s *Service) runAudit(ctx context.Context, report *types.AuditReport) {
 		for _, obj := range objects {
 			report.TotalObjects++
 			if !obj.IsChunked {
-				s.checkObject(ctx, report, obj)
+				if repair := s.checkObject(ctx, report, obj, factors); repair != nil {
+					repairs = append(repairs, *repair)
+				}
 			}
 			report.CheckedCount++
 		}
@@ -99,7 +127,9 @@ func (s *Service) runAudit(ctx context.Context, report *types.AuditReport) {
 
 		for _, chunk := range chunks {
 			report.TotalChunks++
-			s.checkChunk(ctx, report, chunk)
+			if repair := s.checkChunk(ctx, report, chunk, factors); repair != nil {
+				repairs = append(repairs, *repair)
+			}
 			report.CheckedCount++
 		}
 		offset += limit
@@ -108,17 +138,23 @@ func (s *Service) runAudit(ctx context.Context, report *types.AuditReport) {
 	endTime := time.Now()
 	report.EndTime = &endTime
 	report.Status = "completed"
-	report.Summary = fmt.Sprintf("Checked %d objects and %d chunks. Found %d issues.", report.TotalObjects, report.TotalChunks, len(report.Issues))
+	for _, repair := range repairs {
+		report.RepairTasks = append(report.RepairTasks, repair.taskID)
+	}
+	report.Summary = fmt.Sprintf("Checked %d objects and %d chunks. Found %d issues. Queued %d repairs.", report.TotalObjects, report.TotalChunks, len(report.Issues), len(repairs))
 	
 	log.Printf("Audit %s completed: %s", report.ID, report.Summary)
+
+	s.runRepairs(ctx, repairs)
 }
 
-func (s *Service) checkObject(ctx context.Context, report *types.AuditReport, obj *types.Object) {
+func (s *Service) checkObject(ctx context.Context, report *types.AuditReport, obj *types.Object, factors map[string]int) *repairRequest {
 	if obj.AccountID == "00000000-0000-0000-0000-000000000000" {
 		// Local storage, skip for now or implement local check
-		return
+		return nil
 	}
 
+	copies := 0
 	err := s.checkRemoteFile(ctx, obj.AccountID, obj.RemoteID)
 	if err != nil {
 		s.addIssue(report, types.AuditIssue{
@@ -129,10 +165,38 @@ func (s *Service) checkObject(ctx context.Context, report *types.AuditReport, ob
 			RemoteID:    obj.RemoteID,
 			Description: fmt.Sprintf("Object missing or inaccessible: %v", err),
 		})
+	} else {
+		copies++
 	}
+
+	copies += s.checkReplicas(ctx, report, obj.Bucket, obj.Key, types.WholeObjectIndex)
+	return s.checkReplication(ctx, report, obj.Bucket, obj.Key, types.WholeObjectIndex, copies, factors)
 }
 
-func (s *Service) checkChunk(ctx context.Context, report *types.AuditReport, chunk *types.ObjectChunk) {
+func (s *Service) checkChunk(ctx context.Context, report *types.AuditReport, chunk *types.ObjectChunk, factors map[string]int) *repairRequest {
+	if chunk.AccountID == "00000000-0000-0000-0000-000000000000" {
+		// Chunk pinned to local storage
+		return nil
+	}
+
+	if chunk.IsShard() {
+		// Erasure-coded shards are rebuilt from the rest of their stripe
+		if err := s.checkRemoteFile(ctx, chunk.AccountID, chunk.RemoteID); err != nil {
+			s.addIssue(report, types.AuditIssue{
+				Type:        "missing_shard",
+				Bucket:      chunk.Bucket,
+				Key:         chunk.Key,
+				ChunkIndex:  &chunk.ChunkIndex,
+				AccountID:   chunk.AccountID,
+				RemoteID:    chunk.RemoteID,
+				Description: fmt.Sprintf("Shard %d of %d+%d missing or inaccessible: %v", chunk.ShardIndex, chunk.DataShards, chunk.ParityShards, err),
+			})
+			return s.queueRepair(chunk.Bucket, chunk.Key, chunk.ChunkIndex)
+		}
+		return nil
+	}
+
+	copies := 0
 	err := s.checkRemoteFile(ctx, chunk.AccountID, chunk.RemoteID)
 	if err != nil {
 		s.addIssue(report, types.AuditIssue{
@@ -144,6 +208,132 @@ func (s *Service) checkChunk(ctx context.Context, report *types.AuditReport, chu
 			RemoteID:    chunk.RemoteID,
 			Description: fmt.Sprintf("Chunk missing or inaccessible: %v", err),
 		})
+	} else {
+		copies++
+	}
+
+	copies += s.checkReplicas(ctx, report, chunk.Bucket, chunk.Key, chunk.ChunkIndex)
+	return s.checkReplication(ctx, report, chunk.Bucket, chunk.Key, chunk.ChunkIndex, copies, factors)
+}
+
+// checkReplicas checks the replicas of an object or chunk and returns how many are intact
+func (s *Service) checkReplicas(ctx context.Context, report *types.AuditReport, bucket, key string, chunkIndex int) int {
+	replicas, err := s.replicaRepo.List(ctx, bucket, key, chunkIndex)
+	if err != nil {
+		log.Printf("Error listing replicas of %s/%s: %v", bucket, key, err)
+		return 0
+	}
+
+	intact := 0
+	for _, replica := range replicas {
+		if err := s.checkRemoteFile(ctx, replica.AccountID, replica.RemoteID); err != nil {
+			issue := types.AuditIssue{
+				Type:        "missing_replica",
+				Bucket:      bucket,
+				Key:         key,
+				AccountID:   replica.AccountID,
+				RemoteID:    replica.RemoteID,
+				Description: fmt.Sprintf("Replica missing or inaccessible: %v", err),
+			}
+			if chunkIndex != types.WholeObjectIndex {
+				index := chunkIndex
+				issue.ChunkIndex = &index
+			}
+			s.addIssue(report, issue)
+			continue
+		}
+		intact++
+	}
+	return intact
+}
+
+// checkReplication reports data with fewer intact copies than its bucket's replication factor
+// and queues a repair task for it
+func (s *Service) checkReplication(ctx context.Context, report *types.AuditReport, bucket, key string, chunkIndex, copies int, factors map[string]int) *repairRequest {
+	factor, cached := factors[bucket]
+	if !cached {
+		factor = 1
+		if b, err := s.bucketRepo.Get(ctx, bucket); err == nil {
+			factor = b.Policy.Copies()
+		}
+		factors[bucket] = factor
+	}
+
+	if copies >= factor {
+		return nil
+	}
+
+	issue := types.AuditIssue{
+		Type:        "under_replicated",
+		Bucket:      bucket,
+		Key:         key,
+		Description: fmt.Sprintf("%d of %d copies intact", copies, factor),
+	}
+	if chunkIndex != types.WholeObjectIndex {
+		index := chunkIndex
+		issue.ChunkIndex = &index
+	}
+	s.addIssue(report, issue)
+
+	if copies == 0 {
+		// Nothing left to copy from
+		return nil
+	}
+	return s.queueRepair(bucket, key, chunkIndex)
+}
+
+// queueRepair creates a repair task unless one is already pending for the same data
+func (s *Service) queueRepair(bucket, key string, chunkIndex int) *repairRequest {
+	target := fmt.Sprintf("%s/%s#%d", bucket, key, chunkIndex)
+
+	s.mu.Lock()
+	if s.pendingRepairs[target] {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pendingRepairs[target] = true
+	s.mu.Unlock()
+
+	t, err := s.taskService.CreateTask(types.TaskTypeRepair, map[string]interface{}{
+		"bucket":      bucket,
+		"key":         key,
+		"chunk_index": chunkIndex,
+	})
+	if err != nil {
+		log.Printf("Failed to create repair task for %s: %v", target, err)
+		s.mu.Lock()
+		delete(s.pendingRepairs, target)
+		s.mu.Unlock()
+		return nil
+	}
+
+	return &repairRequest{
+		taskID:     t.ID,
+		target:     target,
+		bucket:     bucket,
+		key:        key,
+		chunkIndex: chunkIndex,
+	}
+}
+
+// runRepairs executes queued repair tasks one at a time
+func (s *Service) runRepairs(ctx context.Context, repairs []repairRequest) {
+	for _, repair := range repairs {
+		s.taskService.UpdateProgress(repair.taskID, 1)
+
+		copies, err := s.repairer.RepairReplicas(ctx, repair.bucket, repair.key, repair.chunkIndex)
+		if err != nil {
+			log.Printf("Repair of %s failed: %v", repair.target, err)
+			s.taskService.FailTask(repair.taskID, err.Error())
+		} else {
+			s.taskService.CompleteTask(repair.taskID, map[string]interface{}{
+				"copies_created": copies,
+			})
+		}
+
+		s.mu.Lock()
+		delete(s.pendingRepairs, repair.target)
+		s.mu.Unlock()
 	}
 }
 
@@ -162,7 +352,7 @@ func (s *Service) checkRemoteFile(ctx context.Context, accountID, remoteID strin
 	// Get fresh account
 	account, _ = s.accountService.Get(ctx, account.ID)
 
-	client := onedrive.NewClient(account.AccessToken)
+	client := s.accountService.Client(account)
 	
 	// We need a lightweight way to check existence. 
 	// GetDriveItem is not implemented in the client yet, but DownloadFile is.
@@ -3,21 +3,25 @@ package bucket
 import (
 	"context"
 	"database/sql"
+	"fmt"
 
+	"github.com/google/uuid"
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 )
 
 // Service provides bucket management operations
 type Service struct {
-	repo *repository.BucketRepository
+	repo        *repository.BucketRepository
+	accountRepo *repository.AccountRepository
 }
 
 // NewService creates a new bucket service
-func NewService(repo *repository.BucketRepository) *Service {
-	return &Service{repo: repo}
+func NewService(repo *repository.BucketRepository, accountRepo *repository.AccountRepository) *Service {
+	return &Service{repo: repo, accountRepo: accountRepo}
 }
 
 // List returns all buckets
@@ -25,8 +29,8 @@ func (s *Service) List(ctx context.Context) ([]*types.Bucket, error) {
 	return s.repo.List(ctx)
 }
 
-// Create creates a new bucket
-func (s *Service) Create(ctx context.Context, name string) (*types.Bucket, error) {
+// Create creates a new bucket owned by a user, or without an owner when ownerID is empty
+func (s *Service) Create(ctx context.Context, name, ownerID string) (*types.Bucket, error) {
 	// Validate bucket name
 	if !utils.ValidateBucketName(name) {
 		return nil, errors.InvalidBucket(name)
@@ -42,7 +46,7 @@ func (s *Service) Create(ctx context.Context, name string) (*types.Bucket, error
 	}
 
 	// Create bucket
-	bucket, err := s.repo.Create(ctx, name)
+	bucket, err := s.repo.Create(ctx, name, ownerID)
 	if err != nil {
 		return nil, errors.InternalError(err.Error())
 	}
@@ -50,6 +54,17 @@ func (s *Service) Create(ctx context.Context, name string) (*types.Bucket, error
 	return bucket, nil
 }
 
+// SetOwner changes the owner of a bucket; an empty ownerID leaves it without an owner
+func (s *Service) SetOwner(ctx context.Context, name, ownerID string) (*types.Bucket, error) {
+	if err := s.repo.SetOwner(ctx, name, ownerID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.BucketNotFound(name)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	return s.Get(ctx, name)
+}
+
 // Delete deletes a bucket
 func (s *Service) Delete(ctx context.Context, name string) error {
 	// Check if bucket exists
@@ -94,6 +109,142 @@ func (s *Service) Get(ctx context.Context, name string) (*types.Bucket, error) {
 	return bucket, nil
 }
 
+// MaxReplicationFactor is the largest number of copies a bucket may request
+const MaxReplicationFactor = 5
+
+// MaxErasureShards is the largest number of data plus parity shards a bucket may request
+const MaxErasureShards = 16
+
+// Default erasure coding layout
+const (
+	DefaultDataShards   = 4
+	DefaultParityShards = 2
+)
+
+// GetPolicy retrieves the placement policy of a bucket
+func (s *Service) GetPolicy(ctx context.Context, name string) (*types.PlacementPolicy, error) {
+	bucket, err := s.Get(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return &bucket.Policy, nil
+}
+
+// UpdatePolicy validates and stores the placement policy of a bucket
+func (s *Service) UpdatePolicy(ctx context.Context, name string, policy *types.PlacementPolicy) (*types.PlacementPolicy, error) {
+	applyPolicyDefaults(policy)
+	if err := validatePolicy(policy); err != nil {
+		return nil, err
+	}
+	if err := s.checkAllowedAccounts(ctx, policy.AllowedAccounts); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.UpdatePolicy(ctx, name, policy); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.BucketNotFound(name)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+
+	return policy, nil
+}
+
+// checkAllowedAccounts checks that every account a policy allows exists, once
+func (s *Service) checkAllowedAccounts(ctx context.Context, accountIDs []string) error {
+	seen := make(map[string]bool, len(accountIDs))
+	for _, id := range accountIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return errors.InvalidRequest(fmt.Sprintf("invalid account ID in allowed_accounts: %s", id))
+		}
+		if seen[id] {
+			return errors.InvalidRequest(fmt.Sprintf("account listed twice in allowed_accounts: %s", id))
+		}
+		seen[id] = true
+
+		if _, err := s.accountRepo.Get(ctx, id); err != nil {
+			if err == sql.ErrNoRows {
+				return errors.InvalidRequest(fmt.Sprintf("unknown account in allowed_accounts: %s", id))
+			}
+			return errors.InternalError(err.Error())
+		}
+	}
+	return nil
+}
+
+// applyPolicyDefaults fills in unset policy fields
+func applyPolicyDefaults(policy *types.PlacementPolicy) {
+	if policy.ReplicationFactor == 0 {
+		policy.ReplicationFactor = 1
+	}
+	if policy.StorageClass == "" {
+		policy.StorageClass = types.StorageClassSingle
+		if policy.ReplicationFactor > 1 {
+			policy.StorageClass = types.StorageClassReplicated
+		}
+	}
+	if policy.StorageClass == types.StorageClassErasure {
+		if policy.DataShards == 0 {
+			policy.DataShards = DefaultDataShards
+		}
+		if policy.ParityShards == 0 {
+			policy.ParityShards = DefaultParityShards
+		}
+	}
+}
+
+// validatePolicy checks a placement policy for invalid or conflicting settings
+func validatePolicy(policy *types.PlacementPolicy) error {
+	if policy.ReplicationFactor < 1 || policy.ReplicationFactor > MaxReplicationFactor {
+		return errors.InvalidRequest(fmt.Sprintf("replication_factor must be between 1 and %d", MaxReplicationFactor))
+	}
+	switch policy.StorageClass {
+	case types.StorageClassSingle:
+		if policy.ReplicationFactor != 1 {
+			return errors.InvalidRequest("storage_class single requires replication_factor 1")
+		}
+	case types.StorageClassReplicated:
+		if policy.ReplicationFactor < 2 {
+			return errors.InvalidRequest("storage_class replicated requires replication_factor of at least 2")
+		}
+	case types.StorageClassErasure:
+		if policy.ReplicationFactor != 1 {
+			return errors.InvalidRequest("storage_class erasure cannot be combined with replication_factor")
+		}
+		if policy.DataShards < 1 || policy.ParityShards < 1 || policy.DataShards+policy.ParityShards > MaxErasureShards {
+			return errors.InvalidRequest(fmt.Sprintf("data_shards and parity_shards must be at least 1 and total at most %d", MaxErasureShards))
+		}
+		if policy.PinLocal {
+			return errors.InvalidRequest("pin_local cannot be combined with storage_class erasure")
+		}
+		if len(policy.AllowedAccounts) > 0 && policy.DataShards+policy.ParityShards > len(policy.AllowedAccounts) {
+			return errors.InvalidRequest("data_shards plus parity_shards exceeds the number of allowed accounts")
+		}
+	default:
+		return errors.InvalidRequest(fmt.Sprintf("unknown storage_class: %s", policy.StorageClass))
+	}
+	if policy.StorageClass != types.StorageClassErasure && (policy.DataShards != 0 || policy.ParityShards != 0) {
+		return errors.InvalidRequest("data_shards and parity_shards require storage_class erasure")
+	}
+	if policy.Strategy != "" {
+		if _, err := loadbalancer.ParseStrategy(policy.Strategy); err != nil {
+			return errors.InvalidRequest(err.Error())
+		}
+	}
+	if policy.PinLocal {
+		if policy.IsRestricted() {
+			return errors.InvalidRequest("pin_local cannot be combined with allowed_accounts or allowed_tags")
+		}
+		if policy.ReplicationFactor > 1 {
+			return errors.InvalidRequest("pin_local cannot be combined with replication_factor above 1")
+		}
+	}
+	if len(policy.AllowedAccounts) > 0 && policy.ReplicationFactor > len(policy.AllowedAccounts) {
+		return errors.InvalidRequest("replication_factor exceeds the number of allowed accounts")
+	}
+	return nil
+}
+
 // Exists checks if a bucket exists
 func (s *Service) Exists(ctx context.Context, name string) (bool, error) {
 	return s.repo.Exists(ctx, name)
@@ -0,0 +1,129 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/service/account"
+	"github.com/xuecangming/onedrive-storage/internal/service/task"
+)
+
+// localAccountID is the placeholder account of data kept on local disk
+const localAccountID = "00000000-0000-0000-0000-000000000000"
+
+// AccountMigrator moves all data stored on an account to other accounts
+type AccountMigrator interface {
+	MigrateAccount(ctx context.Context, accountID string, progress func(done, total int64)) (*types.MigrationResult, error)
+}
+
+// Service drains storage accounts so they can be retired
+type Service struct {
+	accountService *account.Service
+	taskService    *task.Service
+	migrator       AccountMigrator
+	running        map[string]string // account ID -> task ID
+	mu             sync.Mutex
+}
+
+// NewService creates a new migration service
+func NewService(accountService *account.Service, taskService *task.Service, migrator AccountMigrator) *Service {
+	return &Service{
+		accountService: accountService,
+		taskService:    taskService,
+		migrator:       migrator,
+		running:        make(map[string]string),
+	}
+}
+
+// DrainAccount marks an account as draining and starts a background task that migrates its data
+// to other accounts. The account is deleted once nothing references it anymore. Draining an account
+// that is already being drained returns the running task.
+func (s *Service) DrainAccount(ctx context.Context, accountID string) (*types.Task, error) {
+	if accountID == localAccountID {
+		return nil, errors.InvalidRequest("the local storage account cannot be drained")
+	}
+	if _, err := s.accountService.Get(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if taskID, exists := s.running[accountID]; exists {
+		return s.taskService.GetTask(taskID)
+	}
+
+	if err := s.accountService.SetStatus(ctx, accountID, account.StatusDraining); err != nil {
+		return nil, err
+	}
+
+	t, err := s.taskService.CreateTask(types.TaskTypeMigrate, map[string]interface{}{
+		"account_id": accountID,
+	})
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	s.running[accountID] = t.ID
+
+	go s.drain(context.Background(), t.ID, accountID)
+
+	return t, nil
+}
+
+// drain runs the migration task of an account
+func (s *Service) drain(ctx context.Context, taskID, accountID string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, accountID)
+		s.mu.Unlock()
+	}()
+
+	result, err := s.migrator.MigrateAccount(ctx, accountID, func(done, total int64) {
+		if total <= 0 {
+			return
+		}
+		// 100 is reserved for the completed task
+		progress := int(done * 99 / total)
+		if progress < 1 {
+			progress = 1
+		}
+		s.taskService.UpdateProgress(taskID, progress)
+	})
+	if err != nil {
+		log.Printf("Migration of account %s failed: %v", accountID, err)
+		s.taskService.FailTask(taskID, err.Error())
+		return
+	}
+
+	remaining, err := s.accountService.CountReferences(ctx, accountID)
+	if err != nil {
+		s.taskService.FailTask(taskID, err.Error())
+		return
+	}
+	if remaining > 0 {
+		message := fmt.Sprintf("%d items could not be migrated, account %s is still draining", remaining, accountID)
+		if len(result.Errors) > 0 {
+			message += ": " + strings.Join(result.Errors, "; ")
+		}
+		s.taskService.FailTask(taskID, message)
+		return
+	}
+
+	if err := s.accountService.Delete(ctx, accountID); err != nil {
+		s.taskService.FailTask(taskID, fmt.Sprintf("account is empty but could not be deleted: %v", err))
+		return
+	}
+
+	log.Printf("Account %s drained: %d items moved", accountID, result.Moved)
+	s.taskService.CompleteTask(taskID, map[string]interface{}{
+		"account_id": accountID,
+		"total":      result.Total,
+		"moved":      result.Moved,
+		"deleted":    true,
+	})
+}
@@ -0,0 +1,229 @@
+package object
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"log"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/erasure"
+)
+
+// shardPath returns the remote path of an erasure-coded shard
+func shardPath(bucket, key string, chunkIndex, shardIndex int) string {
+	return fmt.Sprintf("%s/%s_part%d_shard%d", bucket, key, chunkIndex, shardIndex)
+}
+
+// shardChecksum returns the checksum stored for a shard
+func shardChecksum(data []byte) string {
+	hash := md5.Sum(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// uploadStripe erasure-codes one chunk and stores each shard on a distinct account
+func (s *Service) uploadStripe(ctx context.Context, bucket, key string, index int, data []byte, policy *types.PlacementPolicy, accounts []*types.StorageAccount) error {
+	codec, err := erasure.New(policy.DataShards, policy.ParityShards)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+	if len(accounts) < codec.TotalShards() {
+		return errors.ServiceUnavailable(fmt.Sprintf("erasure coding needs %d accounts, %d available", codec.TotalShards(), len(accounts)))
+	}
+
+	shards := codec.Split(data)
+	if err := codec.Encode(shards); err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	candidates := accounts
+	opts := selectOptions(bucket, policy)
+	chunks := make([]*types.ObjectChunk, 0, len(shards))
+	for i, shard := range shards {
+		path := shardPath(bucket, key, index, i)
+		account, item, err := s.uploadWithFailover(ctx, candidates, path, shard, opts)
+		if err != nil {
+			// The shards already uploaded are not recorded anywhere, so remove them now
+			for _, chunk := range chunks {
+				s.deleteChunkData(ctx, chunk)
+			}
+			return uploadError(err)
+		}
+		candidates = excludeAccount(candidates, account.ID)
+
+		chunks = append(chunks, &types.ObjectChunk{
+			ID:           utils.GenerateID(),
+			Bucket:       bucket,
+			Key:          key,
+			ChunkIndex:   index,
+			AccountID:    account.ID,
+			RemoteID:     item.ID,
+			RemotePath:   path,
+			ChunkSize:    int64(len(shard)),
+			Checksum:     shardChecksum(shard),
+			Status:       "active",
+			ShardIndex:   i,
+			DataShards:   codec.DataShards(),
+			ParityShards: codec.ParityShards(),
+			StripeSize:   int64(len(data)),
+		})
+	}
+
+	for _, chunk := range chunks {
+		if err := s.objectRepo.CreateChunk(ctx, chunk); err != nil {
+			return errors.InternalError(err.Error())
+		}
+	}
+	return nil
+}
+
+// fetchShards downloads shards until enough are available to rebuild the stripe.
+// Data shards on healthy accounts are preferred, so a healthy stripe needs no decoding.
+// A shard whose checksum does not match is treated as missing.
+func (s *Service) fetchShards(ctx context.Context, codec *erasure.Codec, stripe []*types.ObjectChunk, all bool) [][]byte {
+	shards := make([][]byte, codec.TotalShards())
+
+	var healthy, unhealthy []*types.ObjectChunk
+	for _, chunk := range stripe {
+		if chunk.ShardIndex < 0 || chunk.ShardIndex >= codec.TotalShards() {
+			continue
+		}
+		if s.balancer.IsHealthy(chunk.AccountID) {
+			healthy = append(healthy, chunk)
+		} else {
+			unhealthy = append(unhealthy, chunk)
+		}
+	}
+
+	present := 0
+	for _, chunk := range append(healthy, unhealthy...) {
+		if !all && present >= codec.DataShards() {
+			break
+		}
+		data, err := s.downloadFromAccount(ctx, chunk.AccountID, chunk.RemoteID)
+		if err != nil {
+			log.Printf("Shard %d of %s is unavailable: %v", chunk.ShardIndex, shardPath(chunk.Bucket, chunk.Key, chunk.ChunkIndex, chunk.ShardIndex), err)
+			continue
+		}
+		if chunk.Checksum != "" && shardChecksum(data) != chunk.Checksum {
+			log.Printf("Shard %d of %s/%s failed checksum verification", chunk.ShardIndex, chunk.Bucket, chunk.Key)
+			continue
+		}
+		shards[chunk.ShardIndex] = data
+		present++
+	}
+	return shards
+}
+
+// readStripe rebuilds a chunk from any DataShards of its shards
+func (s *Service) readStripe(ctx context.Context, stripe []*types.ObjectChunk) ([]byte, error) {
+	if len(stripe) == 0 {
+		return nil, errors.InternalError("stripe has no shards")
+	}
+	first := stripe[0]
+	codec, err := erasure.New(first.DataShards, first.ParityShards)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	shards := s.fetchShards(ctx, codec, stripe, false)
+	for i := 0; i < codec.DataShards(); i++ {
+		if len(shards[i]) == 0 {
+			if err := codec.Reconstruct(shards); err != nil {
+				return nil, errors.UpstreamError(fmt.Sprintf("cannot rebuild chunk %d of %s/%s: %v", first.ChunkIndex, first.Bucket, first.Key, err))
+			}
+			break
+		}
+	}
+
+	return codec.Join(shards, int(first.StripeSize))
+}
+
+// repairStripe re-creates lost shards of a chunk on other accounts and returns how many were written
+func (s *Service) repairStripe(ctx context.Context, bucket, key string, chunkIndex int) (int, error) {
+	chunks, err := s.objectRepo.GetChunks(ctx, bucket, key)
+	if err != nil {
+		return 0, errors.InternalError(err.Error())
+	}
+	var stripe []*types.ObjectChunk
+	for _, chunk := range chunks {
+		if chunk.ChunkIndex == chunkIndex {
+			stripe = append(stripe, chunk)
+		}
+	}
+	if len(stripe) == 0 {
+		return 0, errors.ObjectNotFound(bucket, key)
+	}
+
+	first := stripe[0]
+	codec, err := erasure.New(first.DataShards, first.ParityShards)
+	if err != nil {
+		return 0, errors.InternalError(err.Error())
+	}
+
+	shards := s.fetchShards(ctx, codec, stripe, true)
+	var lost []*types.ObjectChunk
+	var used []string
+	for _, chunk := range stripe {
+		used = append(used, chunk.AccountID)
+		if len(shards[chunk.ShardIndex]) == 0 {
+			lost = append(lost, chunk)
+		}
+	}
+	if len(lost) == 0 {
+		return 0, nil
+	}
+
+	if err := codec.Reconstruct(shards); err != nil {
+		return 0, errors.InternalError(fmt.Sprintf("cannot rebuild chunk %d of %s/%s: %v", chunkIndex, bucket, key, err))
+	}
+
+	policy, err := s.placementPolicy(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+	accounts, err := s.eligibleAccounts(ctx, bucket, policy)
+	if err != nil {
+		return 0, err
+	}
+	candidates := accounts
+	for _, accountID := range used {
+		candidates = excludeAccount(candidates, accountID)
+	}
+
+	repaired := 0
+	for _, chunk := range lost {
+		path := shardPath(bucket, key, chunkIndex, chunk.ShardIndex)
+		account, item, err := s.uploadWithFailover(ctx, candidates, path, shards[chunk.ShardIndex], selectOptions(bucket, policy))
+		if err != nil {
+			return repaired, uploadError(err)
+		}
+		candidates = excludeAccount(candidates, account.ID)
+
+		if err := s.objectRepo.UpdateChunkLocation(ctx, chunk.ID, account.ID, item.ID, path); err != nil {
+			return repaired, errors.InternalError(err.Error())
+		}
+		repaired++
+	}
+	return repaired, nil
+}
+
+// groupStripes returns one chunk per chunk index plus the shards of erasure-coded chunks keyed by index
+func groupStripes(chunks []*types.ObjectChunk) ([]*types.ObjectChunk, map[int][]*types.ObjectChunk) {
+	var primary []*types.ObjectChunk
+	stripes := make(map[int][]*types.ObjectChunk)
+	for _, chunk := range chunks {
+		if chunk.IsShard() {
+			if _, exists := stripes[chunk.ChunkIndex]; !exists {
+				primary = append(primary, chunk)
+			}
+			stripes[chunk.ChunkIndex] = append(stripes[chunk.ChunkIndex], chunk)
+			continue
+		}
+		primary = append(primary, chunk)
+	}
+	return primary, stripes
+}
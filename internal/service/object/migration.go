@@ -0,0 +1,305 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
+)
+
+// migrationBatchSize is the number of rows loaded at a time while draining an account
+const migrationBatchSize = 100
+
+// maxMigrationErrors caps the error messages kept in a migration result
+const maxMigrationErrors = 20
+
+// migrationItem is one stored copy to move off an account
+type migrationItem struct {
+	bucket     string
+	key        string
+	remoteID   string
+	remotePath string
+	checksum   string     // expected MD5 of the content, empty when unknown
+	sources    []location // copies the content can be read from, starting with the one being moved
+	exclude    []string   // accounts that already hold another copy of the same data
+	commit     func(accountID, remoteID, remotePath string) error
+}
+
+// MigrateAccount moves every object, chunk and replica stored on an account to other accounts allowed
+// by each bucket's placement policy. Every copy is verified against its checksum before it is switched
+// over, and the old copy is deleted afterwards. progress is called after each item with the number of
+// items processed and the total. Items that fail stay on the account and are reported in the result.
+func (s *Service) MigrateAccount(ctx context.Context, accountID string, progress func(done, total int64)) (*types.MigrationResult, error) {
+	if !s.useOneDrive || s.accountService == nil {
+		return nil, errors.InternalError("migration requires OneDrive storage")
+	}
+
+	total, err := s.accountService.CountReferences(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	result := &types.MigrationResult{AccountID: accountID, Total: total}
+
+	record := func(what string, err error) {
+		if err == nil {
+			result.Moved++
+		} else {
+			result.Failed++
+			log.Printf("Failed to migrate %s off account %s: %v", what, accountID, err)
+			if len(result.Errors) < maxMigrationErrors {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", what, err))
+			}
+		}
+		if progress != nil {
+			progress(result.Moved+result.Failed, total)
+		}
+	}
+
+	// Items that migrate leave the account, so only failed items need to be skipped on the next batch
+	for skip := 0; ; {
+		objects, err := s.objectRepo.ListObjectsByAccount(ctx, accountID, migrationBatchSize, skip)
+		if err != nil {
+			return result, errors.InternalError(err.Error())
+		}
+		if len(objects) == 0 {
+			break
+		}
+		for _, obj := range objects {
+			err := s.migrateItem(ctx, accountID, s.objectMigration(ctx, accountID, obj))
+			if err != nil {
+				skip++
+			}
+			record(replicaPath(obj.Bucket, obj.Key, types.WholeObjectIndex), err)
+		}
+	}
+
+	for skip := 0; ; {
+		chunks, err := s.objectRepo.ListChunksByAccount(ctx, accountID, migrationBatchSize, skip)
+		if err != nil {
+			return result, errors.InternalError(err.Error())
+		}
+		if len(chunks) == 0 {
+			break
+		}
+		for _, chunk := range chunks {
+			item, err := s.chunkMigration(ctx, accountID, chunk)
+			if err == nil {
+				err = s.migrateItem(ctx, accountID, item)
+			}
+			if err != nil {
+				skip++
+			}
+			record(chunk.RemotePath, err)
+		}
+	}
+
+	if s.replicaRepo != nil {
+		for skip := 0; ; {
+			replicas, err := s.replicaRepo.ListByAccount(ctx, accountID, migrationBatchSize, skip)
+			if err != nil {
+				return result, errors.InternalError(err.Error())
+			}
+			if len(replicas) == 0 {
+				break
+			}
+			for _, replica := range replicas {
+				item, err := s.replicaMigration(ctx, accountID, replica)
+				if err == nil {
+					err = s.migrateItem(ctx, accountID, item)
+				}
+				if err != nil {
+					skip++
+				}
+				record(replica.RemotePath, err)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// objectMigration describes moving the primary copy of a non-chunked object
+func (s *Service) objectMigration(ctx context.Context, accountID string, obj *types.Object) *migrationItem {
+	sources := s.objectLocations(ctx, obj)
+	return &migrationItem{
+		bucket:     obj.Bucket,
+		key:        obj.Key,
+		remoteID:   obj.RemoteID,
+		remotePath: obj.RemotePath,
+		checksum:   obj.ETag,
+		sources:    sources,
+		exclude:    locationAccounts(sources),
+		commit: func(newAccountID, remoteID, remotePath string) error {
+			return s.objectRepo.MoveObject(ctx, obj.Bucket, obj.Key, accountID, newAccountID, remoteID, remotePath)
+		},
+	}
+}
+
+// chunkMigration describes moving a chunk or erasure-coded shard
+func (s *Service) chunkMigration(ctx context.Context, accountID string, chunk *types.ObjectChunk) (*migrationItem, error) {
+	item := &migrationItem{
+		bucket:     chunk.Bucket,
+		key:        chunk.Key,
+		remoteID:   chunk.RemoteID,
+		remotePath: chunk.RemotePath,
+		checksum:   chunk.Checksum,
+		commit: func(newAccountID, remoteID, remotePath string) error {
+			return s.objectRepo.MoveChunk(ctx, chunk.ID, accountID, newAccountID, remoteID, remotePath)
+		},
+	}
+
+	if !chunk.IsShard() {
+		item.sources = s.chunkLocations(ctx, chunk)
+		item.exclude = locationAccounts(item.sources)
+		return item, nil
+	}
+
+	// A shard has no replicas, and the other shards of its stripe must stay on distinct accounts
+	chunks, err := s.objectRepo.GetChunks(ctx, chunk.Bucket, chunk.Key)
+	if err != nil {
+		return nil, err
+	}
+	item.sources = []location{{accountID: chunk.AccountID, remoteID: chunk.RemoteID, remotePath: chunk.RemotePath}}
+	for _, other := range chunks {
+		if other.ChunkIndex == chunk.ChunkIndex {
+			item.exclude = append(item.exclude, other.AccountID)
+		}
+	}
+	return item, nil
+}
+
+// replicaMigration describes moving a replica of an object or chunk
+func (s *Service) replicaMigration(ctx context.Context, accountID string, replica *types.ObjectReplica) (*migrationItem, error) {
+	item := &migrationItem{
+		bucket:     replica.Bucket,
+		key:        replica.Key,
+		remoteID:   replica.RemoteID,
+		remotePath: replica.RemotePath,
+		commit: func(newAccountID, remoteID, remotePath string) error {
+			return s.replicaRepo.Move(ctx, replica.ID, accountID, newAccountID, remoteID, remotePath)
+		},
+	}
+
+	var primary location
+	if replica.ChunkIndex == types.WholeObjectIndex {
+		obj, err := s.objectRepo.Get(ctx, replica.Bucket, replica.Key)
+		if err != nil {
+			return nil, err
+		}
+		item.checksum = obj.ETag
+		primary = location{accountID: obj.AccountID, remoteID: obj.RemoteID, remotePath: obj.RemotePath}
+	} else {
+		chunk, err := s.objectRepo.GetChunk(ctx, replica.Bucket, replica.Key, replica.ChunkIndex)
+		if err != nil {
+			return nil, err
+		}
+		item.checksum = chunk.Checksum
+		primary = location{accountID: chunk.AccountID, remoteID: chunk.RemoteID, remotePath: chunk.RemotePath}
+	}
+
+	// Read the replica being moved first, then the other copies
+	item.sources = []location{{accountID: replica.AccountID, remoteID: replica.RemoteID, remotePath: replica.RemotePath, replicaID: replica.ID}}
+	for _, loc := range s.locations(ctx, replica.Bucket, replica.Key, replica.ChunkIndex, primary) {
+		if loc.replicaID != replica.ID {
+			item.sources = append(item.sources, loc)
+		}
+	}
+	item.exclude = locationAccounts(item.sources)
+	return item, nil
+}
+
+// migrateItem moves one item to any other account allowed by its bucket's placement policy
+func (s *Service) migrateItem(ctx context.Context, fromAccountID string, item *migrationItem) error {
+	policy, err := s.placementPolicy(ctx, item.bucket)
+	if err != nil {
+		return err
+	}
+	candidates, err := s.migrationTargets(ctx, fromAccountID, item, policy)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no other account available")
+	}
+	return s.copyItem(ctx, fromAccountID, item, candidates, selectOptions(item.bucket, policy))
+}
+
+// migrationTargets returns the active accounts an item may be moved to
+func (s *Service) migrationTargets(ctx context.Context, fromAccountID string, item *migrationItem, policy *types.PlacementPolicy) ([]*types.StorageAccount, error) {
+	candidates, err := s.accountService.GetActiveAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if policy.IsRestricted() {
+		candidates, err = s.eligibleAccounts(ctx, item.bucket, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, accountID := range append(item.exclude, fromAccountID) {
+		candidates = excludeAccount(candidates, accountID)
+	}
+	return candidates, nil
+}
+
+// copyItem copies one item to one of the candidate accounts, verifies the copy and switches the item over to it
+func (s *Service) copyItem(ctx context.Context, fromAccountID string, item *migrationItem, candidates []*types.StorageAccount, opts loadbalancer.SelectOptions) error {
+	data, err := s.readWithFailover(ctx, item.sources)
+	if err != nil {
+		return fmt.Errorf("read failed: %w", err)
+	}
+	sum := md5.Sum(data)
+	if item.checksum != "" && fmt.Sprintf("%x", sum) != item.checksum {
+		return fmt.Errorf("checksum mismatch: stored %s, read %x", item.checksum, sum)
+	}
+
+	account, uploaded, err := s.uploadWithFailover(ctx, candidates, item.remotePath, data, opts)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	// Read the new copy back before switching over to it
+	copied, err := s.downloadFromAccount(ctx, account.ID, uploaded.ID)
+	if err == nil && !bytes.Equal(data, copied) {
+		err = fmt.Errorf("checksum mismatch on account %s", account.ID)
+	}
+	if err == nil {
+		err = item.commit(account.ID, uploaded.ID, item.remotePath)
+		if err == sql.ErrNoRows {
+			err = fmt.Errorf("item changed during migration")
+		}
+	}
+	if err != nil {
+		s.deleteRemote(ctx, account.ID, uploaded.ID)
+		return err
+	}
+
+	s.deleteRemote(ctx, fromAccountID, item.remoteID)
+	return nil
+}
+
+// deleteRemote deletes an item from an account, logging failures
+func (s *Service) deleteRemote(ctx context.Context, accountID, remoteID string) {
+	client, err := s.clientForAccount(ctx, accountID)
+	if err == nil {
+		err = client.DeleteFile(ctx, remoteID)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to delete %s from account %s: %v", remoteID, accountID, err)
+	}
+}
+
+// locationAccounts returns the accounts of a set of locations
+func locationAccounts(locations []location) []string {
+	accounts := make([]string, 0, len(locations))
+	for _, loc := range locations {
+		accounts = append(accounts, loc.accountID)
+	}
+	return accounts
+}
@@ -0,0 +1,155 @@
+package object
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
+	"github.com/xuecangming/onedrive-storage/internal/core/rebalance"
+)
+
+// RebalanceCandidates returns up to limit objects and up to limit chunks stored on an account,
+// with the accounts each of them may be moved to
+func (s *Service) RebalanceCandidates(ctx context.Context, accountID string, limit int) ([]rebalance.Candidate, error) {
+	active, err := s.accountService.GetActiveAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := make(map[string][]string)
+	allowedFor := func(bucket string) ([]string, error) {
+		if ids, cached := allowed[bucket]; cached {
+			return ids, nil
+		}
+		policy, err := s.placementPolicy(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		var ids []string
+		if policy.IsRestricted() {
+			ids = []string{}
+			for _, account := range active {
+				if policy.AllowsAccount(account) {
+					ids = append(ids, account.ID)
+				}
+			}
+		}
+		allowed[bucket] = ids
+		return ids, nil
+	}
+
+	var candidates []rebalance.Candidate
+
+	objects, err := s.objectRepo.ListObjectsByAccount(ctx, accountID, limit, 0)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	for _, obj := range objects {
+		ids, err := allowedFor(obj.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, rebalance.Candidate{
+			Move: types.RebalanceMove{
+				Bucket:        obj.Bucket,
+				Key:           obj.Key,
+				Size:          obj.Size,
+				FromAccountID: accountID,
+			},
+			Exclude: locationAccounts(s.objectLocations(ctx, obj)),
+			Allowed: ids,
+		})
+	}
+
+	chunks, err := s.objectRepo.ListChunksByAccount(ctx, accountID, limit, 0)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	for _, chunk := range chunks {
+		ids, err := allowedFor(chunk.Bucket)
+		if err != nil {
+			return nil, err
+		}
+		item, err := s.chunkMigration(ctx, accountID, chunk)
+		if err != nil {
+			return nil, errors.InternalError(err.Error())
+		}
+		index := chunk.ChunkIndex
+		candidates = append(candidates, rebalance.Candidate{
+			Move: types.RebalanceMove{
+				Bucket:        chunk.Bucket,
+				Key:           chunk.Key,
+				ChunkID:       chunk.ID,
+				ChunkIndex:    &index,
+				Size:          chunk.ChunkSize,
+				FromAccountID: accountID,
+			},
+			Exclude: item.exclude,
+			Allowed: ids,
+		})
+	}
+
+	return candidates, nil
+}
+
+// Relocate moves an object or chunk to the destination account of a planned move.
+// The move is refused when the data is no longer on the source account or the destination
+// already holds another copy of it.
+func (s *Service) Relocate(ctx context.Context, move types.RebalanceMove) error {
+	var item *migrationItem
+	if move.ChunkID == "" {
+		obj, err := s.objectRepo.Get(ctx, move.Bucket, move.Key)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return errors.ObjectNotFound(move.Bucket, move.Key)
+			}
+			return errors.InternalError(err.Error())
+		}
+		if obj.IsChunked || obj.AccountID != move.FromAccountID {
+			return errors.NewConflictError(fmt.Sprintf("%s/%s is no longer on account %s", move.Bucket, move.Key, move.FromAccountID))
+		}
+		item = s.objectMigration(ctx, move.FromAccountID, obj)
+	} else {
+		chunks, err := s.objectRepo.GetChunks(ctx, move.Bucket, move.Key)
+		if err != nil {
+			return errors.InternalError(err.Error())
+		}
+		var chunk *types.ObjectChunk
+		for _, c := range chunks {
+			if c.ID == move.ChunkID {
+				chunk = c
+			}
+		}
+		if chunk == nil || chunk.AccountID != move.FromAccountID {
+			return errors.NewConflictError(fmt.Sprintf("chunk %s is no longer on account %s", move.ChunkID, move.FromAccountID))
+		}
+		item, err = s.chunkMigration(ctx, move.FromAccountID, chunk)
+		if err != nil {
+			return errors.InternalError(err.Error())
+		}
+	}
+
+	policy, err := s.placementPolicy(ctx, move.Bucket)
+	if err != nil {
+		return err
+	}
+	candidates, err := s.migrationTargets(ctx, move.FromAccountID, item, policy)
+	if err != nil {
+		return err
+	}
+	var destination []*types.StorageAccount
+	for _, account := range candidates {
+		if account.ID == move.ToAccountID {
+			destination = append(destination, account)
+		}
+	}
+	if len(destination) == 0 {
+		return errors.NewConflictError(fmt.Sprintf("account %s cannot take %s/%s", move.ToAccountID, move.Bucket, move.Key))
+	}
+
+	// The destination is fixed, so no strategy is needed to choose it
+	return s.copyItem(ctx, move.FromAccountID, item, destination, loadbalancer.SelectOptions{Bucket: move.Bucket})
+}
@@ -0,0 +1,290 @@
+package object
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/loadbalancer"
+)
+
+// location is one stored copy of an object or chunk
+type location struct {
+	accountID  string
+	remoteID   string
+	remotePath string
+	replicaID  string // empty for the primary copy
+}
+
+// replicaPath returns the remote path used for an object or chunk
+func replicaPath(bucket, key string, chunkIndex int) string {
+	if chunkIndex == types.WholeObjectIndex {
+		return fmt.Sprintf("%s/%s", bucket, key)
+	}
+	return fmt.Sprintf("%s/%s_part%d", bucket, key, chunkIndex)
+}
+
+// writeReplicas uploads additional copies of data to accounts other than the excluded ones.
+// Writing fewer replicas than requested is not an error: the audit repairs under-replicated data.
+func (s *Service) writeReplicas(ctx context.Context, accounts []*types.StorageAccount, exclude []string, bucket, key string, chunkIndex int, data []byte, opts loadbalancer.SelectOptions, count int) []*types.ObjectReplica {
+	candidates := accounts
+	for _, accountID := range exclude {
+		candidates = excludeAccount(candidates, accountID)
+	}
+
+	path := replicaPath(bucket, key, chunkIndex)
+	var replicas []*types.ObjectReplica
+	for len(replicas) < count && len(candidates) > 0 {
+		account, item, err := s.uploadWithFailover(ctx, candidates, path, data, opts)
+		if err != nil {
+			log.Printf("Failed to write replica of %s: %v", path, err)
+			break
+		}
+
+		replicas = append(replicas, &types.ObjectReplica{
+			ID:         utils.GenerateID(),
+			Bucket:     bucket,
+			Key:        key,
+			ChunkIndex: chunkIndex,
+			AccountID:  account.ID,
+			RemoteID:   item.ID,
+			RemotePath: path,
+			Status:     "active",
+		})
+		candidates = excludeAccount(candidates, account.ID)
+	}
+
+	if len(replicas) < count {
+		log.Printf("Warning: %s is under-replicated, wrote %d of %d replicas", path, len(replicas), count)
+	}
+	return replicas
+}
+
+// saveReplicas stores replica locations
+func (s *Service) saveReplicas(ctx context.Context, replicas []*types.ObjectReplica) error {
+	for _, replica := range replicas {
+		if err := s.replicaRepo.Create(ctx, replica); err != nil {
+			return errors.InternalError(err.Error())
+		}
+	}
+	return nil
+}
+
+// locations returns the primary copy followed by the replicas of an object or chunk
+func (s *Service) locations(ctx context.Context, bucket, key string, chunkIndex int, primary location) []location {
+	locations := []location{primary}
+	if s.replicaRepo == nil {
+		return locations
+	}
+
+	replicas, err := s.replicaRepo.List(ctx, bucket, key, chunkIndex)
+	if err != nil {
+		log.Printf("Warning: failed to list replicas of %s: %v", replicaPath(bucket, key, chunkIndex), err)
+		return locations
+	}
+	for _, replica := range replicas {
+		locations = append(locations, location{
+			accountID:  replica.AccountID,
+			remoteID:   replica.RemoteID,
+			remotePath: replica.RemotePath,
+			replicaID:  replica.ID,
+		})
+	}
+	return locations
+}
+
+// objectLocations returns every stored copy of a non-chunked object
+func (s *Service) objectLocations(ctx context.Context, obj *types.Object) []location {
+	return s.locations(ctx, obj.Bucket, obj.Key, types.WholeObjectIndex, location{
+		accountID:  obj.AccountID,
+		remoteID:   obj.RemoteID,
+		remotePath: obj.RemotePath,
+	})
+}
+
+// chunkLocations returns every stored copy of a chunk
+func (s *Service) chunkLocations(ctx context.Context, chunk *types.ObjectChunk) []location {
+	return s.locations(ctx, chunk.Bucket, chunk.Key, chunk.ChunkIndex, location{
+		accountID:  chunk.AccountID,
+		remoteID:   chunk.RemoteID,
+		remotePath: chunk.RemotePath,
+	})
+}
+
+// readWithFailover downloads from the first location that succeeds.
+// Locations on accounts with a closed circuit breaker are tried first.
+func (s *Service) readWithFailover(ctx context.Context, locations []location) ([]byte, error) {
+	var healthy, unhealthy []location
+	for _, loc := range locations {
+		if s.balancer.IsHealthy(loc.accountID) {
+			healthy = append(healthy, loc)
+		} else {
+			unhealthy = append(unhealthy, loc)
+		}
+	}
+
+	var lastErr error
+	for _, loc := range append(healthy, unhealthy...) {
+		data, err := s.downloadFromAccount(ctx, loc.accountID, loc.remoteID)
+		if err == nil {
+			return data, nil
+		}
+		log.Printf("Read from account %s failed: %v", loc.accountID, err)
+		lastErr = err
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no replicas available")
+	}
+	return nil, lastErr
+}
+
+// checkLocation verifies that a copy still exists on its account
+func (s *Service) checkLocation(ctx context.Context, loc location) error {
+	client, err := s.clientForAccount(ctx, loc.accountID)
+	if err != nil {
+		return err
+	}
+	_, err = client.GetItem(ctx, loc.remoteID)
+	return err
+}
+
+// deleteReplicas removes all replicas of an object and its chunks.
+// Remote deletion is best effort, matching primary chunk cleanup.
+func (s *Service) deleteReplicas(ctx context.Context, bucket, key string) error {
+	if s.replicaRepo == nil {
+		return nil
+	}
+
+	replicas, err := s.replicaRepo.ListForObject(ctx, bucket, key)
+	if err != nil {
+		return errors.InternalError(err.Error())
+	}
+
+	for _, replica := range replicas {
+		client, err := s.clientForAccount(ctx, replica.AccountID)
+		if err != nil {
+			log.Printf("Warning: failed to get client for replica %s: %v", replica.ID, err)
+			continue
+		}
+		if err := client.DeleteFile(ctx, replica.RemoteID); err != nil {
+			log.Printf("Warning: failed to delete replica %s from OneDrive: %v", replica.RemoteID, err)
+		}
+	}
+
+	if err := s.replicaRepo.DeleteForObject(ctx, bucket, key); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// RepairReplicas restores the replication factor of an object (chunkIndex is types.WholeObjectIndex)
+// or chunk by re-copying from a surviving replica. Lost copies are dropped, and a lost primary is
+// replaced by a surviving replica. Erasure-coded chunks are rebuilt from their remaining shards.
+// It returns the number of copies written.
+func (s *Service) RepairReplicas(ctx context.Context, bucket, key string, chunkIndex int) (int, error) {
+	if s.replicaRepo == nil || !s.useOneDrive {
+		return 0, errors.InternalError("replication is not available")
+	}
+
+	policy, err := s.placementPolicy(ctx, bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	var primary location
+	var chunkID string
+	if chunkIndex == types.WholeObjectIndex {
+		obj, err := s.objectRepo.Get(ctx, bucket, key)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, errors.ObjectNotFound(bucket, key)
+			}
+			return 0, errors.InternalError(err.Error())
+		}
+		if obj.IsChunked {
+			return 0, errors.InvalidRequest("chunked objects are repaired per chunk")
+		}
+		primary = location{accountID: obj.AccountID, remoteID: obj.RemoteID, remotePath: obj.RemotePath}
+	} else {
+		chunk, err := s.objectRepo.GetChunk(ctx, bucket, key, chunkIndex)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, errors.ObjectNotFound(bucket, key)
+			}
+			return 0, errors.InternalError(err.Error())
+		}
+		if chunk.IsShard() {
+			return s.repairStripe(ctx, bucket, key, chunkIndex)
+		}
+		chunkID = chunk.ID
+		primary = location{accountID: chunk.AccountID, remoteID: chunk.RemoteID, remotePath: chunk.RemotePath}
+	}
+	if primary.accountID == localAccountID {
+		return 0, nil
+	}
+
+	// Find the copies that still exist
+	var alive []location
+	var used []string
+	for _, loc := range s.locations(ctx, bucket, key, chunkIndex, primary) {
+		used = append(used, loc.accountID)
+		if err := s.checkLocation(ctx, loc); err != nil {
+			log.Printf("Copy of %s on account %s is lost: %v", replicaPath(bucket, key, chunkIndex), loc.accountID, err)
+			if loc.replicaID != "" {
+				if err := s.replicaRepo.Delete(ctx, loc.replicaID); err != nil && err != sql.ErrNoRows {
+					return 0, errors.InternalError(err.Error())
+				}
+			}
+			continue
+		}
+		alive = append(alive, loc)
+	}
+	if len(alive) == 0 {
+		return 0, errors.InternalError(fmt.Sprintf("no surviving copy of %s", replicaPath(bucket, key, chunkIndex)))
+	}
+
+	// Promote a surviving replica when the primary copy is lost
+	if alive[0].replicaID != "" {
+		promoted := alive[0]
+		if chunkIndex == types.WholeObjectIndex {
+			err = s.objectRepo.UpdateLocation(ctx, bucket, key, promoted.accountID, promoted.remoteID, promoted.remotePath)
+		} else {
+			err = s.objectRepo.UpdateChunkLocation(ctx, chunkID, promoted.accountID, promoted.remoteID, promoted.remotePath)
+		}
+		if err != nil {
+			return 0, errors.InternalError(err.Error())
+		}
+		if err := s.replicaRepo.Delete(ctx, promoted.replicaID); err != nil && err != sql.ErrNoRows {
+			return 0, errors.InternalError(err.Error())
+		}
+	}
+
+	missing := policy.Copies() - len(alive)
+	if missing <= 0 {
+		return 0, nil
+	}
+
+	data, err := s.readWithFailover(ctx, alive)
+	if err != nil {
+		return 0, errors.UpstreamError(err.Error())
+	}
+
+	accounts, err := s.eligibleAccounts(ctx, bucket, policy)
+	if err != nil {
+		return 0, err
+	}
+	replicas := s.writeReplicas(ctx, accounts, used, bucket, key, chunkIndex, data, selectOptions(bucket, policy), missing)
+	if err := s.saveReplicas(ctx, replicas); err != nil {
+		return 0, err
+	}
+	if len(replicas) < missing {
+		return len(replicas), errors.ServiceUnavailable(fmt.Sprintf("wrote %d of %d missing copies of %s", len(replicas), missing, replicaPath(bucket, key, chunkIndex)))
+	}
+	return len(replicas), nil
+}
+
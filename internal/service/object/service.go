@@ -10,6 +10,7 @@ import (
 	"io"
 	"log"
 	"math"
+	"time"
 
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
@@ -23,12 +24,19 @@ import (
 
 const DefaultChunkSize = 10 * 1024 * 1024 // 10MB
 
+// maxUploadAttempts is the number of accounts tried before an upload fails
+const maxUploadAttempts = 3
+
+// localAccountID is the placeholder account of data kept on local disk
+const localAccountID = "00000000-0000-0000-0000-000000000000"
+
 // Service provides object storage operations
 type Service struct {
 	objectRepo     *repository.ObjectRepository
 	bucketRepo     *repository.BucketRepository
 	accountService *account.Service
 	balancer       *loadbalancer.Balancer
+	replicaRepo    *repository.ReplicaRepository
 	useOneDrive    bool                   // Flag to enable/disable OneDrive
 	localStorage   *storage.LocalStorage  // Local file storage
 }
@@ -51,7 +59,7 @@ func NewService(objectRepo *repository.ObjectRepository, bucketRepo *repository.
 }
 
 // NewServiceWithOneDrive creates a new object service with OneDrive integration
-func NewServiceWithOneDrive(objectRepo *repository.ObjectRepository, bucketRepo *repository.BucketRepository, accountService *account.Service) *Service {
+func NewServiceWithOneDrive(objectRepo *repository.ObjectRepository, bucketRepo *repository.BucketRepository, replicaRepo *repository.ReplicaRepository, accountService *account.Service, balancer *loadbalancer.Balancer) *Service {
 	// Initialize local storage as fallback
 	localStorage, _ := storage.NewLocalStorage("./data/storage")
 	
@@ -59,7 +67,8 @@ func NewServiceWithOneDrive(objectRepo *repository.ObjectRepository, bucketRepo
 		objectRepo:     objectRepo,
 		bucketRepo:     bucketRepo,
 		accountService: accountService,
-		balancer:       loadbalancer.NewBalancer(loadbalancer.StrategyLeastUsed),
+		balancer:       balancer,
+		replicaRepo:    replicaRepo,
 		useOneDrive:    true,
 		localStorage:   localStorage,
 	}
@@ -177,6 +186,9 @@ func (s *Service) CompleteMultipartUpload(ctx context.Context, bucket, key strin
 		return nil, errors.NewInvalidRequestError("no chunks found for upload")
 	}
 
+	// Erasure-coded parts are stored as several shard rows
+	parts, _ := groupStripes(chunks)
+
 	// Create object metadata
 	obj := &types.Object{
 		Bucket:     bucket,
@@ -184,7 +196,7 @@ func (s *Service) CompleteMultipartUpload(ctx context.Context, bucket, key strin
 		Size:       totalSize,
 		MimeType:   mimeType,
 		IsChunked:  true,
-		ChunkCount: len(chunks),
+		ChunkCount: len(parts),
 		Metadata:   make(map[string]string),
 		AccountID:  "00000000-0000-0000-0000-000000000000", // Distributed
 	}
@@ -226,7 +238,7 @@ func (s *Service) GetThumbnail(ctx context.Context, bucket, key string, size str
 	account, _ = s.accountService.Get(ctx, account.ID)
 
 	// Get thumbnail from OneDrive
-	client := onedrive.NewClient(account.AccessToken)
+	client := s.accountService.Client(account)
 	return client.GetThumbnail(ctx, obj.RemoteID, size)
 }
 
@@ -236,54 +248,44 @@ func (s *Service) uploadSingle(ctx context.Context, bucket, key string, data []b
 	etag := hex.EncodeToString(hash[:])
 
 	var accountID, remoteID, remotePath string
+	var replicas []*types.ObjectReplica
 	uploadedToOneDrive := false
 
-	// Try to upload to OneDrive if enabled
+	// Upload to OneDrive if enabled, failing over between accounts allowed by the bucket policy
 	if s.useOneDrive && s.accountService != nil {
-		// Get active accounts
-		accounts, err := s.accountService.GetActiveAccounts(ctx)
+		policy, err := s.placementPolicy(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		accounts, err := s.eligibleAccounts(ctx, bucket, policy)
 		if err != nil {
-			log.Printf("Failed to get active accounts: %v", err)
+			return nil, err
+		}
+		if policy.PinLocal {
+			log.Printf("Bucket %s is pinned to local storage", bucket)
 		} else if len(accounts) == 0 {
 			log.Printf("No active OneDrive accounts available, falling back to local storage")
 		} else {
-			// Select account using load balancer
-			account, err := s.balancer.SelectAccount(ctx, accounts, int64(len(data)))
+			path := fmt.Sprintf("%s/%s", bucket, key)
+			log.Printf("Uploading file to OneDrive: %s (size: %d bytes)", path, len(data))
+			account, item, err := s.uploadWithFailover(ctx, accounts, path, data, selectOptions(bucket, policy))
 			if err != nil {
-				log.Printf("Failed to select account: %v, falling back to local storage", err)
-			} else {
-				// Ensure token is valid
-				if err := s.accountService.EnsureTokenValid(ctx, account.ID); err != nil {
-					log.Printf("Failed to ensure token valid for account %s: %v", account.ID, err)
-				} else {
-					// Get fresh account with updated token
-					account, err = s.accountService.Get(ctx, account.ID)
-					if err != nil {
-						log.Printf("Failed to get fresh account %s: %v", account.ID, err)
-					} else {
-						// Create OneDrive client
-						client := onedrive.NewClient(account.AccessToken)
-
-						// Upload file to OneDrive
-						path := fmt.Sprintf("%s/%s", bucket, key)
-						log.Printf("Uploading file to OneDrive: %s (size: %d bytes)", path, len(data))
-						item, err := client.UploadSmallFile(ctx, path, data)
-						if err != nil {
-							log.Printf("Failed to upload to OneDrive: %v", err)
-						} else {
-							log.Printf("Successfully uploaded to OneDrive: %s (ID: %s)", path, item.ID)
-							accountID = account.ID
-							remoteID = item.ID
-							remotePath = path
-							uploadedToOneDrive = true
-						}
-					}
-				}
+				return nil, uploadError(err)
+			}
+			log.Printf("Successfully uploaded to OneDrive: %s (ID: %s, account: %s)", path, item.ID, account.ID)
+			accountID = account.ID
+			remoteID = item.ID
+			remotePath = path
+			uploadedToOneDrive = true
+
+			// Write additional copies to distinct accounts
+			if policy.Copies() > 1 && s.replicaRepo != nil {
+				replicas = s.writeReplicas(ctx, accounts, []string{account.ID}, bucket, key, types.WholeObjectIndex, data, selectOptions(bucket, policy), policy.Copies()-1)
 			}
 		}
 	}
 
-	// Fallback to local storage if OneDrive upload failed or not enabled
+	// Use local storage when OneDrive is not enabled, has no accounts or the bucket is pinned locally
 	if !uploadedToOneDrive {
 		if s.localStorage != nil {
 			// Store data in local file system
@@ -320,6 +322,9 @@ func (s *Service) uploadSingle(ctx context.Context, bucket, key string, data []b
 	if err := s.objectRepo.Create(ctx, obj); err != nil {
 		return nil, errors.InternalError(err.Error())
 	}
+	if err := s.saveReplicas(ctx, replicas); err != nil {
+		return nil, err
+	}
 
 	// Update bucket stats
 	s.objectRepo.UpdateBucketStats(ctx, bucket)
@@ -345,27 +350,9 @@ func (s *Service) AbortMultipartUpload(ctx context.Context, bucket, key string)
 		return err
 	}
 
-	// Delete chunks from OneDrive
+	// Delete chunks from storage
 	for _, chunk := range chunks {
-		// Get account
-		account, err := s.accountService.Get(ctx, chunk.AccountID)
-		if err != nil {
-			log.Printf("Warning: failed to get account %s for chunk deletion: %v", chunk.AccountID, err)
-			continue
-		}
-
-		// Ensure token is valid
-		if err := s.accountService.EnsureTokenValid(ctx, account.ID); err != nil {
-			log.Printf("Warning: failed to refresh token for account %s: %v", account.ID, err)
-			continue
-		}
-		account, _ = s.accountService.Get(ctx, account.ID)
-
-		// Delete from OneDrive
-		client := onedrive.NewClient(account.AccessToken)
-		if err := client.DeleteFile(ctx, chunk.RemoteID); err != nil {
-			log.Printf("Warning: failed to delete chunk %s from OneDrive: %v", chunk.RemoteID, err)
-		}
+		s.deleteChunkData(ctx, chunk)
 	}
 
 	// Delete chunks from DB
@@ -379,9 +366,39 @@ func (s *Service) AbortMultipartUpload(ctx context.Context, bucket, key string)
 	// We can't modify repo easily without reading it again.
 	// But wait, I can modify repo.
 	
+	if err := s.deleteReplicas(ctx, bucket, key); err != nil {
+		return err
+	}
 	return s.objectRepo.DeleteChunks(ctx, bucket, key)
 }
 
+// deleteChunkData deletes the stored data of a chunk from wherever it lives: local
+// storage for pinned buckets, otherwise the OneDrive account holding it. Failures are
+// logged and leave the data orphaned.
+func (s *Service) deleteChunkData(ctx context.Context, chunk *types.ObjectChunk) {
+	if chunk.AccountID == localAccountID {
+		if s.localStorage == nil {
+			return
+		}
+		if err := s.localStorage.Delete(chunk.Bucket, localChunkKey(chunk.Key, chunk.ChunkIndex)); err != nil {
+			log.Printf("Warning: failed to delete local chunk %d of %s/%s: %v", chunk.ChunkIndex, chunk.Bucket, chunk.Key, err)
+		}
+		return
+	}
+	if s.accountService == nil {
+		return
+	}
+
+	client, err := s.clientForAccount(ctx, chunk.AccountID)
+	if err != nil {
+		log.Printf("Warning: failed to get client for account %s for chunk deletion: %v", chunk.AccountID, err)
+		return
+	}
+	if err := client.DeleteFile(ctx, chunk.RemoteID); err != nil {
+		log.Printf("Warning: failed to delete chunk %s from OneDrive: %v", chunk.RemoteID, err)
+	}
+}
+
 func (s *Service) uploadChunked(ctx context.Context, bucket, key string, content io.Reader, size int64, mimeType string) (*types.Object, error) {
 	chunkCount := int(math.Ceil(float64(size) / float64(DefaultChunkSize)))
 
@@ -428,45 +445,230 @@ func (s *Service) uploadChunked(ctx context.Context, bucket, key string, content
 }
 
 func (s *Service) uploadOneChunk(ctx context.Context, bucket, key string, index int, data []byte) error {
-	// Get active accounts
-	accounts, err := s.accountService.GetActiveAccounts(ctx)
-	if err != nil || len(accounts) == 0 {
-		return errors.InternalError("no active accounts available for chunk upload")
+	policy, err := s.placementPolicy(ctx, bucket)
+	if err != nil {
+		return err
+	}
+
+	chunk := &types.ObjectChunk{
+		ID:         utils.GenerateID(),
+		Bucket:     bucket,
+		Key:        key,
+		ChunkIndex: index,
+		ChunkSize:  int64(len(data)),
+		Status:     "active",
 	}
 
-	// Select account using load balancer
-	account, err := s.balancer.SelectAccount(ctx, accounts, int64(len(data)))
+	if policy.PinLocal {
+		if s.localStorage == nil {
+			return errors.InternalError("no storage backend available")
+		}
+		filePath, err := s.localStorage.Store(bucket, localChunkKey(key, index), data)
+		if err != nil {
+			return errors.InternalError(fmt.Sprintf("failed to store chunk: %v", err))
+		}
+		chunk.AccountID = localAccountID
+		chunk.RemoteID = "local-storage"
+		chunk.RemotePath = filePath
+		return s.objectRepo.CreateChunk(ctx, chunk)
+	}
+
+	// Get active accounts allowed by the bucket policy
+	accounts, err := s.eligibleAccounts(ctx, bucket, policy)
 	if err != nil {
 		return err
 	}
+	if len(accounts) == 0 {
+		return errors.InternalError("no active accounts available for chunk upload")
+	}
 
-	// Ensure token is valid
-	if err := s.accountService.EnsureTokenValid(ctx, account.ID); err != nil {
-		return err
+	if policy.StorageClass == types.StorageClassErasure {
+		return s.uploadStripe(ctx, bucket, key, index, data, policy, accounts)
 	}
-	account, _ = s.accountService.Get(ctx, account.ID)
 
-	// Upload to OneDrive
-	client := onedrive.NewClient(account.AccessToken)
+	// Upload to OneDrive, failing over between accounts
 	remotePath := fmt.Sprintf("%s/%s_part%d", bucket, key, index)
-	item, err := client.UploadSmallFile(ctx, remotePath, data)
+	account, item, err := s.uploadWithFailover(ctx, accounts, remotePath, data, selectOptions(bucket, policy))
 	if err != nil {
-		return err
+		return uploadError(err)
+	}
+
+	// Write additional copies to distinct accounts
+	var replicas []*types.ObjectReplica
+	if policy.Copies() > 1 && s.replicaRepo != nil {
+		replicas = s.writeReplicas(ctx, accounts, []string{account.ID}, bucket, key, index, data, selectOptions(bucket, policy), policy.Copies()-1)
 	}
 
 	// Save chunk metadata
-	chunk := &types.ObjectChunk{
-		ID:         utils.GenerateID(),
-		Bucket:     bucket,
-		Key:        key,
-		ChunkIndex: index,
-		AccountID:  account.ID,
-		RemoteID:   item.ID,
-		RemotePath: remotePath,
-		ChunkSize:  int64(len(data)),
-		Status:     "active",
+	chunk.AccountID = account.ID
+	chunk.RemoteID = item.ID
+	chunk.RemotePath = remotePath
+	if err := s.objectRepo.CreateChunk(ctx, chunk); err != nil {
+		return err
+	}
+	return s.saveReplicas(ctx, replicas)
+}
+
+// placementPolicy returns the placement policy of a bucket
+func (s *Service) placementPolicy(ctx context.Context, bucket string) (*types.PlacementPolicy, error) {
+	b, err := s.bucketRepo.Get(ctx, bucket)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.BucketNotFound(bucket)
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	return &b.Policy, nil
+}
+
+// eligibleAccounts returns the active accounts the placement policy allows.
+// A restricted policy never falls back to other storage, so having no eligible accounts is an error.
+func (s *Service) eligibleAccounts(ctx context.Context, bucket string, policy *types.PlacementPolicy) ([]*types.StorageAccount, error) {
+	if policy.PinLocal {
+		return nil, nil
+	}
+
+	accounts, err := s.accountService.GetActiveAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.IsRestricted() {
+		return accounts, nil
+	}
+
+	var eligible []*types.StorageAccount
+	for _, account := range accounts {
+		if policy.AllowsAccount(account) {
+			eligible = append(eligible, account)
+		}
+	}
+	if len(eligible) == 0 {
+		return nil, errors.ServiceUnavailable(fmt.Sprintf("no active accounts allowed by the placement policy of bucket %s", bucket))
+	}
+	return eligible, nil
+}
+
+// selectOptions builds balancer options from a bucket's placement policy
+func selectOptions(bucket string, policy *types.PlacementPolicy) loadbalancer.SelectOptions {
+	return loadbalancer.SelectOptions{
+		Bucket:   bucket,
+		Strategy: loadbalancer.Strategy(policy.Strategy),
+	}
+}
+
+// localChunkKey returns the local storage key of a chunk
+func localChunkKey(key string, index int) string {
+	return fmt.Sprintf("%s_part%d", key, index)
+}
+
+// readChunk reads a chunk from local storage or its OneDrive account
+func (s *Service) readChunk(ctx context.Context, chunk *types.ObjectChunk) ([]byte, error) {
+	if chunk.AccountID == localAccountID {
+		if s.localStorage == nil {
+			return nil, errors.InternalError("no storage backend available")
+		}
+		return s.localStorage.Retrieve(chunk.Bucket, localChunkKey(chunk.Key, chunk.ChunkIndex))
+	}
+	return s.readWithFailover(ctx, s.chunkLocations(ctx, chunk))
+}
+
+// uploadWithFailover uploads data to the account chosen by the balancer.
+// A failed upload is retried on the next-best account, up to maxUploadAttempts accounts.
+func (s *Service) uploadWithFailover(ctx context.Context, accounts []*types.StorageAccount, path string, data []byte, opts loadbalancer.SelectOptions) (*types.StorageAccount, *onedrive.DriveItem, error) {
+	candidates := accounts
+	var lastErr error
+
+	for attempt := 0; attempt < maxUploadAttempts && len(candidates) > 0; attempt++ {
+		account, err := s.balancer.SelectAccountWithOptions(ctx, candidates, int64(len(data)), opts)
+		if err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			break
+		}
+
+		item, err := s.uploadToAccount(ctx, account.ID, path, data)
+		if err == nil {
+			return account, item, nil
+		}
+
+		log.Printf("Upload of %s to account %s failed: %v", path, account.ID, err)
+		lastErr = err
+		candidates = excludeAccount(candidates, account.ID)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no accounts available")
 	}
-	return s.objectRepo.CreateChunk(ctx, chunk)
+	return nil, nil, lastErr
+}
+
+// uploadToAccount uploads data to a single account and records the outcome in its circuit breaker
+func (s *Service) uploadToAccount(ctx context.Context, accountID, path string, data []byte) (*onedrive.DriveItem, error) {
+	client, err := s.clientForAccount(ctx, accountID)
+	if err != nil {
+		s.balancer.RecordResult(accountID, err, 0)
+		return nil, err
+	}
+
+	start := time.Now()
+	item, err := client.UploadSmallFile(ctx, path, data)
+	latency := time.Since(start)
+	s.balancer.RecordResult(accountID, err, latency)
+	if err == nil {
+		s.balancer.RecordUploadLatency(accountID, latency, int64(len(data)))
+	}
+	return item, err
+}
+
+// downloadFromAccount downloads an item from an account and records the outcome in its circuit breaker
+func (s *Service) downloadFromAccount(ctx context.Context, accountID, remoteID string) ([]byte, error) {
+	client, err := s.clientForAccount(ctx, accountID)
+	if err != nil {
+		s.balancer.RecordResult(accountID, err, 0)
+		return nil, err
+	}
+
+	start := time.Now()
+	data, err := client.DownloadFile(ctx, remoteID)
+	s.balancer.RecordResult(accountID, err, time.Since(start))
+	return data, err
+}
+
+// clientForAccount returns a OneDrive client for an account, refreshing its token if needed
+func (s *Service) clientForAccount(ctx context.Context, accountID string) (*onedrive.Client, error) {
+	if err := s.accountService.EnsureTokenValid(ctx, accountID); err != nil {
+		return nil, err
+	}
+
+	account, err := s.accountService.Get(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.accountService.Client(account), nil
+}
+
+// excludeAccount returns accounts without the given account ID
+func excludeAccount(accounts []*types.StorageAccount, accountID string) []*types.StorageAccount {
+	remaining := make([]*types.StorageAccount, 0, len(accounts))
+	for _, account := range accounts {
+		if account.ID != accountID {
+			remaining = append(remaining, account)
+		}
+	}
+	return remaining
+}
+
+// uploadError converts an upload failure into an application error
+func uploadError(err error) error {
+	if _, ok := err.(*errors.AppError); ok {
+		return err
+	}
+	if err == loadbalancer.ErrNoHealthyAccounts {
+		return errors.ServiceUnavailable("all storage accounts are temporarily unavailable")
+	}
+	return errors.UpstreamError(err.Error())
 }
 
 // ReadSeekCloser combines Reader, Seeker and Closer
@@ -498,10 +700,12 @@ func (s *Service) Download(ctx context.Context, bucket, key string) (*types.Obje
 		if err != nil {
 			return nil, nil, errors.InternalError(err.Error())
 		}
+		chunks, stripes := groupStripes(chunks)
 		return obj, &ChunkReader{
 			ctx:       ctx,
 			service:   s,
 			chunks:    chunks,
+			stripes:   stripes,
 			totalSize: obj.Size,
 		}, nil
 	}
@@ -516,28 +720,8 @@ func (s *Service) Download(ctx context.Context, bucket, key string) (*types.Obje
 func (s *Service) downloadSingle(ctx context.Context, obj *types.Object) (ReadSeekCloser, error) {
 	// Download from OneDrive if enabled and not using dummy account
 	if s.useOneDrive && s.accountService != nil && obj.AccountID != "00000000-0000-0000-0000-000000000000" {
-		// Get account
-		account, err := s.accountService.Get(ctx, obj.AccountID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Ensure token is valid
-		if err := s.accountService.EnsureTokenValid(ctx, account.ID); err != nil {
-			return nil, err
-		}
-
-		// Get fresh account with updated token
-		account, err = s.accountService.Get(ctx, account.ID)
-		if err != nil {
-			return nil, err
-		}
-
-		// Create OneDrive client
-		client := onedrive.NewClient(account.AccessToken)
-
-		// Download file from OneDrive
-		data, err := client.DownloadFile(ctx, obj.RemoteID)
+		// Download file from OneDrive, failing over to replicas
+		data, err := s.readWithFailover(ctx, s.objectLocations(ctx, obj))
 		if err != nil {
 			return nil, errors.UpstreamError(err.Error())
 		}
@@ -558,6 +742,7 @@ type ChunkReader struct {
 	ctx           context.Context
 	service       *Service
 	chunks        []*types.ObjectChunk
+	stripes       map[int][]*types.ObjectChunk // shards of erasure-coded chunks by chunk index
 	currentIdx    int
 	currentReader io.ReadSeeker
 	totalSize     int64
@@ -576,19 +761,12 @@ func (r *ChunkReader) Read(p []byte) (n int, err error) {
 		// Load next chunk
 		chunk := r.chunks[r.currentIdx]
 
-		// Get account
-		account, err := r.service.accountService.Get(r.ctx, chunk.AccountID)
-		if err != nil {
-			return 0, err
-		}
-		// Ensure token
-		if err := r.service.accountService.EnsureTokenValid(r.ctx, account.ID); err != nil {
-			return 0, err
+		var data []byte
+		if stripe, ok := r.stripes[chunk.ChunkIndex]; ok {
+			data, err = r.service.readStripe(r.ctx, stripe)
+		} else {
+			data, err = r.service.readChunk(r.ctx, chunk)
 		}
-		account, _ = r.service.accountService.Get(r.ctx, account.ID)
-
-		client := onedrive.NewClient(account.AccessToken)
-		data, err := client.DownloadFile(r.ctx, chunk.RemoteID)
 		if err != nil {
 			return 0, err
 		}
@@ -690,8 +868,17 @@ func (s *Service) Delete(ctx context.Context, bucket, key string) error {
 		return errors.InternalError(err.Error())
 	}
 
-	// Delete from OneDrive if enabled and not using dummy account
-	if s.useOneDrive && s.accountService != nil && obj.AccountID != "00000000-0000-0000-0000-000000000000" {
+	if obj.IsChunked {
+		// Each chunk lives on its own account, or on local storage for pinned buckets
+		chunks, err := s.objectRepo.GetChunks(ctx, bucket, key)
+		if err != nil {
+			return errors.InternalError(err.Error())
+		}
+		for _, chunk := range chunks {
+			s.deleteChunkData(ctx, chunk)
+		}
+	} else if s.useOneDrive && s.accountService != nil && obj.AccountID != "00000000-0000-0000-0000-000000000000" {
+		// Delete from OneDrive if enabled and not using dummy account
 		// Get account
 		account, err := s.accountService.Get(ctx, obj.AccountID)
 		if err != nil {
@@ -710,7 +897,7 @@ func (s *Service) Delete(ctx context.Context, bucket, key string) error {
 		}
 
 		// Create OneDrive client
-		client := onedrive.NewClient(account.AccessToken)
+		client := s.accountService.Client(account)
 
 		// Delete file from OneDrive
 		if err := client.DeleteFile(ctx, obj.RemoteID); err != nil {
@@ -723,6 +910,11 @@ func (s *Service) Delete(ctx context.Context, bucket, key string) error {
 		}
 	}
 
+	// Delete replicas on other accounts
+	if err := s.deleteReplicas(ctx, bucket, key); err != nil {
+		return err
+	}
+
 	// Delete from database
 	if err := s.objectRepo.Delete(ctx, bucket, key); err != nil {
 		if err == sql.ErrNoRows {
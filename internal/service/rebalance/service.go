@@ -0,0 +1,283 @@
+package rebalance
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/rebalance"
+	"github.com/xuecangming/onedrive-storage/internal/service/account"
+	"github.com/xuecangming/onedrive-storage/internal/service/task"
+)
+
+// maxRounds bounds how often the plan is recomputed in one run
+const maxRounds = 5
+
+// candidatesPerAccount is the number of objects and chunks considered per over-used account
+const candidatesPerAccount = 1000
+
+// Mover lists movable data on an account and moves it between accounts
+type Mover interface {
+	RebalanceCandidates(ctx context.Context, accountID string, limit int) ([]rebalance.Candidate, error)
+	Relocate(ctx context.Context, move types.RebalanceMove) error
+}
+
+// Service evens out usage across storage accounts
+type Service struct {
+	accountService *account.Service
+	taskService    *task.Service
+	mover          Mover
+	config         types.RebalanceConfig
+	runningTask    string
+	mu             sync.Mutex
+}
+
+// NewService creates a new rebalance service
+func NewService(accountService *account.Service, taskService *task.Service, mover Mover, config types.RebalanceConfig) *Service {
+	if config.Tolerance <= 0 {
+		config.Tolerance = rebalance.DefaultTolerance
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 20
+	}
+	return &Service{
+		accountService: accountService,
+		taskService:    taskService,
+		mover:          mover,
+		config:         config,
+	}
+}
+
+// Plan computes a rebalance plan without moving anything.
+// A tolerance of 0 uses the configured tolerance.
+func (s *Service) Plan(ctx context.Context, tolerance float64) (*types.RebalancePlan, error) {
+	tolerance, err := s.tolerance(tolerance)
+	if err != nil {
+		return nil, err
+	}
+	accounts, err := s.accountService.GetActiveAccounts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.plan(ctx, accounts, tolerance)
+}
+
+// Start starts a rebalance task. Only one rebalance runs at a time.
+func (s *Service) Start(ctx context.Context, tolerance float64) (*types.Task, error) {
+	tolerance, err := s.tolerance(tolerance)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.runningTask != "" {
+		return nil, errors.NewConflictError(fmt.Sprintf("rebalance task %s is already running", s.runningTask))
+	}
+
+	t, err := s.taskService.CreateTask(types.TaskTypeRebalance, map[string]interface{}{
+		"tolerance": tolerance,
+	})
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	s.runningTask = t.ID
+
+	go s.run(context.Background(), t.ID, tolerance)
+
+	return t, nil
+}
+
+// tolerance validates a requested tolerance, defaulting to the configured one
+func (s *Service) tolerance(tolerance float64) (float64, error) {
+	if tolerance == 0 {
+		return s.config.Tolerance, nil
+	}
+	if tolerance < 0 || tolerance >= 1 {
+		return 0, errors.InvalidRequest("tolerance must be between 0 and 1")
+	}
+	return tolerance, nil
+}
+
+// plan collects candidates from accounts above their target and plans moves for them
+func (s *Service) plan(ctx context.Context, accounts []*types.StorageAccount, tolerance float64) (*types.RebalancePlan, error) {
+	targets := rebalance.Targets(accounts)
+
+	var candidates []rebalance.Candidate
+	for _, account := range accounts {
+		target, ok := targets[account.ID]
+		if !ok || account.UsedSpace-target <= int64(tolerance*float64(account.TotalSpace)) {
+			continue
+		}
+		items, err := s.mover.RebalanceCandidates(ctx, account.ID, candidatesPerAccount)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, items...)
+	}
+
+	return rebalance.Plan(accounts, candidates, tolerance, s.config.MaxMoves), nil
+}
+
+// errCancelled stops a run whose task was cancelled
+var errCancelled = fmt.Errorf("rebalance cancelled")
+
+// runState is the progress of a rebalance run
+type runState struct {
+	byID         map[string]*types.StorageAccount
+	touched      map[string]bool
+	moved        int64
+	failed       int64
+	movedBytes   int64
+	plannedBytes int64
+	lastErr      string
+	balanced     bool
+}
+
+// result is the task result of a run so far
+func (st *runState) result() map[string]interface{} {
+	return map[string]interface{}{
+		"moved":       st.moved,
+		"failed":      st.failed,
+		"moved_bytes": st.movedBytes,
+		"balanced":    st.balanced,
+	}
+}
+
+// run executes rebalance rounds until the accounts are balanced, nothing more can be moved,
+// or the task is cancelled. Usage is tracked in memory between rounds, since OneDrive quotas
+// are only refreshed once the run is over.
+func (s *Service) run(ctx context.Context, taskID string, tolerance float64) {
+	defer func() {
+		s.mu.Lock()
+		s.runningTask = ""
+		s.mu.Unlock()
+	}()
+
+	accounts, err := s.accountService.GetActiveAccounts(ctx)
+	if err != nil {
+		s.taskService.FailTask(taskID, err.Error())
+		return
+	}
+	st := &runState{
+		byID:    make(map[string]*types.StorageAccount),
+		touched: make(map[string]bool),
+	}
+	for _, account := range accounts {
+		st.byID[account.ID] = account
+	}
+	throttle := rebalance.NewThrottle(s.config.MaxBytesPerSecond)
+
+	defer func() {
+		// Refresh quotas of the accounts that changed
+		for accountID := range st.touched {
+			if err := s.accountService.SyncSpaceInfo(ctx, accountID); err != nil {
+				log.Printf("Failed to sync space of account %s after rebalance: %v", accountID, err)
+			}
+		}
+	}()
+
+	for round := 0; round < maxRounds; round++ {
+		plan, err := s.plan(ctx, accounts, tolerance)
+		if err != nil {
+			s.taskService.FailTask(taskID, err.Error())
+			return
+		}
+		st.balanced = plan.Balanced
+		if len(plan.Moves) == 0 {
+			break
+		}
+		if round == 0 {
+			st.plannedBytes = plan.TotalBytes
+		}
+
+		progressBefore := st.moved
+		if err := s.executeMoves(ctx, taskID, plan.Moves, throttle, st); err != nil {
+			if err == errCancelled {
+				s.finishCancelled(taskID, st.result())
+			} else {
+				s.taskService.FailTask(taskID, err.Error())
+			}
+			return
+		}
+
+		if st.moved == progressBefore {
+			// Every planned move failed, replanning would try the same moves again
+			break
+		}
+	}
+
+	if s.taskService.IsCancelled(taskID) {
+		s.finishCancelled(taskID, st.result())
+		return
+	}
+	if st.moved == 0 && st.failed > 0 {
+		s.taskService.FailTask(taskID, fmt.Sprintf("%d moves failed, last error: %s", st.failed, st.lastErr))
+		return
+	}
+	s.taskService.CompleteTask(taskID, st.result())
+}
+
+// executeMoves carries out the moves of a round. Cancellation is checked before every
+// move, since each one copies a whole object between accounts; the throttle pauses after
+// every batch of moves for the bytes the batch moved. It returns errCancelled once the
+// task is cancelled.
+func (s *Service) executeMoves(ctx context.Context, taskID string, moves []types.RebalanceMove, throttle *rebalance.Throttle, st *runState) error {
+	var batchBytes int64
+	for i, move := range moves {
+		if s.taskService.IsCancelled(taskID) {
+			return errCancelled
+		}
+
+		if err := s.mover.Relocate(ctx, move); err != nil {
+			st.failed++
+			st.lastErr = err.Error()
+			log.Printf("Rebalance move of %s/%s to account %s failed: %v", move.Bucket, move.Key, move.ToAccountID, err)
+		} else {
+			st.moved++
+			st.movedBytes += move.Size
+			batchBytes += move.Size
+			st.touched[move.FromAccountID] = true
+			st.touched[move.ToAccountID] = true
+			if from, ok := st.byID[move.FromAccountID]; ok {
+				from.UsedSpace -= move.Size
+			}
+			if to, ok := st.byID[move.ToAccountID]; ok {
+				to.UsedSpace += move.Size
+			}
+
+			if st.plannedBytes > 0 {
+				progress := int(st.movedBytes * 99 / st.plannedBytes)
+				if progress > 99 {
+					progress = 99
+				}
+				if progress < 1 {
+					progress = 1
+				}
+				s.taskService.UpdateProgress(taskID, progress)
+			}
+		}
+
+		if (i+1)%s.config.BatchSize == 0 || i == len(moves)-1 {
+			if err := throttle.Wait(ctx, batchBytes); err != nil {
+				return err
+			}
+			batchBytes = 0
+		}
+	}
+	return nil
+}
+
+// finishCancelled records the result of a cancelled run, keeping the cancelled status
+func (s *Service) finishCancelled(taskID string, result map[string]interface{}) {
+	t, err := s.taskService.GetTask(taskID)
+	if err != nil {
+		return
+	}
+	t.Result = result
+	s.taskService.UpdateTask(t)
+}
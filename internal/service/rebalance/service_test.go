@@ -0,0 +1,57 @@
+package rebalance
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/rebalance"
+	"github.com/xuecangming/onedrive-storage/internal/repository"
+	"github.com/xuecangming/onedrive-storage/internal/service/task"
+)
+
+// cancellingMover cancels a task when it is asked for its cancelAfter-th move
+type cancellingMover struct {
+	tasks       *task.Service
+	taskID      string
+	cancelAfter int
+	moves       int
+}
+
+func (m *cancellingMover) RebalanceCandidates(ctx context.Context, accountID string, limit int) ([]rebalance.Candidate, error) {
+	return nil, nil
+}
+
+func (m *cancellingMover) Relocate(ctx context.Context, move types.RebalanceMove) error {
+	m.moves++
+	if m.moves == m.cancelAfter {
+		if _, err := m.tasks.CancelTask(m.taskID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestExecuteMoves_StopsWhenCancelledMidBatch(t *testing.T) {
+	tasks := task.NewService(repository.NewTaskRepository())
+	tk, err := tasks.CreateTask(types.TaskTypeRebalance, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mover := &cancellingMover{tasks: tasks, taskID: tk.ID, cancelAfter: 3}
+	s := NewService(nil, tasks, mover, types.RebalanceConfig{BatchSize: 20})
+
+	moves := make([]types.RebalanceMove, 10)
+	for i := range moves {
+		moves[i] = types.RebalanceMove{Bucket: "b", Key: "k", Size: 1, FromAccountID: "a", ToAccountID: "c"}
+	}
+	st := &runState{byID: map[string]*types.StorageAccount{}, touched: map[string]bool{}}
+
+	err = s.executeMoves(context.Background(), tk.ID, moves, rebalance.NewThrottle(0), st)
+	if err != errCancelled {
+		t.Fatalf("err = %v, want %v", err, errCancelled)
+	}
+	if mover.moves != 3 || st.moved != 3 {
+		t.Errorf("moves = %d, moved = %d after cancelling on the third move, want 3", mover.moves, st.moved)
+	}
+}
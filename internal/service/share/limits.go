@@ -0,0 +1,134 @@
+package share
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+const (
+	// maxPasswordFailures is how many wrong passwords a link accepts per window before
+	// refusing every attempt until the window ends
+	maxPasswordFailures = 10
+	// passwordFailureWindow is how long wrong passwords count against a link
+	passwordFailureWindow = 15 * time.Minute
+	// downloadSessionIdle is how long a partly served download can be continued
+	downloadSessionIdle = time.Hour
+)
+
+// passwordLimiter counts wrong passwords per link
+type passwordLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*failureWindow
+}
+
+// failureWindow is the wrong passwords given to a link since start
+type failureWindow struct {
+	count int
+	start time.Time
+}
+
+func newPasswordLimiter() *passwordLimiter {
+	return &passwordLimiter{failures: make(map[string]*failureWindow)}
+}
+
+// blocked reports whether a link has had too many wrong passwords lately
+func (l *passwordLimiter) blocked(linkID string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	window, exists := l.failures[linkID]
+	return exists && now.Sub(window.start) < passwordFailureWindow && window.count >= maxPasswordFailures
+}
+
+// fail counts a wrong password for a link
+func (l *passwordLimiter) fail(linkID string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, window := range l.failures {
+		if now.Sub(window.start) >= passwordFailureWindow {
+			delete(l.failures, id)
+		}
+	}
+	window, exists := l.failures[linkID]
+	if !exists {
+		window = &failureWindow{start: now}
+		l.failures[linkID] = window
+	}
+	window.count++
+}
+
+// DownloadSession is a download of a file through a link that was counted once and can
+// be continued, for example by a resumed or segmented download, until the file's size
+// has been served
+type DownloadSession struct {
+	key       string
+	remaining int64
+	lastUsed  time.Time
+}
+
+// downloadSessions holds the open download sessions of all links
+type downloadSessions struct {
+	mu       sync.Mutex
+	sessions map[string]*DownloadSession
+}
+
+func newDownloadSessions() *downloadSessions {
+	return &downloadSessions{sessions: make(map[string]*DownloadSession)}
+}
+
+// continuing returns the open session of a key, or nil
+func (d *downloadSessions) continuing(key string, now time.Time) *DownloadSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	session, exists := d.sessions[key]
+	if !exists || session.remaining <= 0 || now.Sub(session.lastUsed) >= downloadSessionIdle {
+		return nil
+	}
+	session.lastUsed = now
+	return session
+}
+
+// start opens a session for a key, replacing the previous one
+func (d *downloadSessions) start(key string, size int64, now time.Time) *DownloadSession {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for k, session := range d.sessions {
+		if session.remaining <= 0 || now.Sub(session.lastUsed) >= downloadSessionIdle {
+			delete(d.sessions, k)
+		}
+	}
+	session := &DownloadSession{key: key, remaining: size, lastUsed: now}
+	d.sessions[key] = session
+	return session
+}
+
+// served takes bytes sent to the client off a session
+func (d *downloadSessions) served(session *DownloadSession, n int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	session.remaining -= n
+	session.lastUsed = time.Now()
+}
+
+// BeginDownload counts a download of a file through a link, unless the same client is
+// continuing a download of the same version it was already counted for. Any request
+// that does not continue one is counted, whatever range it asks for.
+func (s *Service) BeginDownload(ctx context.Context, link *types.ShareLink, access *types.ShareAccess, file *types.VirtualFile) (*DownloadSession, error) {
+	key := link.ID + "|" + access.RemoteAddr + "|" + file.ID + "|" + strconv.Itoa(file.Version)
+	now := time.Now()
+	if session := s.downloads.continuing(key, now); session != nil {
+		return session, nil
+	}
+	if err := s.RecordDownload(ctx, link, access); err != nil {
+		return nil, err
+	}
+	return s.downloads.start(key, file.Size, now), nil
+}
+
+// EndDownload records how many bytes of a file a request served
+func (s *Service) EndDownload(session *DownloadSession, served int64) {
+	s.downloads.served(session, served)
+}
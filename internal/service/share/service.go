@@ -0,0 +1,314 @@
+package share
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/password"
+	"github.com/xuecangming/onedrive-storage/internal/repository"
+)
+
+// TokenPrefix starts every share link token
+const TokenPrefix = "shr_"
+
+// prefixLength is how many characters of a token are kept to recognize it
+const prefixLength = len(TokenPrefix) + 8
+
+// Service manages share links and checks their use
+type Service struct {
+	repo      *repository.ShareRepository
+	vfsRepo   *repository.VFSRepository
+	passwords *passwordLimiter
+	downloads *downloadSessions
+}
+
+// NewService creates a new share link service
+func NewService(repo *repository.ShareRepository, vfsRepo *repository.VFSRepository) *Service {
+	return &Service{
+		repo:      repo,
+		vfsRepo:   vfsRepo,
+		passwords: newPasswordLimiter(),
+		downloads: newDownloadSessions(),
+	}
+}
+
+// Create creates a share link for a file or directory. The returned link holds the only copy of its token.
+func (s *Service) Create(ctx context.Context, bucket string, req *types.CreateShareRequest, createdBy string) (*types.ShareLink, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = types.ShareModeRead
+	}
+	if mode != types.ShareModeRead && mode != types.ShareModeUpload {
+		return nil, errors.InvalidRequest("mode must be read or upload")
+	}
+	if req.MaxDownloads < 0 {
+		return nil, errors.InvalidRequest("max_downloads cannot be negative")
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return nil, errors.InvalidRequest("expires_at is in the past")
+	}
+
+	link := &types.ShareLink{
+		ID:           uuid.New().String(),
+		Bucket:       bucket,
+		Path:         cleanPath(req.Path),
+		Mode:         mode,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+		CreatedBy:    createdBy,
+	}
+	if err := s.resolveTarget(bucket, link); err != nil {
+		return nil, err
+	}
+	if link.Mode == types.ShareModeUpload && link.TargetType != "directory" {
+		return nil, errors.InvalidRequest("upload links can only share directories")
+	}
+
+	if req.Password != "" {
+		if err := password.Validate(req.Password); err != nil {
+			return nil, errors.InvalidRequest(err.Error())
+		}
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return nil, errors.InternalError(err.Error())
+		}
+		link.PasswordHash = hash
+		link.HasPassword = true
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	link.Token = token
+	link.TokenHash = hashToken(token)
+	link.Prefix = token[:prefixLength]
+
+	if err := s.repo.Create(ctx, link); err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	return link, nil
+}
+
+// resolveTarget finds the file or directory at the link's path
+func (s *Service) resolveTarget(bucket string, link *types.ShareLink) error {
+	link.TargetType = "directory"
+	if link.Path == "/" {
+		return nil
+	}
+
+	file, err := s.vfsRepo.GetFile(bucket, link.Path)
+	if err == nil {
+		link.TargetType = "file"
+		link.TargetID = file.ID
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return errors.InternalError(err.Error())
+	}
+
+	dir, err := s.vfsRepo.GetDirectory(bucket, link.Path)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewNotFoundError("nothing to share at " + link.Path)
+		}
+		return errors.InternalError(err.Error())
+	}
+	link.TargetID = dir.ID
+	return nil
+}
+
+// List lists the share links of a bucket
+func (s *Service) List(ctx context.Context, bucket string) ([]*types.ShareLink, error) {
+	links, err := s.repo.List(ctx, bucket)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	if links == nil {
+		links = []*types.ShareLink{}
+	}
+	return links, nil
+}
+
+// Get returns a share link of a bucket
+func (s *Service) Get(ctx context.Context, bucket, id string) (*types.ShareLink, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, shareNotFound()
+	}
+
+	link, err := s.repo.Get(ctx, id)
+	if err == sql.ErrNoRows || (err == nil && link.Bucket != bucket) {
+		return nil, shareNotFound()
+	}
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	return link, nil
+}
+
+// Delete revokes a share link
+func (s *Service) Delete(ctx context.Context, bucket, id string) error {
+	if _, err := s.Get(ctx, bucket, id); err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return shareNotFound()
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// Accesses lists the most recent uses of a share link
+func (s *Service) Accesses(ctx context.Context, bucket, id string, limit int) ([]*types.ShareAccess, error) {
+	if _, err := s.Get(ctx, bucket, id); err != nil {
+		return nil, err
+	}
+
+	accesses, err := s.repo.ListAccesses(ctx, id, limit)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	return accesses, nil
+}
+
+// Open returns the link behind a token after checking its expiry and password.
+// Refused attempts on existing links are recorded with the access details. After too
+// many wrong passwords a link refuses every password for a while.
+func (s *Service) Open(ctx context.Context, token, pw string, access *types.ShareAccess) (*types.ShareLink, error) {
+	link, err := s.repo.GetByHash(ctx, hashToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, shareNotFound()
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		s.deny(ctx, link, access)
+		return nil, errors.NewAppError("SHARE_EXPIRED", "share link has expired", 410)
+	}
+	if link.PasswordHash != "" {
+		if pw == "" {
+			s.deny(ctx, link, access)
+			return nil, errors.NewAppError("SHARE_PASSWORD_REQUIRED", "share link needs a password", 401)
+		}
+		now := time.Now()
+		if s.passwords.blocked(link.ID, now) {
+			s.deny(ctx, link, access)
+			return nil, errors.NewAppError("SHARE_PASSWORD_LOCKED", "too many wrong passwords, try again later", 429)
+		}
+		ok, err := password.Verify(pw, link.PasswordHash)
+		if err != nil {
+			return nil, errors.InternalError(err.Error())
+		}
+		if !ok {
+			s.passwords.fail(link.ID, now)
+			s.deny(ctx, link, access)
+			return nil, errors.NewAppError("SHARE_PASSWORD_INVALID", "wrong share link password", 401)
+		}
+	}
+
+	return link, nil
+}
+
+// Resolve maps a path relative to a shared directory to its bucket path.
+// File links always resolve to the file.
+func (s *Service) Resolve(link *types.ShareLink, rel string) string {
+	if link.TargetType == "file" {
+		return link.Path
+	}
+	return path.Join(link.Path, cleanPath(rel))
+}
+
+// Relative maps a bucket path below a shared item to the path seen through the link
+func (s *Service) Relative(link *types.ShareLink, p string) string {
+	if link.TargetType == "file" {
+		return "/" + path.Base(link.Path)
+	}
+	if link.Path == "/" {
+		return cleanPath(p)
+	}
+	return cleanPath(strings.TrimPrefix(p, link.Path))
+}
+
+// RecordView counts a view of a link
+func (s *Service) RecordView(ctx context.Context, link *types.ShareLink, access *types.ShareAccess) {
+	if err := s.repo.CountView(ctx, link.ID); err != nil {
+		log.Printf("Warning: failed to count view of share link %s: %v", link.ID, err)
+	}
+	s.record(ctx, link, types.ShareAccessView, access)
+}
+
+// RecordDownload counts a download, failing when the link has no downloads left
+func (s *Service) RecordDownload(ctx context.Context, link *types.ShareLink, access *types.ShareAccess) error {
+	if err := s.repo.CountDownload(ctx, link.ID); err != nil {
+		if err == sql.ErrNoRows {
+			s.deny(ctx, link, access)
+			return errors.NewAppError("SHARE_LIMIT_REACHED", "share link has no downloads left", 410)
+		}
+		return errors.InternalError(err.Error())
+	}
+	s.record(ctx, link, types.ShareAccessDownload, access)
+	return nil
+}
+
+// RecordUpload counts an upload through a link
+func (s *Service) RecordUpload(ctx context.Context, link *types.ShareLink, access *types.ShareAccess) {
+	if err := s.repo.CountUpload(ctx, link.ID); err != nil {
+		log.Printf("Warning: failed to count upload through share link %s: %v", link.ID, err)
+	}
+	s.record(ctx, link, types.ShareAccessUpload, access)
+}
+
+// deny records a refused use of a link
+func (s *Service) deny(ctx context.Context, link *types.ShareLink, access *types.ShareAccess) {
+	s.record(ctx, link, types.ShareAccessDenied, access)
+}
+
+// record writes an entry of a link's access log. Failures are logged, not returned,
+// so statistics never stand in the way of a download.
+func (s *Service) record(ctx context.Context, link *types.ShareLink, action string, access *types.ShareAccess) {
+	entry := *access
+	entry.ShareID = link.ID
+	entry.Action = action
+	if err := s.repo.RecordAccess(ctx, &entry); err != nil {
+		log.Printf("Warning: failed to record access to share link %s: %v", link.ID, err)
+	}
+}
+
+// generateToken returns a new random token
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the stored hash of a token. Tokens are random, so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// cleanPath normalizes a path the way the VFS does
+func cleanPath(p string) string {
+	return path.Clean("/" + p)
+}
+
+func shareNotFound() error {
+	return errors.NewAppError("SHARE_NOT_FOUND", "share link not found", 404)
+}
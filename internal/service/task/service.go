@@ -1,6 +1,7 @@
 package task
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
@@ -22,10 +23,16 @@ func NewService(repo *repository.TaskRepository) *Service {
 
 // CreateTask creates a new task
 func (s *Service) CreateTask(taskType types.TaskType, metadata map[string]interface{}) (*types.Task, error) {
+	return s.CreateUserTask(taskType, "", metadata)
+}
+
+// CreateUserTask creates a new task started by a user
+func (s *Service) CreateUserTask(taskType types.TaskType, userID string, metadata map[string]interface{}) (*types.Task, error) {
 	now := time.Now()
 	task := &types.Task{
 		ID:        utils.GenerateID(),
 		Type:      taskType,
+		UserID:    userID,
 		Status:    types.TaskStatusPending,
 		Progress:  0,
 		Metadata:  metadata,
@@ -78,6 +85,11 @@ func (s *Service) CompleteTask(id string, result map[string]interface{}) error {
 
 // FailTask marks a task as failed
 func (s *Service) FailTask(id string, errorMsg string) error {
+	return s.FailTaskWithResult(id, errorMsg, nil)
+}
+
+// FailTaskWithResult marks a task as failed, recording what it did before it failed
+func (s *Service) FailTaskWithResult(id string, errorMsg string, result map[string]interface{}) error {
 	task, err := s.repo.Get(id)
 	if err != nil {
 		return err
@@ -86,16 +98,63 @@ func (s *Service) FailTask(id string, errorMsg string) error {
 	now := time.Now()
 	task.Status = types.TaskStatusFailed
 	task.Error = errorMsg
+	task.Result = result
 	task.CompletedAt = &now
 	
 	return s.repo.Update(task)
 }
 
+// CancelTask requests cancellation of a pending or running task.
+// Workers check IsCancelled between steps and stop at the next one.
+func (s *Service) CancelTask(id string) (*types.Task, error) {
+	task, err := s.repo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.Status != types.TaskStatusPending && task.Status != types.TaskStatusRunning {
+		return nil, fmt.Errorf("task is already %s", task.Status)
+	}
+
+	now := time.Now()
+	task.Status = types.TaskStatusCancelled
+	task.CompletedAt = &now
+
+	if err := s.repo.Update(task); err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// IsCancelled reports whether cancellation of a task has been requested
+func (s *Service) IsCancelled(id string) bool {
+	task, err := s.repo.Get(id)
+	if err != nil {
+		return false
+	}
+	return task.Status == types.TaskStatusCancelled
+}
+
 // ListTasks lists all tasks
 func (s *Service) ListTasks() ([]*types.Task, error) {
 	return s.repo.List()
 }
 
+// ListUserTasks lists the tasks started by a user
+func (s *Service) ListUserTasks(userID string) ([]*types.Task, error) {
+	tasks, err := s.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	owned := []*types.Task{}
+	for _, task := range tasks {
+		if task.UserID == userID {
+			owned = append(owned, task)
+		}
+	}
+	return owned, nil
+}
+
 // GetTaskByMetadata finds a task by metadata
 func (s *Service) GetTaskByMetadata(key string, value interface{}) (*types.Task, error) {
 	return s.repo.FindByMetadata(key, value)
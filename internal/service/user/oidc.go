@@ -0,0 +1,130 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/oauthstate"
+)
+
+// OIDCEnabled reports whether users can sign in with OIDC
+func (s *Service) OIDCEnabled() bool {
+	return s.oidc != nil
+}
+
+// OIDCLoginURL returns the provider URL that starts an OIDC sign-in returning to redirectURI
+func (s *Service) OIDCLoginURL(ctx context.Context, redirectURI string) (string, error) {
+	if s.oidc == nil {
+		return "", errors.NewAppError("OIDC_DISABLED", "OIDC login is not enabled", 404)
+	}
+
+	state, entry, err := s.states.Issue("", redirectURI)
+	if err != nil {
+		return "", errors.InternalError(err.Error())
+	}
+	authURL, err := s.oidc.AuthorizationURL(ctx, redirectURI, state, oauthstate.CodeChallenge(entry.CodeVerifier), entry.Nonce)
+	if err != nil {
+		return "", errors.UpstreamError(err.Error())
+	}
+	return authURL, nil
+}
+
+// OIDCCallback finishes an OIDC sign-in and starts a session. Users are matched by the
+// provider's subject; unknown users are created when auto_create is on.
+func (s *Service) OIDCCallback(ctx context.Context, code, state, redirectURI string) (*types.Session, *types.User, error) {
+	if s.oidc == nil {
+		return nil, nil, errors.NewAppError("OIDC_DISABLED", "OIDC login is not enabled", 404)
+	}
+
+	// The state is consumed even when the sign-in fails, so it cannot be replayed
+	entry, err := s.states.Consume(state)
+	if err != nil {
+		return nil, nil, errors.InvalidRequest(err.Error())
+	}
+	if entry.RedirectURI != redirectURI {
+		return nil, nil, errors.InvalidRequest("redirect URI mismatch")
+	}
+
+	token, err := s.oidc.Exchange(ctx, code, redirectURI, entry.CodeVerifier)
+	if err != nil {
+		return nil, nil, errors.UpstreamError(err.Error())
+	}
+	// The user is whoever the signed ID token names; userinfo only adds profile claims
+	claims, err := s.oidc.VerifyIDToken(ctx, token.IDToken, entry.Nonce)
+	if err != nil {
+		return nil, nil, errors.Unauthorized(err.Error())
+	}
+	info, err := s.oidc.UserInfo(ctx, token.AccessToken)
+	if err != nil {
+		return nil, nil, errors.UpstreamError(err.Error())
+	}
+	if info.Subject != claims.Subject {
+		return nil, nil, errors.Unauthorized("userinfo subject does not match the ID token")
+	}
+
+	subject, _ := s.oidcSubject(claims.Subject)
+	user, err := s.repo.GetByOIDCSubject(ctx, subject)
+	if err == sql.ErrNoRows {
+		if !s.autoCreate {
+			return nil, nil, errors.Forbidden("no user is linked to this OIDC account")
+		}
+		user, err = s.createOIDCUser(ctx, subject, info.PreferredUsername, info.Email, info.Name)
+	}
+	if err != nil {
+		if _, ok := err.(*errors.AppError); ok {
+			return nil, nil, err
+		}
+		return nil, nil, errors.InternalError(err.Error())
+	}
+
+	session, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, user, nil
+}
+
+// oidcSubject returns the stored subject of an OIDC account, which is only unique per issuer
+func (s *Service) oidcSubject(sub string) (string, error) {
+	if s.oidc == nil {
+		return "", errors.InvalidRequest("OIDC login is not enabled")
+	}
+	return s.oidc.Issuer() + "|" + sub, nil
+}
+
+// createOIDCUser creates a user on their first OIDC sign-in, picking a free username
+func (s *Service) createOIDCUser(ctx context.Context, subject, preferred, email, name string) (*types.User, error) {
+	base := preferred
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	user := &types.User{
+		ID:          uuid.New().String(),
+		Email:       email,
+		DisplayName: name,
+		OIDCSubject: subject,
+	}
+	for i := 0; i < 100; i++ {
+		user.Username = base
+		if i > 0 {
+			user.Username = fmt.Sprintf("%s-%d", base, i+1)
+		}
+		err := s.create(ctx, user)
+		if err == nil {
+			return user, nil
+		}
+		if appErr, ok := err.(*errors.AppError); !ok || appErr.HTTPStatus != 409 {
+			return nil, err
+		}
+	}
+	return nil, errors.NewConflictError("no free username for " + base)
+}
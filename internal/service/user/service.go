@@ -0,0 +1,407 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/oauthstate"
+	"github.com/xuecangming/onedrive-storage/internal/core/password"
+	"github.com/xuecangming/onedrive-storage/internal/infrastructure/oidc"
+	"github.com/xuecangming/onedrive-storage/internal/repository"
+	"github.com/xuecangming/onedrive-storage/internal/service/apikey"
+)
+
+// SessionPrefix starts every session token, telling them apart from API keys
+const SessionPrefix = "sess_"
+
+// DefaultSessionTTL is how long a session lasts when the configuration does not say
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// Service manages users, their sessions and how requests are authenticated
+type Service struct {
+	repo       *repository.UserRepository
+	sessions   *repository.SessionRepository
+	keys       *apikey.Service
+	sessionTTL time.Duration
+
+	oidc       *oidc.Client // nil when OIDC login is disabled
+	states     *oauthstate.Store
+	autoCreate bool
+}
+
+// NewService creates a new user service. OIDC login is enabled when the configuration asks for it.
+func NewService(repo *repository.UserRepository, sessions *repository.SessionRepository, keys *apikey.Service, config types.AuthConfig, states *oauthstate.Store) *Service {
+	s := &Service{
+		repo:       repo,
+		sessions:   sessions,
+		keys:       keys,
+		sessionTTL: time.Duration(config.SessionTTL) * time.Hour,
+		states:     states,
+		autoCreate: config.OIDC.AutoCreate,
+	}
+	if s.sessionTTL <= 0 {
+		s.sessionTTL = DefaultSessionTTL
+	}
+	if config.OIDC.Enabled {
+		s.oidc = oidc.NewClient(oidc.Config{
+			Issuer:       config.OIDC.Issuer,
+			ClientID:     config.OIDC.ClientID,
+			ClientSecret: config.OIDC.ClientSecret,
+			Scopes:       config.OIDC.Scopes,
+		})
+	}
+	return s
+}
+
+// SessionTTL returns how long a new session lasts
+func (s *Service) SessionTTL() time.Duration {
+	return s.sessionTTL
+}
+
+// ==================== Users ====================
+
+// Create creates a user. Users without a password can only sign in with OIDC,
+// through the subject they are linked to or by being created on first sign-in.
+func (s *Service) Create(ctx context.Context, req *types.UserRequest) (*types.User, error) {
+	username := strings.TrimSpace(req.Username)
+	if username == "" {
+		return nil, errors.InvalidRequest("username is required")
+	}
+
+	user := &types.User{
+		ID:          uuid.New().String(),
+		Username:    username,
+		Email:       req.Email,
+		DisplayName: req.DisplayName,
+		IsAdmin:     req.IsAdmin != nil && *req.IsAdmin,
+	}
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+	if req.OIDCSubject != "" {
+		subject, err := s.oidcSubject(req.OIDCSubject)
+		if err != nil {
+			return nil, err
+		}
+		user.OIDCSubject = subject
+	}
+
+	if err := s.create(ctx, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// create stores a new user, rejecting taken usernames
+func (s *Service) create(ctx context.Context, user *types.User) error {
+	if _, err := s.repo.GetByUsername(ctx, user.Username); err == nil {
+		return errors.NewConflictError("username already exists: " + user.Username)
+	} else if err != sql.ErrNoRows {
+		return errors.InternalError(err.Error())
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// Get retrieves a user by ID
+func (s *Service) Get(ctx context.Context, id string) (*types.User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, userNotFound()
+	}
+	user, err := s.repo.Get(ctx, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, userNotFound()
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	return user, nil
+}
+
+// List retrieves all users
+func (s *Service) List(ctx context.Context) ([]*types.User, error) {
+	users, err := s.repo.List(ctx)
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+	if users == nil {
+		users = []*types.User{}
+	}
+	return users, nil
+}
+
+// Update updates a user. Fields left empty keep their value; setting a password signs the user out everywhere.
+func (s *Service) Update(ctx context.Context, id string, req *types.UserRequest) (*types.User, error) {
+	user, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if username := strings.TrimSpace(req.Username); username != "" && username != user.Username {
+		if _, err := s.repo.GetByUsername(ctx, username); err == nil {
+			return nil, errors.NewConflictError("username already exists: " + username)
+		} else if err != sql.ErrNoRows {
+			return nil, errors.InternalError(err.Error())
+		}
+		user.Username = username
+	}
+	if req.Email != "" {
+		user.Email = req.Email
+	}
+	if req.DisplayName != "" {
+		user.DisplayName = req.DisplayName
+	}
+	if req.IsAdmin != nil {
+		user.IsAdmin = *req.IsAdmin
+	}
+	if req.OIDCSubject != "" {
+		if user.OIDCSubject, err = s.oidcSubject(req.OIDCSubject); err != nil {
+			return nil, err
+		}
+	}
+	if req.Password != "" {
+		hash, err := hashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, userNotFound()
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	if req.Password != "" {
+		s.revokeSessions(ctx, user.ID)
+	}
+	return user, nil
+}
+
+// Delete deletes a user with their sessions and API keys. Buckets and files they owned are kept without an owner.
+func (s *Service) Delete(ctx context.Context, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return userNotFound()
+	}
+	if err := s.repo.Delete(ctx, id); err != nil {
+		if err == sql.ErrNoRows {
+			return userNotFound()
+		}
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// ChangePassword changes a user's own password after checking the current one, and signs them out everywhere
+func (s *Service) ChangePassword(ctx context.Context, id, current, next string) error {
+	user, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if user.PasswordHash != "" {
+		ok, err := password.Verify(current, user.PasswordHash)
+		if err != nil {
+			return errors.InternalError(err.Error())
+		}
+		if !ok {
+			return errors.Forbidden("current password is incorrect")
+		}
+	}
+
+	hash, err := hashPassword(next)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = hash
+	if err := s.repo.Update(ctx, user); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	s.revokeSessions(ctx, user.ID)
+	return nil
+}
+
+// ==================== Sessions ====================
+
+// Login checks a username and password and starts a session
+func (s *Service) Login(ctx context.Context, username, pw string) (*types.Session, *types.User, error) {
+	user, err := s.repo.GetByUsername(ctx, username)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, errors.InternalError(err.Error())
+	}
+	if user == nil || user.PasswordHash == "" {
+		return nil, nil, errors.Unauthorized("invalid username or password")
+	}
+
+	ok, err := password.Verify(pw, user.PasswordHash)
+	if err != nil {
+		return nil, nil, errors.InternalError(err.Error())
+	}
+	if !ok {
+		return nil, nil, errors.Unauthorized("invalid username or password")
+	}
+
+	session, err := s.startSession(ctx, user)
+	if err != nil {
+		return nil, nil, err
+	}
+	return session, user, nil
+}
+
+// Logout ends the session of a token
+func (s *Service) Logout(ctx context.Context, token string) error {
+	if !strings.HasPrefix(token, SessionPrefix) {
+		return nil
+	}
+	if err := s.sessions.Delete(ctx, hashToken(token)); err != nil {
+		return errors.InternalError(err.Error())
+	}
+	return nil
+}
+
+// startSession starts a session for a user and records the sign-in
+func (s *Service) startSession(ctx context.Context, user *types.User) (*types.Session, error) {
+	token, err := generateToken()
+	if err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	session := &types.Session{
+		Token:     token,
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(s.sessionTTL),
+	}
+	if err := s.sessions.Create(ctx, session); err != nil {
+		return nil, errors.InternalError(err.Error())
+	}
+
+	now := time.Now()
+	if err := s.repo.TouchLastLogin(ctx, user.ID, now); err != nil {
+		log.Printf("Warning: failed to record login of user %s: %v", user.ID, err)
+	}
+	user.LastLoginAt = &now
+
+	// Sign-ins are rare enough to clean up after
+	if n, err := s.sessions.DeleteExpired(ctx); err != nil {
+		log.Printf("Warning: failed to remove expired sessions: %v", err)
+	} else if n > 0 {
+		log.Printf("Removed %d expired sessions", n)
+	}
+
+	return session, nil
+}
+
+// revokeSessions ends all sessions of a user, logging failures
+func (s *Service) revokeSessions(ctx context.Context, userID string) {
+	if err := s.sessions.DeleteByUser(ctx, userID); err != nil {
+		log.Printf("Warning: failed to revoke sessions of user %s: %v", userID, err)
+	}
+}
+
+// ==================== Authentication ====================
+
+// Authenticate returns the caller behind a session token or API key.
+// Signed-in admins get the admin scope and other users the write scope, limited to what they own.
+func (s *Service) Authenticate(ctx context.Context, token string) (*types.Principal, error) {
+	if !strings.HasPrefix(token, SessionPrefix) {
+		principal, err := s.keys.Authenticate(ctx, token)
+		if err != nil || principal.UserID == "" {
+			return principal, err
+		}
+		// Keys of users who are no longer admins lose the admin scope
+		user, err := s.repo.Get(ctx, principal.UserID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.Unauthorized("invalid API key")
+			}
+			return nil, errors.InternalError(err.Error())
+		}
+		if !user.IsAdmin {
+			principal.Scopes = capScopes(principal.Scopes)
+		}
+		return principal, nil
+	}
+
+	session, err := s.sessions.GetByHash(ctx, hashToken(token))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.Unauthorized("session expired or invalid")
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+	user, err := s.repo.Get(ctx, session.UserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.Unauthorized("session expired or invalid")
+		}
+		return nil, errors.InternalError(err.Error())
+	}
+
+	scope := types.ScopeWrite
+	if user.IsAdmin {
+		scope = types.ScopeAdmin
+	}
+	return &types.Principal{UserID: user.ID, Scopes: []string{scope}}, nil
+}
+
+// capScopes replaces the admin scope with the write scope
+func capScopes(scopes []string) []string {
+	capped := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope == types.ScopeAdmin {
+			scope = types.ScopeWrite
+		}
+		capped = append(capped, scope)
+	}
+	return capped
+}
+
+// hashPassword validates and hashes a new password
+func hashPassword(pw string) (string, error) {
+	if err := password.Validate(pw); err != nil {
+		return "", errors.InvalidRequest(err.Error())
+	}
+	hash, err := password.Hash(pw)
+	if err != nil {
+		return "", errors.InternalError(err.Error())
+	}
+	return hash, nil
+}
+
+// generateToken returns a new random session token
+func generateToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return SessionPrefix + base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// hashToken returns the stored hash of a session token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// userNotFound returns the error for an unknown user
+func userNotFound() error {
+	return errors.NewAppError("USER_NOT_FOUND", "user not found", 404)
+}
@@ -0,0 +1,82 @@
+package vfs
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// LargestDirectories lists up to limit directories below a path that use the most space,
+// largest first, with the totals of their subtrees
+func (s *Service) LargestDirectories(bucket, path string, limit int) ([]types.VFSItem, error) {
+	if limit <= 0 {
+		return nil, errors.NewInvalidRequestError("limit must be positive")
+	}
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	path = normalizePath(path)
+	prefix := "/"
+	if path != "/" {
+		if _, err := s.vfsRepo.GetDirectory(bucket, path); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.NewNotFoundError(fmt.Sprintf("directory not found: %s", path))
+			}
+			return nil, err
+		}
+		prefix = path + "/"
+	}
+
+	dirs, err := s.vfsRepo.ListLargestDirectories(bucket, prefix, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]types.VFSItem, 0, len(dirs))
+	for _, dir := range dirs {
+		items = append(items, types.VFSItem{
+			ID:        dir.ID,
+			Name:      dir.Name,
+			Path:      dir.FullPath,
+			Type:      "directory",
+			Size:      dir.TotalSize,
+			FileCount: dir.FileCount,
+			DirCount:  dir.DirCount,
+			CreatedAt: dir.CreatedAt,
+		})
+	}
+	return items, nil
+}
+
+// RepairAggregatesAsync recomputes the directory aggregates of a bucket in a task started
+// by userID. The database keeps them up to date; a repair fixes totals that drifted, for
+// example after rows were changed by hand.
+func (s *Service) RepairAggregatesAsync(bucket, userID string) (*types.Task, error) {
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	task, err := s.taskSvc.CreateUserTask(types.TaskTypeRepair, userID, map[string]interface{}{
+		"bucket":    bucket,
+		"operation": "repair_directory_aggregates",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		fixed, err := s.vfsRepo.RepairAggregates(bucket)
+		if err != nil {
+			s.taskSvc.FailTask(task.ID, err.Error())
+			return
+		}
+		s.taskSvc.CompleteTask(task.ID, map[string]interface{}{
+			"fixed": fixed,
+		})
+	}()
+
+	return task, nil
+}
@@ -0,0 +1,195 @@
+package vfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/archive"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+)
+
+const (
+	// maxArchiveSelection is the most items one archive download may select
+	maxArchiveSelection = 1000
+	// archiveBatch is the number of files an archive reads from the database at a time
+	archiveBatch = 100
+)
+
+// Archive is a set of files and directories to stream as one archive. It is resolved
+// before anything is written, so a missing item is reported while the response can
+// still carry an error.
+type Archive struct {
+	s      *Service
+	bucket string
+	roots  []archiveRoot
+}
+
+// archiveRoot is a selected item and the name it has in the archive. A directory with
+// an empty name has its contents at the top of the archive.
+type archiveRoot struct {
+	name string
+	file *types.VirtualFile
+	dir  *types.VirtualDirectory
+	path string
+}
+
+// DirectoryArchive returns an archive of everything below a directory
+func (s *Service) DirectoryArchive(bucket, path string) (*Archive, error) {
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+	path = normalizePath(path)
+	root := archiveRoot{path: path}
+	if path != "/" {
+		dir, err := s.vfsRepo.GetDirectory(bucket, path)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.NewNotFoundError(fmt.Sprintf("directory not found: %s", path))
+			}
+			return nil, err
+		}
+		root.dir = dir
+	}
+	return &Archive{s: s, bucket: bucket, roots: []archiveRoot{root}}, nil
+}
+
+// SelectionArchive returns an archive of selected files and directories, each at the top
+// of the archive under its own name. Items with the same name are renamed like
+// "report (1).pdf"; selecting the root puts its contents at the top.
+func (s *Service) SelectionArchive(bucket string, paths []string) (*Archive, error) {
+	if len(paths) == 0 {
+		return nil, errors.NewInvalidRequestError("paths is required")
+	}
+	if len(paths) > maxArchiveSelection {
+		return nil, errors.NewInvalidRequestError(fmt.Sprintf("an archive may select at most %d items", maxArchiveSelection))
+	}
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	a := &Archive{s: s, bucket: bucket}
+	taken := make(map[string]bool)
+	for _, p := range paths {
+		p = normalizePath(p)
+		if p == "/" {
+			a.roots = append(a.roots, archiveRoot{path: p})
+			continue
+		}
+
+		root := archiveRoot{path: p}
+		file, err := s.vfsRepo.GetFile(bucket, p)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if file != nil {
+			root.file = file
+		} else {
+			dir, err := s.vfsRepo.GetDirectory(bucket, p)
+			if err != nil {
+				if err == sql.ErrNoRows {
+					return nil, errors.NewNotFoundError(fmt.Sprintf("path not found: %s", p))
+				}
+				return nil, err
+			}
+			root.dir = dir
+		}
+
+		_, name := splitPath(p)
+		root.name = name
+		for n := 1; taken[root.name]; n++ {
+			root.name = conflict.Candidate(name, n, root.dir != nil)
+		}
+		taken[root.name] = true
+		a.roots = append(a.roots, root)
+	}
+	return a, nil
+}
+
+// Write streams the archive to w, keeping the entries the filter selects. Content is read
+// object by object and file listings page by page, so nothing is held in full.
+func (a *Archive) Write(w archive.Writer, filter *archive.Filter) error {
+	for _, root := range a.roots {
+		var err error
+		if root.file != nil {
+			if filter.File(root.name) {
+				err = a.writeFile(w, root.name, root.file)
+			}
+		} else {
+			err = a.writeDirectory(w, root, filter)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeDirectory writes the entries of a directory and everything below it
+func (a *Archive) writeDirectory(w archive.Writer, root archiveRoot, filter *archive.Filter) error {
+	if root.name != "" && filter.Dir(root.name) {
+		if err := w.Dir(root.name, root.dir.CreatedAt); err != nil {
+			return err
+		}
+	}
+	prefix := strings.TrimSuffix(root.path, "/") + "/"
+
+	// Directories get their own entries so empty ones survive
+	dirs, err := a.s.vfsRepo.ListDirectoriesByPath(a.bucket, prefix)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		rel := strings.TrimPrefix(dir.FullPath, prefix)
+		if rel == "" {
+			continue
+		}
+		name := archiveName(root.name, rel)
+		if filter.Dir(name) {
+			if err := w.Dir(name, dir.CreatedAt); err != nil {
+				return err
+			}
+		}
+	}
+
+	after := ""
+	for {
+		files, err := a.s.vfsRepo.ListFilesAfter(a.bucket, prefix, after, archiveBatch)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			name := archiveName(root.name, strings.TrimPrefix(file.FullPath, prefix))
+			if filter.File(name) {
+				if err := a.writeFile(w, name, file); err != nil {
+					return err
+				}
+			}
+			after = file.FullPath
+		}
+		if len(files) < archiveBatch {
+			return nil
+		}
+	}
+}
+
+// writeFile writes a file entry with the content of its object
+func (a *Archive) writeFile(w archive.Writer, name string, file *types.VirtualFile) error {
+	_, reader, err := a.s.objectSvc.Download(context.Background(), a.bucket, file.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	return w.File(name, file.Size, file.UpdatedAt, reader)
+}
+
+// archiveName returns the name of an entry below a selected directory
+func archiveName(root, rel string) string {
+	if root == "" {
+		return rel
+	}
+	return root + "/" + rel
+}
@@ -0,0 +1,223 @@
+package vfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+)
+
+// maxBatchOperations is the largest number of operations a batch may have
+const maxBatchOperations = 1000
+
+// Statuses of batch operations
+const (
+	batchSucceeded = "succeeded"
+	batchFailed    = "failed"
+	batchSkipped   = "skipped"
+)
+
+// BatchAuthorizer checks that the caller of a batch may run one of its operations.
+// An error fails the operation.
+type BatchAuthorizer func(op types.BatchOperation) error
+
+// StartBatch checks a batch and runs its operations one after another in a task started
+// by userID. Operations that fail are reported in the task's result; the others still run
+// unless the batch stops on errors.
+func (s *Service) StartBatch(bucket string, req types.BatchRequest, userID string, authorize BatchAuthorizer) (*types.Task, error) {
+	if err := validateBatch(req); err != nil {
+		return nil, err
+	}
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	task, err := s.taskSvc.CreateUserTask(types.TaskTypeBatch, userID, map[string]interface{}{
+		"bucket":        bucket,
+		"operations":    len(req.Operations),
+		"stop_on_error": req.StopOnError,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	go s.runBatch(task.ID, bucket, req, userID, authorize)
+
+	return task, nil
+}
+
+// validateBatch checks that every operation of a batch names what it needs, so a batch
+// that could never run is rejected before its task starts
+func validateBatch(req types.BatchRequest) error {
+	if len(req.Operations) == 0 {
+		return errors.NewInvalidRequestError("operations are required")
+	}
+	if len(req.Operations) > maxBatchOperations {
+		return errors.NewInvalidRequestError(fmt.Sprintf("a batch can have at most %d operations", maxBatchOperations))
+	}
+	if !conflict.Valid(req.Conflict) {
+		return errors.NewInvalidRequestError(fmt.Sprintf("unknown conflict policy %q", req.Conflict))
+	}
+
+	for i, op := range req.Operations {
+		switch op.Op {
+		case "move", "copy":
+			if op.Source == "" || op.Destination == "" {
+				return errors.NewInvalidRequestError(fmt.Sprintf("operation %d: source and destination are required", i))
+			}
+			if !conflict.Valid(op.Conflict) {
+				return errors.NewInvalidRequestError(fmt.Sprintf("operation %d: unknown conflict policy %q", i, op.Conflict))
+			}
+		case "delete", "star", "unstar":
+			if op.Path == "" {
+				return errors.NewInvalidRequestError(fmt.Sprintf("operation %d: path is required", i))
+			}
+		default:
+			return errors.NewInvalidRequestError(fmt.Sprintf("operation %d: unknown op %q", i, op.Op))
+		}
+	}
+	return nil
+}
+
+// runBatch runs the operations of a batch and records their results on its task
+func (s *Service) runBatch(taskID, bucket string, req types.BatchRequest, userID string, authorize BatchAuthorizer) {
+	results := make([]types.BatchResult, len(req.Operations))
+	var succeeded, failed, skipped int
+	stop, cancelled := false, false
+
+	for i, op := range req.Operations {
+		results[i] = types.BatchResult{Index: i, Op: op.Op, Status: batchSkipped}
+		if !stop && s.taskSvc.IsCancelled(taskID) {
+			stop, cancelled = true, true
+		}
+		if stop {
+			skipped++
+			continue
+		}
+
+		if op.Conflict == "" {
+			op.Conflict = req.Conflict
+		}
+		var err error
+		if authorize != nil {
+			err = authorize(op)
+		}
+		if err == nil {
+			results[i].Destination, results[i].Conflict, err = s.runBatchOperation(bucket, op, userID)
+		}
+
+		if err != nil {
+			results[i].Status = batchFailed
+			if appErr, ok := err.(*errors.AppError); ok {
+				results[i].Code, results[i].Error = string(appErr.Code), appErr.Message
+			} else {
+				results[i].Code, results[i].Error = string(errors.ErrInternal), err.Error()
+			}
+			failed++
+			stop = req.StopOnError
+		} else {
+			results[i].Status = batchSucceeded
+			succeeded++
+		}
+
+		progress := (i + 1) * 99 / len(req.Operations)
+		if progress < 1 {
+			progress = 1
+		}
+		s.taskSvc.UpdateProgress(taskID, progress)
+	}
+
+	result := map[string]interface{}{
+		"results":   results,
+		"succeeded": succeeded,
+		"failed":    failed,
+		"skipped":   skipped,
+	}
+	switch {
+	case cancelled:
+		// The task keeps its cancelled status, with what ran before
+		if task, err := s.taskSvc.GetTask(taskID); err == nil {
+			task.Result = result
+			s.taskSvc.UpdateTask(task)
+		}
+	case failed > 0:
+		s.taskSvc.FailTaskWithResult(taskID, fmt.Sprintf("%d of %d operations failed", failed, len(req.Operations)), result)
+	default:
+		s.taskSvc.CompleteTask(taskID, result)
+	}
+}
+
+// runBatchOperation runs one operation of a batch. Moves and copies return where the item
+// ended up and the conflict policy applied there, if any.
+func (s *Service) runBatchOperation(bucket string, op types.BatchOperation, userID string) (string, string, error) {
+	switch op.Op {
+	case "move":
+		dir, err := s.isDirectory(bucket, op.Source)
+		if err != nil {
+			return "", "", err
+		}
+		if dir {
+			moved, resolution, err := s.MoveDirectory(bucket, op.Source, op.Destination, userID, op.Conflict)
+			if err != nil {
+				return "", "", err
+			}
+			return moved.FullPath, resolution, nil
+		}
+		moved, resolution, err := s.MoveFile(bucket, op.Source, op.Destination, userID, op.Conflict, nil)
+		if err != nil {
+			return "", "", err
+		}
+		return moved.FullPath, resolution, nil
+
+	case "copy":
+		dir, err := s.isDirectory(bucket, op.Source)
+		if err != nil {
+			return "", "", err
+		}
+		if dir {
+			return s.CopyDirectory(bucket, op.Source, op.Destination, userID, op.Conflict)
+		}
+		copied, resolution, err := s.CopyFile(bucket, op.Source, op.Destination, userID, op.Conflict)
+		if err != nil {
+			return "", "", err
+		}
+		return copied.FullPath, resolution, nil
+
+	case "delete":
+		dir, err := s.isDirectory(bucket, op.Path)
+		if err != nil {
+			return "", "", err
+		}
+		if dir {
+			return "", "", s.DeleteDirectory(bucket, op.Path, op.Recursive)
+		}
+		return "", "", s.DeleteFile(bucket, op.Path, nil)
+
+	case "star", "unstar":
+		file, err := s.GetFile(bucket, op.Path)
+		if err != nil {
+			return "", "", err
+		}
+		if op.Op == "star" {
+			return "", "", s.enhancedRepo.StarFile(bucket, file.ID, file.FullPath, userID)
+		}
+		return "", "", s.enhancedRepo.UnstarFile(bucket, file.ID, userID)
+	}
+	return "", "", errors.NewInvalidRequestError(fmt.Sprintf("unknown op %q", op.Op))
+}
+
+// isDirectory tells whether a path in a batch names a directory: it ends with a slash, as
+// in the single-item endpoints, or no file but a directory is at it
+func (s *Service) isDirectory(bucket, path string) (bool, error) {
+	if strings.HasSuffix(path, "/") {
+		return true, nil
+	}
+	path = normalizePath(path)
+	if _, err := s.vfsRepo.GetFile(bucket, path); err != sql.ErrNoRows {
+		return false, err
+	}
+	return s.vfsRepo.DirectoryExists(bucket, path)
+}
@@ -0,0 +1,60 @@
+package vfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// Precondition checks the current state of a file before it is changed. file is nil when
+// nothing is at the path. A non-nil error aborts the change.
+type Precondition func(file *types.VirtualFile) error
+
+// ETag returns the entity tag of a file's current content: its SHA-256 when known, else its
+// ID and version, which also change whenever the content does
+func ETag(file *types.VirtualFile) string {
+	if file.ContentHash != "" {
+		return `"` + file.ContentHash + `"`
+	}
+	return fmt.Sprintf(`"%s-%d"`, file.ID, file.Version)
+}
+
+// check runs a precondition, if any
+func (pre Precondition) check(file *types.VirtualFile) error {
+	if pre == nil {
+		return nil
+	}
+	return pre(file)
+}
+
+// changedError is returned when a file changed between being read and being written.
+// Conditional requests were made against the state that is gone, so they fail their
+// precondition; others conflict.
+func changedError(file *types.VirtualFile, pre Precondition) error {
+	if pre != nil {
+		return errors.PreconditionFailed(fmt.Sprintf("file was changed by another request: %s", file.FullPath))
+	}
+	return errors.NewConflictError(fmt.Sprintf("file was changed by another request: %s", file.FullPath))
+}
+
+// hashingReader computes the SHA-256 of what is read through it
+type hashingReader struct {
+	io.Reader
+	hash hash.Hash
+}
+
+// newHashingReader wraps content so its hash is known once it has been read
+func newHashingReader(content io.Reader) *hashingReader {
+	h := sha256.New()
+	return &hashingReader{Reader: io.TeeReader(content, h), hash: h}
+}
+
+// Sum returns the hex SHA-256 of the content read so far
+func (r *hashingReader) Sum() string {
+	return hex.EncodeToString(r.hash.Sum(nil))
+}
@@ -0,0 +1,100 @@
+package vfs
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+	"github.com/xuecangming/onedrive-storage/internal/core/listing"
+)
+
+// maxRenameAttempts is the number of alternative names tried before a rename gives up
+const maxRenameAttempts = 1000
+
+// freePath returns the first path next to a taken one that has neither a file nor a
+// directory at it, such as "/docs/report (1).pdf"
+func (s *Service) freePath(bucket, path string, dir bool) (string, error) {
+	parent, name := splitPath(path)
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := joinPath(parent, conflict.Candidate(name, n, dir))
+
+		fileExists, err := s.vfsRepo.FileExists(bucket, candidate)
+		if err != nil {
+			return "", err
+		}
+		dirExists, err := s.vfsRepo.DirectoryExists(bucket, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !fileExists && !dirExists {
+			return candidate, nil
+		}
+	}
+	return "", errors.NewConflictError(fmt.Sprintf("no free name found for %s", path))
+}
+
+// checkReplaceable checks that a directory may be overwritten by or merged with another:
+// replacing or merging into one of the source's ancestors would take the source with it
+func checkReplaceable(source, destination string) error {
+	if strings.HasPrefix(source, destination+"/") {
+		return errors.NewInvalidRequestError("cannot overwrite or merge into a directory containing the source")
+	}
+	return nil
+}
+
+// mergeDirectory moves everything in a directory into another one and deletes the
+// emptied source. Subdirectories the destination has too are merged in turn; colliding
+// files are renamed. The merge is not atomic: if it fails, what was moved stays moved.
+func (s *Service) mergeDirectory(bucket string, source *types.VirtualDirectory, destination, ownerID string) error {
+	items, err := s.vfsRepo.ListDirectoryContents(bucket, &source.ID, types.ListOptions{Sort: listing.SortName}, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		target := joinPath(destination, item.Name)
+		if item.Type == "file" {
+			if _, _, err := s.MoveFile(bucket, item.Path, target, ownerID, conflict.Rename, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		existing, err := s.vfsRepo.GetDirectory(bucket, target)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if existing == nil {
+			if _, _, err := s.MoveDirectory(bucket, item.Path, target, ownerID, conflict.Fail); err != nil {
+				return err
+			}
+			continue
+		}
+		child, err := s.vfsRepo.GetDirectory(bucket, item.Path)
+		if err != nil {
+			return err
+		}
+		if err := s.mergeDirectory(bucket, child, target, ownerID); err != nil {
+			return err
+		}
+	}
+
+	if err := s.vfsRepo.DeleteEmptyDirectory(source.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewConflictError(fmt.Sprintf("directory was changed by another request: %s", source.FullPath))
+		}
+		return err
+	}
+	return nil
+}
+
+// joinPath returns the path of an item named name in a directory
+func joinPath(dir, name string) string {
+	if dir == "/" {
+		return "/" + name
+	}
+	return dir + "/" + name
+}
@@ -1,8 +1,10 @@
 package vfs
 
 import (
+	"database/sql"
 	"time"
 
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 )
@@ -12,37 +14,49 @@ type EnhancedService struct {
 	enhancedRepo *repository.EnhancedVFSRepository
 	vfsRepo      *repository.VFSRepository
 	bucketRepo   *repository.BucketRepository
+	vfsSvc       *Service
 }
 
-// NewEnhancedService creates a new enhanced VFS service
-func NewEnhancedService(enhancedRepo *repository.EnhancedVFSRepository, vfsRepo *repository.VFSRepository, bucketRepo *repository.BucketRepository) *EnhancedService {
+// NewEnhancedService creates a new enhanced VFS service. Trash items are restored and
+// purged through vfsSvc, which moves deleted items there.
+func NewEnhancedService(enhancedRepo *repository.EnhancedVFSRepository, vfsRepo *repository.VFSRepository, bucketRepo *repository.BucketRepository, vfsSvc *Service) *EnhancedService {
 	return &EnhancedService{
 		enhancedRepo: enhancedRepo,
 		vfsRepo:      vfsRepo,
 		bucketRepo:   bucketRepo,
+		vfsSvc:       vfsSvc,
 	}
 }
 
 // ==================== Starred Files ====================
 
-// StarFile stars a file
-func (s *EnhancedService) StarFile(bucket, fileID, filePath string) error {
-	return s.enhancedRepo.StarFile(bucket, fileID, filePath)
+// StarFile stars a file for a user
+func (s *EnhancedService) StarFile(bucket, fileID, filePath, userID string) error {
+	return s.enhancedRepo.StarFile(bucket, fileID, filePath, userID)
 }
 
-// UnstarFile unstars a file
-func (s *EnhancedService) UnstarFile(bucket, fileID string) error {
-	return s.enhancedRepo.UnstarFile(bucket, fileID)
+// GetFileByID returns a file of a bucket by ID
+func (s *EnhancedService) GetFileByID(bucket, fileID string) (*types.VirtualFile, error) {
+	file, err := s.vfsRepo.GetFileByID(fileID)
+	if err == sql.ErrNoRows || (err == nil && file.Bucket != bucket) {
+		return nil, errors.NewNotFoundError("file not found: " + fileID)
+	}
+	return file, err
+}
+
+// UnstarFile unstars a file for a user
+func (s *EnhancedService) UnstarFile(bucket, fileID, userID string) error {
+	return s.enhancedRepo.UnstarFile(bucket, fileID, userID)
 }
 
-// IsFileStarred checks if a file is starred
-func (s *EnhancedService) IsFileStarred(bucket, fileID string) (bool, error) {
-	return s.enhancedRepo.IsFileStarred(bucket, fileID)
+// IsFileStarred checks if a user starred a file
+func (s *EnhancedService) IsFileStarred(bucket, fileID, userID string) (bool, error) {
+	return s.enhancedRepo.IsFileStarred(bucket, fileID, userID)
 }
 
-// GetStarredFiles returns all starred files
-func (s *EnhancedService) GetStarredFiles(bucket string) ([]types.VFSItem, error) {
-	return s.enhancedRepo.GetStarredFiles(bucket)
+// GetStarredFiles returns the files a user starred
+func (s *EnhancedService) GetStarredFiles(bucket, userID string) ([]types.VFSItem, error) {
+	return s.enhancedRepo.GetStarredFiles(bucket, userID)
 }
 
 // ==================== Trash ====================
@@ -52,69 +66,53 @@ func (s *EnhancedService) GetTrashItems(bucket string) ([]*types.TrashItem, erro
 	return s.enhancedRepo.GetTrashItems(bucket)
 }
 
-// RestoreFromTrash restores an item from trash
-func (s *EnhancedService) RestoreFromTrash(trashID string) error {
-	// Get the trash item
-	item, err := s.enhancedRepo.GetTrashItem(trashID)
+// RestoreFromTrash restores an item from a bucket's trash to its original path
+func (s *EnhancedService) RestoreFromTrash(bucket, trashID string) error {
+	item, err := s.trashItem(bucket, trashID)
 	if err != nil {
 		return err
 	}
+	return s.vfsSvc.RestoreFromTrash(item)
+}
 
-	// For files, we need to recreate the virtual file record
-	if item.OriginalType == "file" {
-		// Check if original path is available
-		exists, err := s.vfsRepo.FileExists(item.Bucket, item.OriginalPath)
-		if err != nil {
-			return err
-		}
-		if exists {
-			// Path is taken, we can't restore
-			return &restoreError{message: "original path is no longer available"}
-		}
-
-		// Recreate the virtual file (the object should still exist in storage)
-		now := time.Now()
-		file := &types.VirtualFile{
-			ID:        item.OriginalID,
-			Bucket:    item.Bucket,
-			Name:      item.OriginalName,
-			FullPath:  item.OriginalPath,
-			ObjectKey: item.ObjectKey,
-			Size:      item.Size,
-			MimeType:  item.MimeType,
-			CreatedAt: now,
-			UpdatedAt: now,
-		}
-
-		if err := s.vfsRepo.CreateFile(file); err != nil {
-			return err
-		}
+// DeleteFromTrash permanently deletes an item from a bucket's trash, with its objects
+func (s *EnhancedService) DeleteFromTrash(bucket, trashID string) error {
+	item, err := s.trashItem(bucket, trashID)
+	if err != nil {
+		return err
 	}
+	return s.vfsSvc.PurgeTrashItem(item)
+}
 
-	// Remove from trash
-	return s.enhancedRepo.DeleteFromTrash(trashID)
+// GetTrashItem returns an item of a bucket's trash
+func (s *EnhancedService) GetTrashItem(bucket, trashID string) (*types.TrashItem, error) {
+	return s.trashItem(bucket, trashID)
 }
 
-// DeleteFromTrash permanently deletes an item from trash
-func (s *EnhancedService) DeleteFromTrash(trashID string) error {
-	return s.enhancedRepo.DeleteFromTrash(trashID)
+// trashItem returns an item of a bucket's trash
+func (s *EnhancedService) trashItem(bucket, trashID string) (*types.TrashItem, error) {
+	item, err := s.enhancedRepo.GetTrashItem(trashID)
+	if err == sql.ErrNoRows || (err == nil && item.Bucket != bucket) {
+		return nil, errors.NewNotFoundError("trash item not found: " + trashID)
+	}
+	return item, err
 }
 
-// EmptyTrash empties the trash
+// EmptyTrash empties the trash, deleting the objects of its files
 func (s *EnhancedService) EmptyTrash(bucket string) (int64, error) {
-	return s.enhancedRepo.EmptyTrash(bucket)
+	return s.vfsSvc.EmptyTrash(bucket)
 }
 
 // ==================== Recent Files ====================
 
-// RecordFileAccess records a file access
-func (s *EnhancedService) RecordFileAccess(bucket, fileID, filePath, fileName string) error {
-	return s.enhancedRepo.RecordFileAccess(bucket, fileID, filePath, fileName)
+// RecordFileAccess records a user's access to a file
+func (s *EnhancedService) RecordFileAccess(bucket, fileID, filePath, fileName, userID string) error {
+	return s.enhancedRepo.RecordFileAccess(bucket, fileID, filePath, fileName, userID)
 }
 
-// GetRecentFiles returns recently accessed files
-func (s *EnhancedService) GetRecentFiles(bucket string, limit int) ([]types.VFSItem, error) {
-	return s.enhancedRepo.GetRecentFiles(bucket, limit)
+// GetRecentFiles returns the files a user recently accessed
+func (s *EnhancedService) GetRecentFiles(bucket, userID string, limit int) ([]types.VFSItem, error) {
+	return s.enhancedRepo.GetRecentFiles(bucket, userID, limit)
 }
 
 // ==================== Search ====================
@@ -133,12 +131,3 @@ func (s *EnhancedService) SearchByType(bucket, fileType string, limit int) ([]ty
 func (s *EnhancedService) GetFilesByDateRange(bucket string, from, to time.Time, limit int) ([]types.VFSItem, error) {
 	return s.enhancedRepo.GetFilesByCreatedDate(bucket, from, to, limit)
 }
-
-// restoreError represents a restore error
-type restoreError struct {
-	message string
-}
-
-func (e *restoreError) Error() string {
-	return e.message
-}
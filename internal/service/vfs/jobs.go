@@ -0,0 +1,322 @@
+package vfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+)
+
+const (
+	// copyBatch is the number of files a directory copy reads at a time
+	copyBatch = 100
+	// objectDeletionBatch is the number of queued object deletions processed at a time
+	objectDeletionBatch = 100
+)
+
+// errCopyCancelled stops a copy whose task was cancelled
+var errCopyCancelled = fmt.Errorf("copy cancelled")
+
+// startCopy checks that a directory can be copied and records the copy, so it can be
+// resumed if the server stops before it is done. A directory already at the destination
+// is handled by the conflict policy: overwriting puts it in the trash first, renaming
+// copies next to it and merging copies into it. Skipping returns no copy to run.
+func (s *Service) startCopy(bucket, source, destination, ownerID, policy string) (*types.CopyJob, error) {
+	source = normalizePath(source)
+	destination = normalizePath(destination)
+
+	if source == "/" || destination == "/" {
+		return nil, errors.NewInvalidRequestError("cannot copy root directory")
+	}
+
+	if source == destination {
+		return nil, errors.NewInvalidRequestError("source and destination are the same")
+	}
+
+	// Check if destination is a subdirectory of source
+	if strings.HasPrefix(destination, source+"/") {
+		return nil, errors.NewInvalidRequestError("cannot copy directory into itself")
+	}
+
+	if _, err := s.vfsRepo.GetDirectory(bucket, source); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, errors.NewNotFoundError(fmt.Sprintf("source directory not found: %s", source))
+		}
+		return nil, err
+	}
+
+	// Check if destination already exists
+	exists, err := s.vfsRepo.DirectoryExists(bucket, destination)
+	if err != nil {
+		return nil, err
+	}
+	resolution := ""
+	if exists {
+		switch policy {
+		case conflict.Overwrite:
+			if err := checkReplaceable(source, destination); err != nil {
+				return nil, err
+			}
+			if err := s.DeleteDirectory(bucket, destination, true); err != nil {
+				return nil, err
+			}
+		case conflict.Skip:
+			return nil, nil
+		case conflict.Rename:
+			if destination, err = s.freePath(bucket, destination, true); err != nil {
+				return nil, err
+			}
+		case conflict.Merge:
+			if err := checkReplaceable(source, destination); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.NewConflictError(fmt.Sprintf("destination directory already exists: %s", destination))
+		}
+		resolution = policy
+	}
+
+	total, err := s.vfsRepo.CountFilesByPath(bucket, source+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &types.CopyJob{
+		ID:          utils.GenerateID(),
+		Bucket:      bucket,
+		Source:      source,
+		Destination: destination,
+		OwnerID:     ownerID,
+		Conflict:    resolution,
+		Total:       total,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := s.vfsRepo.CreateCopyJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// runCopyJob copies a directory tree from its checkpoint on, recording a new checkpoint
+// after every file. taskID, if any, gets the progress and can cancel the copy. The copy's
+// record is removed once it ends, whether it finished, was cancelled or failed, so only
+// copies interrupted by the server stopping are resumed; a failed copy is reported by its
+// task and can be run again with the merge policy to finish it.
+func (s *Service) runCopyJob(job *types.CopyJob, taskID string) error {
+	err := s.copyTree(job, taskID)
+	if deleteErr := s.vfsRepo.DeleteCopyJob(job.ID); deleteErr != nil && err == nil {
+		err = deleteErr
+	}
+	return err
+}
+
+// copyTree copies the directories and then the files of a copy job after its checkpoint
+func (s *Service) copyTree(job *types.CopyJob, taskID string) error {
+	// Directories first; after a restart the ones already there are kept
+	if _, err := s.ensureDirectoryPath(job.Bucket, job.Destination, job.OwnerID); err != nil {
+		return err
+	}
+	dirs, err := s.vfsRepo.ListDirectoriesByPath(job.Bucket, job.Source+"/")
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if _, err := s.ensureDirectoryPath(job.Bucket, job.Destination+strings.TrimPrefix(dir.FullPath, job.Source), job.OwnerID); err != nil {
+			return err
+		}
+	}
+
+	for {
+		files, err := s.vfsRepo.ListFilesAfter(job.Bucket, job.Source+"/", job.Checkpoint, copyBatch)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			break
+		}
+
+		for _, file := range files {
+			if taskID != "" && s.taskSvc.IsCancelled(taskID) {
+				return errCopyCancelled
+			}
+
+			if err := s.copyFile(file, job.Destination+strings.TrimPrefix(file.FullPath, job.Source), job.OwnerID, job.Conflict == conflict.Merge); err != nil {
+				return err
+			}
+
+			job.Checkpoint = file.FullPath
+			job.Copied++
+			if err := s.vfsRepo.CheckpointCopyJob(job); err != nil {
+				return err
+			}
+			if taskID != "" && job.Total > 0 {
+				progress := int(job.Copied * 99 / job.Total)
+				if progress < 1 {
+					progress = 1
+				}
+				s.taskSvc.UpdateProgress(taskID, progress)
+			}
+		}
+	}
+	return nil
+}
+
+// copyFile copies a file's content to a new path. A copy already at the path was made
+// before the last checkpoint could be recorded and is kept. When merging into an existing
+// directory, a file at the path may also have been there before: it is kept if it has the
+// same content, else the copy is renamed, once even when the copy is resumed.
+func (s *Service) copyFile(file *types.VirtualFile, path, ownerID string, merge bool) error {
+	existing, err := s.vfsRepo.GetFile(file.Bucket, path)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if existing != nil {
+		if !merge || sameContent(existing, file) {
+			return nil
+		}
+		if path, err = s.mergedCopyPath(file, path); err != nil || path == "" {
+			return err
+		}
+	}
+
+	// Note: This streams data through the server; a server-side copy would avoid that
+	_, reader, err := s.objectSvc.Download(context.Background(), file.Bucket, file.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = s.UploadFile(file.Bucket, path, reader, file.Size, file.MimeType, ownerID)
+	return err
+}
+
+// mergedCopyPath picks the path of a file merged onto a taken one: the first free name
+// next to it, unless a renamed file before that already has the file's content, which is
+// a copy made before the last checkpoint could be recorded. It returns an empty path then.
+func (s *Service) mergedCopyPath(file *types.VirtualFile, path string) (string, error) {
+	parent, name := splitPath(path)
+	for n := 1; n <= maxRenameAttempts; n++ {
+		candidate := joinPath(parent, conflict.Candidate(name, n, false))
+
+		existing, err := s.vfsRepo.GetFile(file.Bucket, candidate)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+		if existing != nil {
+			if sameContent(existing, file) {
+				return "", nil
+			}
+			continue
+		}
+		dirExists, err := s.vfsRepo.DirectoryExists(file.Bucket, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !dirExists {
+			return candidate, nil
+		}
+	}
+	return "", errors.NewConflictError(fmt.Sprintf("no free name found for %s", path))
+}
+
+// sameContent reports whether two files are known to have the same content
+func sameContent(a, b *types.VirtualFile) bool {
+	return a.ContentHash != "" && a.ContentHash == b.ContentHash
+}
+
+// finishCopyTask records the outcome of a directory copy on its task. job is nil when
+// the copy was skipped because destination was taken.
+func (s *Service) finishCopyTask(taskID string, job *types.CopyJob, destination string, err error) {
+	switch {
+	case err == errCopyCancelled:
+		// The task keeps its cancelled status
+	case err != nil:
+		s.taskSvc.FailTask(taskID, err.Error())
+	case job == nil:
+		s.taskSvc.CompleteTask(taskID, conflictResult(normalizePath(destination), conflict.Skip))
+	default:
+		result := conflictResult(job.Destination, job.Conflict)
+		result["copied"] = job.Copied
+		s.taskSvc.CompleteTask(taskID, result)
+	}
+}
+
+// ResumeJobs finishes the work a previous run of the server left behind: directory copies
+// continue from their checkpoint in new tasks and queued objects are deleted from storage
+func (s *Service) ResumeJobs() {
+	jobs, err := s.vfsRepo.ListCopyJobs()
+	if err != nil {
+		log.Printf("Warning: failed to list interrupted copies: %v", err)
+	}
+	for _, job := range jobs {
+		task, err := s.taskSvc.CreateUserTask(types.TaskTypeCopy, job.OwnerID, map[string]interface{}{
+			"bucket":      job.Bucket,
+			"source":      job.Source,
+			"destination": job.Destination,
+			"operation":   "copy_directory",
+			"resumed":     true,
+		})
+		if err != nil {
+			log.Printf("Warning: failed to resume copy of %s: %v", job.Source, err)
+			continue
+		}
+
+		log.Printf("Resuming copy of %s to %s after %d of %d files", job.Source, job.Destination, job.Copied, job.Total)
+		go func(job *types.CopyJob, taskID string) {
+			s.finishCopyTask(taskID, job, job.Destination, s.runCopyJob(job, taskID))
+		}(job, task.ID)
+	}
+
+	go s.deleteQueuedObjects()
+}
+
+// deleteQueuedObjects deletes queued objects from storage. Objects that fail stay queued
+// and are tried again with the next trash purge. Passes run one at a time: a call made
+// while one runs has it go again once it ends, so objects queued after it listed its
+// last batch are not left behind.
+func (s *Service) deleteQueuedObjects() {
+	s.deletions.Run(s.deleteQueuedObjectsPass)
+}
+
+// deleteQueuedObjectsPass deletes the queued objects batch by batch until none are left
+// or one fails
+func (s *Service) deleteQueuedObjectsPass() {
+	ctx := context.Background()
+	for {
+		deletions, err := s.vfsRepo.ListObjectDeletions(objectDeletionBatch)
+		if err != nil {
+			log.Printf("Warning: failed to list queued object deletions: %v", err)
+			return
+		}
+
+		failed := false
+		for _, d := range deletions {
+			err := s.objectSvc.Delete(ctx, d.Bucket, d.ObjectKey)
+			if appErr, ok := err.(*errors.AppError); ok && appErr.Code == errors.ErrObjectNotFound {
+				err = nil // deleted before a crash left it queued
+			}
+			if err != nil {
+				log.Printf("Warning: failed to delete object %s from storage: %v", d.ObjectKey, err)
+				failed = true
+				continue
+			}
+			if err := s.vfsRepo.DeleteObjectDeletion(d.Bucket, d.ObjectKey); err != nil {
+				log.Printf("Warning: failed to dequeue deleted object %s: %v", d.ObjectKey, err)
+				return
+			}
+		}
+
+		if failed || len(deletions) < objectDeletionBatch {
+			return
+		}
+	}
+}
@@ -12,6 +12,10 @@ import (
 	"github.com/xuecangming/onedrive-storage/internal/common/errors"
 	"github.com/xuecangming/onedrive-storage/internal/common/types"
 	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/archive"
+	"github.com/xuecangming/onedrive-storage/internal/core/coalesce"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+	"github.com/xuecangming/onedrive-storage/internal/core/listing"
 	"github.com/xuecangming/onedrive-storage/internal/repository"
 	"github.com/xuecangming/onedrive-storage/internal/service/object"
 	"github.com/xuecangming/onedrive-storage/internal/service/task"
@@ -19,24 +23,34 @@ import (
 
 // Service handles virtual file system operations
 type Service struct {
-	vfsRepo    *repository.VFSRepository
-	objectSvc  *object.Service
-	bucketRepo *repository.BucketRepository
-	taskSvc    *task.Service
+	vfsRepo       *repository.VFSRepository
+	enhancedRepo  *repository.EnhancedVFSRepository
+	versionRepo   *repository.VersionRepository
+	objectSvc     *object.Service
+	bucketRepo    *repository.BucketRepository
+	taskSvc       *task.Service
+	trashConfig   types.TrashConfig
+	versionConfig types.VersionConfig
+	deletions     coalesce.Runner // runs the passes deleting queued objects from storage
 }
 
 // NewService creates a new VFS service
-func NewService(vfsRepo *repository.VFSRepository, objectSvc *object.Service, bucketRepo *repository.BucketRepository, taskSvc *task.Service) *Service {
+func NewService(vfsRepo *repository.VFSRepository, enhancedRepo *repository.EnhancedVFSRepository, versionRepo *repository.VersionRepository, objectSvc *object.Service, bucketRepo *repository.BucketRepository, taskSvc *task.Service, trashConfig types.TrashConfig, versionConfig types.VersionConfig) *Service {
 	return &Service{
-		vfsRepo:    vfsRepo,
-		objectSvc:  objectSvc,
-		bucketRepo: bucketRepo,
-		taskSvc:    taskSvc,
+		vfsRepo:       vfsRepo,
+		enhancedRepo:  enhancedRepo,
+		versionRepo:   versionRepo,
+		objectSvc:     objectSvc,
+		bucketRepo:    bucketRepo,
+		taskSvc:       taskSvc,
+		trashConfig:   trashConfig,
+		versionConfig: versionConfig,
 	}
 }
 
-// UploadFile uploads a file to a virtual path
-func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64, mimeType string) (*types.VirtualFile, error) {
+// UploadFile uploads a file to a virtual path. ownerID is the user the file and any
+// directories created for it belong to, empty when uploaded without a user.
+func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64, mimeType, ownerID string) (*types.VirtualFile, error) {
 	// Validate bucket
 	_, err := s.bucketRepo.Get(context.Background(), bucket)
 	if err != nil {
@@ -67,7 +81,7 @@ func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64,
 	// Ensure directory path exists
 	var directoryID *string
 	if dirPath != "/" {
-		dir, err := s.ensureDirectoryPath(bucket, dirPath)
+		dir, err := s.ensureDirectoryPath(bucket, dirPath, ownerID)
 		if err != nil {
 			return nil, err
 		}
@@ -79,7 +93,8 @@ func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64,
 
 	// Upload to object storage
 	ctx := context.Background()
-	_, err = s.objectSvc.Upload(ctx, bucket, objectKey, content, size, mimeType)
+	hashed := newHashingReader(content)
+	_, err = s.objectSvc.Upload(ctx, bucket, objectKey, hashed, size, mimeType)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +110,9 @@ func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64,
 		ObjectKey:   objectKey,
 		Size:        size,
 		MimeType:    mimeType,
+		OwnerID:     ownerID,
+		Version:     1,
+		ContentHash: hashed.Sum(),
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -108,8 +126,9 @@ func (s *Service) UploadFile(bucket, path string, content io.Reader, size int64,
 	return file, nil
 }
 
-// InitiateUpload starts a multipart upload
-func (s *Service) InitiateUpload(bucket, path, mimeType string, size int64) (string, error) {
+// InitiateUpload starts a multipart upload on behalf of a user. A file already at the
+// path fails it unless a conflict policy is given, which CompleteUpload applies.
+func (s *Service) InitiateUpload(bucket, path, mimeType string, size int64, ownerID, policy string) (string, error) {
 	// Validate bucket
 	_, err := s.bucketRepo.Get(context.Background(), bucket)
 	if err != nil {
@@ -130,7 +149,7 @@ func (s *Service) InitiateUpload(bucket, path, mimeType string, size int64) (str
 	if err != nil {
 		return "", err
 	}
-	if exists {
+	if exists && policy == "" {
 		return "", errors.NewConflictError(fmt.Sprintf("file already exists at path: %s", path))
 	}
 
@@ -145,7 +164,7 @@ func (s *Service) InitiateUpload(bucket, path, mimeType string, size int64) (str
 	}
 
 	// Create upload task
-	_, err = s.taskSvc.CreateTask(types.TaskTypeUpload, map[string]interface{}{
+	_, err = s.taskSvc.CreateUserTask(types.TaskTypeUpload, ownerID, map[string]interface{}{
 		"upload_id":      objectKey,
 		"bucket":         bucket,
 		"path":           path,
@@ -213,29 +232,77 @@ func (s *Service) UploadPart(bucket, uploadID string, partNumber int, data []byt
 	return nil
 }
 
-// CompleteUpload completes a multipart upload
-func (s *Service) CompleteUpload(bucket, path, uploadID string, totalSize int64, mimeType string) (*types.VirtualFile, error) {
+// CompleteUpload completes a multipart upload, creating a file owned by ownerID. A file
+// already at the path is handled by the conflict policy like in PutFile: overwriting keeps
+// its content as a previous version, skipping discards the uploaded parts and returns it,
+// and renaming and merging create the file next to it. It returns the policy applied, or
+// an empty string when the path was free.
+func (s *Service) CompleteUpload(bucket, path, uploadID string, totalSize int64, mimeType, ownerID, policy string) (*types.VirtualFile, string, error) {
 	// Normalize path
 	path = normalizePath(path)
-	
+
+	// An upload can only complete at the path it was started for
+	uploadPath, err := s.UploadPath(bucket, uploadID)
+	if err != nil {
+		return nil, "", err
+	}
+	if path != uploadPath {
+		return nil, "", errors.NewInvalidRequestError(fmt.Sprintf("upload %s was started for %s", uploadID, uploadPath))
+	}
+
+	existing, err := s.vfsRepo.GetFile(bucket, path)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+	ctx := context.Background()
+	resolution := ""
+	if existing != nil {
+		switch policy {
+		case conflict.Overwrite:
+		case conflict.Skip:
+			if err := s.objectSvc.AbortMultipartUpload(ctx, bucket, uploadID); err != nil {
+				return nil, "", err
+			}
+			s.completeUploadTask(uploadID, existing)
+			return existing, conflict.Skip, nil
+		case conflict.Rename, conflict.Merge:
+			if path, err = s.freePath(bucket, path, false); err != nil {
+				return nil, "", err
+			}
+			existing = nil
+			policy = conflict.Rename
+		default:
+			return nil, "", errors.NewConflictError(fmt.Sprintf("file already exists at path: %s", path))
+		}
+		resolution = policy
+	}
+
 	// Parse directory and filename
 	dirPath, filename := splitPath(path)
 
 	// Ensure directory path exists
 	var directoryID *string
 	if dirPath != "/" {
-		dir, err := s.ensureDirectoryPath(bucket, dirPath)
+		dir, err := s.ensureDirectoryPath(bucket, dirPath, ownerID)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		directoryID = &dir.ID
 	}
 
 	// Complete object upload
-	ctx := context.Background()
-	_, err := s.objectSvc.CompleteMultipartUpload(ctx, bucket, uploadID, totalSize, mimeType)
+	_, err = s.objectSvc.CompleteMultipartUpload(ctx, bucket, uploadID, totalSize, mimeType)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+
+	if existing != nil {
+		if err := s.replaceContent(existing, uploadID, totalSize, mimeType, "", nil); err != nil {
+			_ = s.objectSvc.Delete(ctx, bucket, uploadID)
+			return nil, "", err
+		}
+		s.completeUploadTask(uploadID, existing)
+		return existing, resolution, nil
 	}
 
 	// Create virtual file record
@@ -249,6 +316,8 @@ func (s *Service) CompleteUpload(bucket, path, uploadID string, totalSize int64,
 		ObjectKey:   uploadID,
 		Size:        totalSize,
 		MimeType:    mimeType,
+		OwnerID:     ownerID,
+		Version:     1,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
@@ -256,10 +325,15 @@ func (s *Service) CompleteUpload(bucket, path, uploadID string, totalSize int64,
 	if err := s.vfsRepo.CreateFile(file); err != nil {
 		// Clean up object if file creation fails
 		_ = s.objectSvc.Delete(ctx, bucket, uploadID)
-		return nil, err
+		return nil, "", err
 	}
 
-	// Complete task
+	s.completeUploadTask(uploadID, file)
+	return file, resolution, nil
+}
+
+// completeUploadTask completes the task tracking a multipart upload
+func (s *Service) completeUploadTask(uploadID string, file *types.VirtualFile) {
 	task, err := s.taskSvc.GetTaskByMetadata("upload_id", uploadID)
 	if err == nil && task != nil {
 		_ = s.taskSvc.CompleteTask(task.ID, map[string]interface{}{
@@ -267,8 +341,19 @@ func (s *Service) CompleteUpload(bucket, path, uploadID string, totalSize int64,
 			"path":    file.FullPath,
 		})
 	}
+}
 
-	return file, nil
+// UploadPath returns the path a multipart upload was started for, from its upload task
+func (s *Service) UploadPath(bucket, uploadID string) (string, error) {
+	task, err := s.taskSvc.GetTaskByMetadata("upload_id", uploadID)
+	if err != nil || task == nil {
+		return "", errors.NewNotFoundError(fmt.Sprintf("upload not found: %s", uploadID))
+	}
+	path, _ := task.Metadata["path"].(string)
+	if task.Metadata["bucket"] != bucket || path == "" {
+		return "", errors.NewNotFoundError(fmt.Sprintf("upload not found: %s", uploadID))
+	}
+	return path, nil
 }
 
 // ListParts lists uploaded parts for a multipart upload
@@ -386,15 +471,79 @@ func (s *Service) ListDirectory(bucket, path string, recursive bool) ([]types.VF
 	}
 
 	// List immediate children
-	return s.vfsRepo.ListDirectoryContents(bucket, directoryID)
+	return s.vfsRepo.ListDirectoryContents(bucket, directoryID, types.ListOptions{Sort: listing.SortName}, nil)
+}
+
+// ListDirectoryPage lists a page of a directory's immediate children
+func (s *Service) ListDirectoryPage(bucket, path string, opts types.ListOptions) (*types.DirectoryPage, error) {
+	if opts.Sort == "" {
+		opts.Sort = listing.SortName
+	}
+	if !listing.ValidSort(opts.Sort) {
+		return nil, errors.NewInvalidRequestError(fmt.Sprintf("cannot sort by %s", opts.Sort))
+	}
+	if !listing.ValidType(opts.Type) {
+		return nil, errors.NewInvalidRequestError("kind must be file or directory")
+	}
+	if opts.Limit < 0 {
+		return nil, errors.NewInvalidRequestError("limit cannot be negative")
+	}
+	var after *types.ListCursor
+	if opts.Cursor != "" {
+		c, err := listing.Decode(opts.Cursor, opts)
+		if err != nil {
+			return nil, errors.NewInvalidRequestError(err.Error())
+		}
+		after = c
+	}
+
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+	path = normalizePath(path)
+
+	var directoryID *string
+	if path != "/" {
+		dir, err := s.vfsRepo.GetDirectory(bucket, path)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil, errors.NewNotFoundError(fmt.Sprintf("directory not found: %s", path))
+			}
+			return nil, err
+		}
+		directoryID = &dir.ID
+	}
+
+	// One item more than the page tells whether there is a next page
+	limit := opts.Limit
+	if limit > 0 {
+		opts.Limit = limit + 1
+	}
+	items, err := s.vfsRepo.ListDirectoryContents(bucket, directoryID, opts, after)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := s.vfsRepo.CountDirectoryContents(bucket, directoryID, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &types.DirectoryPage{Path: path, Items: items, Total: total}
+	if limit > 0 && len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = listing.Encode(listing.CursorAfter(items[limit-1], opts))
+	}
+	return page, nil
 }
 
 // listRecursive lists all items recursively under a path
 func (s *Service) listRecursive(bucket, path string) ([]types.VFSItem, error) {
 	var items []types.VFSItem
+	prefix := strings.TrimSuffix(path, "/") + "/"
 
 	// Get all directories under this path
-	dirs, err := s.vfsRepo.ListDirectoriesByPath(bucket, path)
+	dirs, err := s.vfsRepo.ListDirectoriesByPath(bucket, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -405,13 +554,16 @@ func (s *Service) listRecursive(bucket, path string) ([]types.VFSItem, error) {
 			Name:      dir.Name,
 			Path:      dir.FullPath,
 			Type:      "directory",
+			Size:      dir.TotalSize,
+			FileCount: dir.FileCount,
+			DirCount:  dir.DirCount,
 			CreatedAt: dir.CreatedAt,
 		}
 		items = append(items, item)
 	}
 
 	// Get all files under this path
-	files, err := s.vfsRepo.ListFilesByDirectory(bucket, path)
+	files, err := s.vfsRepo.ListFilesByDirectory(bucket, prefix)
 	if err != nil {
 		return nil, err
 	}
@@ -433,8 +585,18 @@ func (s *Service) listRecursive(bucket, path string) ([]types.VFSItem, error) {
 	return items, nil
 }
 
-// CreateDirectory creates a directory
-func (s *Service) CreateDirectory(bucket, path string) (*types.VirtualDirectory, error) {
+// WriteZip writes a directory and everything below it to w as a zip archive,
+// with entries named relative to the directory
+func (s *Service) WriteZip(w io.Writer, bucket, path string) error {
+	a, err := s.DirectoryArchive(bucket, path)
+	if err != nil {
+		return err
+	}
+	return a.Write(archive.NewWriter(archive.FormatZip, w), nil)
+}
+
+// CreateDirectory creates a directory owned by ownerID
+func (s *Service) CreateDirectory(bucket, path, ownerID string) (*types.VirtualDirectory, error) {
 	// Validate bucket
 	ctx := context.Background()
 	_, err := s.bucketRepo.Get(ctx, bucket)
@@ -459,38 +621,48 @@ func (s *Service) CreateDirectory(bucket, path string) (*types.VirtualDirectory,
 		return nil, errors.NewConflictError(fmt.Sprintf("directory already exists: %s", path))
 	}
 
-	return s.ensureDirectoryPath(bucket, path)
+	return s.ensureDirectoryPath(bucket, path, ownerID)
 }
 
-// DeleteFile deletes a file
-func (s *Service) DeleteFile(bucket, path string) error {
+// DeleteFile moves a file to the trash. Its object is kept until the trash is purged.
+// pre, if any, is checked against the file first.
+func (s *Service) DeleteFile(bucket, path string, pre Precondition) error {
 	path = normalizePath(path)
 
 	file, err := s.vfsRepo.GetFile(bucket, path)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.NewNotFoundError(fmt.Sprintf("file not found: %s", path))
-		}
+	if err != nil && err != sql.ErrNoRows {
 		return err
 	}
-
-	// Delete virtual file record
-	if err := s.vfsRepo.DeleteFile(file.ID); err != nil {
+	if err := pre.check(file); err != nil {
 		return err
 	}
-
-	// Delete from object storage
-	ctx := context.Background()
-	if err := s.objectSvc.Delete(ctx, bucket, file.ObjectKey); err != nil {
-		// Log error but don't fail - virtual file is already deleted
-		// In production, this should use proper logging framework
-		fmt.Printf("Warning: failed to delete object %s from storage: %v\n", file.ObjectKey, err)
+	if file == nil {
+		return errors.NewNotFoundError(fmt.Sprintf("file not found: %s", path))
 	}
 
-	return nil
+	item := &types.TrashItem{
+		Bucket:       bucket,
+		OriginalType: "file",
+		OriginalID:   file.ID,
+		OriginalPath: file.FullPath,
+		OriginalName: file.Name,
+		ObjectKey:    file.ObjectKey,
+		Size:         file.Size,
+		MimeType:     file.MimeType,
+		OwnerID:      file.OwnerID,
+	}
+	return s.moveToTrash(item, nil, func(tx *sql.Tx) error {
+		if err := s.vfsRepo.DeleteFileVersionTx(tx, file.ID, file.Version); err != nil {
+			if err == sql.ErrNoRows {
+				return changedError(file, pre)
+			}
+			return err
+		}
+		return nil
+	})
 }
 
-// DeleteDirectory deletes a directory
+// DeleteDirectory moves a directory to the trash with everything below it
 func (s *Service) DeleteDirectory(bucket, path string, recursive bool) error {
 	path = normalizePath(path)
 	if path == "/" {
@@ -515,63 +687,106 @@ func (s *Service) DeleteDirectory(bucket, path string, recursive bool) error {
 		return errors.NewConflictError("directory is not empty, use recursive=true to delete")
 	}
 
-	var objectKeysToDelete []string
-	if recursive && childCount > 0 {
-		// Get all files before deleting (so we can clean up objects after)
-		searchPath := strings.TrimSuffix(path, "/")
-		files, err := s.vfsRepo.ListFilesByDirectory(bucket, searchPath)
+	item := &types.TrashItem{
+		Bucket:       bucket,
+		OriginalType: "directory",
+		OriginalID:   dir.ID,
+		OriginalPath: dir.FullPath,
+		OriginalName: dir.Name,
+		OwnerID:      dir.OwnerID,
+	}
+
+	// Record the subtree so the directory can be restored whole
+	var entries []*types.TrashEntry
+	if childCount > 0 {
+		dirs, err := s.vfsRepo.ListDirectoriesByPath(bucket, path+"/")
 		if err != nil {
-			return fmt.Errorf("failed to list files for deletion: %w", err)
+			return fmt.Errorf("failed to list directories for deletion: %w", err)
 		}
-
-		// Collect object keys
-		for _, file := range files {
-			objectKeysToDelete = append(objectKeysToDelete, file.ObjectKey)
+		for _, d := range dirs {
+			entries = append(entries, &types.TrashEntry{
+				Type:    "directory",
+				ID:      d.ID,
+				Path:    strings.TrimPrefix(d.FullPath, path),
+				Name:    d.Name,
+				OwnerID: d.OwnerID,
+			})
 		}
-	}
-
-	// Delete the directory itself (this will cascade delete all virtual_files and subdirectories)
-	if err := s.vfsRepo.DeleteDirectory(dir.ID); err != nil {
-		return err
-	}
 
-	// Now delete the objects from storage (after virtual_files are deleted)
-	ctx := context.Background()
-	for _, objectKey := range objectKeysToDelete {
-		if err := s.objectSvc.Delete(ctx, bucket, objectKey); err != nil {
-			// Log error but continue - virtual files are already deleted
-			fmt.Printf("Warning: failed to delete object %s from storage: %v\n", objectKey, err)
+		files, err := s.vfsRepo.ListFilesByDirectory(bucket, path+"/")
+		if err != nil {
+			return fmt.Errorf("failed to list files for deletion: %w", err)
+		}
+		for _, f := range files {
+			entries = append(entries, &types.TrashEntry{
+				Type:      "file",
+				ID:        f.ID,
+				Path:      strings.TrimPrefix(f.FullPath, path),
+				Name:      f.Name,
+				ObjectKey: f.ObjectKey,
+				Size:      f.Size,
+				MimeType:  f.MimeType,
+				OwnerID:   f.OwnerID,
+			})
+			item.Size += f.Size
 		}
 	}
 
-	return nil
+	// Deleting the directory cascades to its virtual files and subdirectories
+	return s.moveToTrash(item, entries, func(tx *sql.Tx) error {
+		return s.vfsRepo.DeleteDirectoryTx(tx, dir.ID)
+	})
 }
 
-// MoveFile moves or renames a file
-func (s *Service) MoveFile(bucket, source, destination string) (*types.VirtualFile, error) {
+// MoveFile moves or renames a file. The file keeps its owner; directories created
+// for the destination belong to ownerID. pre, if any, is checked against the source file.
+// A file already at the destination is handled by the conflict policy: overwriting puts it
+// in the trash, skipping leaves the source where it is and returns the file at the
+// destination, and renaming and merging move the source next to it. It returns the policy
+// applied, or an empty string when the destination was free.
+func (s *Service) MoveFile(bucket, source, destination, ownerID, policy string, pre Precondition) (*types.VirtualFile, string, error) {
 	source = normalizePath(source)
 	destination = normalizePath(destination)
 
 	if source == destination {
-		return nil, errors.NewInvalidRequestError("source and destination are the same")
+		return nil, "", errors.NewInvalidRequestError("source and destination are the same")
 	}
 
 	// Get source file
 	file, err := s.vfsRepo.GetFile(bucket, source)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, errors.NewNotFoundError(fmt.Sprintf("source file not found: %s", source))
-		}
-		return nil, err
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+	if err := pre.check(file); err != nil {
+		return nil, "", err
+	}
+	if file == nil {
+		return nil, "", errors.NewNotFoundError(fmt.Sprintf("source file not found: %s", source))
 	}
 
 	// Check if destination already exists
-	exists, err := s.vfsRepo.FileExists(bucket, destination)
-	if err != nil {
-		return nil, err
+	existing, err := s.vfsRepo.GetFile(bucket, destination)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
 	}
-	if exists {
-		return nil, errors.NewConflictError(fmt.Sprintf("destination file already exists: %s", destination))
+	resolution := ""
+	if existing != nil {
+		switch policy {
+		case conflict.Overwrite:
+			if err := s.DeleteFile(bucket, destination, nil); err != nil {
+				return nil, "", err
+			}
+		case conflict.Skip:
+			return existing, conflict.Skip, nil
+		case conflict.Rename, conflict.Merge:
+			policy = conflict.Rename
+			if destination, err = s.freePath(bucket, destination, false); err != nil {
+				return nil, "", err
+			}
+		default:
+			return nil, "", errors.NewConflictError(fmt.Sprintf("destination file already exists: %s", destination))
+		}
+		resolution = policy
 	}
 
 	// Parse destination directory and filename
@@ -580,9 +795,9 @@ func (s *Service) MoveFile(bucket, source, destination string) (*types.VirtualFi
 	// Ensure destination directory exists
 	var destDirID *string
 	if destDirPath != "/" {
-		dir, err := s.ensureDirectoryPath(bucket, destDirPath)
+		dir, err := s.ensureDirectoryPath(bucket, destDirPath, ownerID)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		destDirID = &dir.ID
 	}
@@ -594,46 +809,77 @@ func (s *Service) MoveFile(bucket, source, destination string) (*types.VirtualFi
 	file.UpdatedAt = time.Now()
 
 	if err := s.vfsRepo.UpdateFile(file); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return file, nil
+	return file, resolution, nil
 }
 
-// MoveDirectory moves or renames a directory
-func (s *Service) MoveDirectory(bucket, source, destination string) (*types.VirtualDirectory, error) {
+// MoveDirectory moves or renames a directory. Moved items keep their owner; parent
+// directories created for the destination belong to ownerID. A directory already at the
+// destination is handled by the conflict policy: overwriting puts it in the trash,
+// skipping leaves the source where it is and returns the directory at the destination,
+// renaming moves the source next to it and merging moves the source's contents into it.
+// It returns the policy applied, or an empty string when the destination was free.
+func (s *Service) MoveDirectory(bucket, source, destination, ownerID, policy string) (*types.VirtualDirectory, string, error) {
 	source = normalizePath(source)
 	destination = normalizePath(destination)
 
 	if source == "/" || destination == "/" {
-		return nil, errors.NewInvalidRequestError("cannot move root directory")
+		return nil, "", errors.NewInvalidRequestError("cannot move root directory")
 	}
 
 	if source == destination {
-		return nil, errors.NewInvalidRequestError("source and destination are the same")
+		return nil, "", errors.NewInvalidRequestError("source and destination are the same")
 	}
 
 	// Check if destination is a subdirectory of source
 	if strings.HasPrefix(destination, source+"/") {
-		return nil, errors.NewInvalidRequestError("cannot move directory into itself")
+		return nil, "", errors.NewInvalidRequestError("cannot move directory into itself")
 	}
 
 	// Get source directory
 	dir, err := s.vfsRepo.GetDirectory(bucket, source)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.NewNotFoundError(fmt.Sprintf("source directory not found: %s", source))
+			return nil, "", errors.NewNotFoundError(fmt.Sprintf("source directory not found: %s", source))
 		}
-		return nil, err
+		return nil, "", err
 	}
 
 	// Check if destination already exists
-	exists, err := s.vfsRepo.DirectoryExists(bucket, destination)
-	if err != nil {
-		return nil, err
+	existing, err := s.vfsRepo.GetDirectory(bucket, destination)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
 	}
-	if exists {
-		return nil, errors.NewConflictError(fmt.Sprintf("destination directory already exists: %s", destination))
+	resolution := ""
+	if existing != nil {
+		switch policy {
+		case conflict.Overwrite:
+			if err := checkReplaceable(source, destination); err != nil {
+				return nil, "", err
+			}
+			if err := s.DeleteDirectory(bucket, destination, true); err != nil {
+				return nil, "", err
+			}
+		case conflict.Skip:
+			return existing, conflict.Skip, nil
+		case conflict.Rename:
+			if destination, err = s.freePath(bucket, destination, true); err != nil {
+				return nil, "", err
+			}
+		case conflict.Merge:
+			if err := checkReplaceable(source, destination); err != nil {
+				return nil, "", err
+			}
+			if err := s.mergeDirectory(bucket, dir, destination, ownerID); err != nil {
+				return nil, "", err
+			}
+			return existing, conflict.Merge, nil
+		default:
+			return nil, "", errors.NewConflictError(fmt.Sprintf("destination directory already exists: %s", destination))
+		}
+		resolution = policy
 	}
 
 	// Parse destination parent directory and name
@@ -642,130 +888,105 @@ func (s *Service) MoveDirectory(bucket, source, destination string) (*types.Virt
 	// Ensure destination parent directory exists
 	var destParentID *string
 	if destParentPath != "/" {
-		parentDir, err := s.ensureDirectoryPath(bucket, destParentPath)
+		parentDir, err := s.ensureDirectoryPath(bucket, destParentPath, ownerID)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		destParentID = &parentDir.ID
 	}
 
-	// Update all subdirectories and files with new paths
-	if err := s.updatePathsAfterMove(bucket, source, destination); err != nil {
-		return nil, err
+	// Move the directory and rewrite every path below it in one transaction, so a failure
+	// leaves the tree where it was
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return nil, "", err
 	}
+	defer tx.Rollback()
 
-	// Update the directory itself
 	dir.ParentID = destParentID
 	dir.Name = destName
 	dir.FullPath = destination
 
-	if err := s.vfsRepo.UpdateDirectory(dir); err != nil {
-		return nil, err
-	}
-
-	return dir, nil
-}
-
-// updatePathsAfterMove updates all paths under a moved directory
-func (s *Service) updatePathsAfterMove(bucket, oldPath, newPath string) error {
-	// Ensure paths don't have trailing slashes for consistent replacement
-	oldPath = strings.TrimSuffix(oldPath, "/")
-	newPath = strings.TrimSuffix(newPath, "/")
-
-	// Get all subdirectories
-	dirs, err := s.vfsRepo.ListDirectoriesByPath(bucket, oldPath+"/")
-	if err != nil {
-		return err
-	}
-
-	// Update subdirectory paths
-	for _, dir := range dirs {
-		newFullPath := strings.Replace(dir.FullPath, oldPath, newPath, 1)
-		dir.FullPath = newFullPath
-		if err := s.vfsRepo.UpdateDirectory(dir); err != nil {
-			return err
+	if err := s.vfsRepo.MoveDirectoryTx(tx, dir, source); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", errors.NewConflictError(fmt.Sprintf("directory was changed by another request: %s", source))
 		}
+		return nil, "", err
 	}
-
-	// Get all files
-	files, err := s.vfsRepo.ListFilesByDirectory(bucket, oldPath+"/")
-	if err != nil {
-		return err
-	}
-
-	// Update file paths
-	for _, file := range files {
-		newFullPath := strings.Replace(file.FullPath, oldPath, newPath, 1)
-		file.FullPath = newFullPath
-		file.UpdatedAt = time.Now()
-		if err := s.vfsRepo.UpdateFile(file); err != nil {
-			return err
-		}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
 	}
 
-	return nil
+	return dir, resolution, nil
 }
 
-// ensureDirectoryPath ensures all directories in a path exist, creating them if necessary
-func (s *Service) ensureDirectoryPath(bucket, path string) (*types.VirtualDirectory, error) {
+// ensureDirectoryPath ensures all directories in a path exist, creating them owned by ownerID if necessary.
+// The existing ancestors are looked up in a single query.
+func (s *Service) ensureDirectoryPath(bucket, path, ownerID string) (*types.VirtualDirectory, error) {
 	path = normalizePath(path)
 	if path == "/" {
 		return nil, nil
 	}
 
-	// Check if directory already exists
-	dir, err := s.vfsRepo.GetDirectory(bucket, path)
-	if err == nil {
-		return dir, nil
-	}
-	if err != sql.ErrNoRows {
+	paths := ancestorPaths(path)
+	existing, err := s.vfsRepo.GetDirectoriesByPaths(bucket, paths)
+	if err != nil {
 		return nil, err
 	}
+	byPath := make(map[string]*types.VirtualDirectory, len(existing))
+	for _, dir := range existing {
+		byPath[dir.FullPath] = dir
+	}
 
-	// Directory doesn't exist, create it and all parent directories
-	parts := strings.Split(strings.Trim(path, "/"), "/")
-	var currentPath string
-	var parentID *string
-
-	for _, part := range parts {
-		if currentPath == "" {
-			currentPath = "/" + part
-		} else {
-			currentPath = currentPath + "/" + part
-		}
-
-		// Check if this level exists
-		existingDir, err := s.vfsRepo.GetDirectory(bucket, currentPath)
-		if err == nil {
-			parentID = &existingDir.ID
+	var dir *types.VirtualDirectory
+	for _, currentPath := range paths {
+		if existingDir, ok := byPath[currentPath]; ok {
 			dir = existingDir
 			continue
 		}
-		if err != sql.ErrNoRows {
-			return nil, err
-		}
 
 		// Create this level
+		var parentID *string
+		if dir != nil {
+			parentID = &dir.ID
+		}
+		_, name := splitPath(currentPath)
 		newDir := &types.VirtualDirectory{
 			ID:        utils.GenerateID(),
 			Bucket:    bucket,
 			ParentID:  parentID,
-			Name:      part,
+			Name:      name,
 			FullPath:  currentPath,
+			OwnerID:   ownerID,
 			CreatedAt: time.Now(),
 		}
 
 		if err := s.vfsRepo.CreateDirectory(newDir); err != nil {
-			return nil, err
+			// Another request may have created it meanwhile
+			concurrent, getErr := s.vfsRepo.GetDirectory(bucket, currentPath)
+			if getErr != nil {
+				return nil, err
+			}
+			newDir = concurrent
 		}
-
-		parentID = &newDir.ID
 		dir = newDir
 	}
 
 	return dir, nil
 }
 
+// ancestorPaths returns a path and each of its ancestors below the root, shallowest first
+func ancestorPaths(path string) []string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	paths := make([]string, len(parts))
+	current := ""
+	for i, part := range parts {
+		current += "/" + part
+		paths[i] = current
+	}
+	return paths
+}
+
 // normalizePath normalizes a virtual path
 func normalizePath(path string) string {
 	// Clean the path
@@ -800,8 +1021,8 @@ func splitPath(path string) (string, string) {
 	return dir, file
 }
 
-// DeleteDirectoryAsync deletes a directory asynchronously
-func (s *Service) DeleteDirectoryAsync(bucket, path string, recursive bool) (*types.Task, error) {
+// DeleteDirectoryAsync deletes a directory asynchronously in a task started by userID
+func (s *Service) DeleteDirectoryAsync(bucket, path string, recursive bool, userID string) (*types.Task, error) {
 	// Create task
 	metadata := map[string]interface{}{
 		"bucket":    bucket,
@@ -810,7 +1031,7 @@ func (s *Service) DeleteDirectoryAsync(bucket, path string, recursive bool) (*ty
 		"operation": "delete_directory",
 	}
 	
-	task, err := s.taskSvc.CreateTask(types.TaskTypeDelete, metadata)
+	task, err := s.taskSvc.CreateUserTask(types.TaskTypeDelete, userID, metadata)
 	if err != nil {
 		return nil, err
 	}
@@ -828,8 +1049,9 @@ func (s *Service) DeleteDirectoryAsync(bucket, path string, recursive bool) (*ty
 	return task, nil
 }
 
-// MoveDirectoryAsync moves a directory asynchronously
-func (s *Service) MoveDirectoryAsync(bucket, source, destination string) (*types.Task, error) {
+// MoveDirectoryAsync moves a directory asynchronously in a task started by userID,
+// resolving a conflict at the destination by policy
+func (s *Service) MoveDirectoryAsync(bucket, source, destination, userID, policy string) (*types.Task, error) {
 	// Create task
 	metadata := map[string]interface{}{
 		"bucket":      bucket,
@@ -837,27 +1059,31 @@ func (s *Service) MoveDirectoryAsync(bucket, source, destination string) (*types
 		"destination": destination,
 		"operation":   "move_directory",
 	}
+	if policy != "" {
+		metadata["conflict"] = policy
+	}
 	
-	task, err := s.taskSvc.CreateTask(types.TaskTypeMove, metadata)
+	task, err := s.taskSvc.CreateUserTask(types.TaskTypeMove, userID, metadata)
 	if err != nil {
 		return nil, err
 	}
 
 	// Start background process
 	go func() {
-		_, err := s.MoveDirectory(bucket, source, destination)
+		dir, resolution, err := s.MoveDirectory(bucket, source, destination, userID, policy)
 		if err != nil {
 			s.taskSvc.FailTask(task.ID, err.Error())
 		} else {
-			s.taskSvc.CompleteTask(task.ID, nil)
+			s.taskSvc.CompleteTask(task.ID, conflictResult(dir.FullPath, resolution))
 		}
 	}()
 
 	return task, nil
 }
 
-// CopyDirectoryAsync copies a directory asynchronously
-func (s *Service) CopyDirectoryAsync(bucket, source, destination string) (*types.Task, error) {
+// CopyDirectoryAsync copies a directory asynchronously in a task started by userID,
+// resolving a conflict at the destination by policy. The copies belong to userID.
+func (s *Service) CopyDirectoryAsync(bucket, source, destination, userID, policy string) (*types.Task, error) {
 	// Create task
 	metadata := map[string]interface{}{
 		"bucket":      bucket,
@@ -865,123 +1091,61 @@ func (s *Service) CopyDirectoryAsync(bucket, source, destination string) (*types
 		"destination": destination,
 		"operation":   "copy_directory",
 	}
+	if policy != "" {
+		metadata["conflict"] = policy
+	}
 	
-	task, err := s.taskSvc.CreateTask(types.TaskTypeCopy, metadata)
+	task, err := s.taskSvc.CreateUserTask(types.TaskTypeCopy, userID, metadata)
 	if err != nil {
 		return nil, err
 	}
 
 	// Start background process
 	go func() {
-		err := s.CopyDirectory(bucket, source, destination)
-		if err != nil {
-			s.taskSvc.FailTask(task.ID, err.Error())
-		} else {
-			s.taskSvc.CompleteTask(task.ID, nil)
+		job, err := s.startCopy(bucket, source, destination, userID, policy)
+		if err == nil && job != nil {
+			err = s.runCopyJob(job, task.ID)
 		}
+		s.finishCopyTask(task.ID, job, destination, err)
 	}()
 
 	return task, nil
 }
 
-// CopyDirectory copies a directory (synchronous implementation)
-func (s *Service) CopyDirectory(bucket, source, destination string) error {
-	source = normalizePath(source)
-	destination = normalizePath(destination)
-
-	if source == "/" || destination == "/" {
-		return errors.NewInvalidRequestError("cannot copy root directory")
-	}
-
-	if source == destination {
-		return errors.NewInvalidRequestError("source and destination are the same")
-	}
-
-	// Check if destination is a subdirectory of source
-	if strings.HasPrefix(destination, source+"/") {
-		return errors.NewInvalidRequestError("cannot copy directory into itself")
-	}
-
-	// Get source directory
-	_, err := s.vfsRepo.GetDirectory(bucket, source)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return errors.NewNotFoundError(fmt.Sprintf("source directory not found: %s", source))
-		}
-		return err
-	}
-
-	// Check if destination already exists
-	exists, err := s.vfsRepo.DirectoryExists(bucket, destination)
+// CopyFile copies a file to a new path. The copy belongs to ownerID. A file already at the
+// destination is handled by the conflict policy like an upload; the policy applied is returned.
+func (s *Service) CopyFile(bucket, source, destination, ownerID, policy string) (*types.VirtualFile, string, error) {
+	// Note: This streams data through the server; a server-side copy would avoid that
+	reader, file, err := s.DownloadFile(bucket, source)
 	if err != nil {
-		return err
-	}
-	if exists {
-		return errors.NewConflictError(fmt.Sprintf("destination directory already exists: %s", destination))
-	}
-
-	// Create destination directory
-	_, err = s.ensureDirectoryPath(bucket, destination)
-	if err != nil {
-		return err
+		return nil, "", err
 	}
+	defer reader.Close()
 
-	// Copy all subdirectories and files
-	return s.copyRecursive(bucket, source, destination)
+	return s.PutFile(bucket, destination, reader, file.Size, file.MimeType, ownerID, policy, nil)
 }
 
-// copyRecursive recursively copies files and directories
-func (s *Service) copyRecursive(bucket, source, destination string) error {
-	// Ensure paths don't have trailing slashes for consistent replacement
-	source = strings.TrimSuffix(source, "/")
-	destination = strings.TrimSuffix(destination, "/")
-
-	// List all files in source directory
-	files, err := s.vfsRepo.ListFilesByDirectory(bucket, source)
+// CopyDirectory copies a directory (synchronous implementation). The copies belong to ownerID.
+// It returns where the copy went and the conflict policy applied at the destination, if any.
+func (s *Service) CopyDirectory(bucket, source, destination, ownerID, policy string) (string, string, error) {
+	job, err := s.startCopy(bucket, source, destination, ownerID, policy)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-
-	// Copy files
-	for _, file := range files {
-		// Calculate new path
-		newPath := strings.Replace(file.FullPath, source, destination, 1)
-		
-		// Download file content
-		reader, _, err := s.DownloadFile(bucket, file.FullPath)
-		if err != nil {
-			return err
-		}
-		
-		// Upload to new location
-		// Note: This is inefficient for large files/directories as it streams data through the server
-		// A better approach would be to use cloud provider's server-side copy if available
-		_, err = s.UploadFile(bucket, newPath, reader, file.Size, file.MimeType)
-		reader.Close()
-		if err != nil {
-			return err
-		}
-	}
-
-	// List all subdirectories
-	dirs, err := s.vfsRepo.ListDirectoriesByPath(bucket, source+"/")
-	if err != nil {
-		return err
+	if job == nil {
+		return normalizePath(destination), conflict.Skip, nil
 	}
+	return job.Destination, job.Conflict, s.runCopyJob(job, "")
+}
 
-	// Create subdirectories
-	for _, dir := range dirs {
-		// Calculate new path
-		newPath := strings.Replace(dir.FullPath, source, destination, 1)
-		
-		// Create directory
-		_, err := s.ensureDirectoryPath(bucket, newPath)
-		if err != nil {
-			return err
-		}
+// conflictResult is the result of a task that moved or copied a directory to path,
+// resolving a conflict there as resolution says
+func conflictResult(path, resolution string) map[string]interface{} {
+	result := map[string]interface{}{"destination": path}
+	if resolution != "" {
+		result["conflict"] = resolution
 	}
-
-	return nil
+	return result
 }
 
 // progressReader wraps a ReadSeekCloser to track download progress
@@ -0,0 +1,278 @@
+package vfs
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"path"
+	"time"
+
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+)
+
+// defaultTrashRetentionDays applies when the configuration does not set a retention
+const defaultTrashRetentionDays = 30
+
+// moveToTrash records an item in the trash and removes its virtual rows in one transaction.
+// The objects of its files stay in storage until the item is purged.
+func (s *Service) moveToTrash(item *types.TrashItem, entries []*types.TrashEntry, remove func(tx *sql.Tx) error) error {
+	days := s.trashConfig.RetentionDays
+	if days <= 0 {
+		days = defaultTrashRetentionDays
+	}
+	item.ExpiresAt = time.Now().AddDate(0, 0, days)
+
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.enhancedRepo.MoveToTrashTx(tx, item, entries); err != nil {
+		return fmt.Errorf("failed to move %s to trash: %w", item.OriginalPath, err)
+	}
+	if err := remove(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RestoreFromTrash puts a trashed file or directory back at its original path with its
+// original IDs, recreating missing parent directories for the item's owner. The item's
+// rows are inserted and its trash entry removed in one transaction, so a failure midway
+// leaves it whole in the trash.
+func (s *Service) RestoreFromTrash(item *types.TrashItem) error {
+	fileExists, err := s.vfsRepo.FileExists(item.Bucket, item.OriginalPath)
+	if err != nil {
+		return err
+	}
+	dirExists, err := s.vfsRepo.DirectoryExists(item.Bucket, item.OriginalPath)
+	if err != nil {
+		return err
+	}
+	if fileExists || dirExists {
+		return errors.NewConflictError(fmt.Sprintf("original path is no longer available: %s", item.OriginalPath))
+	}
+
+	var parentID *string
+	parent, err := s.ensureDirectoryPath(item.Bucket, path.Dir(item.OriginalPath), item.OwnerID)
+	if err != nil {
+		return err
+	}
+	if parent != nil {
+		parentID = &parent.ID
+	}
+
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	if item.OriginalType == "file" {
+		version, err := s.versionRepo.NextVersion(item.OriginalID)
+		if err != nil {
+			return err
+		}
+		file := &types.VirtualFile{
+			ID:          item.OriginalID,
+			Bucket:      item.Bucket,
+			DirectoryID: parentID,
+			Name:        item.OriginalName,
+			FullPath:    item.OriginalPath,
+			ObjectKey:   item.ObjectKey,
+			Size:        item.Size,
+			MimeType:    item.MimeType,
+			OwnerID:     item.OwnerID,
+			Version:     version,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := s.vfsRepo.CreateFileTx(tx, file); err != nil {
+			return err
+		}
+		if err := s.enhancedRepo.DeleteFromTrashTx(tx, item.ID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	entries, err := s.enhancedRepo.GetTrashEntries(item.ID)
+	if err != nil {
+		return err
+	}
+
+	root := &types.VirtualDirectory{
+		ID:        item.OriginalID,
+		Bucket:    item.Bucket,
+		ParentID:  parentID,
+		Name:      item.OriginalName,
+		FullPath:  item.OriginalPath,
+		OwnerID:   item.OwnerID,
+		CreatedAt: now,
+	}
+	if err := s.vfsRepo.CreateDirectoryTx(tx, root); err != nil {
+		return err
+	}
+
+	// Entries come directories first, each after its parent, so every parent ID is known
+	dirIDs := map[string]string{"/": root.ID}
+	for _, e := range entries {
+		parentID := dirIDs[path.Dir(e.Path)]
+		fullPath := item.OriginalPath + e.Path
+
+		if e.Type == "directory" {
+			dir := &types.VirtualDirectory{
+				ID:        e.ID,
+				Bucket:    item.Bucket,
+				ParentID:  &parentID,
+				Name:      e.Name,
+				FullPath:  fullPath,
+				OwnerID:   e.OwnerID,
+				CreatedAt: now,
+			}
+			if err := s.vfsRepo.CreateDirectoryTx(tx, dir); err != nil {
+				return err
+			}
+			dirIDs[e.Path] = dir.ID
+			continue
+		}
+
+		// Files keep counting from their previous versions, which stayed with them in the trash
+		version, err := s.versionRepo.NextVersion(e.ID)
+		if err != nil {
+			return err
+		}
+		file := &types.VirtualFile{
+			ID:          e.ID,
+			Bucket:      item.Bucket,
+			DirectoryID: &parentID,
+			Name:        e.Name,
+			FullPath:    fullPath,
+			ObjectKey:   e.ObjectKey,
+			Size:        e.Size,
+			MimeType:    e.MimeType,
+			OwnerID:     e.OwnerID,
+			Version:     version,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+		if err := s.vfsRepo.CreateFileTx(tx, file); err != nil {
+			return err
+		}
+	}
+
+	if err := s.enhancedRepo.DeleteFromTrashTx(tx, item.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// PurgeTrashItem permanently deletes a trash item, the previous versions of its files
+// and their objects. The rows go in one transaction that queues the objects for deletion,
+// so objects are never orphaned when the server stops midway.
+func (s *Service) PurgeTrashItem(item *types.TrashItem) error {
+	var objectKeys, fileIDs []string
+	if item.OriginalType == "file" {
+		objectKeys = append(objectKeys, item.ObjectKey)
+		fileIDs = append(fileIDs, item.OriginalID)
+	} else {
+		entries, err := s.enhancedRepo.GetTrashEntries(item.ID)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Type == "file" {
+				objectKeys = append(objectKeys, e.ObjectKey)
+				fileIDs = append(fileIDs, e.ID)
+			}
+		}
+	}
+
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(fileIDs) > 0 {
+		versionKeys, err := s.versionRepo.DeleteByFilesTx(tx, fileIDs)
+		if err != nil {
+			return fmt.Errorf("failed to delete versions of trash item %s: %w", item.ID, err)
+		}
+		objectKeys = append(objectKeys, versionKeys...)
+	}
+	if err := s.vfsRepo.QueueObjectDeletionsTx(tx, item.Bucket, objectKeys); err != nil {
+		return err
+	}
+	if err := s.enhancedRepo.DeleteFromTrashTx(tx, item.ID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteQueuedObjects()
+	return nil
+}
+
+// EmptyTrash permanently deletes every item in a bucket's trash
+func (s *Service) EmptyTrash(bucket string) (int64, error) {
+	items, err := s.enhancedRepo.ListAllTrashItems(bucket)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for _, item := range items {
+		if err := s.PurgeTrashItem(item); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// PurgeExpiredTrash permanently deletes the items of all buckets whose time in trash is over
+func (s *Service) PurgeExpiredTrash() (int, error) {
+	items, err := s.enhancedRepo.ListExpiredTrashItems()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, item := range items {
+		if err := s.PurgeTrashItem(item); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// StartTrashPurge purges expired trash items in the background at the configured interval
+func (s *Service) StartTrashPurge() {
+	if s.trashConfig.PurgeInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(s.trashConfig.PurgeInterval) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			// Retry objects whose deletion failed before
+			s.deleteQueuedObjects()
+
+			count, err := s.PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("Warning: trash purge failed: %v", err)
+			}
+			if count > 0 {
+				log.Printf("Purged %d expired trash items", count)
+			}
+		}
+	}()
+}
@@ -0,0 +1,368 @@
+package vfs
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/xuecangming/onedrive-storage/internal/common/errors"
+	"github.com/xuecangming/onedrive-storage/internal/common/types"
+	"github.com/xuecangming/onedrive-storage/internal/common/utils"
+	"github.com/xuecangming/onedrive-storage/internal/core/conflict"
+	"github.com/xuecangming/onedrive-storage/internal/service/object"
+)
+
+// PutFile uploads content to a path. A file already there is handled by the conflict
+// policy: overwriting keeps its current content as a previous version, skipping returns it
+// unchanged, and renaming and merging upload next to it. It returns the policy applied, or
+// an empty string when the path was free. Files that do not exist yet are created for
+// ownerID. pre, if any, is checked against the file at the path first.
+func (s *Service) PutFile(bucket, path string, content io.Reader, size int64, mimeType, ownerID, policy string, pre Precondition) (*types.VirtualFile, string, error) {
+	path = normalizePath(path)
+
+	file, err := s.vfsRepo.GetFile(bucket, path)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, "", err
+	}
+	if err := pre.check(file); err != nil {
+		return nil, "", err
+	}
+	if file == nil {
+		file, err := s.UploadFile(bucket, path, content, size, mimeType, ownerID)
+		return file, "", err
+	}
+
+	switch policy {
+	case conflict.Overwrite:
+	case conflict.Skip:
+		return file, conflict.Skip, nil
+	case conflict.Rename, conflict.Merge:
+		renamed, err := s.freePath(bucket, path, false)
+		if err != nil {
+			return nil, "", err
+		}
+		file, err := s.UploadFile(bucket, renamed, content, size, mimeType, ownerID)
+		return file, conflict.Rename, err
+	default:
+		return nil, "", errors.NewConflictError(fmt.Sprintf("file already exists at path: %s", path))
+	}
+
+	objectKey := utils.GenerateID()
+	ctx := context.Background()
+	hashed := newHashingReader(content)
+	if _, err := s.objectSvc.Upload(ctx, bucket, objectKey, hashed, size, mimeType); err != nil {
+		return nil, "", err
+	}
+
+	if err := s.replaceContent(file, objectKey, size, mimeType, hashed.Sum(), pre); err != nil {
+		_ = s.objectSvc.Delete(ctx, bucket, objectKey)
+		return nil, "", err
+	}
+	return file, conflict.Overwrite, nil
+}
+
+// replaceContent points a file at new content and keeps its current content as a version.
+// contentHash is empty when the hash of the new content is unknown.
+func (s *Service) replaceContent(file *types.VirtualFile, objectKey string, size int64, mimeType, contentHash string, pre Precondition) error {
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	previous := &types.FileVersion{
+		ID:          utils.GenerateID(),
+		FileID:      file.ID,
+		Bucket:      file.Bucket,
+		Version:     file.Version,
+		ObjectKey:   file.ObjectKey,
+		Size:        file.Size,
+		MimeType:    file.MimeType,
+		ContentHash: file.ContentHash,
+		CreatedAt:   file.UpdatedAt,
+		ArchivedAt:  now,
+	}
+	if err := s.versionRepo.CreateTx(tx, previous); err != nil {
+		return err
+	}
+
+	readVersion := file.Version
+	file.ObjectKey = objectKey
+	file.Size = size
+	file.MimeType = mimeType
+	file.ContentHash = contentHash
+	file.Version = readVersion + 1
+	file.UpdatedAt = now
+	if err := s.vfsRepo.UpdateFileContentTx(tx, file, readVersion); err != nil {
+		if err == sql.ErrNoRows {
+			return changedError(file, pre)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ListVersions returns a file and its previous versions, newest first
+func (s *Service) ListVersions(bucket, path string) (*types.VirtualFile, []*types.FileVersion, error) {
+	file, err := s.GetFile(bucket, path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	versions, err := s.versionRepo.List(file.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, versions, nil
+}
+
+// GetVersion returns a previous version of a file in a bucket, with the file
+func (s *Service) GetVersion(bucket, versionID string) (*types.FileVersion, *types.VirtualFile, error) {
+	notFound := errors.NewNotFoundError(fmt.Sprintf("version not found: %s", versionID))
+	if _, err := uuid.Parse(versionID); err != nil {
+		return nil, nil, notFound
+	}
+
+	v, err := s.versionRepo.Get(versionID)
+	if err == sql.ErrNoRows || (err == nil && v.Bucket != bucket) {
+		return nil, nil, notFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Versions of files in the trash are only reachable again once the file is restored
+	file, err := s.vfsRepo.GetFileByID(v.FileID)
+	if err == sql.ErrNoRows {
+		return nil, nil, notFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, file, nil
+}
+
+// DownloadVersion opens the content of a previous version
+func (s *Service) DownloadVersion(v *types.FileVersion) (object.ReadSeekCloser, error) {
+	_, reader, err := s.objectSvc.Download(context.Background(), v.Bucket, v.ObjectKey)
+	return reader, err
+}
+
+// RestoreVersion makes a previous version the current content of its file. The content it
+// replaces is kept as a new version, so restoring can be undone.
+func (s *Service) RestoreVersion(bucket, versionID string) (*types.VirtualFile, error) {
+	v, file, err := s.GetVersion(bucket, versionID)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	current := &types.FileVersion{
+		ID:          utils.GenerateID(),
+		FileID:      file.ID,
+		Bucket:      file.Bucket,
+		Version:     file.Version,
+		ObjectKey:   file.ObjectKey,
+		Size:        file.Size,
+		MimeType:    file.MimeType,
+		ContentHash: file.ContentHash,
+		CreatedAt:   file.UpdatedAt,
+		ArchivedAt:  now,
+	}
+	if err := s.versionRepo.CreateTx(tx, current); err != nil {
+		return nil, err
+	}
+	if err := s.versionRepo.DeleteTx(tx, v.ID); err != nil {
+		return nil, err
+	}
+
+	readVersion := file.Version
+	file.ObjectKey = v.ObjectKey
+	file.Size = v.Size
+	file.MimeType = v.MimeType
+	file.ContentHash = v.ContentHash
+	file.Version = readVersion + 1
+	file.UpdatedAt = now
+	if err := s.vfsRepo.UpdateFileContentTx(tx, file, readVersion); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, changedError(file, nil)
+		}
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// DeleteVersion permanently deletes a previous version and its content
+func (s *Service) DeleteVersion(bucket, versionID string) error {
+	v, _, err := s.GetVersion(bucket, versionID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.versionRepo.DeleteTx(tx, v.ID); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewNotFoundError(fmt.Sprintf("version not found: %s", versionID))
+		}
+		return err
+	}
+	if err := s.vfsRepo.QueueObjectDeletionsTx(tx, bucket, []string{v.ObjectKey}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.deleteQueuedObjects()
+	return nil
+}
+
+// VersionRules returns the version rules of a bucket, or the configured defaults
+func (s *Service) VersionRules(bucket string) (*types.VersionRules, error) {
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	rules, err := s.versionRepo.GetRules(bucket)
+	if err == sql.ErrNoRows {
+		return &types.VersionRules{
+			Bucket:       bucket,
+			KeepVersions: s.versionConfig.KeepVersions,
+			KeepDays:     s.versionConfig.KeepDays,
+			Default:      true,
+		}, nil
+	}
+	return rules, err
+}
+
+// SetVersionRules sets the version rules of a bucket
+func (s *Service) SetVersionRules(bucket string, rules *types.VersionRules) (*types.VersionRules, error) {
+	if rules.KeepVersions < 0 || rules.KeepDays < 0 {
+		return nil, errors.NewInvalidRequestError("keep_versions and keep_days cannot be negative")
+	}
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+
+	rules.Bucket = bucket
+	rules.Default = false
+	if err := s.versionRepo.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// ResetVersionRules removes the version rules of a bucket, so the defaults apply again
+func (s *Service) ResetVersionRules(bucket string) (*types.VersionRules, error) {
+	if err := s.checkBucket(bucket); err != nil {
+		return nil, err
+	}
+	if err := s.versionRepo.DeleteRules(bucket); err != nil {
+		return nil, err
+	}
+	return s.VersionRules(bucket)
+}
+
+// PruneVersions deletes the versions every bucket's rules no longer keep, with their content
+func (s *Service) PruneVersions() (int, error) {
+	buckets, err := s.bucketRepo.List(context.Background())
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, b := range buckets {
+		rules, err := s.VersionRules(b.Name)
+		if err != nil {
+			return count, err
+		}
+		if rules.KeepVersions == 0 && rules.KeepDays == 0 {
+			continue
+		}
+
+		pruned, err := s.pruneBucketVersions(b.Name, rules)
+		if err != nil {
+			return count, err
+		}
+		count += pruned
+	}
+
+	s.deleteQueuedObjects()
+	return count, nil
+}
+
+// pruneBucketVersions deletes the versions a bucket's rules no longer keep and queues their
+// content for deletion
+func (s *Service) pruneBucketVersions(bucket string, rules *types.VersionRules) (int, error) {
+	tx, err := s.vfsRepo.BeginTx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	keys, err := s.versionRepo.PruneTx(tx, bucket, rules.KeepVersions, rules.KeepDays)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.vfsRepo.QueueObjectDeletionsTx(tx, bucket, keys); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// StartVersionPrune prunes versions in the background at the configured interval
+func (s *Service) StartVersionPrune() {
+	if s.versionConfig.PruneInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(s.versionConfig.PruneInterval) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			count, err := s.PruneVersions()
+			if err != nil {
+				log.Printf("Warning: version prune failed: %v", err)
+			}
+			if count > 0 {
+				log.Printf("Pruned %d file versions", count)
+			}
+		}
+	}()
+}
+
+// checkBucket checks that a bucket exists
+func (s *Service) checkBucket(bucket string) error {
+	if _, err := s.bucketRepo.Get(context.Background(), bucket); err != nil {
+		if err == sql.ErrNoRows {
+			return errors.NewBucketNotFoundError(bucket)
+		}
+		return err
+	}
+	return nil
+}